@@ -0,0 +1,389 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+)
+
+const (
+	// refreshInterval is how often the key ring is reloaded and checked for rotation
+	refreshInterval = time.Minute
+	// minReloadInterval throttles reloads triggered by tokens with an unknown kid
+	minReloadInterval = 5 * time.Second
+	// lockTTL bounds how long a replica holds the key ring lock, should it die holding it
+	lockTTL = 30 * time.Second
+	// initialKeyWait is how long a starting replica waits for another one creating the first key
+	initialKeyWait = 10 * time.Second
+)
+
+// MinLegacySecretLength is the minimum length of the HS256 secret, the size of its hash
+const MinLegacySecretLength = 32
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrLegacySecretExpired is returned for HS256 tokens once the legacy secret expired
+	ErrLegacySecretExpired = errors.New("legacy signing secret expired")
+)
+
+// KeyRingConfig configures the signing keys of a KeyRing
+type KeyRingConfig struct {
+	// Algorithm of new keys, RS256 or ES256
+	Algorithm string
+	// RotationInterval is how long a key is used for signing before a new one is created
+	RotationInterval time.Duration
+	// TokenTTL is the lifetime of issued tokens. A key stays valid for verification
+	// until the last token it signed has expired.
+	TokenTTL time.Duration
+	// LegacySecret, if set, verifies HS256 tokens issued before the key ring was introduced,
+	// until LegacySecretExpiresAt. Legacy tokens are no longer issued, so the expiry is due
+	// once they have all expired.
+	LegacySecret          []byte
+	LegacySecretExpiresAt time.Time
+}
+
+// KeyRing signs tokens with the newest key and verifies them with any key still valid.
+// Keys are kept in a KeyStore shared by all gateway replicas; a replica seeing a token
+// signed with a key it does not know reloads the store.
+type KeyRing struct {
+	store  KeyStore
+	config KeyRingConfig
+	logger log.Logger
+
+	mu       sync.RWMutex
+	keys     []*SigningKey // oldest first
+	lastLoad time.Time
+}
+
+// NewKeyRing loads the key ring from store, creating the first key if there is none
+func NewKeyRing(ctx context.Context, store KeyStore, config KeyRingConfig, logger log.Logger) (*KeyRing, error) {
+	if _, err := signingMethod(config.Algorithm); err != nil {
+		return nil, err
+	}
+	if config.RotationInterval <= 0 || config.TokenTTL <= 0 {
+		return nil, fmt.Errorf("rotation interval and token ttl must be positive")
+	}
+	if len(config.LegacySecret) > 0 {
+		if len(config.LegacySecret) < MinLegacySecretLength {
+			return nil, fmt.Errorf("legacy secret must be at least %d bytes", MinLegacySecretLength)
+		}
+		if config.LegacySecretExpiresAt.IsZero() {
+			return nil, fmt.Errorf("legacy secret must have an expiry")
+		}
+	}
+
+	kr := &KeyRing{store: store, config: config, logger: logger}
+	if err := kr.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	// Another replica may be creating the first key
+	deadline := time.Now().Add(initialKeyWait)
+	for kr.current() == nil {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("no signing key was created")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		if err := kr.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// Run refreshes the key ring until ctx is done
+func (kr *KeyRing) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := kr.Refresh(ctx); err != nil {
+				kr.logger.Error("Failed to refresh signing keys", log.Error(err))
+			}
+		}
+	}
+}
+
+// Refresh reloads the keys, rotates the signing key when it is due and removes keys
+// whose tokens have all expired. Only the replica holding the store lock changes the keys,
+// the others load its changes on their next refresh.
+func (kr *KeyRing) Refresh(ctx context.Context) error {
+	if err := kr.load(ctx); err != nil {
+		return err
+	}
+	if !kr.rotationDue() && len(kr.expiredKeys()) == 0 {
+		return nil
+	}
+
+	unlock, err := kr.store.Lock(ctx, lockTTL)
+	if errors.Is(err, ErrKeyStoreLocked) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("lock signing keys: %w", err)
+	}
+	defer unlock()
+
+	// The replica that held the lock before may have rotated already
+	if err := kr.load(ctx); err != nil {
+		return err
+	}
+	if kr.rotationDue() {
+		if _, err := kr.rotate(ctx); err != nil {
+			return err
+		}
+	}
+
+	return kr.prune(ctx)
+}
+
+// Rotate creates a new signing key. Tokens signed with older keys remain valid.
+// It returns ErrKeyStoreLocked while another replica changes the keys.
+func (kr *KeyRing) Rotate(ctx context.Context) (*SigningKey, error) {
+	unlock, err := kr.store.Lock(ctx, lockTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return kr.rotate(ctx)
+}
+
+// rotationDue reports whether the signing key is missing, too old or of another algorithm
+func (kr *KeyRing) rotationDue() bool {
+	current := kr.current()
+	return current == nil ||
+		current.Algorithm != kr.config.Algorithm ||
+		time.Since(current.CreatedAt) >= kr.config.RotationInterval
+}
+
+// rotate creates a new signing key, the store lock must be held
+func (kr *KeyRing) rotate(ctx context.Context) (*SigningKey, error) {
+	key, err := generateKey(kr.config.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if err := kr.store.Add(ctx, key); err != nil {
+		return nil, fmt.Errorf("store signing key: %w", err)
+	}
+
+	kr.mu.Lock()
+	kr.keys = append(kr.keys, key)
+	sortKeys(kr.keys)
+	kr.mu.Unlock()
+
+	kr.logger.Info("Rotated signing key", log.String("kid", key.ID), log.String("alg", key.Algorithm))
+	return key, nil
+}
+
+// load replaces the keys with the content of the store
+func (kr *KeyRing) load(ctx context.Context) error {
+	keys, err := kr.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("load signing keys: %w", err)
+	}
+	sortKeys(keys)
+
+	kr.mu.Lock()
+	kr.keys = keys
+	kr.lastLoad = time.Now()
+	kr.mu.Unlock()
+	return nil
+}
+
+// expiredKeys returns the keys whose successor has been signing for longer than the token lifetime
+func (kr *KeyRing) expiredKeys() []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	var expired []string
+	for i := 0; i < len(kr.keys)-1; i++ {
+		if time.Since(kr.keys[i+1].CreatedAt) > kr.config.TokenTTL {
+			expired = append(expired, kr.keys[i].ID)
+		}
+	}
+	return expired
+}
+
+// prune removes the expired keys, the store lock must be held
+func (kr *KeyRing) prune(ctx context.Context) error {
+	expired := kr.expiredKeys()
+	if len(expired) == 0 {
+		return nil
+	}
+
+	for _, id := range expired {
+		if err := kr.store.Remove(ctx, id); err != nil {
+			return fmt.Errorf("remove signing key %s: %w", id, err)
+		}
+		kr.logger.Info("Removed expired signing key", log.String("kid", id))
+	}
+	return kr.load(ctx)
+}
+
+// current returns the key used for signing
+func (kr *KeyRing) current() *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if len(kr.keys) == 0 {
+		return nil
+	}
+	return kr.keys[len(kr.keys)-1]
+}
+
+// key returns the key with the given ID
+func (kr *KeyRing) key(id string) *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys valid for verification, oldest first
+func (kr *KeyRing) Keys() []*SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return append([]*SigningKey(nil), kr.keys...)
+}
+
+// Sign signs claims with the current key and sets the kid header
+func (kr *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := kr.current()
+	if key == nil {
+		return "", ErrUnknownKey
+	}
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// Keyfunc returns the key verifying a token, for use with jwt.Parse.
+// The algorithm of the token must match the algorithm of the key named by its kid.
+func (kr *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(kr.config.LegacySecret) == 0 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		if !time.Now().Before(kr.config.LegacySecretExpiresAt) {
+			return nil, ErrLegacySecretExpired
+		}
+		return kr.config.LegacySecret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	id, _ := token.Header["kid"].(string)
+	key := kr.key(id)
+	if key == nil && kr.reloadAllowed() {
+		// The key may have been created by another replica since the last refresh
+		if err := kr.load(context.Background()); err != nil {
+			kr.logger.Error("Failed to reload signing keys", log.Error(err))
+		}
+		key = kr.key(id)
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if key.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("key %s does not use %s", key.ID, token.Method.Alg())
+	}
+
+	return key.PublicKey(), nil
+}
+
+func (kr *KeyRing) reloadAllowed() bool {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return time.Since(kr.lastLoad) >= minReloadInterval
+}
+
+// Parse parses and verifies a token into claims
+func (kr *KeyRing) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, kr.Keyfunc)
+}
+
+func sortKeys(keys []*SigningKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+// generateKey creates a key for the algorithm, identified by its creation date and random bytes
+func generateKey(algorithm string) (*SigningKey, error) {
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch algorithm {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate signing key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return &SigningKey{
+		ID:         now.Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Algorithm:  algorithm,
+		PrivateKey: signer,
+		CreatedAt:  now,
+	}, nil
+}
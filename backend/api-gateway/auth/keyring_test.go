@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var legacySecret = []byte(strings.Repeat("s", MinLegacySecretLength))
+
+func newTestKeyRing(t *testing.T, config KeyRingConfig) (*KeyRing, error) {
+	store, err := NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+
+	config.Algorithm = AlgorithmES256
+	config.RotationInterval = time.Hour
+	config.TokenTTL = 15 * time.Minute
+	return NewKeyRing(context.Background(), store, config, log.NoopLogger)
+}
+
+func legacyToken(t *testing.T) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "user"}).SignedString(legacySecret)
+	require.NoError(t, err)
+	return token
+}
+
+func TestKeyRingLegacySecret(t *testing.T) {
+	t.Run("accepted until it expires", func(t *testing.T) {
+		kr, err := newTestKeyRing(t, KeyRingConfig{LegacySecret: legacySecret, LegacySecretExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+
+		_, err = kr.Parse(legacyToken(t), &jwt.StandardClaims{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejected once expired", func(t *testing.T) {
+		kr, err := newTestKeyRing(t, KeyRingConfig{LegacySecret: legacySecret, LegacySecretExpiresAt: time.Now().Add(-time.Second)})
+		require.NoError(t, err)
+
+		_, err = kr.Parse(legacyToken(t), &jwt.StandardClaims{})
+		var validationErr *jwt.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		assert.ErrorIs(t, validationErr.Inner, ErrLegacySecretExpired)
+	})
+
+	t.Run("rejected without a legacy secret", func(t *testing.T) {
+		kr, err := newTestKeyRing(t, KeyRingConfig{})
+		require.NoError(t, err)
+
+		_, err = kr.Parse(legacyToken(t), &jwt.StandardClaims{})
+		assert.Error(t, err)
+	})
+}
+
+func TestNewKeyRingRejectsWeakLegacySecrets(t *testing.T) {
+	_, err := newTestKeyRing(t, KeyRingConfig{LegacySecret: []byte("0"), LegacySecretExpiresAt: time.Now().Add(time.Hour)})
+	assert.Error(t, err)
+
+	_, err = newTestKeyRing(t, KeyRingConfig{LegacySecret: legacySecret})
+	assert.Error(t, err)
+}
+
+func TestKeyRingRotateNeedsTheLock(t *testing.T) {
+	store, err := NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+	kr, err := NewKeyRing(context.Background(), store, KeyRingConfig{
+		Algorithm:        AlgorithmES256,
+		RotationInterval: time.Hour,
+		TokenTTL:         15 * time.Minute,
+	}, log.NoopLogger)
+	require.NoError(t, err)
+
+	unlock, err := store.Lock(context.Background(), time.Minute)
+	require.NoError(t, err)
+
+	_, err = kr.Rotate(context.Background())
+	assert.ErrorIs(t, err, ErrKeyStoreLocked)
+
+	unlock()
+	_, err = kr.Rotate(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, kr.Keys(), 2)
+}
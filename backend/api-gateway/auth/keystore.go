@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// SigningKeysKey is the Redis hash holding the key ring, one field per key ID
+const SigningKeysKey = "jwt:keys"
+
+// SigningKeysLockKey is held by the gateway replica rotating or pruning the key ring
+const SigningKeysLockKey = "jwt:keys:lock"
+
+// ErrKeyStoreLocked is returned when another replica holds the key store lock
+var ErrKeyStoreLocked = errors.New("signing keys are locked by another replica")
+
+// SigningKey is a private key of the key ring
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	CreatedAt  time.Time
+}
+
+// PublicKey returns the key used to verify tokens signed with this key
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// storedKey is the serialized form of a SigningKey
+type storedKey struct {
+	ID         string    `json:"kid"`
+	Algorithm  string    `json:"alg"`
+	PrivateKey string    `json:"private_key"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func marshalKey(key *SigningKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	return json.Marshal(storedKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  key.CreatedAt,
+	})
+}
+
+func unmarshalKey(data []byte) (*SigningKey, error) {
+	var stored storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(stored.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("key %s: invalid PEM", stored.ID)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", stored.ID, err)
+	}
+
+	var signer crypto.Signer
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		signer = k
+	case *ecdsa.PrivateKey:
+		signer = k
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", stored.ID, parsed)
+	}
+
+	return &SigningKey{ID: stored.ID, Algorithm: stored.Algorithm, PrivateKey: signer, CreatedAt: stored.CreatedAt}, nil
+}
+
+// KeyStore persists the key ring so keys survive restarts and are shared by gateway replicas
+type KeyStore interface {
+	Load(ctx context.Context) ([]*SigningKey, error)
+	Add(ctx context.Context, key *SigningKey) error
+	Remove(ctx context.Context, id string) error
+	// Lock makes the caller the only replica changing the keys until unlock is called or
+	// ttl has passed. It returns ErrKeyStoreLocked when another replica holds the lock.
+	Lock(ctx context.Context, ttl time.Duration) (unlock func(), err error)
+}
+
+// FileKeyStore keeps each key in <dir>/<kid>.json, readable by the owner only
+type FileKeyStore struct {
+	dir string
+}
+
+// NewFileKeyStore creates a key store in dir, creating the directory if needed
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create key directory: %w", err)
+	}
+	return &FileKeyStore{dir: dir}, nil
+}
+
+// Load implements KeyStore
+func (s *FileKeyStore) Load(ctx context.Context) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read key: %w", err)
+		}
+		key, err := unmarshalKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Add implements KeyStore
+func (s *FileKeyStore) Add(ctx context.Context, key *SigningKey) error {
+	data, err := marshalKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a concurrent Load never sees a partial key
+	path := filepath.Join(s.dir, key.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write key: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove implements KeyStore
+func (s *FileKeyStore) Remove(ctx context.Context, id string) error {
+	if strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	err := os.Remove(filepath.Join(s.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Lock implements KeyStore with a lock file, removed as stale once older than ttl
+func (s *FileKeyStore) Lock(ctx context.Context, ttl time.Duration) (func(), error) {
+	path := filepath.Join(s.dir, "keys.lock")
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < ttl {
+			return nil, ErrKeyStoreLocked
+		}
+		os.Remove(path)
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if errors.Is(err, os.ErrExist) {
+			return nil, ErrKeyStoreLocked
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create lock file: %w", err)
+	}
+	file.Close()
+
+	return func() { os.Remove(path) }, nil
+}
+
+// RedisKeyStore keeps the key ring in the SigningKeysKey hash
+type RedisKeyStore struct {
+	client *redis.Client
+}
+
+// NewRedisKeyStore creates a key store in Redis
+func NewRedisKeyStore(client *redis.Client) *RedisKeyStore {
+	return &RedisKeyStore{client: client}
+}
+
+// Load implements KeyStore
+func (s *RedisKeyStore) Load(ctx context.Context) ([]*SigningKey, error) {
+	values, err := s.client.HGetAll(ctx, SigningKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(values))
+	for id, value := range values {
+		key, err := unmarshalKey([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", id, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Add implements KeyStore
+func (s *RedisKeyStore) Add(ctx context.Context, key *SigningKey) error {
+	data, err := marshalKey(key)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, SigningKeysKey, key.ID, data).Err()
+}
+
+// Remove implements KeyStore
+func (s *RedisKeyStore) Remove(ctx context.Context, id string) error {
+	return s.client.HDel(ctx, SigningKeysKey, id).Err()
+}
+
+// unlockScript releases a lock only if it is still held by its owner, a lock that expired
+// may have been taken by another replica since
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lock implements KeyStore with SigningKeysLockKey, set to a random owner token
+func (s *RedisKeyStore) Lock(ctx context.Context, ttl time.Duration) (func(), error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(owner)
+
+	acquired, err := s.client.SetNX(ctx, SigningKeysLockKey, token, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrKeyStoreLocked
+	}
+
+	return func() {
+		unlockScript.Run(context.Background(), s.client, []string{SigningKeysLockKey}, token)
+	}, nil
+}
@@ -0,0 +1,399 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// clockSkew is the leeway allowed when checking the expiry of ID tokens
+const clockSkew = time.Minute
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match")
+)
+
+// OIDCProviderConfig configures an OpenID Connect identity provider
+type OIDCProviderConfig struct {
+	// Name identifies the provider in /auth/{provider}/login
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+}
+
+// LoadOIDCProviders reads provider configurations from the JSON file at path.
+// Environment variables in the file are expanded, so secrets can stay out of it.
+func LoadOIDCProviders(path string) ([]OIDCProviderConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read OIDC providers: %w", err)
+	}
+	var configs []OIDCProviderConfig
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &configs); err != nil {
+		return nil, fmt.Errorf("parse OIDC providers: %w", err)
+	}
+
+	names := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC provider %q: name, issuer, client_id and redirect_url are required", config.Name)
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate OIDC provider %s", config.Name)
+		}
+		names[config.Name] = true
+	}
+	return configs, nil
+}
+
+// oidcMetadata is the part of the discovery document used by the gateway
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCTokens is the response of the token endpoint
+type OIDCTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// audience is the aud claim, a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// IDTokenClaims are the claims of an ID token used to link a local user
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp,omitempty"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Name              string   `json:"name,omitempty"`
+}
+
+// Valid implements jwt.Claims
+func (c *IDTokenClaims) Valid() error {
+	if c.ExpiresAt == 0 || time.Now().Add(-clockSkew).Unix() > c.ExpiresAt {
+		return fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+// OIDCProvider signs users in with an OpenID Connect provider using the authorization
+// code flow with PKCE. The discovery document and the signing keys of the provider are
+// fetched on first use, keys are reloaded when an ID token names an unknown kid.
+type OIDCProvider struct {
+	config OIDCProviderConfig
+	client *http.Client
+
+	mu       sync.RWMutex
+	metadata *oidcMetadata
+	keys     map[string]crypto.PublicKey
+	lastLoad time.Time
+}
+
+// NewOIDCProvider creates a provider, client is used for discovery, token and JWKS requests
+func NewOIDCProvider(config OIDCProviderConfig, client *http.Client) *OIDCProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{config: config, client: client}
+}
+
+// Name returns the name of the provider
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// discover returns the discovery document of the provider, fetching it once
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.RLock()
+	metadata := p.metadata
+	p.mu.RUnlock()
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	metadata = &oidcMetadata{}
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, metadata); err != nil {
+		return nil, fmt.Errorf("discover %s: %w", p.config.Name, err)
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discover %s: issuer %s does not match %s", p.config.Name, metadata.Issuer, p.config.Issuer)
+	}
+
+	p.mu.Lock()
+	p.metadata = metadata
+	p.mu.Unlock()
+	return metadata, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// AuthCodeURL returns the URL of the provider the user is sent to for signing in
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and its PKCE verifier for tokens
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (*OIDCTokens, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+		"client_id":     {p.config.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &oauthErr)
+		return nil, fmt.Errorf("exchange code: %s: %s %s", resp.Status, oauthErr.Error, oauthErr.Description)
+	}
+
+	var tokens OIDCTokens
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("exchange code: %w: missing from token response", ErrInvalidIDToken)
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken checks the signature of an ID token against the JWKS of the provider,
+// its issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		id, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, metadata, id)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Issuer != metadata.Issuer {
+		return nil, fmt.Errorf("%w: issuer %s", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.config.ClientID) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidIDToken, []string(claims.Audience))
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: authorized party %s", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// publicKey returns the signing key of the provider with the given kid, reloading the
+// JWKS if it is unknown. Tokens without a kid are accepted when the provider has one key.
+func (p *OIDCProvider) publicKey(ctx context.Context, metadata *oidcMetadata, id string) (crypto.PublicKey, error) {
+	key, ok := p.key(id)
+	if !ok && p.reloadAllowed() {
+		if err := p.loadKeys(ctx, metadata); err != nil {
+			return nil, err
+		}
+		key, ok = p.key(id)
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) key(id string) (crypto.PublicKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if id == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[id]
+	return key, ok
+}
+
+func (p *OIDCProvider) reloadAllowed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return time.Since(p.lastLoad) >= minReloadInterval
+}
+
+// loadKeys fetches the JWKS of the provider, skipping keys not meant for signatures
+func (p *OIDCProvider) loadKeys(ctx context.Context, metadata *oidcMetadata) error {
+	var set JWKSet
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return fmt.Errorf("load JWKS of %s: %w", p.config.Name, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.lastLoad = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// RandomToken returns n random bytes encoded as base64url, for states, nonces and PKCE verifiers
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge returns the S256 code challenge of a PKCE code verifier (RFC 7636)
+func PKCEChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
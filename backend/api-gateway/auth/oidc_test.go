@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"api-gateway/auth/oidctest"
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testClientID = "gateway"
+
+// startSignIn sends a user to the provider as OIDCLoginHandler does, and returns the code
+// of the callback with the nonce and PKCE verifier of the sign in
+func startSignIn(t *testing.T, idp *oidctest.Provider, provider *OIDCProvider) (code, nonce, verifier string) {
+	state, err := RandomToken(32)
+	require.NoError(t, err)
+	nonce, err = RandomToken(32)
+	require.NoError(t, err)
+	verifier, err = RandomToken(32)
+	require.NoError(t, err)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, PKCEChallenge(verifier))
+	require.NoError(t, err)
+
+	code, callbackState, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, state, callbackState)
+	return code, nonce, verifier
+}
+
+func newTestOIDCProvider(t *testing.T) (*oidctest.Provider, *OIDCProvider) {
+	idp := oidctest.NewProvider(testClientID)
+	t.Cleanup(idp.Close)
+
+	return idp, NewOIDCProvider(OIDCProviderConfig{
+		Name:        "test",
+		Issuer:      idp.Issuer(),
+		ClientID:    testClientID,
+		RedirectURL: "https://gateway.example.com/auth/test/callback",
+	}, idp.Server.Client())
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	idp, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+
+	state, nonce, verifier := "state", "nonce", "verifier-of-the-sign-in"
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, PKCEChallenge(verifier))
+	require.NoError(t, err)
+
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	params := u.Query()
+	assert.Equal(t, idp.Issuer()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "code", params.Get("response_type"))
+	assert.Equal(t, testClientID, params.Get("client_id"))
+	assert.Equal(t, "openid email profile", params.Get("scope"))
+	assert.Equal(t, state, params.Get("state"))
+	assert.Equal(t, nonce, params.Get("nonce"))
+	assert.Equal(t, PKCEChallenge(verifier), params.Get("code_challenge"))
+	assert.Equal(t, "S256", params.Get("code_challenge_method"))
+
+	code, _, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+
+	tokens, err := provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+
+	claims, err := provider.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	require.NoError(t, err)
+	assert.Equal(t, idp.Identity.Subject, claims.Subject)
+	assert.Equal(t, idp.Identity.Email, claims.Email)
+	assert.True(t, claims.EmailVerified)
+}
+
+func TestOIDCVerifyIDTokenRejectsNonceMismatch(t *testing.T) {
+	idp, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+
+	t.Run("nonce of another sign in", func(t *testing.T) {
+		code, _, verifier := startSignIn(t, idp, provider)
+		tokens, err := provider.Exchange(ctx, code, verifier)
+		require.NoError(t, err)
+
+		_, err = provider.VerifyIDToken(ctx, tokens.IDToken, "nonce-of-another-sign-in")
+		assert.ErrorIs(t, err, ErrNonceMismatch)
+	})
+
+	t.Run("token issued with another nonce", func(t *testing.T) {
+		idp.Nonce = "replayed-nonce"
+		defer func() { idp.Nonce = "" }()
+
+		code, nonce, verifier := startSignIn(t, idp, provider)
+		tokens, err := provider.Exchange(ctx, code, verifier)
+		require.NoError(t, err)
+
+		_, err = provider.VerifyIDToken(ctx, tokens.IDToken, nonce)
+		assert.ErrorIs(t, err, ErrNonceMismatch)
+	})
+
+	t.Run("empty nonce", func(t *testing.T) {
+		code, _, verifier := startSignIn(t, idp, provider)
+		tokens, err := provider.Exchange(ctx, code, verifier)
+		require.NoError(t, err)
+
+		_, err = provider.VerifyIDToken(ctx, tokens.IDToken, "")
+		assert.ErrorIs(t, err, ErrNonceMismatch)
+	})
+}
+
+func TestOIDCExchangeFailures(t *testing.T) {
+	idp, provider := newTestOIDCProvider(t)
+	ctx := context.Background()
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		code, _, _ := startSignIn(t, idp, provider)
+		_, err := provider.Exchange(ctx, code, "verifier-of-another-sign-in")
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		_, err := provider.Exchange(ctx, "forged-code", "verifier")
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("code redeemed twice", func(t *testing.T) {
+		code, _, verifier := startSignIn(t, idp, provider)
+		_, err := provider.Exchange(ctx, code, verifier)
+		require.NoError(t, err)
+
+		_, err = provider.Exchange(ctx, code, verifier)
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("provider unreachable", func(t *testing.T) {
+		code, _, verifier := startSignIn(t, idp, provider)
+		idp.Close()
+
+		_, err := provider.Exchange(ctx, code, verifier)
+		assert.Error(t, err)
+	})
+}
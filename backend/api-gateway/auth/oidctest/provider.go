@@ -0,0 +1,215 @@
+// Package oidctest runs an OpenID Connect provider for tests of the authorization code
+// flow with PKCE, in the spirit of net/http/httptest.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// keyID is the kid of the signing key of the provider
+const keyID = "oidctest"
+
+// Identity is the user signing in at the provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// grant is an authorization code waiting to be redeemed
+type grant struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	identity      Identity
+}
+
+// Provider is an identity provider serving discovery, token and JWKS endpoints. Users sign
+// in with Authorize, which stands for the browser visiting the authorization endpoint.
+type Provider struct {
+	Server   *httptest.Server
+	ClientID string
+	// Identity signs in on the next calls to Authorize
+	Identity Identity
+	// Nonce, if set, replaces the nonce of the authorization request in ID tokens
+	Nonce string
+
+	key *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// NewProvider starts a provider issuing ID tokens to clientID
+func NewProvider(clientID string) *Provider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: generate key: %v", err))
+	}
+
+	p := &Provider{
+		ClientID: clientID,
+		Identity: Identity{Subject: "oidctest-user", Email: "user@example.com", EmailVerified: true, Username: "oidctest"},
+		key:      key,
+		grants:   make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer returns the issuer of the provider, its discovery document is served below it
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Close shuts the provider down
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// Authorize signs Identity in at an authorization URL and returns the code and state the
+// provider redirects back with
+func (p *Provider) Authorize(authURL string) (code string, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	params := u.Query()
+
+	switch {
+	case params.Get("response_type") != "code":
+		return "", "", fmt.Errorf("unsupported response type %q", params.Get("response_type"))
+	case params.Get("client_id") != p.ClientID:
+		return "", "", fmt.Errorf("unknown client %q", params.Get("client_id"))
+	case params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "":
+		return "", "", fmt.Errorf("missing S256 code challenge")
+	case params.Get("state") == "" || params.Get("nonce") == "":
+		return "", "", fmt.Errorf("missing state or nonce")
+	}
+
+	code = randomString()
+	p.mu.Lock()
+	p.grants[code] = grant{
+		clientID:      params.Get("client_id"),
+		redirectURI:   params.Get("redirect_uri"),
+		codeChallenge: params.Get("code_challenge"),
+		nonce:         params.Get("nonce"),
+		identity:      p.Identity,
+	}
+	p.mu.Unlock()
+
+	return code, params.Get("state"), nil
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.Issuer() + "/authorize",
+		"token_endpoint":         p.Issuer() + "/token",
+		"jwks_uri":               p.Issuer() + "/jwks",
+	})
+}
+
+// token redeems an authorization code once, for the client and redirect URI it was issued
+// to and the verifier of its challenge
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	verifierHash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown code"})
+		return
+	case r.PostForm.Get("client_id") != g.clientID || r.PostForm.Get("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code issued to another client"})
+		return
+	case base64.RawURLEncoding.EncodeToString(verifierHash[:]) != g.codeChallenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code verifier does not match"})
+		return
+	}
+
+	nonce := g.nonce
+	if p.Nonce != "" {
+		nonce = p.Nonce
+	}
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss":                p.Issuer(),
+		"sub":                g.identity.Subject,
+		"aud":                g.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              nonce,
+		"email":              g.identity.Email,
+		"email_verified":     g.identity.EmailVerified,
+		"preferred_username": g.identity.Username,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"id_token":     signed,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	coordinate := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	x := p.key.X.FillBytes(make([]byte, 32))
+	y := p.key.Y.FillBytes(make([]byte, 32))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "EC", "kid": keyID, "alg": "ES256", "use": "sig", "crv": "P-256", "x": coordinate(x), "y": coordinate(y)},
+		},
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
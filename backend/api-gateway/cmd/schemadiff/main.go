@@ -0,0 +1,103 @@
+// Command schemadiff compares two subgraph schemas and exits with status 1 when the new
+// one has breaking changes, so it can gate deployments:
+//
+//	go run ./cmd/schemadiff ../order-service/graph/schema.graphqls http://localhost:8081/query
+//
+// Each schema is a file, or the URL of a running subgraph queried for _service { sdl }.
+package main
+
+import (
+	"api-gateway/schemadiff"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	jsonOutput := flag.Bool("json", false, "Print the changes as JSON")
+	raw := flag.Bool("raw", false, "Fetch URLs with GET, returning the SDL itself instead of answering _service { sdl }")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for fetching schemas from URLs")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <old schema> <new schema>\n\nA schema is a file or the URL of a running subgraph.\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client := &http.Client{}
+
+	oldSDL, err := loadSDL(ctx, client, flag.Arg(0), *raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load old schema: %v\n", err)
+		os.Exit(2)
+	}
+	newSDL, err := loadSDL(ctx, client, flag.Arg(1), *raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load new schema: %v\n", err)
+		os.Exit(2)
+	}
+
+	changes, err := schemadiff.DiffSDL(oldSDL, newSDL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compare schemas: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]interface{}{"changes": changes})
+	} else {
+		printChanges(changes)
+	}
+
+	if len(schemadiff.BreakingChanges(changes)) > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadSDL reads a schema file, or fetches the schema of a running subgraph
+func loadSDL(ctx context.Context, client *http.Client, source string, raw bool) (string, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return schemadiff.FetchSDL(ctx, client, source, raw)
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func printChanges(changes []schemadiff.Change) {
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+
+	counts := map[schemadiff.Criticality]int{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, criticality := range []schemadiff.Criticality{schemadiff.Breaking, schemadiff.Dangerous, schemadiff.Safe} {
+		for _, change := range changes {
+			if change.Criticality == criticality {
+				counts[criticality]++
+				fmt.Fprintf(w, "%s\t%s\t%s\n", change.Criticality, change.Path, change.Message)
+			}
+		}
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d changes: %d breaking, %d dangerous, %d safe\n",
+		len(changes), counts[schemadiff.Breaking], counts[schemadiff.Dangerous], counts[schemadiff.Safe])
+}
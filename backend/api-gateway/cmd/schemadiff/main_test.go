@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSDL = `type Query { orders: [ID] }`
+
+func TestLoadSDL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schema.graphqls")
+	require.NoError(t, os.WriteFile(file, []byte(testSDL), 0o644))
+
+	subgraph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(testSDL))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"_service": map[string]string{"sdl": testSDL}},
+		})
+	}))
+	defer subgraph.Close()
+
+	tests := []struct {
+		name   string
+		source string
+		raw    bool
+	}{
+		{"file", file, false},
+		{"subgraph", subgraph.URL, false},
+		{"raw url", subgraph.URL, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sdl, err := loadSDL(context.Background(), subgraph.Client(), tt.source, tt.raw)
+			require.NoError(t, err)
+			assert.Equal(t, testSDL, sdl)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := loadSDL(context.Background(), subgraph.Client(), filepath.Join(t.TempDir(), "missing.graphqls"), false)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
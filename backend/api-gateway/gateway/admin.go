@@ -0,0 +1,77 @@
+package main
+
+import (
+	appHandler "api-gateway/handler"
+	"api-gateway/redis"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// AdminMiddleware protects the /admin endpoints with the ADMIN_TOKEN bearer token
+func AdminMiddleware(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userRolesRequest assigns roles to a user
+type userRolesRequest struct {
+	UserID string   `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+// UserRolesAdminHandler replaces the roles of a user with PUT. Roles must be known to
+// roleScopes. Access tokens carry the new roles from the next refresh on.
+func UserRolesAdminHandler(users *redis.UserStore, roleScopes appHandler.RoleScopes, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req userRolesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || len(req.Roles) == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		for _, role := range req.Roles {
+			if _, ok := roleScopes[role]; !ok {
+				http.Error(w, fmt.Sprintf("Unknown role %q", role), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		err := users.SetRoles(ctx, req.UserID, req.Roles)
+		if errors.Is(err, redis.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to set user roles", log.String("user_id", req.UserID), log.Error(err))
+			http.Error(w, "Failed to set user roles", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("User roles changed", log.String("user_id", req.UserID), log.Strings("roles", req.Roles))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user_id": req.UserID, "roles": req.Roles, "scopes": roleScopes.Scopes(req.Roles)})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"api-gateway/models"
+	"api-gateway/redis"
+	"api-gateway/tracing"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/golang-jwt/jwt"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// defaultRotationGracePeriod is how long a rotated API key keeps working unless the request says otherwise
+const defaultRotationGracePeriod = 24 * time.Hour
+
+const apiKeyContextKey contextKey = "api_key"
+
+// APIKeyFromContext returns the API key the request was authenticated with, if any
+func APIKeyFromContext(ctx context.Context) (*redis.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(*redis.APIKey)
+	return key, ok
+}
+
+// APIKeyMiddleware authenticates machine clients sending an X-API-Key header instead of
+// a token. The key is turned into claims carrying its scopes, so the rest of the chain
+// treats it like a token; JWTMiddleware then lets the request through.
+func APIKeyMiddleware(next http.Handler, apiKeys *redis.APIKeyStore, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartRequest(r, "gateway.api_key")
+		defer span.End()
+
+		rawKey := r.Header.Get(APIKeyHeader)
+		if rawKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := apiKeys.Verify(r.Context(), rawKey)
+		switch {
+		case errors.Is(err, redis.ErrInvalidAPIKey), errors.Is(err, redis.ErrAPIKeyRevoked), errors.Is(err, redis.ErrAPIKeyExpired):
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		case err != nil:
+			logger.Error("Failed to verify API key", log.Error(err))
+			http.Error(w, "Could not verify API key", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := apiKeys.RecordUsage(r.Context(), key.ID); err != nil {
+			logger.Error("Failed to record API key usage", log.String("id", key.ID), log.Error(err))
+		}
+
+		claims := &models.Claims{
+			ClientID: key.ID,
+			Scopes:   key.Scopes,
+			StandardClaims: jwt.StandardClaims{
+				Subject: "apikey:" + key.ID,
+			},
+		}
+		logging.SetUserID(r.Context(), claims.Subject)
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		ctx = context.WithValue(ctx, apiKeyContextKey, key)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiKeyRateLimitPolicy returns the policy of an API key with its own rate limit
+func apiKeyRateLimitPolicy(key *redis.APIKey) (RateLimitPolicy, bool) {
+	if key.RateLimit == nil {
+		return RateLimitPolicy{}, false
+	}
+	return RateLimitPolicy{
+		Name:      "api-key:" + key.ID,
+		Key:       RateLimitKeyAPIKey,
+		Algorithm: key.RateLimit.Algorithm,
+		Limit:     key.RateLimit.Limit,
+		Window:    Duration(time.Duration(key.RateLimit.WindowSeconds) * time.Second),
+		Burst:     key.RateLimit.Burst,
+	}, true
+}
+
+// createAPIKeyRequest creates an API key. ExpiresIn is a duration such as "720h", empty for no expiry.
+type createAPIKeyRequest struct {
+	Name      string           `json:"name"`
+	Owner     string           `json:"owner"`
+	Scopes    []string         `json:"scopes"`
+	ExpiresIn Duration         `json:"expires_in"`
+	RateLimit *RateLimitPolicy `json:"rate_limit"`
+}
+
+// rotateAPIKeyRequest rotates an API key, the old key works for GracePeriod
+type rotateAPIKeyRequest struct {
+	GracePeriod *Duration `json:"grace_period"`
+}
+
+// createdAPIKey is the response of create and rotate, the only time the key is shown
+type createdAPIKey struct {
+	Key    string        `json:"key"`
+	APIKey *redis.APIKey `json:"api_key"`
+}
+
+// APIKeyAdminHandler manages API keys:
+//
+//	POST   /admin/api-keys             create a key, the response is the only time it is shown
+//	GET    /admin/api-keys?owner=      list keys, of one owner if given
+//	DELETE /admin/api-keys/{id}        revoke a key
+//	POST   /admin/api-keys/{id}/rotate replace a key, the old one works for a grace period
+func APIKeyAdminHandler(apiKeys *redis.APIKeyStore, rateLimiter *redis.RateLimiter, logger log.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /admin/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		var req createAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Owner == "" {
+			http.Error(w, "Invalid request, name and owner are required", http.StatusBadRequest)
+			return
+		}
+		for _, scope := range req.Scopes {
+			if scope == "" {
+				http.Error(w, "Invalid request, scopes must not be empty", http.StatusBadRequest)
+				return
+			}
+		}
+
+		key := &redis.APIKey{Name: req.Name, Owner: req.Owner, Scopes: req.Scopes}
+		if req.ExpiresIn > 0 {
+			expiresAt := time.Now().Add(time.Duration(req.ExpiresIn))
+			key.ExpiresAt = &expiresAt
+		}
+		if req.RateLimit != nil {
+			req.RateLimit.Name = "api-key"
+			req.RateLimit.Key = RateLimitKeyAPIKey
+			if err := req.RateLimit.validate(rateLimiter); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if time.Duration(req.RateLimit.Window) < time.Second {
+				http.Error(w, "Invalid request, rate limit window must be at least 1s", http.StatusBadRequest)
+				return
+			}
+			key.RateLimit = &redis.APIKeyRateLimit{
+				Algorithm:     req.RateLimit.Algorithm,
+				Limit:         req.RateLimit.Limit,
+				WindowSeconds: int(time.Duration(req.RateLimit.Window).Seconds()),
+				Burst:         req.RateLimit.Burst,
+			}
+		}
+
+		secret, err := apiKeys.Create(r.Context(), key)
+		if err != nil {
+			logger.Error("Failed to create API key", log.Error(err))
+			http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("API key created", log.String("id", key.ID), log.String("owner", key.Owner))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createdAPIKey{Key: secret, APIKey: key})
+	})
+
+	mux.HandleFunc("GET /admin/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		keys, err := apiKeys.List(r.Context(), r.URL.Query().Get("owner"))
+		if err != nil {
+			logger.Error("Failed to list API keys", log.Error(err))
+			http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"api_keys": keys})
+	})
+
+	mux.HandleFunc("DELETE /admin/api-keys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		key, err := apiKeys.Revoke(r.Context(), r.PathValue("id"))
+		if errors.Is(err, redis.ErrAPIKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to revoke API key", log.Error(err))
+			http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"api_key": key})
+	})
+
+	mux.HandleFunc("POST /admin/api-keys/{id}/rotate", func(w http.ResponseWriter, r *http.Request) {
+		var req rotateAPIKeyRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		gracePeriod := defaultRotationGracePeriod
+		if req.GracePeriod != nil {
+			gracePeriod = time.Duration(*req.GracePeriod)
+		}
+
+		key, secret, err := apiKeys.Rotate(r.Context(), r.PathValue("id"), gracePeriod)
+		switch {
+		case errors.Is(err, redis.ErrAPIKeyNotFound):
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		case errors.Is(err, redis.ErrAPIKeyRevoked):
+			http.Error(w, "API key is revoked or expired", http.StatusConflict)
+			return
+		case err != nil:
+			logger.Error("Failed to rotate API key", log.Error(err))
+			http.Error(w, "Failed to rotate API key", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createdAPIKey{Key: secret, APIKey: key})
+	})
+
+	return mux
+}
+
+// APIKeyUsageHandler reports the request counters of every active API key
+func APIKeyUsageHandler(apiKeys *redis.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		usage, err := apiKeys.Usage(ctx)
+		if err != nil {
+			http.Error(w, "Failed to read API key usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"api_keys":  usage,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"api-gateway/models"
+	"api-gateway/redis"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyTestServer serves the API key admin endpoints and, behind APIKeyMiddleware, the
+// subject and scopes a request was authenticated with
+type apiKeyTestServer struct {
+	admin   http.Handler
+	gateway http.Handler
+	redis   *miniredis.Miniredis
+}
+
+func newAPIKeyTestServer(t *testing.T) *apiKeyTestServer {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	apiKeys := redis.NewAPIKeyStore(client, log.NoopLogger)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(claimsContextKey).(*models.Claims)
+		key, _ := APIKeyFromContext(r.Context())
+		if claims == nil || key == nil {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		fmt.Fprintf(w, "%s %s", claims.Subject, strings.Join(claims.Scopes, ","))
+	})
+
+	return &apiKeyTestServer{
+		admin:   APIKeyAdminHandler(apiKeys, redis.NewRateLimiter(client, log.NoopLogger), log.NoopLogger),
+		gateway: APIKeyMiddleware(next, apiKeys, log.NoopLogger),
+		redis:   server,
+	}
+}
+
+func (s *apiKeyTestServer) serveAdmin(method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.admin.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+// query sends a request with rawKey, if any, and returns the status and the body
+func (s *apiKeyTestServer) query(rawKey string) (int, string) {
+	r := httptest.NewRequest(http.MethodPost, "/query", nil)
+	if rawKey != "" {
+		r.Header.Set(APIKeyHeader, rawKey)
+	}
+	w := httptest.NewRecorder()
+	s.gateway.ServeHTTP(w, r)
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func (s *apiKeyTestServer) create(t *testing.T, body string) createdAPIKey {
+	w := s.serveAdmin(http.MethodPost, "/admin/api-keys", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created createdAPIKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Key)
+	return created
+}
+
+func TestAPIKeyAdminHandlerCreate(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+
+	created := s.create(t, `{"name":"billing","owner":"team-billing","scopes":["orders:read"],"expires_in":"720h","rate_limit":{"algorithm":"gcra","limit":10,"window":"1m","burst":5}}`)
+	assert.Equal(t, "team-billing", created.APIKey.Owner)
+	assert.Equal(t, &redis.APIKeyRateLimit{Algorithm: "gcra", Limit: 10, WindowSeconds: 60, Burst: 5}, created.APIKey.RateLimit)
+	require.NotNil(t, created.APIKey.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(720*time.Hour), *created.APIKey.ExpiresAt, 2*time.Second)
+
+	status, body := s.query(created.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "apikey:"+created.APIKey.ID+" orders:read", body)
+
+	// The key is only shown by create
+	w := s.serveAdmin(http.MethodGet, "/admin/api-keys?owner=team-billing", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.APIKey.ID)
+	assert.NotContains(t, w.Body.String(), created.Key[strings.LastIndex(created.Key, "_")+1:])
+	var listed struct {
+		APIKeys []map[string]interface{} `json:"api_keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.APIKeys, 1)
+	assert.NotContains(t, listed.APIKeys[0], "key")
+
+	w = s.serveAdmin(http.MethodGet, "/admin/api-keys?owner=someone-else", "")
+	assert.JSONEq(t, `{"api_keys":[]}`, w.Body.String())
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "without name", body: `{"owner":"team-billing"}`},
+		{name: "without owner", body: `{"name":"billing"}`},
+		{name: "empty scope", body: `{"name":"billing","owner":"team-billing","scopes":[""]}`},
+		{name: "unknown algorithm", body: `{"name":"billing","owner":"team-billing","rate_limit":{"algorithm":"leaky","limit":10,"window":"1m"}}`},
+		{name: "window under a second", body: `{"name":"billing","owner":"team-billing","rate_limit":{"algorithm":"gcra","limit":10,"window":"500ms"}}`},
+		{name: "not JSON", body: `name=billing`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.serveAdmin(http.MethodPost, "/admin/api-keys", tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestAPIKeyMiddlewareRejects(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+
+	revoked := s.create(t, `{"name":"revoked","owner":"team-billing"}`)
+	w := s.serveAdmin(http.MethodDelete, "/admin/api-keys/"+revoked.APIKey.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"revoked_at"`)
+
+	expired := s.create(t, `{"name":"expired","owner":"team-billing","expires_in":"1h"}`)
+	s.redis.HSet(redis.APIKeyPrefix+expired.APIKey.ID, "expires_at", fmt.Sprint(time.Now().Add(-time.Second).Unix()))
+
+	tests := []struct {
+		name   string
+		rawKey string
+		status int
+		body   string
+	}{
+		{name: "no key", status: http.StatusOK, body: "anonymous"},
+		{name: "revoked", rawKey: revoked.Key, status: http.StatusUnauthorized, body: "Invalid API key"},
+		{name: "expired", rawKey: expired.Key, status: http.StatusUnauthorized, body: "Invalid API key"},
+		{name: "unknown", rawKey: "gk_0000000000000000_secret", status: http.StatusUnauthorized, body: "Invalid API key"},
+		{name: "malformed", rawKey: "not-a-key", status: http.StatusUnauthorized, body: "Invalid API key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := s.query(tt.rawKey)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.body, body)
+		})
+	}
+
+	w = s.serveAdmin(http.MethodDelete, "/admin/api-keys/0000000000000000", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPIKeyAdminHandlerRotate(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+	old := s.create(t, `{"name":"billing","owner":"team-billing","scopes":["orders:read"]}`)
+
+	w := s.serveAdmin(http.MethodPost, "/admin/api-keys/"+old.APIKey.ID+"/rotate", `{"grace_period":"1h"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var next createdAPIKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &next))
+	assert.NotEqual(t, old.APIKey.ID, next.APIKey.ID)
+	assert.Equal(t, []string{"orders:read"}, next.APIKey.Scopes)
+
+	status, body := s.query(next.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "apikey:"+next.APIKey.ID+" orders:read", body)
+
+	// Both keys work during the grace period
+	status, body = s.query(old.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "apikey:"+old.APIKey.ID+" orders:read", body)
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), s.unixField(t, old.APIKey.ID, "expires_at"), 2)
+
+	// and only the new one after it
+	s.redis.HSet(redis.APIKeyPrefix+old.APIKey.ID, "expires_at", fmt.Sprint(time.Now().Add(-time.Second).Unix()))
+	status, _ = s.query(old.Key)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = s.query(next.Key)
+	assert.Equal(t, http.StatusOK, status)
+
+	w = s.serveAdmin(http.MethodPost, "/admin/api-keys/"+old.APIKey.ID+"/rotate", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = s.serveAdmin(http.MethodPost, "/admin/api-keys/0000000000000000/rotate", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = s.serveAdmin(http.MethodPost, "/admin/api-keys/"+next.APIKey.ID+"/rotate", `{"grace_period":"soon"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIKeyAdminHandlerRotateDefaultGracePeriod(t *testing.T) {
+	s := newAPIKeyTestServer(t)
+	old := s.create(t, `{"name":"billing","owner":"team-billing"}`)
+
+	w := s.serveAdmin(http.MethodPost, "/admin/api-keys/"+old.APIKey.ID+"/rotate", "")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	assert.InDelta(t, time.Now().Add(defaultRotationGracePeriod).Unix(), s.unixField(t, old.APIKey.ID, "expires_at"), 2)
+	status, _ := s.query(old.Key)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+// unixField returns a unix time stored in a field of the hash of an API key
+func (s *apiKeyTestServer) unixField(t *testing.T, id, field string) int64 {
+	unix, err := strconv.ParseInt(s.redis.HGet(redis.APIKeyPrefix+id, field), 10, 64)
+	require.NoError(t, err)
+	return unix
+}
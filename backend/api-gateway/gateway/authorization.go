@@ -0,0 +1,325 @@
+package main
+
+import (
+	"api-gateway/models"
+	"api-gateway/tracing"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	abstractlogger "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/astparser"
+)
+
+const (
+	authenticatedDirective  = "authenticated"
+	requiresScopesDirective = "requiresScopes"
+
+	ForbiddenCode       = "FORBIDDEN"
+	UnauthenticatedCode = "UNAUTHENTICATED"
+	BadRequestCode      = "BAD_REQUEST"
+	// GraphQLParseFailedCode is returned for operations that do not parse or normalize
+	// against the supergraph
+	GraphQLParseFailedCode = "GRAPHQL_PARSE_FAILED"
+)
+
+// authorizationRule is the combination of @authenticated and @requiresScopes on a
+// field or type. Scopes holds alternatives: the caller needs every scope of one of them.
+type authorizationRule struct {
+	authenticated bool
+	scopes        [][]string
+}
+
+func (r authorizationRule) empty() bool {
+	return !r.authenticated && len(r.scopes) == 0
+}
+
+// merge combines two rules so that both have to be satisfied
+func (r authorizationRule) merge(other authorizationRule) authorizationRule {
+	merged := authorizationRule{authenticated: r.authenticated || other.authenticated}
+	switch {
+	case len(r.scopes) == 0:
+		merged.scopes = other.scopes
+	case len(other.scopes) == 0:
+		merged.scopes = r.scopes
+	default:
+		// (a OR b) AND (c OR d) = ac OR ad OR bc OR bd
+		for _, left := range r.scopes {
+			for _, right := range other.scopes {
+				merged.scopes = append(merged.scopes, append(append([]string(nil), left...), right...))
+			}
+		}
+	}
+	return merged
+}
+
+// allows reports whether a caller with the given claims satisfies the rule
+func (r authorizationRule) allows(claims *models.Claims) bool {
+	if claims == nil {
+		return r.empty()
+	}
+	if len(r.scopes) == 0 {
+		return true
+	}
+
+	granted := make(map[string]struct{}, len(claims.Scopes))
+	for _, scope := range claims.Scopes {
+		granted[scope] = struct{}{}
+	}
+	for _, alternative := range r.scopes {
+		satisfied := true
+		for _, scope := range alternative {
+			if _, ok := granted[scope]; !ok {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedField is a field declared in a subgraph SDL and the rule guarding it
+type authorizedField struct {
+	typeName string
+	rule     authorizationRule
+}
+
+// AuthorizationPolicy enforces the @authenticated and @requiresScopes directives
+// declared in subgraph SDLs. It is registered as a DataSourceObserver so the rules
+// follow schema updates.
+type AuthorizationPolicy struct {
+	mu        sync.RWMutex
+	fields    map[string]map[string]authorizedField
+	typeRules map[string]authorizationRule
+}
+
+// NewAuthorizationPolicy creates a policy without rules until the first schema update
+func NewAuthorizationPolicy() *AuthorizationPolicy {
+	return &AuthorizationPolicy{
+		fields:    make(map[string]map[string]authorizedField),
+		typeRules: make(map[string]authorizationRule),
+	}
+}
+
+// UpdateDataSources implements DataSourceObserver
+func (p *AuthorizationPolicy) UpdateDataSources(subgraphsConfigs []engine.SubgraphConfiguration) {
+	fields := make(map[string]map[string]authorizedField)
+	typeRules := make(map[string]authorizationRule)
+
+	for _, subgraph := range subgraphsConfigs {
+		doc, report := astparser.ParseGraphqlDocumentString(subgraph.SDL)
+		if report.HasErrors() {
+			log.Printf("Failed to parse SDL of %s for authorization rules: %v", subgraph.Name, report)
+			continue
+		}
+		collectAuthorizationRules(&doc, fields, typeRules)
+	}
+
+	p.mu.Lock()
+	p.fields = fields
+	p.typeRules = typeRules
+	p.mu.Unlock()
+}
+
+// collectAuthorizationRules adds the fields and authorization rules of a subgraph SDL.
+// Rules of the same field or type declared by several subgraphs must all be satisfied.
+func collectAuthorizationRules(doc *ast.Document, fields map[string]map[string]authorizedField, typeRules map[string]authorizationRule) {
+	addTypeRule := func(typeName string, directiveRefs []int) {
+		if rule := parseAuthorizationRule(doc, directiveRefs); !rule.empty() {
+			typeRules[typeName] = typeRules[typeName].merge(rule)
+		}
+	}
+	addType := func(typeName string, directiveRefs []int, fieldRefs []int) {
+		addTypeRule(typeName, directiveRefs)
+
+		if fields[typeName] == nil {
+			fields[typeName] = make(map[string]authorizedField)
+		}
+		for _, fieldRef := range fieldRefs {
+			name := doc.FieldDefinitionNameString(fieldRef)
+			field := fields[typeName][name]
+			field.typeName = doc.ResolveTypeNameString(doc.FieldDefinitionType(fieldRef))
+			field.rule = field.rule.merge(parseAuthorizationRule(doc, doc.FieldDefinitionDirectives(fieldRef)))
+			fields[typeName][name] = field
+		}
+	}
+
+	for i := range doc.ObjectTypeDefinitions {
+		def := doc.ObjectTypeDefinitions[i]
+		addType(doc.ObjectTypeDefinitionNameString(i), def.Directives.Refs, def.FieldsDefinition.Refs)
+	}
+	for i := range doc.ObjectTypeExtensions {
+		ext := doc.ObjectTypeExtensions[i]
+		addType(doc.ObjectTypeExtensionNameString(i), ext.Directives.Refs, ext.FieldsDefinition.Refs)
+	}
+	for i := range doc.InterfaceTypeDefinitions {
+		def := doc.InterfaceTypeDefinitions[i]
+		addType(doc.InterfaceTypeDefinitionNameString(i), def.Directives.Refs, def.FieldsDefinition.Refs)
+	}
+	for i := range doc.InterfaceTypeExtensions {
+		ext := doc.InterfaceTypeExtensions[i]
+		addType(doc.InterfaceTypeExtensionNameString(i), ext.Directives.Refs, ext.FieldsDefinition.Refs)
+	}
+	for i := range doc.ScalarTypeDefinitions {
+		addTypeRule(doc.ScalarTypeDefinitionNameString(i), doc.ScalarTypeDefinitions[i].Directives.Refs)
+	}
+	for i := range doc.EnumTypeDefinitions {
+		addTypeRule(doc.EnumTypeDefinitionNameString(i), doc.EnumTypeDefinitions[i].Directives.Refs)
+	}
+}
+
+// parseAuthorizationRule reads @authenticated and @requiresScopes(scopes: [[...]]) from a list of directives
+func parseAuthorizationRule(doc *ast.Document, directiveRefs []int) authorizationRule {
+	var rule authorizationRule
+	for _, ref := range directiveRefs {
+		switch doc.DirectiveNameString(ref) {
+		case authenticatedDirective:
+			rule.authenticated = true
+
+		case requiresScopesDirective:
+			value, ok := doc.DirectiveArgumentValueByName(ref, []byte("scopes"))
+			if !ok || value.Kind != ast.ValueKindList {
+				continue
+			}
+			var alternatives [][]string
+			for _, outerRef := range doc.ListValues[value.Ref].Refs {
+				outer := doc.Values[outerRef]
+				if outer.Kind != ast.ValueKindList {
+					continue
+				}
+				var scopes []string
+				for _, innerRef := range doc.ListValues[outer.Ref].Refs {
+					if inner := doc.Values[innerRef]; inner.Kind == ast.ValueKindString {
+						scopes = append(scopes, doc.StringValueContentString(inner.Ref))
+					}
+				}
+				alternatives = append(alternatives, scopes)
+			}
+			rule = rule.merge(authorizationRule{authenticated: true, scopes: alternatives})
+		}
+	}
+	return rule
+}
+
+// authorizationError is a field the caller may not query
+type authorizationError struct {
+	Path []string
+	Code string
+}
+
+// Check returns the fields of an operation the caller is not allowed to query. Claims
+// are nil for anonymous callers. A field is guarded by its own rule and by the rule of
+// the type it returns.
+func (p *AuthorizationPolicy) Check(op *Operation, claims *models.Claims) []authorizationError {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var denied []authorizationError
+	operation := op.Document.OperationDefinitions[op.Ref]
+	if operation.HasSelections {
+		p.walk(op.Document, operation.SelectionSet, rootTypeName(operation.OperationType), nil, claims, &denied)
+	}
+	return denied
+}
+
+func (p *AuthorizationPolicy) walk(doc *ast.Document, selectionSet int, parentType string, path []string, claims *models.Claims, denied *[]authorizationError) {
+	for _, selectionRef := range doc.SelectionSets[selectionSet].SelectionRefs {
+		selection := doc.Selections[selectionRef]
+
+		switch selection.Kind {
+		case ast.SelectionKindField:
+			name := doc.FieldNameString(selection.Ref)
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+
+			fieldPath := append(append([]string(nil), path...), doc.FieldAliasOrNameString(selection.Ref))
+			field := p.fields[parentType][name]
+			rule := field.rule.merge(p.typeRules[field.typeName])
+
+			if !rule.allows(claims) {
+				code := ForbiddenCode
+				if claims == nil {
+					code = UnauthenticatedCode
+				}
+				*denied = append(*denied, authorizationError{Path: fieldPath, Code: code})
+				// Fields below a denied field are not resolved anyway
+				continue
+			}
+
+			if doc.Fields[selection.Ref].HasSelections {
+				p.walk(doc, doc.Fields[selection.Ref].SelectionSet, field.typeName, fieldPath, claims, denied)
+			}
+
+		case ast.SelectionKindInlineFragment:
+			fragment := doc.InlineFragments[selection.Ref]
+			typeName := parentType
+			if fragment.TypeCondition.Type != -1 {
+				typeName = doc.InlineFragmentTypeConditionNameString(selection.Ref)
+			}
+			if fragment.HasSelections {
+				p.walk(doc, fragment.SelectionSet, typeName, path, claims, denied)
+			}
+		}
+	}
+}
+
+// AuthorizationMiddleware rejects operations selecting fields the caller is not allowed
+// to query, with a FORBIDDEN or UNAUTHENTICATED error per denied field. It must run after
+// QueryCostMiddleware, which parses the operation: requests without a parsed operation
+// are rejected, as their fields cannot be checked.
+func AuthorizationMiddleware(next http.Handler, policy *AuthorizationPolicy, logger abstractlogger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartRequest(r, "gateway.authorization")
+		defer span.End()
+
+		op, ok := OperationFromContext(r.Context())
+		if !ok {
+			writeGraphQLError(w, http.StatusBadRequest, "Operation could not be parsed", BadRequestCode)
+			return
+		}
+
+		claims, _ := ClaimsFromContext(r.Context())
+		denied := policy.Check(op, claims)
+		if len(denied) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		errors := make([]map[string]interface{}, len(denied))
+		for i, d := range denied {
+			message := "Not authorized to query field " + strings.Join(d.Path, ".")
+			if d.Code == UnauthenticatedCode {
+				message = "Authentication required to query field " + strings.Join(d.Path, ".")
+			}
+			errors[i] = map[string]interface{}{
+				"message":    message,
+				"path":       d.Path,
+				"extensions": map[string]interface{}{"code": d.Code},
+			}
+		}
+
+		subject := ""
+		if claims != nil {
+			subject = claimsSubject(claims)
+		}
+		logger.Warn("Rejected operation with unauthorized fields",
+			abstractlogger.String("operation", op.Name),
+			abstractlogger.String("subject", subject),
+			abstractlogger.Int("fields", len(denied)),
+		)
+
+		// Partial execution would need the planner, the whole operation is rejected instead
+		body, _ := json.Marshal(map[string]interface{}{"data": nil, "errors": errors})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"api-gateway/models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+)
+
+const (
+	// authorizationTestSchema is the supergraph the operations are parsed against
+	authorizationTestSchema = `
+		interface Node {
+			id: ID!
+		}
+
+		type Order implements Node {
+			id: ID!
+			total: Float!
+			buyer: String
+		}
+
+		type Invoice implements Node {
+			id: ID!
+			amount: Float!
+		}
+
+		type Query {
+			order(id: ID!): Order
+			node(id: ID!): Node
+			invoice(orderId: ID!): Invoice
+			status: String
+		}
+	`
+
+	authorizationOrdersSDL = `
+		interface Node {
+			id: ID!
+		}
+
+		type Order implements Node @key(fields: "id") {
+			id: ID!
+			total: Float! @requiresScopes(scopes: [["orders:read"]])
+		}
+
+		type Query {
+			order(id: ID!): Order @authenticated
+			node(id: ID!): Node
+			status: String
+		}
+	`
+
+	authorizationBillingSDL = `
+		type Invoice implements Node @key(fields: "id") @requiresScopes(scopes: [["billing:read"]]) {
+			id: ID!
+			amount: Float!
+		}
+
+		type Order @key(fields: "id") {
+			id: ID!
+			total: Float! @requiresScopes(scopes: [["billing:read"], ["billing:admin"]])
+			buyer: String @authenticated
+		}
+
+		type Query {
+			invoice(orderId: ID!): Invoice
+		}
+	`
+)
+
+// parseTestOperation parses query against the schema SDL
+func parseTestOperation(t *testing.T, sdl string, query string) *Operation {
+	schema, err := graphql.NewSchemaFromString(sdl)
+	require.NoError(t, err)
+	op, err := ParseOperation(schema, GraphQLRequest{Query: query})
+	require.NoError(t, err)
+	return op
+}
+
+func newTestAuthorizationPolicy(subgraphs ...engine.SubgraphConfiguration) *AuthorizationPolicy {
+	policy := NewAuthorizationPolicy()
+	policy.UpdateDataSources(subgraphs)
+	return policy
+}
+
+func claimsWithScopes(scopes ...string) *models.Claims {
+	return &models.Claims{UserID: "user-1", Scopes: scopes}
+}
+
+func TestAuthorizationRuleMerge(t *testing.T) {
+	read := authorizationRule{authenticated: true, scopes: [][]string{{"orders:read"}}}
+	billing := authorizationRule{authenticated: true, scopes: [][]string{{"billing:read"}, {"billing:admin"}}}
+
+	assert.Equal(t, read, authorizationRule{}.merge(read))
+	assert.Equal(t, read, read.merge(authorizationRule{}))
+	assert.Equal(t, authorizationRule{authenticated: true, scopes: [][]string{
+		{"orders:read", "billing:read"},
+		{"orders:read", "billing:admin"},
+	}}, read.merge(billing))
+}
+
+func TestAuthorizationPolicyCheck(t *testing.T) {
+	policy := newTestAuthorizationPolicy(
+		engine.SubgraphConfiguration{Name: "orders", SDL: authorizationOrdersSDL},
+		engine.SubgraphConfiguration{Name: "billing", SDL: authorizationBillingSDL},
+	)
+
+	tests := []struct {
+		name   string
+		query  string
+		claims *models.Claims
+		denied []authorizationError
+	}{
+		{
+			name:   "unguarded field without claims",
+			query:  `{ status }`,
+			claims: nil,
+		},
+		{
+			name:   "authenticated field without claims",
+			query:  `{ status order(id: "1") { id } }`,
+			claims: nil,
+			denied: []authorizationError{{Path: []string{"order"}, Code: UnauthenticatedCode}},
+		},
+		{
+			name:   "authenticated field with claims without scopes",
+			query:  `{ order(id: "1") { id } }`,
+			claims: claimsWithScopes(),
+		},
+		{
+			name:   "rules of a field merged across subgraphs",
+			query:  `{ order(id: "1") { total } }`,
+			claims: claimsWithScopes("orders:read"),
+			denied: []authorizationError{{Path: []string{"order", "total"}, Code: ForbiddenCode}},
+		},
+		{
+			name:   "rules of a field merged across subgraphs satisfied by an alternative",
+			query:  `{ order(id: "1") { total } }`,
+			claims: claimsWithScopes("orders:read", "billing:admin"),
+		},
+		{
+			name:   "rule of the returned type",
+			query:  `{ invoice(orderId: "1") { id } }`,
+			claims: claimsWithScopes("orders:read"),
+			denied: []authorizationError{{Path: []string{"invoice"}, Code: ForbiddenCode}},
+		},
+		{
+			name:   "rule of the returned type satisfied",
+			query:  `{ invoice(orderId: "1") { id amount } }`,
+			claims: claimsWithScopes("billing:read"),
+		},
+		{
+			name:   "inline fragment on an interface",
+			query:  `{ node(id: "1") { id ... on Order { total buyer } ... on Invoice { amount } } }`,
+			claims: claimsWithScopes("billing:read"),
+			denied: []authorizationError{{Path: []string{"node", "total"}, Code: ForbiddenCode}},
+		},
+		{
+			name:   "inline fragment on an interface without claims",
+			query:  `{ node(id: "1") { id ... on Order { buyer } } }`,
+			claims: nil,
+			denied: []authorizationError{{Path: []string{"node", "buyer"}, Code: UnauthenticatedCode}},
+		},
+		{
+			name:   "aliases in the paths",
+			query:  `{ mine: order(id: "1") { id cost: total } theirs: order(id: "2") { total } }`,
+			claims: claimsWithScopes("billing:read"),
+			denied: []authorizationError{
+				{Path: []string{"mine", "cost"}, Code: ForbiddenCode},
+				{Path: []string{"theirs", "total"}, Code: ForbiddenCode},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := parseTestOperation(t, authorizationTestSchema, tt.query)
+			assert.Equal(t, tt.denied, policy.Check(op, tt.claims))
+		})
+	}
+}
+
+func TestAuthorizationPolicyOrderService(t *testing.T) {
+	sdl, err := os.ReadFile("../../order-service/graph/schema.graphqls")
+	require.NoError(t, err)
+	policy := newTestAuthorizationPolicy(engine.SubgraphConfiguration{Name: "orders", SDL: string(sdl)})
+
+	const updateOrderDetail = `mutation { updateOrderDetail(orderDetailId: "6f1c2a4e-8d0b-4c1e-9a3f-2b7d5e6f8a9c", quantity: 2) { id quantity } }`
+
+	tests := []struct {
+		name   string
+		claims *models.Claims
+		denied []authorizationError
+	}{
+		{
+			name:   "anonymous",
+			denied: []authorizationError{{Path: []string{"updateOrderDetail"}, Code: UnauthenticatedCode}},
+		},
+		{
+			name:   "customer",
+			claims: claimsWithScopes("orders:read", "orders:write"),
+			denied: []authorizationError{{Path: []string{"updateOrderDetail"}, Code: ForbiddenCode}},
+		},
+		{
+			name:   "support",
+			claims: claimsWithScopes("orders:read", "orders:write", "orders:admin"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := parseTestOperation(t, string(sdl), updateOrderDetail)
+			assert.Equal(t, tt.denied, policy.Check(op, tt.claims))
+		})
+	}
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	policy := newTestAuthorizationPolicy(
+		engine.SubgraphConfiguration{Name: "orders", SDL: authorizationOrdersSDL},
+		engine.SubgraphConfiguration{Name: "billing", SDL: authorizationBillingSDL},
+	)
+	handler := AuthorizationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{}}`))
+	}), policy, log.NoopLogger)
+
+	serve := func(op *Operation, claims *models.Claims) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader("{}"))
+		ctx := r.Context()
+		if op != nil {
+			ctx = context.WithValue(ctx, operationContextKey, op)
+		}
+		if claims != nil {
+			ctx = context.WithValue(ctx, claimsContextKey, claims)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		return w
+	}
+
+	t.Run("denied fields", func(t *testing.T) {
+		op := parseTestOperation(t, authorizationTestSchema, `{ mine: order(id: "1") { cost: total } }`)
+		w := serve(op, claimsWithScopes())
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"data":null,"errors":[{
+			"message":"Not authorized to query field mine.cost",
+			"path":["mine","cost"],
+			"extensions":{"code":"FORBIDDEN"}
+		}]}`, w.Body.String())
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		op := parseTestOperation(t, authorizationTestSchema, `{ order(id: "1") { total } }`)
+		w := serve(op, claimsWithScopes("orders:read", "billing:read"))
+		assert.Equal(t, `{"data":{}}`, w.Body.String())
+	})
+
+	t.Run("unparsed operation", func(t *testing.T) {
+		w := serve(nil, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), BadRequestCode)
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/astparser"
+)
+
+// CacheScope tells whether a cached response may be shared between users
+type CacheScope string
+
+const (
+	CacheScopePublic  CacheScope = "PUBLIC"
+	CacheScopePrivate CacheScope = "PRIVATE"
+)
+
+const cacheControlDirective = "cacheControl"
+
+// CachePolicy is the caching behavior computed for a single operation
+type CachePolicy struct {
+	MaxAge time.Duration
+	Scope  CacheScope
+}
+
+// Cacheable reports whether the response may be cached at all
+func (p CachePolicy) Cacheable() bool {
+	return p.MaxAge > 0
+}
+
+// cacheHint is a parsed @cacheControl(maxAge:, scope:) directive
+type cacheHint struct {
+	maxAge    time.Duration
+	hasMaxAge bool
+	scope     CacheScope
+}
+
+// fieldInfo describes a field as declared in a subgraph SDL
+type fieldInfo struct {
+	typeName string
+	hint     *cacheHint
+}
+
+// CacheControlPolicy computes cache policies from @cacheControl hints declared in subgraph SDLs.
+// It is registered as a DataSourceObserver so the hints follow schema updates.
+type CacheControlPolicy struct {
+	defaultMaxAge time.Duration
+
+	mu          sync.RWMutex
+	fields      map[string]map[string]fieldInfo
+	typeHints   map[string]cacheHint
+	objectTypes map[string]struct{}
+}
+
+// NewCacheControlPolicy creates a policy that applies defaultMaxAge to root and
+// object fields without a hint
+func NewCacheControlPolicy(defaultMaxAge time.Duration) *CacheControlPolicy {
+	return &CacheControlPolicy{
+		defaultMaxAge: defaultMaxAge,
+		fields:        make(map[string]map[string]fieldInfo),
+		typeHints:     make(map[string]cacheHint),
+		objectTypes:   make(map[string]struct{}),
+	}
+}
+
+// UpdateDataSources implements DataSourceObserver
+func (p *CacheControlPolicy) UpdateDataSources(subgraphsConfigs []engine.SubgraphConfiguration) {
+	fields := make(map[string]map[string]fieldInfo)
+	typeHints := make(map[string]cacheHint)
+	objectTypes := make(map[string]struct{})
+
+	for _, subgraph := range subgraphsConfigs {
+		doc, report := astparser.ParseGraphqlDocumentString(subgraph.SDL)
+		if report.HasErrors() {
+			log.Printf("Failed to parse SDL of %s for cache hints: %v", subgraph.Name, report)
+			continue
+		}
+		collectCacheHints(&doc, fields, typeHints)
+
+		for i := range doc.ObjectTypeDefinitions {
+			objectTypes[doc.ObjectTypeDefinitionNameString(i)] = struct{}{}
+		}
+		for i := range doc.ObjectTypeExtensions {
+			objectTypes[doc.ObjectTypeExtensionNameString(i)] = struct{}{}
+		}
+	}
+
+	p.mu.Lock()
+	p.fields = fields
+	p.typeHints = typeHints
+	p.objectTypes = objectTypes
+	p.mu.Unlock()
+}
+
+// collectCacheHints adds the fields and @cacheControl hints of a subgraph SDL
+func collectCacheHints(doc *ast.Document, fields map[string]map[string]fieldInfo, typeHints map[string]cacheHint) {
+	addType := func(typeName string, directiveRefs []int, fieldRefs []int) {
+		if hint, ok := parseCacheHint(doc, directiveRefs); ok {
+			typeHints[typeName] = mergeCacheHints(typeHints[typeName], hint)
+		}
+
+		if fields[typeName] == nil {
+			fields[typeName] = make(map[string]fieldInfo)
+		}
+		for _, fieldRef := range fieldRefs {
+			info := fieldInfo{typeName: doc.ResolveTypeNameString(doc.FieldDefinitionType(fieldRef))}
+			if hint, ok := parseCacheHint(doc, doc.FieldDefinitionDirectives(fieldRef)); ok {
+				info.hint = &hint
+			}
+
+			name := doc.FieldDefinitionNameString(fieldRef)
+			// The same entity field may be declared by several subgraphs, keep the strictest hint
+			if existing, ok := fields[typeName][name]; ok && existing.hint != nil {
+				if info.hint == nil {
+					info.hint = existing.hint
+				} else {
+					merged := mergeCacheHints(*existing.hint, *info.hint)
+					info.hint = &merged
+				}
+			}
+			fields[typeName][name] = info
+		}
+	}
+
+	for i := range doc.ObjectTypeDefinitions {
+		def := doc.ObjectTypeDefinitions[i]
+		addType(doc.ObjectTypeDefinitionNameString(i), def.Directives.Refs, def.FieldsDefinition.Refs)
+	}
+	for i := range doc.ObjectTypeExtensions {
+		ext := doc.ObjectTypeExtensions[i]
+		addType(doc.ObjectTypeExtensionNameString(i), ext.Directives.Refs, ext.FieldsDefinition.Refs)
+	}
+	for i := range doc.InterfaceTypeDefinitions {
+		def := doc.InterfaceTypeDefinitions[i]
+		addType(doc.InterfaceTypeDefinitionNameString(i), def.Directives.Refs, def.FieldsDefinition.Refs)
+	}
+	for i := range doc.InterfaceTypeExtensions {
+		ext := doc.InterfaceTypeExtensions[i]
+		addType(doc.InterfaceTypeExtensionNameString(i), ext.Directives.Refs, ext.FieldsDefinition.Refs)
+	}
+	for i := range doc.UnionTypeDefinitions {
+		addType(doc.UnionTypeDefinitionNameString(i), doc.UnionTypeDefinitions[i].Directives.Refs, nil)
+	}
+}
+
+// parseCacheHint reads the @cacheControl directive from a list of directives
+func parseCacheHint(doc *ast.Document, directiveRefs []int) (cacheHint, bool) {
+	for _, ref := range directiveRefs {
+		if doc.DirectiveNameString(ref) != cacheControlDirective {
+			continue
+		}
+
+		hint := cacheHint{scope: CacheScopePublic}
+		if value, ok := doc.DirectiveArgumentValueByName(ref, []byte("maxAge")); ok && value.Kind == ast.ValueKindInteger {
+			hint.maxAge = time.Duration(doc.IntValueAsInt(value.Ref)) * time.Second
+			hint.hasMaxAge = true
+		}
+		if value, ok := doc.DirectiveArgumentValueByName(ref, []byte("scope")); ok && value.Kind == ast.ValueKindEnum {
+			if strings.EqualFold(doc.EnumValueNameString(value.Ref), string(CacheScopePrivate)) {
+				hint.scope = CacheScopePrivate
+			}
+		}
+		return hint, true
+	}
+
+	return cacheHint{}, false
+}
+
+// mergeCacheHints combines two hints into the strictest of both
+func mergeCacheHints(a, b cacheHint) cacheHint {
+	merged := a
+	if merged.scope == "" {
+		merged.scope = b.scope
+	}
+	if b.scope == CacheScopePrivate {
+		merged.scope = CacheScopePrivate
+	}
+	if b.hasMaxAge && (!merged.hasMaxAge || b.maxAge < merged.maxAge) {
+		merged.maxAge = b.maxAge
+		merged.hasMaxAge = true
+	}
+	return merged
+}
+
+// Compute returns the cache policy of an operation. Like Apollo, the max age of the
+// response is the lowest max age of all its fields. Root fields and fields returning
+// objects without a hint get the default max age, scalar fields inherit from their parent.
+func (p *CacheControlPolicy) Compute(op *Operation) CachePolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	acc := &cachePolicyAccumulator{policy: CachePolicy{Scope: CacheScopePublic}}
+
+	operation := op.Document.OperationDefinitions[op.Ref]
+	if operation.HasSelections {
+		p.walk(op.Document, operation.SelectionSet, rootTypeName(operation.OperationType), true, acc)
+	}
+
+	if !acc.constrained {
+		acc.policy.MaxAge = p.defaultMaxAge
+	}
+
+	return acc.policy
+}
+
+// walk applies the hints of every field in a selection set
+func (p *CacheControlPolicy) walk(doc *ast.Document, selectionSet int, parentType string, isRoot bool, acc *cachePolicyAccumulator) {
+	for _, selectionRef := range doc.SelectionSets[selectionSet].SelectionRefs {
+		selection := doc.Selections[selectionRef]
+
+		switch selection.Kind {
+		case ast.SelectionKindField:
+			name := doc.FieldNameString(selection.Ref)
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+
+			field := doc.Fields[selection.Ref]
+			info, known := p.fields[parentType][name]
+
+			hint := info.hint
+			if hint == nil && known {
+				if typeHint, ok := p.typeHints[info.typeName]; ok {
+					hint = &typeHint
+				}
+			}
+
+			if hint != nil && hint.scope == CacheScopePrivate {
+				acc.policy.Scope = CacheScopePrivate
+			}
+
+			switch {
+			case hint != nil && hint.hasMaxAge:
+				acc.apply(hint.maxAge)
+			case isRoot || field.HasSelections || !known:
+				acc.apply(p.defaultMaxAge)
+			}
+
+			if field.HasSelections {
+				p.walk(doc, field.SelectionSet, info.typeName, false, acc)
+			}
+
+		case ast.SelectionKindInlineFragment:
+			fragment := doc.InlineFragments[selection.Ref]
+			typeName := parentType
+			if fragment.TypeCondition.Type != -1 {
+				typeName = doc.InlineFragmentTypeConditionNameString(selection.Ref)
+			}
+			if fragment.HasSelections {
+				p.walk(doc, fragment.SelectionSet, typeName, isRoot, acc)
+			}
+
+		default:
+			// Fragment spreads are inlined by normalization, anything else is not understood
+			acc.apply(p.defaultMaxAge)
+		}
+	}
+}
+
+// cachePolicyAccumulator keeps the lowest max age seen while walking an operation
+type cachePolicyAccumulator struct {
+	policy      CachePolicy
+	constrained bool
+}
+
+func (a *cachePolicyAccumulator) apply(maxAge time.Duration) {
+	if !a.constrained || maxAge < a.policy.MaxAge {
+		a.policy.MaxAge = maxAge
+		a.constrained = true
+	}
+}
+
+// rootTypeName returns the default root type name of an operation type
+func rootTypeName(operationType ast.OperationType) string {
+	switch operationType {
+	case ast.OperationTypeMutation:
+		return "Mutation"
+	case ast.OperationTypeSubscription:
+		return "Subscription"
+	default:
+		return "Query"
+	}
+}
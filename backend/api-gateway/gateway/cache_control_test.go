@@ -0,0 +1,206 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+)
+
+const (
+	// cacheControlTestSchema is the supergraph the operations are parsed against
+	cacheControlTestSchema = `
+		type Query {
+			orders: [Order!]!
+			order(id: ID!): Order
+			me: User
+			status: String
+			live: String
+			products: [Product!]!
+		}
+
+		type Order {
+			id: ID!
+			total: Float
+			note: String
+			product: Product
+		}
+
+		type User {
+			id: ID!
+			email: String
+		}
+
+		type Product {
+			id: ID!
+			name: String
+		}
+	`
+
+	cacheControlOrdersSDL = `
+		type Query {
+			orders: [Order!]! @cacheControl(maxAge: 60)
+			order(id: ID!): Order
+			me: User @cacheControl(maxAge: 300, scope: PRIVATE)
+			status: String
+			live: String @cacheControl(maxAge: 0)
+		}
+
+		type Order @key(fields: "id") @cacheControl(maxAge: 120) {
+			id: ID!
+			total: Float @cacheControl(maxAge: 30)
+			note: String
+		}
+
+		type User {
+			id: ID!
+			email: String
+		}
+	`
+
+	// cacheControlProductsSDL declares Order.total again with a stricter hint
+	cacheControlProductsSDL = `
+		type Order @key(fields: "id") {
+			id: ID!
+			total: Float @cacheControl(maxAge: 40, scope: PRIVATE)
+			product: Product
+		}
+
+		type Product @key(fields: "id") {
+			id: ID!
+			name: String
+		}
+
+		type Query {
+			products: [Product!]!
+		}
+	`
+)
+
+func newTestCacheControlPolicy() *CacheControlPolicy {
+	policy := NewCacheControlPolicy(100 * time.Second)
+	policy.UpdateDataSources([]engine.SubgraphConfiguration{
+		{Name: "orders", SDL: cacheControlOrdersSDL},
+		{Name: "products", SDL: cacheControlProductsSDL},
+	})
+	return policy
+}
+
+func newCacheControlTestSchema(t *testing.T) *graphql.Schema {
+	schema, err := graphql.NewSchemaFromString(cacheControlTestSchema)
+	require.NoError(t, err)
+	return schema
+}
+
+func TestMergeCacheHints(t *testing.T) {
+	public60 := cacheHint{maxAge: 60 * time.Second, hasMaxAge: true, scope: CacheScopePublic}
+	private30 := cacheHint{maxAge: 30 * time.Second, hasMaxAge: true, scope: CacheScopePrivate}
+	privateOnly := cacheHint{scope: CacheScopePrivate}
+
+	tests := []struct {
+		name   string
+		a, b   cacheHint
+		merged cacheHint
+	}{
+		{name: "lowest max age and private scope", a: public60, b: private30, merged: private30},
+		{name: "in either order", a: private30, b: public60, merged: private30},
+		{name: "scope without max age", a: public60, b: privateOnly, merged: cacheHint{maxAge: 60 * time.Second, hasMaxAge: true, scope: CacheScopePrivate}},
+		{name: "max age onto scope only", a: privateOnly, b: public60, merged: cacheHint{maxAge: 60 * time.Second, hasMaxAge: true, scope: CacheScopePrivate}},
+		{name: "onto nothing", a: cacheHint{}, b: public60, merged: public60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.merged, mergeCacheHints(tt.a, tt.b))
+		})
+	}
+}
+
+func TestCacheControlPolicyCompute(t *testing.T) {
+	schema := newCacheControlTestSchema(t)
+	policy := newTestCacheControlPolicy()
+
+	tests := []struct {
+		name   string
+		query  string
+		policy CachePolicy
+	}{
+		{
+			name:   "root field without a hint",
+			query:  `{ status }`,
+			policy: CachePolicy{MaxAge: 100 * time.Second, Scope: CacheScopePublic},
+		},
+		{
+			name:   "root field hint inherited by scalars",
+			query:  `{ orders { id note } }`,
+			policy: CachePolicy{MaxAge: 60 * time.Second, Scope: CacheScopePublic},
+		},
+		{
+			name:   "hint of the returned type",
+			query:  `{ order(id: "1") { id } }`,
+			policy: CachePolicy{MaxAge: 120 * time.Second, Scope: CacheScopePublic},
+		},
+		{
+			name:   "strictest hint of a field declared by several subgraphs",
+			query:  `{ order(id: "1") { total } }`,
+			policy: CachePolicy{MaxAge: 30 * time.Second, Scope: CacheScopePrivate},
+		},
+		{
+			name:   "private root field",
+			query:  `{ me { email } status }`,
+			policy: CachePolicy{MaxAge: 100 * time.Second, Scope: CacheScopePrivate},
+		},
+		{
+			name:   "object field without a hint gets the default",
+			query:  `{ orders { product { name } } }`,
+			policy: CachePolicy{MaxAge: 60 * time.Second, Scope: CacheScopePublic},
+		},
+		{
+			name:   "lowest max age across root fields",
+			query:  `{ orders { id } order(id: "1") { id } products { name } }`,
+			policy: CachePolicy{MaxAge: 60 * time.Second, Scope: CacheScopePublic},
+		},
+		{
+			name:   "uncacheable field",
+			query:  `{ orders { id } live }`,
+			policy: CachePolicy{MaxAge: 0, Scope: CacheScopePublic},
+		},
+		{
+			name:   "introspection only",
+			query:  `{ __typename }`,
+			policy: CachePolicy{MaxAge: 100 * time.Second, Scope: CacheScopePublic},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := ParseOperation(schema, GraphQLRequest{Query: tt.query})
+			require.NoError(t, err)
+			assert.Equal(t, tt.policy, policy.Compute(op))
+		})
+	}
+}
+
+func TestCacheControlPolicyFollowsSchemaUpdates(t *testing.T) {
+	schema := newCacheControlTestSchema(t)
+	policy := newTestCacheControlPolicy()
+
+	op, err := ParseOperation(schema, GraphQLRequest{Query: `{ me { email } }`})
+	require.NoError(t, err)
+	require.Equal(t, CacheScopePrivate, policy.Compute(op).Scope)
+
+	// The hint is gone once the subgraph drops it, an unparsable SDL contributes nothing
+	policy.UpdateDataSources([]engine.SubgraphConfiguration{
+		{Name: "orders", SDL: `type Query { me: User } type User { id: ID! email: String }`},
+		{Name: "products", SDL: `type Query {`},
+	})
+	assert.Equal(t, CachePolicy{MaxAge: 100 * time.Second, Scope: CacheScopePublic}, policy.Compute(op))
+}
+
+func TestCacheControlHeader(t *testing.T) {
+	assert.Equal(t, "max-age=60, public", cacheControlHeader(CachePolicy{MaxAge: time.Minute, Scope: CacheScopePublic}))
+	assert.Equal(t, "max-age=30, private", cacheControlHeader(CachePolicy{MaxAge: 30 * time.Second, Scope: CacheScopePrivate}))
+}
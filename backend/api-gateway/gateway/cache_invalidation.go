@@ -0,0 +1,128 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// Order events that change cached entities
+const (
+	orderUpdatedEvent   = "order_updated"
+	orderCancelledEvent = "order_cancelled"
+)
+
+// orderEvent is an EventBridge event as delivered by an API destination
+type orderEvent struct {
+	DetailType string          `json:"detail-type"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// orderEventDetail holds the identifiers of an order or order detail event payload. Both
+// are the id of the order in order_cancelled events, which list the cancelled items.
+type orderEventDetail struct {
+	ID             string   `json:"id"`
+	OrderID        string   `json:"orderId"`
+	OrderDetailIDs []string `json:"orderDetailIds"`
+}
+
+// orderEventTags returns the entity tags changed by an order event
+func orderEventTags(event orderEvent) ([]string, error) {
+	if event.DetailType != orderUpdatedEvent && event.DetailType != orderCancelledEvent {
+		return nil, nil
+	}
+
+	// The detail is a JSON object, or a JSON string holding one
+	raw := event.Detail
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+
+	var detail orderEventDetail
+	if err := json.Unmarshal(raw, &detail); err != nil {
+		return nil, err
+	}
+	// Cancellations are order events, whose orderId is the order itself. Every item of the
+	// order is cancelled with it, so the responses holding the items are evicted too.
+	if event.DetailType == orderCancelledEvent {
+		orderID := detail.ID
+		if orderID == "" {
+			orderID = detail.OrderID
+		}
+		if orderID == "" {
+			return nil, nil
+		}
+		tags := InvalidationTags(EntityTag("Order", orderID))
+		if len(detail.OrderDetailIDs) > 0 {
+			tags = append(tags, "OrderDetail")
+			for _, orderDetailID := range detail.OrderDetailIDs {
+				tags = append(tags, EntityTag("OrderDetail", orderDetailID))
+			}
+		}
+		return tags, nil
+	}
+
+	if detail.ID == "" {
+		return nil, nil
+	}
+
+	// Order detail events also change the order they belong to
+	if detail.OrderID != "" {
+		tags := InvalidationTags(EntityTag("OrderDetail", detail.ID))
+		return append(tags, InvalidationTags(EntityTag("Order", detail.OrderID))...), nil
+	}
+
+	return InvalidationTags(EntityTag("Order", detail.ID)), nil
+}
+
+// CacheInvalidationHandler receives order-service events from an EventBridge API destination
+// and evicts the cached responses holding the changed orders. Requests must carry the
+// shared token as a bearer token.
+func CacheInvalidationHandler(token string, cacheService *redis.CacheService, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		var event orderEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, "Invalid event", http.StatusBadRequest)
+			return
+		}
+
+		tags, err := orderEventTags(event)
+		if err != nil {
+			http.Error(w, "Invalid event detail", http.StatusBadRequest)
+			return
+		}
+
+		evicted := 0
+		if len(tags) > 0 {
+			evicted, err = cacheService.InvalidateEntityTags(r.Context(), tags...)
+			if err != nil {
+				logger.Error("Failed to invalidate cache for event", log.String("detail_type", event.DetailType), log.Error(err))
+				http.Error(w, "Failed to invalidate cache", http.StatusInternalServerError)
+				return
+			}
+			logger.Info("Invalidated cache for event", log.String("detail_type", event.DetailType), log.Strings("tags", tags), log.Int("evicted", evicted))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tags":    tags,
+			"evicted": evicted,
+		})
+	}
+}
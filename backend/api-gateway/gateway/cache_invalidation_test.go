@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testOrderID        = "0b7e3f3c-52a4-4f7e-9a55-3e0f1c6d2a10"
+	testOrderDetailID  = "5d2c8a61-0f4b-4b8e-8f0e-6c1f0a9b7e22"
+	otherOrderDetailID = "8f4e2b19-3c6a-4d0f-a1b7-2e9c5d8f0a63"
+)
+
+// cancelledDetail is an order_cancelled detail as emitted by the order service
+const cancelledDetail = `{"id":"` + testOrderID + `","orderId":"` + testOrderID + `","userId":"9a1d4c3e-7b2f-4e6a-8d5c-1f0e2b3a4c5d","orderDetailIds":["` + testOrderDetailID + `","` + otherOrderDetailID + `"],"items":[{"productId":"c3e1f2a4-6b5d-4e7f-8a9b-0c1d2e3f4a5b","quantity":2}],"reason":"order_cancelled","cancelledAt":"2026-10-17T00:00:00Z"}`
+
+// encodedEvent returns an event as delivered by the API destination, whose detail is the
+// JSON string the order service puts on the bus
+func encodedEvent(t *testing.T, detailType, detail string) orderEvent {
+	encoded, err := json.Marshal(detail)
+	require.NoError(t, err)
+	return orderEvent{DetailType: detailType, Detail: encoded}
+}
+
+func TestOrderEventTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		event orderEvent
+		tags  []string
+	}{
+		{
+			name:  "cancelled order",
+			event: encodedEvent(t, orderCancelledEvent, cancelledDetail),
+			tags:  []string{"Order:" + testOrderID, "Order", "OrderDetail", "OrderDetail:" + testOrderDetailID, "OrderDetail:" + otherOrderDetailID},
+		},
+		{
+			name:  "cancelled order as a JSON object",
+			event: orderEvent{DetailType: orderCancelledEvent, Detail: json.RawMessage(cancelledDetail)},
+			tags:  []string{"Order:" + testOrderID, "Order", "OrderDetail", "OrderDetail:" + testOrderDetailID, "OrderDetail:" + otherOrderDetailID},
+		},
+		{
+			name:  "cancelled order without id",
+			event: encodedEvent(t, orderCancelledEvent, `{"orderId":"`+testOrderID+`"}`),
+			tags:  []string{"Order:" + testOrderID, "Order"},
+		},
+		{
+			name:  "updated order detail",
+			event: encodedEvent(t, orderUpdatedEvent, `{"id":"`+testOrderDetailID+`","orderId":"`+testOrderID+`","status":"completed"}`),
+			tags:  []string{"OrderDetail:" + testOrderDetailID, "OrderDetail", "Order:" + testOrderID, "Order"},
+		},
+		{
+			name:  "updated order",
+			event: encodedEvent(t, orderUpdatedEvent, `{"id":"`+testOrderID+`"}`),
+			tags:  []string{"Order:" + testOrderID, "Order"},
+		},
+		{
+			name:  "event of another type",
+			event: encodedEvent(t, "order_placed", `{"id":"`+testOrderID+`"}`),
+		},
+		{
+			name:  "event without identifiers",
+			event: encodedEvent(t, orderCancelledEvent, `{"reason":"timeout"}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := orderEventTags(tt.event)
+			require.NoError(t, err)
+			assert.Equal(t, tt.tags, tags)
+		})
+	}
+}
+
+func TestOrderEventTagsRejectsInvalidDetail(t *testing.T) {
+	_, err := orderEventTags(orderEvent{DetailType: orderCancelledEvent, Detail: json.RawMessage(`[1, 2]`)})
+	assert.Error(t, err)
+}
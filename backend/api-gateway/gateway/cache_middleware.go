@@ -1,28 +1,48 @@
 package main
 
 import (
+	"api-gateway/metrics"
 	"api-gateway/redis"
+	"api-gateway/tracing"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
-	"time"
 
 	log "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
 )
 
 // GraphQLRequest represents a GraphQL query request
 type GraphQLRequest struct {
-	Query         string                 `json:"query"`
-	Variables     map[string]interface{} `json:"variables"`
-	OperationName string                 `json:"operationName"`
+	Query         string          `json:"query"`
+	Variables     json.RawMessage `json:"variables,omitempty"`
+	OperationName string          `json:"operationName,omitempty"`
+	// Extensions are kept raw: the engine ignores extensions it does not understand, so a
+	// request must not fail to decode here on one the gateway does not use
+	Extensions json.RawMessage `json:"extensions,omitempty"`
 }
 
-// GraphQLCacheMiddleware caches GraphQL query responses
-func GraphQLCacheMiddleware(next http.Handler, cacheService *redis.CacheService, logger log.Logger) http.Handler {
+// graphQLResponse is the part of a GraphQL response inspected before caching
+type graphQLResponse struct {
+	Errors []json.RawMessage `json:"errors"`
+}
+
+// GraphQLCacheMiddleware caches GraphQL query responses.
+// Requests are parsed against the composed schema, so the operation selected by
+// operationName decides whether the request is cacheable, and the cache key is built
+// from the normalized query and canonical variables. The TTL and scope come from the
+// @cacheControl hints of the subgraphs; PRIVATE responses are keyed by the JWT subject.
+// It must run after JWTMiddleware.
+func GraphQLCacheMiddleware(next http.Handler, schemaProvider SchemaProvider, policy *CacheControlPolicy, cacheService *redis.CacheService, logger log.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartRequest(r, "gateway.cache")
+		defer span.End()
+
 		// Only cache POST requests to /query endpoint
 		if r.Method != http.MethodPost {
 			next.ServeHTTP(w, r)
@@ -37,32 +57,90 @@ func GraphQLCacheMiddleware(next http.Handler, cacheService *redis.CacheService,
 			return
 		}
 		defer r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
 
 		// Parse GraphQL request
 		var gqlReq GraphQLRequest
 		if err := json.Unmarshal(body, &gqlReq); err != nil {
 			logger.Error("Failed to parse GraphQL request", log.Error(err))
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Skip caching for mutations
-		if isMutation(gqlReq.Query) {
-			logger.Debug("Skipping cache for mutation")
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
+		// Reuse the operation parsed by QueryCostMiddleware
+		op, ok := OperationFromContext(r.Context())
+		if !ok {
+			schema := schemaProvider.Schema()
+			if schema == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Invalid requests are left to the gateway to report
+			op, err = ParseOperation(schema, gqlReq)
+			if err != nil {
+				logger.Debug("Skipping cache for unparsable operation", log.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Mutations evict the cached responses holding the entities they return
+		if op.Type == ast.OperationTypeMutation {
+			rec := httptest.NewRecorder()
+			next.ServeHTTP(rec, r)
+
+			if rec.Code == http.StatusOK {
+				invalidateMutatedEntities(r.Context(), op, rec.Body.Bytes(), policy, cacheService, logger)
+			}
+
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			return
+		}
+
+		// Skip caching for subscriptions
+		if !op.IsQuery() {
+			logger.Debug("Skipping cache for non-query operation")
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Generate cache key
-		cacheKey := cacheService.GenerateQueryCacheKey(gqlReq.Query, gqlReq.Variables)
+		cachePolicy := policy.Compute(op)
+		if !cachePolicy.Cacheable() {
+			logger.Debug("Skipping cache for operation with max age 0")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope := "public"
+		if cachePolicy.Scope == CacheScopePrivate {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok || claimsSubject(claims) == "" {
+				logger.Debug("Skipping cache for private operation without subject")
+				next.ServeHTTP(w, r)
+				return
+			}
+			scope = "private:" + claimsSubject(claims)
+		}
+
+		// Generate cache key, persisted queries are identified by their hash
+		document := op.Query
+		if hash, ok := PersistedQueryHashFromContext(r.Context()); ok && hash != "" {
+			document = "pq:" + hash + ":" + op.Name
+		}
+		cacheKey := cacheService.GenerateQueryCacheKey(document, op.Variables, scope)
 
 		// Try to get from cache
 		cached, hit, err := cacheService.GetQueryCache(r.Context(), cacheKey)
 		if hit && err == nil {
+			metrics.CacheLookups.WithLabelValues("hit").Inc()
 			logger.Info("Cache hit", log.String("key", cacheKey))
 			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", cacheControlHeader(cachePolicy))
 			w.Header().Set("X-Cache", "HIT")
 			w.Header().Set("X-Cache-Key", cacheKey[:16]+"...") // Show first 16 chars
 			w.WriteHeader(http.StatusOK)
@@ -74,24 +152,25 @@ func GraphQLCacheMiddleware(next http.Handler, cacheService *redis.CacheService,
 			logger.Error("Cache lookup failed", log.Error(err))
 		}
 
+		metrics.CacheLookups.WithLabelValues("miss").Inc()
 		logger.Debug("Cache miss", log.String("key", cacheKey))
 
 		// Cache miss - capture response
 		rec := httptest.NewRecorder()
-		r.Body = io.NopCloser(bytes.NewBuffer(body)) // Restore body
 		next.ServeHTTP(rec, r)
 
 		// Get response
 		response := rec.Body.Bytes()
 
-		// Only cache successful responses (200 OK)
-		if rec.Code == http.StatusOK && len(response) > 0 {
-			// Store in cache with 5 minute TTL
-			if err := cacheService.SetQueryCache(r.Context(), cacheKey, response, 5*time.Minute); err != nil {
+		// Only cache successful responses (200 OK without GraphQL errors)
+		if rec.Code == http.StatusOK && len(response) > 0 && !hasGraphQLErrors(response) {
+			tags := policy.EntityTags(op, response)
+			if err := cacheService.SetQueryCacheWithTags(r.Context(), cacheKey, response, cachePolicy.MaxAge, tags); err != nil {
 				logger.Error("Failed to set cache", log.Error(err))
 			} else {
 				logger.Info("Cached response", log.String("key", cacheKey), log.Int("size", len(response)))
 			}
+			w.Header().Set("Cache-Control", cacheControlHeader(cachePolicy))
 		}
 
 		// Send response to client
@@ -105,9 +184,35 @@ func GraphQLCacheMiddleware(next http.Handler, cacheService *redis.CacheService,
 	})
 }
 
-// isMutation checks if the query is a mutation
-func isMutation(query string) bool {
-	// Simple check - look for mutation keyword
-	trimmed := strings.TrimSpace(strings.ToLower(query))
-	return strings.HasPrefix(trimmed, "mutation")
+// invalidateMutatedEntities evicts the cached responses that contain an entity returned by a mutation
+func invalidateMutatedEntities(ctx context.Context, op *Operation, response []byte, policy *CacheControlPolicy, cacheService *redis.CacheService, logger log.Logger) {
+	var tags []string
+	for _, tag := range policy.EntityTags(op, response) {
+		tags = append(tags, InvalidationTags(tag)...)
+	}
+	if len(tags) == 0 {
+		return
+	}
+
+	evicted, err := cacheService.InvalidateEntityTags(ctx, tags...)
+	if err != nil {
+		logger.Error("Failed to invalidate cache after mutation", log.Error(err))
+		return
+	}
+
+	logger.Info("Invalidated cache after mutation", log.Strings("tags", tags), log.Int("evicted", evicted))
+}
+
+// hasGraphQLErrors checks if a response contains GraphQL errors, including partial ones
+func hasGraphQLErrors(response []byte) bool {
+	var resp graphQLResponse
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return true
+	}
+	return len(resp.Errors) > 0
+}
+
+// cacheControlHeader renders a cache policy as an HTTP Cache-Control header
+func cacheControlHeader(policy CachePolicy) string {
+	return fmt.Sprintf("max-age=%d, %s", int(policy.MaxAge.Seconds()), strings.ToLower(string(policy.Scope)))
 }
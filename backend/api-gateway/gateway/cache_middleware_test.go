@@ -0,0 +1,176 @@
+package main
+
+import (
+	"api-gateway/models"
+	"api-gateway/redis"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler answers every request with its response and counts them
+type countingHandler struct {
+	response string
+	served   int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.served++
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(h.response))
+}
+
+func newTestCacheMiddleware(t *testing.T, next http.Handler) http.Handler {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cacheService := redis.NewCacheService(client, log.NoopLogger)
+
+	return GraphQLCacheMiddleware(next, staticSchema{newCacheControlTestSchema(t)}, newTestCacheControlPolicy(), cacheService, log.NoopLogger)
+}
+
+// serveCached posts body to handler with the claims of subject, if any
+func serveCached(handler http.Handler, body string, subject string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+	if subject != "" {
+		claims := &models.Claims{StandardClaims: jwt.StandardClaims{Subject: subject}}
+		r = r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims))
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestGraphQLCacheMiddlewarePublic(t *testing.T) {
+	next := &countingHandler{response: `{"data":{"orders":[{"id":"1","note":"gift"}]}}`}
+	handler := newTestCacheMiddleware(t, next)
+
+	w := serveCached(handler, `{"query":"query Orders { orders { id note } }"}`, "")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "max-age=60, public", w.Header().Get("Cache-Control"))
+	assert.Equal(t, next.response, w.Body.String())
+
+	// Equivalent requests share the entry, whoever sends them
+	for _, body := range []string{
+		`{"query":"query Orders { orders { id note } }"}`,
+		`{"query":"query Orders {\n  orders {\n    ...F\n  }\n}\nfragment F on Order { id note }"}`,
+		`{"operationName":"Orders","query":"query Orders { orders { id note } }","variables":null}`,
+	} {
+		w = serveCached(handler, body, "user-2")
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"), body)
+		assert.Equal(t, "max-age=60, public", w.Header().Get("Cache-Control"))
+		assert.Equal(t, next.response, w.Body.String())
+	}
+	assert.Equal(t, 1, next.served)
+
+	// Another selection is another entry
+	w = serveCached(handler, `{"query":"query Orders { orders { id } }"}`, "")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, 2, next.served)
+}
+
+func TestGraphQLCacheMiddlewareVariables(t *testing.T) {
+	next := &countingHandler{response: `{"data":{"order":{"id":"1"}}}`}
+	handler := newTestCacheMiddleware(t, next)
+
+	serveCached(handler, `{"query":"query O($id: ID!, $other: ID!) { order(id: $id) { id } b: order(id: $other) { id } }","variables":{"id":"1","other":"2"}}`, "")
+	w := serveCached(handler, `{"query":"query O($id: ID!, $other: ID!) { order(id: $id) { id } b: order(id: $other) { id } }","variables":{"other":"2","id":"1"}}`, "")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"), "order of the variables")
+
+	serveCached(handler, `{"query":"{ order(id: \"1\") { id } }"}`, "")
+	w = serveCached(handler, `{"query":"{ order(id: \"1\") { id } }"}`, "")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	w = serveCached(handler, `{"query":"{ order(id: \"2\") { id } }"}`, "")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"), "other literal")
+
+	assert.Equal(t, 3, next.served)
+}
+
+func TestGraphQLCacheMiddlewarePrivate(t *testing.T) {
+	next := &countingHandler{response: `{"data":{"me":{"id":"1","email":"alice@example.com"}}}`}
+	handler := newTestCacheMiddleware(t, next)
+	const body = `{"query":"{ me { id email } }"}`
+
+	w := serveCached(handler, body, "alice")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "max-age=300, private", w.Header().Get("Cache-Control"))
+
+	w = serveCached(handler, body, "alice")
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, 1, next.served)
+
+	// Private responses are keyed by the subject
+	w = serveCached(handler, body, "bob")
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, 2, next.served)
+
+	// and not cached without one
+	for i := 0; i < 2; i++ {
+		w = serveCached(handler, body, "")
+		assert.Empty(t, w.Header().Get("X-Cache"))
+		assert.Empty(t, w.Header().Get("Cache-Control"))
+	}
+	assert.Equal(t, 4, next.served)
+}
+
+func TestGraphQLCacheMiddlewareSkips(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		response string
+	}{
+		{
+			name:     "GET",
+			method:   http.MethodGet,
+			response: `{"data":{"status":"ok"}}`,
+		},
+		{
+			name:     "uncacheable field",
+			method:   http.MethodPost,
+			body:     `{"query":"{ live }"}`,
+			response: `{"data":{"live":"now"}}`,
+		},
+		{
+			name:     "response with errors",
+			method:   http.MethodPost,
+			body:     `{"query":"{ status }"}`,
+			response: `{"data":{"status":null},"errors":[{"message":"unavailable"}]}`,
+		},
+		{
+			name:     "unparsable operation",
+			method:   http.MethodPost,
+			body:     `{"query":"{ status "}`,
+			response: `{"errors":[{"message":"parse error"}]}`,
+		},
+		{
+			name:     "body that is not a GraphQL request",
+			method:   http.MethodPost,
+			body:     `{ status }`,
+			response: `{"errors":[{"message":"bad request"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &countingHandler{response: tt.response}
+			handler := newTestCacheMiddleware(t, next)
+
+			for i := 0; i < 2; i++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/query", strings.NewReader(tt.body)))
+				assert.Equal(t, tt.response, w.Body.String())
+				assert.NotEqual(t, "HIT", w.Header().Get("X-Cache"))
+			}
+			assert.Equal(t, 2, next.served)
+		})
+	}
+}
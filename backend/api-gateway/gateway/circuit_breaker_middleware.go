@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	goredis "github.com/go-redis/redis/v8"
@@ -140,6 +141,7 @@ func handleCircuitBreakerError(w http.ResponseWriter, r *http.Request, err error
 
 // CircuitBreakerManager manages multiple circuit breakers for different services
 type CircuitBreakerManager struct {
+	mu       sync.RWMutex
 	breakers map[string]*redis.CircuitBreaker
 	client   *goredis.Client
 	logger   log.Logger
@@ -156,6 +158,9 @@ func NewCircuitBreakerManager(client *goredis.Client, logger log.Logger) *Circui
 
 // GetOrCreateBreaker gets or creates a circuit breaker for a service
 func (m *CircuitBreakerManager) GetOrCreateBreaker(serviceName string, config redis.CircuitBreakerConfig) *redis.CircuitBreaker {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if breaker, exists := m.breakers[serviceName]; exists {
 		return breaker
 	}
@@ -167,6 +172,9 @@ func (m *CircuitBreakerManager) GetOrCreateBreaker(serviceName string, config re
 
 // GetBreaker returns a circuit breaker by name
 func (m *CircuitBreakerManager) GetBreaker(serviceName string) (*redis.CircuitBreaker, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	breaker, exists := m.breakers[serviceName]
 	return breaker, exists
 }
@@ -175,7 +183,7 @@ func (m *CircuitBreakerManager) GetBreaker(serviceName string) (*redis.CircuitBr
 func (m *CircuitBreakerManager) GetAllMetrics(ctx context.Context) map[string]interface{} {
 	metrics := make(map[string]interface{})
 
-	for name, breaker := range m.breakers {
+	for name, breaker := range m.snapshot() {
 		if breakerMetrics, err := breaker.GetMetrics(ctx); err == nil {
 			metrics[name] = breakerMetrics
 		}
@@ -186,7 +194,7 @@ func (m *CircuitBreakerManager) GetAllMetrics(ctx context.Context) map[string]in
 
 // ResetAll resets all circuit breakers
 func (m *CircuitBreakerManager) ResetAll(ctx context.Context) error {
-	for _, breaker := range m.breakers {
+	for _, breaker := range m.snapshot() {
 		if err := breaker.Reset(ctx); err != nil {
 			return err
 		}
@@ -194,6 +202,18 @@ func (m *CircuitBreakerManager) ResetAll(ctx context.Context) error {
 	return nil
 }
 
+// snapshot returns a copy of the registered breakers that is safe to iterate
+func (m *CircuitBreakerManager) snapshot() map[string]*redis.CircuitBreaker {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	breakers := make(map[string]*redis.CircuitBreaker, len(m.breakers))
+	for name, breaker := range m.breakers {
+		breakers[name] = breaker
+	}
+	return breakers
+}
+
 // HealthCheckHandler provides circuit breaker health status
 func (m *CircuitBreakerManager) HealthCheckHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"api-gateway/redis"
+	"api-gateway/schemadiff"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -31,13 +32,6 @@ type DatasourcePollerConfig struct {
 	PollingInterval time.Duration
 }
 
-const ServiceDefinitionQuery = `
-	{
-		"query": "query __ApolloGetServiceDefinition__ { _service { sdl } }",
-		"operationName": "__ApolloGetServiceDefinition__",
-		"variables": {}
-	}`
-
 type GQLErr []struct {
 	Message string `json:"message"`
 }
@@ -69,6 +63,15 @@ func NewDatasourcePoller(
 	}
 }
 
+// registryState tracks the registry version of a subgraph against the SDL it serves
+type registryState struct {
+	version string
+	// polledSDL is the SDL the subgraph served when the version was first seen
+	polledSDL string
+	// live is set once the subgraph serves another SDL, which then wins over the version
+	live bool
+}
+
 type DatasourcePollerPoller struct {
 	httpClient   *http.Client
 	cacheService *redis.CacheService
@@ -78,6 +81,14 @@ type DatasourcePollerPoller struct {
 	config DatasourcePollerConfig
 	sdlMap map[string]string
 
+	validator *SupergraphValidator
+	registry  *redis.SchemaRegistry
+	// registryStates are the registry versions seen per subgraph, see chooseSDL
+	registryStates map[string]registryState
+	// registrySynced is set once the subgraphs have been polled alongside the registry
+	registrySynced bool
+	// observersMu serializes updates from polls and promotions
+	observersMu               sync.Mutex
 	updateDatasourceObservers []DataSourceObserver
 }
 
@@ -85,22 +96,67 @@ func (d *DatasourcePollerPoller) Register(updateDatasourceObserver DataSourceObs
 	d.updateDatasourceObservers = append(d.updateDatasourceObservers, updateDatasourceObserver)
 }
 
+// UseValidator makes the poller pass only supergraphs accepted by the validator to the
+// observers, starting with the last known good one
+func (d *DatasourcePollerPoller) UseValidator(validator *SupergraphValidator) {
+	d.validator = validator
+}
+
+// UseRegistry makes newly published schemas take precedence over polling the subgraphs,
+// and updates the observers as soon as any replica publishes one
+func (d *DatasourcePollerPoller) UseRegistry(registry *redis.SchemaRegistry) {
+	d.registry = registry
+	d.registryStates = make(map[string]registryState)
+}
+
+// Promote passes the last supergraph rejected by the validator to the observers
+func (d *DatasourcePollerPoller) Promote(ctx context.Context) error {
+	d.observersMu.Lock()
+	defer d.observersMu.Unlock()
+
+	subgraphsConfig, err := d.validator.Promote(ctx)
+	if err != nil {
+		return err
+	}
+	d.notifyObservers(subgraphsConfig)
+	return nil
+}
+
 func (d *DatasourcePollerPoller) Run(ctx context.Context) {
-	d.updateSDLs(ctx)
+	// Serve the last known good supergraph until the subgraphs have been polled
+	if d.validator != nil {
+		if subgraphsConfig := d.validator.LastKnownGood(ctx); len(subgraphsConfig) > 0 {
+			d.updateObservers(ctx, subgraphsConfig, nil)
+		}
+	}
 
-	if d.config.PollingInterval == 0 {
-		<-ctx.Done()
-		return
+	// Polling stays as the fallback for missed registry notifications
+	var updates <-chan redis.SchemaPublished
+	if d.registry != nil {
+		updates = d.registry.Subscribe(ctx)
 	}
 
-	ticker := time.NewTicker(d.config.PollingInterval)
-	defer ticker.Stop()
+	d.updateSDLs(ctx)
+
+	var tick <-chan time.Time
+	if d.config.PollingInterval > 0 {
+		ticker := time.NewTicker(d.config.PollingInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-tick:
+			d.updateSDLs(ctx)
+		case update, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
+			}
+			log.Printf("Schema %s of %s published to the registry", update.Version, update.Name)
 			d.updateSDLs(ctx)
 		}
 	}
@@ -108,6 +164,7 @@ func (d *DatasourcePollerPoller) Run(ctx context.Context) {
 
 func (d *DatasourcePollerPoller) updateSDLs(ctx context.Context) {
 	d.sdlMap = make(map[string]string)
+	registered := d.registeredSchemas(ctx)
 
 	var wg sync.WaitGroup
 	resultCh := make(chan struct {
@@ -126,27 +183,11 @@ func (d *DatasourcePollerPoller) updateSDLs(ctx context.Context) {
 			var retry *redis.Retry
 
 			if d.cbManager != nil {
-				subgraphConfig := redis.CircuitBreakerConfig{
-					MaxFailures:      3,                // More sensitive for subgraphs
-					Timeout:          30 * time.Second, // Shorter recovery time
-					MaxRequests:      2,                // Only 2 test requests
-					ResetTimeout:     20 * time.Second,
-					FailureThreshold: 60.0, // 60% failure rate
-				}
-				breaker = d.cbManager.GetOrCreateBreaker("subgraph-"+serviceConf.Name, subgraphConfig)
+				breaker = d.cbManager.GetOrCreateBreaker(subgraphResilienceName(serviceConf.Name), subgraphCircuitBreakerConfig())
 			}
 
 			if d.retryManager != nil {
-				subgraphRetryConfig := redis.RetryConfig{
-					MaxAttempts:    2,                     // 2 retries for schema fetching
-					InitialDelay:   50 * time.Millisecond, // Start with 50ms
-					MaxDelay:       1 * time.Second,       // Cap at 1 second
-					Multiplier:     2.0,                   // Double delay
-					Jitter:         0.1,                   // 10% jitter
-					RetryOnTimeout: true,
-					RetryOn5xx:     true,
-				}
-				retry = d.retryManager.GetOrCreateRetry("subgraph-"+serviceConf.Name, subgraphRetryConfig)
+				retry = d.retryManager.GetOrCreateRetry(subgraphResilienceName(serviceConf.Name), subgraphRetryConfig())
 			}
 
 			// Try to get schema from cache first
@@ -225,10 +266,18 @@ func (d *DatasourcePollerPoller) updateSDLs(ctx context.Context) {
 				sdl, err = d.fetchServiceSDL(ctx, serviceConf.SchemaURL, serviceConf.Method, serviceConf.ResponseType)
 			}
 
+			// Record the outcome so the subgraph status reflects schema fetches too
+			if d.cacheService != nil {
+				if statusErr := d.cacheService.SetSubgraphStatus(ctx, serviceConf.Name, err == nil); statusErr != nil {
+					log.Printf("Failed to set status for %s: %v", serviceConf.Name, statusErr)
+				}
+			}
+
 			if err != nil {
 				log.Println("Failed to get sdl.", err)
 
-				if serviceConf.Fallback == nil {
+				// The schema published to the registry stands in for the fallback
+				if _, ok := registered[serviceConf.Name]; ok || serviceConf.Fallback == nil {
 					return
 				} else {
 					sdl, err = serviceConf.Fallback(&serviceConf)
@@ -263,16 +312,102 @@ func (d *DatasourcePollerPoller) updateSDLs(ctx context.Context) {
 		close(resultCh)
 	}()
 
+	polled := make(map[string]string, len(d.config.Services))
 	for result := range resultCh {
-		d.sdlMap[result.name] = result.sdl
+		polled[result.name] = result.sdl
+	}
+
+	// Breaking changes are only let through for the subgraphs that published them
+	var allowBreaking map[string]bool
+	for _, serviceConf := range d.config.Services {
+		sdl, allow := d.chooseSDL(serviceConf.Name, polled[serviceConf.Name], registered[serviceConf.Name])
+		if sdl == "" {
+			continue
+		}
+		d.sdlMap[serviceConf.Name] = sdl
+		if allow {
+			if allowBreaking == nil {
+				allowBreaking = make(map[string]bool)
+			}
+			allowBreaking[serviceConf.Name] = true
+		}
 	}
+	d.registrySynced = d.registry != nil
 
-	d.updateObservers()
+	d.updateObservers(ctx, d.createSubgraphsConfig(), allowBreaking)
 }
 
-func (d *DatasourcePollerPoller) updateObservers() {
-	subgraphsConfig := d.createSubgraphsConfig()
+// chooseSDL picks between the SDL polled from a subgraph, empty when polling failed, and
+// the latest schema it published to the registry. A newly published version is served
+// until the subgraph serves another SDL than when the version was seen, so a redeploy
+// without publishing is still picked up. Versions published before the first poll may be
+// older than the running subgraph and only stand in when polling fails. Breaking changes
+// are allowed the first time a version allowing them is served.
+func (d *DatasourcePollerPoller) chooseSDL(name string, polled string, schema *redis.RegisteredSchema) (string, bool) {
+	if schema == nil {
+		return polled, false
+	}
+
+	state, seen := d.registryStates[name]
+	if !seen || state.version != schema.Version {
+		state = registryState{
+			version:   schema.Version,
+			polledSDL: polled,
+			live:      !d.registrySynced && polled != "",
+		}
+		d.registryStates[name] = state
+		if !state.live {
+			return schema.SDL, schema.AllowBreaking
+		}
+	}
+
+	switch {
+	case state.live:
+		// The current supergraph keeps the SDL of a subgraph that could not be polled
+		return polled, false
+	case polled == "":
+		return schema.SDL, false
+	case state.polledSDL == "":
+		state.polledSDL = polled
+	case polled != state.polledSDL:
+		log.Printf("Subgraph %s serves a schema newer than version %s in the registry", name, schema.Version)
+		state.live = true
+	}
+	d.registryStates[name] = state
+
+	if state.live {
+		return polled, false
+	}
+	return schema.SDL, false
+}
+
+// registeredSchemas returns the latest registry schema of each subgraph that published one
+func (d *DatasourcePollerPoller) registeredSchemas(ctx context.Context) map[string]*redis.RegisteredSchema {
+	if d.registry == nil {
+		return nil
+	}
+	schemas, err := d.registry.LatestAll(ctx)
+	if err != nil {
+		log.Printf("Failed to read schema registry, polling every subgraph: %v", err)
+		return nil
+	}
+	return schemas
+}
+
+func (d *DatasourcePollerPoller) updateObservers(ctx context.Context, subgraphsConfig []engine.SubgraphConfiguration, allowBreaking map[string]bool) {
+	d.observersMu.Lock()
+	defer d.observersMu.Unlock()
+
+	if d.validator != nil {
+		var ok bool
+		if subgraphsConfig, ok = d.validator.Validate(ctx, subgraphsConfig, allowBreaking); !ok {
+			return
+		}
+	}
+	d.notifyObservers(subgraphsConfig)
+}
 
+func (d *DatasourcePollerPoller) notifyObservers(subgraphsConfig []engine.SubgraphConfiguration) {
 	for i := range d.updateDatasourceObservers {
 		d.updateDatasourceObservers[i].UpdateDataSources(subgraphsConfig)
 	}
@@ -337,7 +472,7 @@ func (d *DatasourcePollerPoller) fetchServiceSDL(ctx context.Context, url string
 	if method == "GET" {
 		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	} else {
-		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader([]byte(ServiceDefinitionQuery)))
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader([]byte(schemadiff.ServiceDefinitionQuery)))
 	}
 	req.Header.Add("Content-Type", "application/json")
 
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+)
+
+// fakeSubgraphs serves the SDL of each subgraph on /{name}, subgraphs without one are down
+type fakeSubgraphs struct {
+	mu   sync.Mutex
+	sdls map[string]string
+}
+
+func (f *fakeSubgraphs) deploy(name, sdl string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sdls[name] = sdl
+}
+
+func (f *fakeSubgraphs) down(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sdls, name)
+}
+
+func (f *fakeSubgraphs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	sdl, ok := f.sdls[strings.TrimPrefix(r.URL.Path, "/")]
+	f.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"errors":[{"message":"unavailable"}]}`))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{"_service": map[string]string{"sdl": sdl}},
+	})
+}
+
+// recordingObserver keeps the SDL of every subgraph it was last updated with
+type recordingObserver struct {
+	updates int
+	sdls    map[string]string
+}
+
+func (o *recordingObserver) UpdateDataSources(subgraphsConfigs []engine.SubgraphConfiguration) {
+	o.updates++
+	o.sdls = make(map[string]string, len(subgraphsConfigs))
+	for _, config := range subgraphsConfigs {
+		o.sdls[config.Name] = config.SDL
+	}
+}
+
+func TestDatasourcePollerRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	subgraphs := &fakeSubgraphs{sdls: map[string]string{"orders": ordersSDL, "products": productsSDL}}
+	server := httptest.NewServer(subgraphs)
+	t.Cleanup(server.Close)
+
+	redisServer := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { client.Close() })
+	registry := redis.NewSchemaRegistry(client, log.NoopLogger)
+
+	publish := func(version, sdl string, allowBreaking bool) {
+		_, err := registry.Publish(ctx, &redis.RegisteredSchema{
+			Name:          "orders",
+			Version:       version,
+			SDL:           sdl,
+			AllowBreaking: allowBreaking,
+			PublishedAt:   time.Now(),
+		})
+		require.NoError(t, err)
+	}
+
+	poller := NewDatasourcePoller(server.Client(), DatasourcePollerConfig{Services: []ServiceConfig{
+		{Name: "orders", URL: server.URL + "/orders", SchemaURL: server.URL + "/orders"},
+		{Name: "products", URL: server.URL + "/products", SchemaURL: server.URL + "/products"},
+	}}, nil, nil, nil)
+	validator := NewSupergraphValidator(ctx, nil, false, log.NoopLogger)
+	poller.UseValidator(validator)
+	poller.UseRegistry(registry)
+	observer := &recordingObserver{}
+	poller.Register(observer)
+
+	// A version published before the gateway started may predate the running subgraph
+	publish("1", withTotal, false)
+	poller.updateSDLs(ctx)
+	require.Equal(t, 1, observer.updates)
+	assert.Equal(t, ordersSDL, observer.sdls["orders"])
+
+	// A newly published version is served before the subgraph is redeployed, with the
+	// breaking changes it allows
+	publish("2", withoutNote, true)
+	poller.updateSDLs(ctx)
+	require.Equal(t, 2, observer.updates)
+	assert.Equal(t, withoutNote, observer.sdls["orders"])
+
+	poller.updateSDLs(ctx)
+	assert.Equal(t, 2, observer.updates, "the subgraph still serves the SDL it served when the version was published")
+
+	// Redeploying without publishing is picked up
+	subgraphs.deploy("orders", withTotal)
+	poller.updateSDLs(ctx)
+	require.Equal(t, 3, observer.updates)
+	assert.Equal(t, withTotal, observer.sdls["orders"])
+
+	// An unreachable subgraph keeps its part of the supergraph rather than going back to
+	// the registry version
+	subgraphs.down("orders")
+	poller.updateSDLs(ctx)
+	assert.Equal(t, 3, observer.updates)
+
+	// Breaking changes allowed by a version of orders do not let those of products through
+	subgraphs.deploy("orders", withTotal)
+	subgraphs.deploy("products", `
+		type Query {
+			products: [Product!]!
+		}
+
+		type Product @key(fields: "id") {
+			id: ID!
+		}
+	`)
+	publish("3", ordersSDL, true)
+	poller.updateSDLs(ctx)
+	assert.Equal(t, 3, observer.updates)
+	rejected := validator.Status().Rejected
+	require.NotNil(t, rejected)
+	assert.Equal(t, "2 breaking changes, 1 not from orders", rejected.Error)
+}
+
+func TestDatasourcePollerRegistryStandsInForUnreachableSubgraph(t *testing.T) {
+	ctx := context.Background()
+
+	subgraphs := &fakeSubgraphs{sdls: map[string]string{"products": productsSDL}}
+	server := httptest.NewServer(subgraphs)
+	t.Cleanup(server.Close)
+
+	redisServer := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { client.Close() })
+	registry := redis.NewSchemaRegistry(client, log.NoopLogger)
+	_, err := registry.Publish(ctx, &redis.RegisteredSchema{Name: "orders", Version: "1", SDL: ordersSDL, PublishedAt: time.Now()})
+	require.NoError(t, err)
+
+	poller := NewDatasourcePoller(server.Client(), DatasourcePollerConfig{Services: []ServiceConfig{
+		{Name: "orders", URL: server.URL + "/orders", SchemaURL: server.URL + "/orders"},
+		{Name: "products", URL: server.URL + "/products", SchemaURL: server.URL + "/products"},
+	}}, nil, nil, nil)
+	poller.UseRegistry(registry)
+	observer := &recordingObserver{}
+	poller.Register(observer)
+
+	poller.updateSDLs(ctx)
+	assert.Equal(t, map[string]string{"orders": ordersSDL, "products": productsSDL}, observer.sdls)
+
+	// The subgraph coming up serving the version keeps it
+	subgraphs.deploy("orders", ordersSDL)
+	poller.updateSDLs(ctx)
+	assert.Equal(t, ordersSDL, observer.sdls["orders"])
+
+	subgraphs.deploy("orders", withTotal)
+	poller.updateSDLs(ctx)
+	assert.Equal(t, withTotal, observer.sdls["orders"])
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+)
+
+// entityIDField is the field identifying an entity in an entity tag
+const entityIDField = "id"
+
+// EntityTag identifies an entity in the cache, e.g. "Order:<uuid>".
+// Objects of a type with an id field whose id was not selected are tagged with the bare type name.
+func EntityTag(typeName string, id string) string {
+	if id == "" {
+		return typeName
+	}
+	return typeName + ":" + id
+}
+
+// InvalidationTags returns the tags to evict when an entity changes: the entity itself, and
+// the responses holding objects of its type that could not be identified
+func InvalidationTags(tag string) []string {
+	typeName, _, found := strings.Cut(tag, ":")
+	if !found {
+		return []string{tag}
+	}
+	return []string{tag, typeName}
+}
+
+// EntityTags returns the entity tags of all objects in the data of a GraphQL response.
+// The operation is walked along with the response, so __typename only needs to be
+// selected for abstract types.
+func (p *CacheControlPolicy) EntityTags(op *Operation, response []byte) []string {
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(response, &resp); err != nil || resp.Data == nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	tags := make(map[string]struct{})
+	operation := op.Document.OperationDefinitions[op.Ref]
+	if operation.HasSelections {
+		p.collectEntityTags(op.Document, operation.SelectionSet, rootTypeName(operation.OperationType), resp.Data, tags)
+	}
+
+	result := make([]string, 0, len(tags))
+	for tag := range tags {
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// collectEntityTags tags the objects of a response value selected by a selection set
+func (p *CacheControlPolicy) collectEntityTags(doc *ast.Document, selectionSet int, typeName string, value interface{}, tags map[string]struct{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			p.collectEntityTags(doc, selectionSet, typeName, item, tags)
+		}
+	case map[string]interface{}:
+		if concrete, ok := v["__typename"].(string); ok {
+			typeName = concrete
+		}
+
+		id := p.walkEntitySelections(doc, selectionSet, typeName, v, tags)
+		if _, hasID := p.fields[typeName][entityIDField]; hasID || id != "" {
+			tags[EntityTag(typeName, id)] = struct{}{}
+		}
+	}
+}
+
+// walkEntitySelections descends into the fields of an object and returns its id, if selected
+func (p *CacheControlPolicy) walkEntitySelections(doc *ast.Document, selectionSet int, typeName string, object map[string]interface{}, tags map[string]struct{}) string {
+	var id string
+
+	for _, selectionRef := range doc.SelectionSets[selectionSet].SelectionRefs {
+		selection := doc.Selections[selectionRef]
+
+		switch selection.Kind {
+		case ast.SelectionKindField:
+			name := doc.FieldNameString(selection.Ref)
+			value, ok := object[doc.FieldAliasOrNameString(selection.Ref)]
+			if !ok || value == nil {
+				continue
+			}
+
+			if name == entityIDField {
+				id = idString(value)
+			}
+
+			field := doc.Fields[selection.Ref]
+			if field.HasSelections {
+				p.collectEntityTags(doc, field.SelectionSet, p.fields[typeName][name].typeName, value, tags)
+			}
+
+		case ast.SelectionKindInlineFragment:
+			fragment := doc.InlineFragments[selection.Ref]
+			if !fragment.HasSelections {
+				continue
+			}
+			if fragment.TypeCondition.Type != -1 {
+				condition := doc.InlineFragmentTypeConditionNameString(selection.Ref)
+				// Skip fragments on another object type, abstract conditions always apply
+				if condition != typeName && p.isConcrete(condition) && p.isConcrete(typeName) {
+					continue
+				}
+			}
+			if fragmentID := p.walkEntitySelections(doc, fragment.SelectionSet, typeName, object, tags); fragmentID != "" {
+				id = fragmentID
+			}
+		}
+	}
+
+	return id
+}
+
+// isConcrete reports whether a type is an object type, as opposed to an interface or union
+func (p *CacheControlPolicy) isConcrete(typeName string) bool {
+	_, isObject := p.objectTypes[typeName]
+	return isObject
+}
+
+// idString renders an id value as it appears in entity tags
+func idString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	default:
+		return ""
+	}
+}
@@ -30,16 +30,20 @@ func (h HandlerFactoryFn) Make(schema *graphql.Schema, engine *engine.ExecutionE
 	return h(schema, engine)
 }
 
+// NewGateway creates a gateway fetching from subgraphs with httpClient. Subscriptions are
+// made with streamingClient, which must not time out.
 func NewGateway(
 	ctx context.Context,
 	gqlHandlerFactory HandlerFactory,
 	httpClient *http.Client,
+	streamingClient *http.Client,
 	logger log.Logger,
 ) *Gateway {
 	return &Gateway{
 		engineCtx:         ctx,
 		gqlHandlerFactory: gqlHandlerFactory,
 		httpClient:        httpClient,
+		streamingClient:   streamingClient,
 		logger:            logger,
 
 		mu:        &sync.Mutex{},
@@ -51,9 +55,12 @@ func NewGateway(
 type Gateway struct {
 	gqlHandlerFactory HandlerFactory
 	httpClient        *http.Client
+	streamingClient   *http.Client
 	logger            log.Logger
 
 	gqlHandler http.Handler
+	schema     *graphql.Schema
+	engine     *engine.ExecutionEngine
 	mu         *sync.Mutex
 
 	readyCh   chan struct{}
@@ -69,6 +76,22 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.ServeHTTP(w, r)
 }
 
+// Schema returns the currently composed supergraph schema
+func (g *Gateway) Schema() *graphql.Schema {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.schema
+}
+
+// Engine returns the execution engine of the current supergraph, nil until it is composed
+func (g *Gateway) Engine() *engine.ExecutionEngine {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.engine
+}
+
 func (g *Gateway) Ready() {
 	<-g.readyCh
 }
@@ -81,9 +104,18 @@ func (g *Gateway) UpdateDataSources(subgraphsConfigs []engine.SubgraphConfigurat
 		g.logger.Error("get engine config: %v", log.Error(err))
 		return
 	}
+	if err := userScopedSubscriptions(g.engineCtx, &engineConfig, g.httpClient, g.streamingClient); err != nil {
+		g.logger.Error("configure subscriptions: %v", log.Error(err))
+		return
+	}
 
 	executionEngine, err := engine.NewExecutionEngine(g.engineCtx, g.logger, engineConfig, resolve.ResolverOptions{
 		MaxConcurrency: 1024,
+		// Pass subgraph errors through so SUBGRAPH_UNAVAILABLE errors reach the client
+		PropagateSubgraphErrors:      true,
+		SubgraphErrorPropagationMode: resolve.SubgraphErrorPropagationModePassThrough,
+		RewriteSubgraphErrorPaths:    true,
+		AllowedErrorExtensionFields:  subgraphErrorExtensionFields,
 	})
 	if err != nil {
 		g.logger.Error("create engine: %v", log.Error(err))
@@ -91,7 +123,9 @@ func (g *Gateway) UpdateDataSources(subgraphsConfigs []engine.SubgraphConfigurat
 	}
 
 	g.mu.Lock()
-	g.gqlHandler = g.gqlHandlerFactory.Make(engineConfig.Schema(), executionEngine)
+	g.schema = engineConfig.Schema()
+	g.engine = executionEngine
+	g.gqlHandler = g.gqlHandlerFactory.Make(g.schema, executionEngine)
 	g.mu.Unlock()
 
 	g.readyOnce.Do(func() { close(g.readyCh) })
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"api-gateway/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Headers carrying the authenticated user to the subgraphs
+const (
+	UserIDHeader        = "X-User-Id"
+	UserClaimsHeader    = "X-User-Claims"
+	UserSignatureHeader = "X-User-Signature"
+)
+
+// identitySignatureVersion prefixes the signed payload, so the format can change later
+const identitySignatureVersion = "v1."
+
+// identityTTL bounds how long forwarded identity headers are accepted by a subgraph
+const identityTTL = time.Minute
+
+// forwardedClaims is the payload of the X-User-Claims header
+type forwardedClaims struct {
+	Subject   string   `json:"sub"`
+	Username  string   `json:"username"`
+	Client    string   `json:"client,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// claimsUserID returns the user ID of a token, or "" for tokens issued without one
+// and for API key clients
+func claimsUserID(claims *models.Claims) string {
+	if claims.ClientID != "" {
+		return ""
+	}
+	if claims.UserID != "" {
+		return claims.UserID
+	}
+	return claims.Subject
+}
+
+// IdentityTransport forwards the user authenticated by JWTMiddleware to the subgraphs.
+// The claims are encoded in X-User-Claims and signed with a secret shared with the
+// subgraphs in X-User-Signature, so a subgraph reachable without the gateway cannot be
+// called as another user. Identity headers sent by clients are always dropped.
+type IdentityTransport struct {
+	next   http.RoundTripper
+	secret []byte
+}
+
+// NewIdentityTransport wraps next with identity forwarding
+func NewIdentityTransport(next http.RoundTripper, secret []byte) *IdentityTransport {
+	return &IdentityTransport{next: next, secret: secret}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *IdentityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Del(UserIDHeader)
+	req.Header.Del(UserClaimsHeader)
+	req.Header.Del(UserSignatureHeader)
+
+	claims, ok := ClaimsFromContext(req.Context())
+	if !ok || len(t.secret) == 0 {
+		return t.next.RoundTrip(req)
+	}
+	userID := claimsUserID(claims)
+	if userID == "" && claims.ClientID == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	// API key clients are forwarded without a user, by their client ID
+	payload, err := json.Marshal(forwardedClaims{
+		Subject:   userID,
+		Username:  claims.Username,
+		Client:    claims.ClientID,
+		Scopes:    claims.Scopes,
+		ExpiresAt: time.Now().Add(identityTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	req.Header.Set(UserIDHeader, userID)
+	req.Header.Set(UserClaimsHeader, encoded)
+	req.Header.Set(UserSignatureHeader, t.sign(encoded))
+
+	return t.next.RoundTrip(req)
+}
+
+// sign returns the base64url HMAC-SHA256 of the encoded claims
+func (t *IdentityTransport) sign(encodedClaims string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(identitySignatureVersion + encodedClaims))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"api-gateway/auth"
 	appHandler "api-gateway/handler"
+	"api-gateway/metrics"
 	redis "api-gateway/redis"
+	"api-gateway/tracing"
 	"api-gateway/utils"
 	"context"
 	"fmt"
@@ -11,10 +14,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/demo-micro/backend/pkg/telemetry"
 	"github.com/gobwas/ws"
 	log "github.com/jensneuse/abstractlogger"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 
 	gatewayHttp "github.com/wundergraph/graphql-go-tools/examples/federation/gateway/http"
 	"github.com/wundergraph/graphql-go-tools/execution/engine"
@@ -24,22 +28,6 @@ import (
 	muxHandler "github.com/gorilla/handlers"
 )
 
-func logger() log.Logger {
-	file, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	// Create a zap logger that writes to the file
-	writer := zapcore.AddSync(file)
-	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), writer, zap.DebugLevel)
-
-	logger := zap.New(core)
-
-	return log.NewZapLogger(logger, log.DebugLevel)
-}
-
 func fallback(sc *ServiceConfig) (string, error) {
 	dat, err := os.ReadFile(sc.Name + "/graph/schema.graphqls")
 	if err != nil {
@@ -50,14 +38,37 @@ func fallback(sc *ServiceConfig) (string, error) {
 }
 
 func startServer() {
-	logger := logger()
-	logger.Info("logger initialized")
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	utils.LoadEnvFile()
 
+	zapLogger, err := logging.New(logging.ConfigFromEnv())
+	if err != nil {
+		panic("Failed to set up logging: " + err.Error())
+	}
+	defer zapLogger.Close()
+	defer logging.SetGlobal(zapLogger)()
+
+	// The level is filtered by zapLogger, so it can be changed at runtime
+	logger := log.NewZapLogger(zapLogger.WithOptions(zap.AddCallerSkip(1)), log.DebugLevel)
+	appHandler.UseLogger(logger)
+	redis.UseLogger(logger)
+	logger.Info("logger initialized")
+
+	shutdownTracing, err := telemetry.Init(ctx, "api-gateway")
+	if err != nil {
+		logger.Fatal("set up tracing", log.Error(err))
+		return
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("Failed to flush traces", log.Error(err))
+		}
+	}()
+
 	upgrader := &ws.DefaultHTTPUpgrader
 	upgrader.Header = http.Header{}
 	upgrader.Header.Add("Sec-Websocket-Protocol", "graphql-ws")
@@ -82,39 +93,100 @@ func startServer() {
 	// Create Circuit Breaker Manager
 	cbManager := NewCircuitBreakerManager(redis.Client(), logger)
 
-	// Create circuit breaker for API Gateway with custom config
-	gatewayConfig := redis.CircuitBreakerConfig{
-		MaxFailures:      5,                // Open after 5 consecutive failures
-		Timeout:          60 * time.Second, // Try recovery after 60 seconds
-		MaxRequests:      3,                // Allow 3 test requests in half-open state
-		ResetTimeout:     30 * time.Second, // Reset failure count after 30s
-		FailureThreshold: 50.0,             // 50% failure rate triggers open
+	// Create Retry Manager
+	retryManager := redis.NewRetryManager(logger)
+
+	logger.Info("Redis services initialized (cache, rate limiter, circuit breaker, retry)")
+
+	// Tokens are signed with a rotating key ring, published at /.well-known/jwks.json
+	keyRing, err := newKeyRing(ctx, logger)
+	if err != nil {
+		logger.Fatal("initialize signing keys", log.Error(err))
+		return
 	}
-	gatewayBreaker := cbManager.GetOrCreateBreaker("api-gateway", gatewayConfig)
+	go keyRing.Run(ctx)
+	appHandler.UseKeyRing(keyRing)
 
-	// Create Retry Manager
-	retryManager := redis.NewRetryManager(redis.Client(), logger)
+	// Refresh sessions, and the revocation list checked for every access token
+	sessionManager := redis.NewSessionManager(redis.Client(), logger)
+	appHandler.UseSessionManager(sessionManager)
+
+	// Users registered before the user store are moved under the user: prefix
+	userStore := redis.NewUserStore(redis.Client(), logger)
+	migrated, err := userStore.MigrateLegacyUsers(ctx)
+	if err != nil {
+		logger.Error("Failed to migrate legacy users", log.Error(err))
+	} else if migrated > 0 {
+		logger.Info("Migrated legacy users", log.Int("count", migrated))
+	}
+	appHandler.UseUserStore(userStore)
+	appHandler.SetPasswordPolicy(appHandler.PasswordPolicyFromEnv())
+	appHandler.SetLockoutPolicy(redis.LockoutPolicy{
+		MaxAttempts: envInt("LOGIN_MAX_FAILED_ATTEMPTS", 5),
+		Window:      envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		Duration:    envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	})
+	logVerificationLinks := os.Getenv("EMAIL_VERIFICATION_LOG_LINKS") == "true"
+	if logVerificationLinks {
+		logger.Warn("EMAIL_VERIFICATION_LOG_LINKS is set, email verification links are logged at debug level")
+	}
+	appHandler.SetVerificationSender(verificationLinkLogger(os.Getenv("EMAIL_VERIFICATION_URL"), logVerificationLinks, logger))
 
-	// Create retry handler for API Gateway
-	gatewayRetryConfig := redis.RetryConfig{
-		MaxAttempts:    3,                      // Retry up to 3 times (total 3 attempts)
-		InitialDelay:   100 * time.Millisecond, // Start with 100ms delay
-		MaxDelay:       2 * time.Second,        // Cap at 2 seconds
-		Multiplier:     2.0,                    // Double delay each retry (exponential backoff)
-		Jitter:         0.1,                    // 10% jitter to prevent thundering herd
-		RetryOnTimeout: true,                   // Retry on timeouts
-		RetryOn5xx:     true,                   // Retry on 5xx errors
+	// Scopes granted by each role, checked against @requiresScopes in subgraph SDLs
+	roleScopes, err := appHandler.LoadRoleScopes(os.Getenv("ROLE_SCOPES_FILE"))
+	if err != nil {
+		logger.Fatal("load role scopes", log.Error(err))
+		return
 	}
-	gatewayRetry := retryManager.GetOrCreateRetry("api-gateway", gatewayRetryConfig)
+	appHandler.SetRoleScopes(roleScopes)
 
-	logger.Info("Redis services initialized (cache, rate limiter, circuit breaker, retry)")
+	// Hashed API keys of machine clients, sent in X-API-Key instead of a token
+	apiKeys := redis.NewAPIKeyStore(redis.Client(), logger)
+
+	// Identity providers users can sign in with at /auth/{provider}/login
+	oidcConfigs, err := auth.LoadOIDCProviders(os.Getenv("OIDC_PROVIDERS_FILE"))
+	if err != nil {
+		logger.Fatal("load OIDC providers", log.Error(err))
+		return
+	}
+	oidcProviders := make([]*auth.OIDCProvider, len(oidcConfigs))
+	for i, config := range oidcConfigs {
+		oidcProviders[i] = auth.NewOIDCProvider(config, nil)
+	}
+	appHandler.UseOIDCProviders(oidcProviders...)
+	appHandler.SetPostLoginRedirect(os.Getenv("OIDC_POST_LOGIN_REDIRECT"))
+
+	services := []ServiceConfig{
+		{Name: "order", URL: os.Getenv("ORDER_URL"), SchemaURL: os.Getenv("ORDER_URL"), WS: os.Getenv("ORDER_WS"), Fallback: fallback},
+		{Name: "inventory", URL: os.Getenv("INVENTORY_URL"), SchemaURL: os.Getenv("INVENTORY_URL")},
+		{Name: "notification", URL: os.Getenv("NOTIFICATION_URL"), SchemaURL: os.Getenv("NOTIFICATION_GET"), Method: "GET", ResponseType: "string"},
+	}
+
+	// Subgraph fetches made by the federation engine go through a per-subgraph
+	// circuit breaker and retry, so one failing subgraph does not take down the others.
+	// The authenticated user is forwarded to each subgraph in signed identity headers.
+	// Each fetch is a span, however many attempts it takes.
+	subgraphSigningSecret := os.Getenv("SUBGRAPH_SIGNING_SECRET")
+	if subgraphSigningSecret == "" {
+		logger.Warn("SUBGRAPH_SIGNING_SECRET is not set, the user is not forwarded to subgraphs")
+	}
+	subgraphTransport := http.DefaultTransport.(*http.Transport).Clone()
+	subgraphTransport.MaxIdleConnsPerHost = 1024
+	subgraphClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: tracing.Transport(NewIdentityTransport(
+			NewSubgraphTransport(subgraphTransport, services, cacheService, cbManager, retryManager, logger),
+			[]byte(subgraphSigningSecret),
+		)),
+	}
+	// Subscriptions hold their upstream connection open, without the timeout, retries
+	// and circuit breaker of subgraph fetches
+	subscriptionClient := &http.Client{
+		Transport: NewIdentityTransport(subgraphTransport, []byte(subgraphSigningSecret)),
+	}
 
 	datasourceWatcher := NewDatasourcePoller(httpClient, DatasourcePollerConfig{
-		Services: []ServiceConfig{
-			{Name: "order", URL: os.Getenv("ORDER_URL"), SchemaURL: os.Getenv("ORDER_URL"), Fallback: fallback},
-			{Name: "inventory", URL: os.Getenv("INVENTORY_URL"), SchemaURL: os.Getenv("INVENTORY_URL")},
-			{Name: "notification", URL: os.Getenv("NOTIFICATION_URL"), SchemaURL: os.Getenv("NOTIFICATION_GET"), Method: "GET", ResponseType: "string"},
-		},
+		Services: services,
 		// Poll every 5 minutes (schemas don't change often)
 		// Cache duration also 5 minutes = perfect sync
 		PollingInterval: 5 * time.Minute,
@@ -143,8 +215,24 @@ func startServer() {
 		return gatewayHttp.NewGraphqlHTTPHandler(schema, engine, upgrader, logger, enableART)
 	}
 
-	gateway := NewGateway(ctx, gqlHandlerFactory, httpClient, logger)
+	gateway := NewGateway(ctx, gqlHandlerFactory, subgraphClient, subscriptionClient, logger)
+
+	// Responses are cached for 5 minutes unless a subgraph declares @cacheControl hints
+	cachePolicy := NewCacheControlPolicy(5 * time.Minute)
+
+	// Fields are guarded by @authenticated and @requiresScopes declared in subgraph SDLs
+	authorizationPolicy := NewAuthorizationPolicy()
+
+	// Supergraphs are composed and checked for breaking changes before they are swapped in
+	supergraphValidator := NewSupergraphValidator(ctx, cacheService, os.Getenv("SCHEMA_ALLOW_BREAKING_CHANGES") == "true", logger)
+	datasourceWatcher.UseValidator(supergraphValidator)
 
+	// Subgraphs publish their SDL to the schema registry, replicas are notified through Redis pub/sub
+	schemaRegistry := redis.NewSchemaRegistry(redis.Client(), logger)
+	datasourceWatcher.UseRegistry(schemaRegistry)
+
+	datasourceWatcher.Register(cachePolicy)
+	datasourceWatcher.Register(authorizationPolicy)
 	datasourceWatcher.Register(gateway)
 	go datasourceWatcher.Run(ctx)
 
@@ -153,47 +241,202 @@ func startServer() {
 	// CORS configuration
 	corsOptions := muxHandler.AllowedOrigins([]string{"http://localhost:3000"}) // Update with your frontend URL
 	corsOptions = muxHandler.AllowedMethods([]string{"GET", "POST", "OPTIONS"})
-	corsOptions = muxHandler.AllowedHeaders([]string{"Content-Type", "Authorization"})
+	corsOptions = muxHandler.AllowedHeaders([]string{"Content-Type", "Authorization", APIKeyHeader})
 
-	mux.HandleFunc("/login", appHandler.LoginHandler)
-	mux.HandleFunc("/register", appHandler.RegisterHandler)
+	// Rate limit policies per route, user, API key and operation, see ratelimit_policy.go
+	rateLimitPolicies, err := LoadRateLimitPolicies(os.Getenv("RATE_LIMIT_POLICIES_FILE"), rateLimiter)
+	if err != nil {
+		logger.Fatal("load rate limit policies", log.Error(err))
+		return
+	}
+	// Clients are rate limited by the address forwarded by these proxies, by the peer address otherwise
+	trustedProxies, err := ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		logger.Fatal("parse trusted proxies", log.Error(err))
+		return
+	}
+
+	mux.HandleFunc("/.well-known/jwks.json", auth.JWKSHandler(keyRing))
+	mux.Handle("/login", RateLimitMiddleware(http.HandlerFunc(appHandler.LoginHandler), rateLimiter, rateLimitPolicies, trustedProxies, logger))
+	mux.Handle("/register", RateLimitMiddleware(http.HandlerFunc(appHandler.RegisterHandler), rateLimiter, rateLimitPolicies, trustedProxies, logger))
+	mux.Handle("/refresh", RateLimitMiddleware(http.HandlerFunc(appHandler.RefreshHandler), rateLimiter, rateLimitPolicies, trustedProxies, logger))
+	mux.Handle("GET /auth/{provider}/login", RateLimitMiddleware(http.HandlerFunc(appHandler.OIDCLoginHandler), rateLimiter, rateLimitPolicies, trustedProxies, logger))
+	mux.Handle("GET /auth/{provider}/callback", RateLimitMiddleware(http.HandlerFunc(appHandler.OIDCCallbackHandler), rateLimiter, rateLimitPolicies, trustedProxies, logger))
+	mux.HandleFunc("/verify-email", appHandler.VerifyEmailHandler)
+	mux.HandleFunc("/logout", appHandler.LogoutHandler)
+	mux.HandleFunc("/logout-all", appHandler.LogoutAllHandler)
 
 	// Health check endpoints
 	mux.HandleFunc("/health/circuit-breakers", cbManager.HealthCheckHandler())
 	mux.HandleFunc("/health/retries", RetryHealthHandler(retryManager))
+	mux.HandleFunc("/health/subgraphs", SubgraphHealthHandler(services, cacheService, cbManager))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/health/api-keys", APIKeyUsageHandler(apiKeys))
+
+	// Order events are delivered by an EventBridge API destination to evict cached orders
+	if token := os.Getenv("CACHE_INVALIDATION_TOKEN"); token != "" {
+		mux.HandleFunc("/cache/invalidate", CacheInvalidationHandler(token, cacheService, logger))
+	} else {
+		logger.Warn("CACHE_INVALIDATION_TOKEN is not set, cache invalidation by order events is disabled")
+	}
 
-	// Wrap /query endpoint with middleware: Retry → Circuit Breaker → Rate Limiting → Cache → JWT → Gateway
-	// Order matters: Retry wraps Circuit Breaker (retry before giving up)
+	// Persisted queries: operations registered from the manifest are always allowed,
+	// in strict mode they are the only operations executed
+	if manifest := os.Getenv("PERSISTED_QUERIES_MANIFEST"); manifest != "" {
+		count, err := LoadPersistedQueryManifest(ctx, manifest, cacheService)
+		if err != nil {
+			logger.Fatal("load persisted query manifest", log.Error(err))
+			return
+		}
+		logger.Info("Persisted query manifest loaded", log.Int("operations", count))
+	}
+	persistedQueryConfig := PersistedQueryConfig{Strict: os.Getenv("PERSISTED_QUERIES_STRICT") == "true"}
+
+	// Admin endpoints
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.Handle("/admin/log-level", AdminMiddleware(zapLogger.Level(), adminToken))
+		mux.Handle("/admin/persisted-queries", AdminMiddleware(PersistedQueryAdminHandler(cacheService, logger), adminToken))
+		apiKeyAdmin := AdminMiddleware(APIKeyAdminHandler(apiKeys, rateLimiter, logger), adminToken)
+		mux.Handle("/admin/api-keys", apiKeyAdmin)
+		mux.Handle("/admin/api-keys/", apiKeyAdmin)
+		mux.Handle("/admin/users/roles", AdminMiddleware(UserRolesAdminHandler(userStore, roleScopes, logger), adminToken))
+		supergraphAdmin := AdminMiddleware(SupergraphAdminHandler(datasourceWatcher, supergraphValidator, logger), adminToken)
+		mux.Handle("/admin/supergraph", supergraphAdmin)
+		mux.Handle("/admin/supergraph/", supergraphAdmin)
+	} else {
+		logger.Warn("ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+
+	if registryToken := os.Getenv("REGISTRY_TOKEN"); registryToken != "" {
+		registryHandler := AdminMiddleware(RegistryHandler(schemaRegistry, supergraphValidator, services, logger), registryToken)
+		mux.Handle("/registry/subgraphs", registryHandler)
+		mux.Handle("/registry/subgraphs/", registryHandler)
+	} else {
+		logger.Warn("REGISTRY_TOKEN is not set, the schema registry is disabled")
+	}
+
+	// Wrap /query endpoint with middleware: Subscriptions → Metrics → API Key → JWT → Persisted Queries → Query Cost → Authorization → Rate Limiting → Cache → Gateway
+	// Machine clients authenticate with an API key instead of a token.
+	// JWT runs first so requests are rate limited per user and private responses are
+	// keyed by the token subject. The query cost is charged by cost based rate limits.
+	// Authorization checks the parsed operation against the scopes of the token.
+	// Retries and circuit breaking happen per subgraph inside the federation engine.
+	// WebSocket upgrades for subscriptions are served before the chain, they authenticate
+	// on connection_init.
 	mux.Handle("/query",
-		CombinedRetryCircuitBreakerMiddleware(
-			RateLimitMiddleware(
-				GraphQLCacheMiddleware(
-					JWTMiddleware(gateway),
-					cacheService,
+		SubscriptionMiddleware(
+			OperationMetricsMiddleware(
+				APIKeyMiddleware(
+					JWTMiddleware(
+						PersistedQueryMiddleware(
+							QueryCostMiddleware(
+								AuthorizationMiddleware(
+									RateLimitMiddleware(
+										GraphQLCacheMiddleware(gateway, gateway, cachePolicy, cacheService, logger),
+										rateLimiter,
+										rateLimitPolicies,
+										trustedProxies,
+										logger,
+									),
+									authorizationPolicy,
+									logger,
+								),
+								gateway,
+								QueryLimitsFromEnv(),
+								logger,
+							),
+							cacheService,
+							persistedQueryConfig,
+							logger,
+						),
+						keyRing,
+						sessionManager,
+					),
+					apiKeys,
 					logger,
 				),
-				rateLimiter,
-				logger,
 			),
-			gatewayRetry,
-			gatewayBreaker,
+			gateway,
+			authorizationPolicy,
+			keyRing,
+			sessionManager,
 			logger,
 		),
 	)
 
-	logger.Info("GraphQL endpoint configured with retry, circuit breaker, caching, and rate limiting")
+	logger.Info("GraphQL endpoint configured with per-subgraph retry and circuit breakers, caching, and rate limiting")
 
 	addr := "0.0.0.0:8080"
 	logger.Info("Listening",
 		log.String("add", addr),
 	)
 	fmt.Printf("Access Playground on: http://%s%s%s\n", prettyAddr(addr), playgroundURLPrefix, playgroundURL)
-	err = http.ListenAndServe(addr, muxHandler.CORS(corsOptions)(mux))
+	err = http.ListenAndServe(addr, tracing.Handler(logging.Middleware(muxHandler.CORS(corsOptions)(mux), zapLogger.Logger), "api-gateway"))
 	if err != nil {
 		logger.Fatal("failed listening", log.Error(err))
 	}
 }
 
+// newKeyRing creates the token signing key ring. Keys are stored in JWT_KEYS_DIR when
+// set, otherwise in Redis so all gateway replicas share them.
+func newKeyRing(ctx context.Context, logger log.Logger) (*auth.KeyRing, error) {
+	var store auth.KeyStore = auth.NewRedisKeyStore(redis.Client())
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		fileStore, err := auth.NewFileKeyStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+
+	config := auth.KeyRingConfig{
+		Algorithm:        auth.AlgorithmES256,
+		RotationInterval: 30 * 24 * time.Hour,
+		TokenTTL:         appHandler.AccessTokenTTL,
+		LegacySecret:     []byte(os.Getenv("JWT_SECRET")),
+	}
+	if algorithm := os.Getenv("JWT_SIGNING_ALG"); algorithm != "" {
+		config.Algorithm = algorithm
+	}
+	if interval := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION_INTERVAL: %w", err)
+		}
+		config.RotationInterval = parsed
+	}
+	// HS256 tokens signed with JWT_SECRET are accepted until JWT_SECRET_EXPIRES_AT
+	if expiresAt := os.Getenv("JWT_SECRET_EXPIRES_AT"); expiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_SECRET_EXPIRES_AT: %w", err)
+		}
+		config.LegacySecretExpiresAt = parsed
+	}
+
+	return auth.NewKeyRing(ctx, store, config, logger)
+}
+
+// verificationLinkLogger stands in for an email sender until one is set up. The links hold
+// the token that verifies the email, so they are only logged, at debug level, when
+// logLinks is set for development.
+func verificationLinkLogger(baseURL string, logLinks bool, logger log.Logger) appHandler.VerificationSender {
+	if baseURL == "" {
+		baseURL = "http://localhost:8080/verify-email"
+	}
+	return func(ctx context.Context, user *redis.User, token string) error {
+		if !logLinks {
+			logger.Info("Email verification link issued", log.String("user_id", user.ID))
+			return nil
+		}
+		logger.Debug("Email verification link",
+			log.String("user_id", user.ID),
+			log.String("link", baseURL+"?token="+token),
+		)
+		return nil
+	}
+}
+
 func prettyAddr(addr string) string {
 	return strings.Replace(addr, "0.0.0.0", "localhost", -1)
 }
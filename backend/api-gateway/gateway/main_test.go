@@ -0,0 +1,62 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"context"
+	"fmt"
+	"testing"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestVerificationLinkLogger(t *testing.T) {
+	const token = "secret-verification-token"
+	user := &redis.User{ID: "user-1", Email: "alice@example.com"}
+
+	tests := []struct {
+		name     string
+		logLinks bool
+		level    zapcore.Level
+		message  string
+	}{
+		{
+			name:    "links not logged",
+			level:   zapcore.InfoLevel,
+			message: "Email verification link issued",
+		},
+		{
+			name:     "links logged for development",
+			logLinks: true,
+			level:    zapcore.DebugLevel,
+			message:  "Email verification link",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			logger := log.NewZapLogger(zap.New(core), log.DebugLevel)
+
+			send := verificationLinkLogger("https://shop.example/verify", tt.logLinks, logger)
+			require.NoError(t, send(context.Background(), user, token))
+
+			entries := logs.All()
+			require.Len(t, entries, 1)
+			assert.Equal(t, tt.level, entries[0].Level)
+			assert.Equal(t, tt.message, entries[0].Message)
+
+			fields := fmt.Sprint(entries[0].ContextMap())
+			if tt.logLinks {
+				assert.Contains(t, fields, "https://shop.example/verify?token="+token)
+			} else {
+				assert.NotContains(t, fields, token)
+			}
+			assert.NotContains(t, fields, user.Email)
+		})
+	}
+}
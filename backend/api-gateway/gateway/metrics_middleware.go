@@ -0,0 +1,83 @@
+package main
+
+import (
+	"api-gateway/metrics"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const operationMetricsContextKey contextKey = "operation_metrics"
+
+// otherOperationName labels the operations that are not on the allowlist. Their names are
+// chosen by clients, and would each add a series.
+const otherOperationName = "other"
+
+// operationMetrics is filled in by the middlewares of a request, for OperationMetricsMiddleware
+// to label its duration with
+type operationMetrics struct {
+	op *Operation
+	// registered is set for operations on the allowlist
+	registered bool
+}
+
+// recordOperation labels the metrics of the request in ctx with the operation it posted
+func recordOperation(ctx context.Context, op *Operation) {
+	if m, ok := ctx.Value(operationMetricsContextKey).(*operationMetrics); ok {
+		m.op = op
+	}
+}
+
+// recordRegisteredOperation notes that the request in ctx posted an operation of the
+// allowlist, so its metrics are labeled with the operation name
+func recordRegisteredOperation(ctx context.Context) {
+	if m, ok := ctx.Value(operationMetricsContextKey).(*operationMetrics); ok {
+		m.registered = true
+	}
+}
+
+// OperationMetricsMiddleware records how long requests to /query take by GraphQL operation.
+// It runs before the operation is parsed, so requests rejected on the way are measured
+// too; those that never got parsed are labeled with the type "unknown". Operations are
+// labeled with their name when they are on the allowlist, and with "other" otherwise.
+func OperationMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m := &operationMetrics{}
+		sw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), operationMetricsContextKey, m)))
+
+		name, operationType := "", "unknown"
+		if m.op != nil {
+			name, operationType = otherOperationName, m.op.Type.Name()
+			if m.registered {
+				name = m.op.Name
+			}
+		}
+		metrics.OperationDuration.
+			WithLabelValues(name, operationType, strconv.Itoa(sw.statusCode)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusResponseWriter remembers the status code written through it
+type statusResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"api-gateway/metrics"
+	"api-gateway/redis"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+)
+
+// operationDurationSeries returns the label values of the observed operation durations
+func operationDurationSeries(t *testing.T) [][3]string {
+	ch := make(chan prometheus.Metric, 16)
+	metrics.OperationDuration.Collect(ch)
+	close(ch)
+
+	var series [][3]string
+	for metric := range ch {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		labels := map[string]string{}
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		series = append(series, [3]string{labels["operation"], labels["type"], labels["status"]})
+	}
+	return series
+}
+
+func TestOperationMetricsMiddleware(t *testing.T) {
+	const registeredQuery = "query ListOrders { orders { id } }"
+
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cacheService := redis.NewCacheService(client, log.NoopLogger)
+	require.NoError(t, cacheService.RegisterPersistedQueries(context.Background(), map[string]string{
+		queryHash(registeredQuery): registeredQuery,
+	}))
+
+	// Stands in for the query cost middleware, which records the operation once parsed
+	parse := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var gqlReq GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&gqlReq); err != nil || gqlReq.Query == "" {
+			http.Error(w, "unparsable", http.StatusBadRequest)
+			return
+		}
+		recordOperation(r.Context(), &Operation{Name: gqlReq.OperationName, Type: ast.OperationTypeQuery})
+	})
+	handler := OperationMetricsMiddleware(PersistedQueryMiddleware(parse, cacheService, PersistedQueryConfig{}, log.NoopLogger))
+
+	persisted := func(query string) json.RawMessage {
+		extensions, _ := json.Marshal(map[string]interface{}{
+			"persistedQuery": PersistedQueryExtension{Version: 1, Sha256Hash: queryHash(query)},
+		})
+		return extensions
+	}
+
+	tests := []struct {
+		name    string
+		request interface{}
+		series  [3]string
+	}{
+		{
+			name:    "registered operation by hash",
+			request: GraphQLRequest{OperationName: "ListOrders", Extensions: persisted(registeredQuery)},
+			series:  [3]string{"ListOrders", "query", "200"},
+		},
+		{
+			name:    "operation not on the allowlist",
+			request: GraphQLRequest{Query: "query Anything { orders { id } }", OperationName: "Anything"},
+			series:  [3]string{otherOperationName, "query", "200"},
+		},
+		{
+			name:    "registered name on another query",
+			request: GraphQLRequest{Query: "query ListOrders { orders { id userId } }", OperationName: "ListOrders"},
+			series:  [3]string{otherOperationName, "query", "200"},
+		},
+		{
+			name:    "unparsed operation",
+			request: map[string]string{"operationName": "Anything"},
+			series:  [3]string{"", "unknown", "400"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics.OperationDuration.Reset()
+			t.Cleanup(metrics.OperationDuration.Reset)
+
+			body, err := json.Marshal(tt.request)
+			require.NoError(t, err)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/query", bytes.NewReader(body)))
+
+			assert.Equal(t, [][3]string{tt.series}, operationDurationSeries(t))
+		})
+	}
+}
@@ -1,16 +1,55 @@
 package main
 
 import (
+	"api-gateway/auth"
+	"api-gateway/models"
+	"api-gateway/redis"
+	"api-gateway/tracing"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/demo-micro/backend/pkg/logging"
 )
 
-func JWTMiddleware(next http.Handler) http.Handler {
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenRevoked = errors.New("token has been revoked")
+)
+
+// ClaimsFromContext returns the claims of the authenticated request, if any
+func ClaimsFromContext(ctx context.Context) (*models.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*models.Claims)
+	return claims, ok
+}
+
+// claimsSubject identifies the user a token was issued to
+func claimsSubject(claims *models.Claims) string {
+	if claims.Subject != "" {
+		return claims.Subject
+	}
+	return claims.Username
+}
+
+// JWTMiddleware verifies the token of the request with the key ring, rejects tokens on the
+// revocation list and puts the claims in the context. Requests already authenticated by
+// APIKeyMiddleware are passed on.
+func JWTMiddleware(next http.Handler, keyRing *auth.KeyRing, sessions *redis.SessionManager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartRequest(r, "gateway.jwt")
+		defer span.End()
+
+		if _, ok := ClaimsFromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		var tokenString string
 
 		authorizationHeader := r.Header.Get("Authorization")
@@ -30,18 +69,41 @@ func JWTMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := verifyToken(r.Context(), keyRing, sessions, tokenString)
+		switch {
+		case errors.Is(err, errInvalidToken):
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
+		case errors.Is(err, errTokenRevoked):
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		case err != nil:
+			http.Error(w, "Could not verify token", http.StatusServiceUnavailable)
+			return
 		}
 
-		next.ServeHTTP(w, r)
+		logging.SetUserID(r.Context(), claims.Subject)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
 	})
 }
+
+// verifyToken parses a token with the key ring and rejects tokens on the revocation list
+func verifyToken(ctx context.Context, keyRing *auth.KeyRing, sessions *redis.SessionManager, tokenString string) (*models.Claims, error) {
+	claims := &models.Claims{}
+	token, err := keyRing.Parse(tokenString, claims)
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	// Tokens of ended sessions are revoked by jti until they expire
+	if claims.Id != "" {
+		revoked, err := sessions.IsTokenRevoked(ctx, claims.Id)
+		if err != nil {
+			return nil, fmt.Errorf("could not verify token: %w", err)
+		}
+		if revoked {
+			return nil, errTokenRevoked
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/astprinter"
+)
+
+var ErrOperationNotFound = errors.New("operation not found")
+
+// SchemaProvider exposes the currently composed supergraph schema
+type SchemaProvider interface {
+	Schema() *graphql.Schema
+}
+
+// Operation is a GraphQL request parsed with the graphql-go-tools parser,
+// reduced to the operation selected by operationName and normalized
+type Operation struct {
+	Name      string
+	Type      ast.OperationType
+	Document  *ast.Document
+	Ref       int    // operation definition ref in Document
+	Query     string // normalized query text
+	Variables []byte // canonical variables JSON
+}
+
+// IsQuery reports whether the operation is a query
+func (o *Operation) IsQuery() bool {
+	return o.Type == ast.OperationTypeQuery
+}
+
+// ParseOperation parses and normalizes a GraphQL request against the composed schema.
+// Normalization inlines fragments and extracts literals into variables, so that
+// equivalent requests produce the same query text and variables.
+func ParseOperation(schema *graphql.Schema, gqlReq GraphQLRequest) (*Operation, error) {
+	req := &graphql.Request{
+		OperationName: gqlReq.OperationName,
+		Variables:     gqlReq.Variables,
+		Query:         gqlReq.Query,
+	}
+
+	opType, err := req.OperationType()
+	if err != nil {
+		return nil, fmt.Errorf("parse operation: %w", err)
+	}
+	if opType == graphql.OperationTypeUnknown {
+		return nil, fmt.Errorf("%w: %q", ErrOperationNotFound, gqlReq.OperationName)
+	}
+
+	result, err := req.Normalize(schema)
+	if err != nil {
+		return nil, fmt.Errorf("normalize operation: %w", err)
+	}
+	if !result.Successful {
+		if result.Errors != nil {
+			return nil, fmt.Errorf("normalize operation: %w", result.Errors)
+		}
+		return nil, errors.New("normalize operation: unsuccessful")
+	}
+
+	doc := req.Document()
+	ref := -1
+	for _, rootNode := range doc.RootNodes {
+		if rootNode.Kind != ast.NodeKindOperationDefinition {
+			continue
+		}
+		if gqlReq.OperationName != "" && doc.OperationDefinitionNameString(rootNode.Ref) != gqlReq.OperationName {
+			continue
+		}
+		ref = rootNode.Ref
+		break
+	}
+	if ref == -1 {
+		return nil, fmt.Errorf("%w: %q", ErrOperationNotFound, gqlReq.OperationName)
+	}
+
+	query, err := astprinter.PrintString(doc)
+	if err != nil {
+		return nil, fmt.Errorf("print operation: %w", err)
+	}
+
+	variables, err := canonicalVariables(req.Variables)
+	if err != nil {
+		return nil, fmt.Errorf("canonicalize variables: %w", err)
+	}
+
+	return &Operation{
+		Name:      doc.OperationDefinitionNameString(ref),
+		Type:      ast.OperationType(opType),
+		Document:  doc,
+		Ref:       ref,
+		Query:     query,
+		Variables: variables,
+	}, nil
+}
+
+// canonicalVariables re-encodes variables with sorted object keys and without insignificant whitespace
+func canonicalVariables(raw json.RawMessage) ([]byte, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return []byte("{}"), nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	// encoding/json writes map keys in sorted order
+	return json.Marshal(value)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+)
+
+func TestParseOperation(t *testing.T) {
+	schema := newQueryCostTestSchema(t)
+
+	op, err := ParseOperation(schema, GraphQLRequest{
+		Query:         `query A { order(id: "1") { id } } mutation B { order(id: "1") { id } }`,
+		OperationName: "A",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "A", op.Name)
+	assert.Equal(t, ast.OperationTypeQuery, op.Type)
+	assert.True(t, op.IsQuery())
+	assert.Equal(t, "A", op.Document.OperationDefinitionNameString(op.Ref))
+
+	_, err = ParseOperation(schema, GraphQLRequest{Query: `query A { order(id: "1") { id } }`, OperationName: "B"})
+	assert.ErrorIs(t, err, ErrOperationNotFound)
+
+	_, err = ParseOperation(schema, GraphQLRequest{Query: `{ order(id: "1") { id `})
+	assert.Error(t, err)
+}
+
+func TestParseOperationNormalizes(t *testing.T) {
+	schema := newQueryCostTestSchema(t)
+
+	parse := func(query, variables string) *Operation {
+		op, err := ParseOperation(schema, GraphQLRequest{Query: query, Variables: json.RawMessage(variables)})
+		require.NoError(t, err)
+		return op
+	}
+
+	tests := []struct {
+		name          string
+		a, b          *Operation
+		sameQuery     bool
+		sameVariables bool
+	}{
+		{
+			name:          "whitespace and field order of the variables",
+			a:             parse(`query P($limit: Int, $first: Int) { products(limit: $limit) { id } orders(first: $first) { edges { node { id } } } }`, `{"limit": 1, "first": 2}`),
+			b:             parse("query P($limit: Int, $first: Int) {\n  products(limit: $limit) { id }\n  orders(first: $first) { edges { node { id } } }\n}", `{"first":2,"limit":1}`),
+			sameQuery:     true,
+			sameVariables: true,
+		},
+		{
+			name:          "fragments inlined",
+			a:             parse(`query P { products(limit: 5) { ...ProductFields } } fragment ProductFields on Product { id name }`, ``),
+			b:             parse(`query P { products(limit: 5) { id name } }`, ``),
+			sameQuery:     true,
+			sameVariables: true,
+		},
+		{
+			name:      "literals extracted into variables",
+			a:         parse(`query P { products(limit: 5) { id } }`, ``),
+			b:         parse(`query P { products(limit: 6) { id } }`, ``),
+			sameQuery: true,
+		},
+		{
+			name: "other selection",
+			a:    parse(`query P { products(limit: 5) { id } }`, ``),
+			b:    parse(`query P { products(limit: 5) { id name } }`, ``),
+			// The extracted literals are the same
+			sameVariables: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.sameQuery, tt.a.Query == tt.b.Query, "%s\n%s", tt.a.Query, tt.b.Query)
+			assert.Equal(t, tt.sameVariables, string(tt.a.Variables) == string(tt.b.Variables), "%s\n%s", tt.a.Variables, tt.b.Variables)
+		})
+	}
+}
+
+func TestCanonicalVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables string
+		canonical string
+	}{
+		{name: "none", variables: ``, canonical: `{}`},
+		{name: "null", variables: ` null `, canonical: `{}`},
+		{name: "sorted keys", variables: `{"b": {"y": 1, "x": 2}, "a": [3, 1]}`, canonical: `{"a":[3,1],"b":{"x":2,"y":1}}`},
+		{name: "numbers kept as written", variables: `{"price": 1.50, "big": 12345678901234567890}`, canonical: `{"big":12345678901234567890,"price":1.50}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canonical, err := canonicalVariables(json.RawMessage(tt.variables))
+			require.NoError(t, err)
+			assert.Equal(t, tt.canonical, string(canonical))
+		})
+	}
+
+	_, err := canonicalVariables(json.RawMessage(`{"a":`))
+	assert.Error(t, err)
+}
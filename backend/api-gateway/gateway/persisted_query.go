@@ -0,0 +1,348 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"api-gateway/tracing"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// Error codes of the persisted query protocol
+const (
+	PersistedQueryNotFoundCode     = "PERSISTED_QUERY_NOT_FOUND"
+	PersistedQueryNotSupportedCode = "PERSISTED_QUERY_NOT_SUPPORTED"
+	PersistedQueryHashMismatchCode = "PERSISTED_QUERY_HASH_MISMATCH"
+	OperationNotAllowedCode        = "OPERATION_NOT_ALLOWED"
+)
+
+// automaticPersistedQueryTTL is how long a query registered by a client is kept
+const automaticPersistedQueryTTL = 24 * time.Hour
+
+const persistedQueryHashContextKey contextKey = "persisted_query_hash"
+
+// PersistedQueryExtension is the extensions.persistedQuery object of a GraphQL request
+type PersistedQueryExtension struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+// RequestExtensions are the extensions of a GraphQL request understood by the gateway
+type RequestExtensions struct {
+	PersistedQuery *PersistedQueryExtension `json:"persistedQuery,omitempty"`
+}
+
+// persistedQueryExtension returns the persisted query extension of a request, nil when
+// its extensions are missing or not in the shape the gateway understands
+func persistedQueryExtension(gqlReq GraphQLRequest) *PersistedQueryExtension {
+	var extensions RequestExtensions
+	if len(gqlReq.Extensions) == 0 || json.Unmarshal(gqlReq.Extensions, &extensions) != nil {
+		return nil
+	}
+	return extensions.PersistedQuery
+}
+
+// PersistedQueryConfig configures the persisted query middleware
+type PersistedQueryConfig struct {
+	// Strict only executes operations registered ahead of time
+	Strict bool
+}
+
+// PersistedQueryHashFromContext returns the SHA-256 hash of the query text of the request, if known
+func PersistedQueryHashFromContext(ctx context.Context) (string, bool) {
+	hash, ok := ctx.Value(persistedQueryHashContextKey).(string)
+	return hash, ok
+}
+
+// queryHash returns the hex encoded SHA-256 hash of a query text
+func queryHash(query string) string {
+	hash := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(hash[:])
+}
+
+// PersistedQueryMiddleware implements Apollo compatible Automatic Persisted Queries.
+// Requests with only extensions.persistedQuery.sha256Hash are completed with the stored
+// query text; requests with both store it. In strict mode only operations registered
+// from the manifest or the admin endpoint are executed, whether sent by hash or in full;
+// requests that are not a POST of a GraphQL request are rejected rather than passed on.
+func PersistedQueryMiddleware(next http.Handler, cacheService *redis.CacheService, config PersistedQueryConfig, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartRequest(r, "gateway.persisted_query")
+		defer span.End()
+
+		if r.Method != http.MethodPost {
+			if config.Strict {
+				w.Header().Set("Allow", http.MethodPost)
+				writeGraphQLError(w, http.StatusMethodNotAllowed, "Operations must be sent with POST", BadRequestCode)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			logger.Error("Failed to read request body", log.Error(err))
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		var gqlReq GraphQLRequest
+		if err := json.Unmarshal(body, &gqlReq); err != nil {
+			if config.Strict {
+				writeGraphQLError(w, http.StatusBadRequest, "Request body is not a GraphQL request", BadRequestCode)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		persisted := persistedQueryExtension(gqlReq)
+
+		ctx := r.Context()
+		var hash string
+
+		switch {
+		case persisted != nil && persisted.Version != 1:
+			writeGraphQLError(w, http.StatusBadRequest, "Unsupported persisted query version", PersistedQueryNotSupportedCode)
+			return
+
+		case persisted != nil && gqlReq.Query == "":
+			// Look the query up by hash
+			hash = strings.ToLower(persisted.Sha256Hash)
+			query, registered, found, err := lookupPersistedQuery(ctx, cacheService, hash, config.Strict)
+			if err != nil {
+				logger.Error("Persisted query lookup failed", log.Error(err))
+				http.Error(w, "Persisted query lookup failed", http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				// Clients answer this error by sending the full query
+				writeGraphQLError(w, http.StatusOK, "PersistedQueryNotFound", PersistedQueryNotFoundCode)
+				return
+			}
+			if registered {
+				recordRegisteredOperation(ctx)
+			}
+
+			gqlReq.Query = query
+			body, err = json.Marshal(gqlReq)
+			if err != nil {
+				http.Error(w, "Failed to encode request", http.StatusInternalServerError)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+			r.ContentLength = int64(len(body))
+
+		case persisted != nil:
+			// Register the query under its hash
+			hash = queryHash(gqlReq.Query)
+			if !strings.EqualFold(hash, persisted.Sha256Hash) {
+				writeGraphQLError(w, http.StatusBadRequest, "provided sha does not match query", PersistedQueryHashMismatchCode)
+				return
+			}
+			if config.Strict {
+				if !isRegisteredQuery(ctx, w, cacheService, hash, logger) {
+					return
+				}
+				recordRegisteredOperation(ctx)
+				break
+			}
+			if err := cacheService.SetAutomaticPersistedQuery(ctx, hash, gqlReq.Query, automaticPersistedQueryTTL); err != nil {
+				logger.Error("Failed to store persisted query", log.Error(err))
+			}
+
+		case config.Strict:
+			hash = queryHash(gqlReq.Query)
+			if !isRegisteredQuery(ctx, w, cacheService, hash, logger) {
+				return
+			}
+			recordRegisteredOperation(ctx)
+
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, persistedQueryHashContextKey, hash)))
+	})
+}
+
+// lookupPersistedQuery finds a query text by hash in the allowlist and, unless strict, in the
+// APQ store, and reports whether it was on the allowlist
+func lookupPersistedQuery(ctx context.Context, cacheService *redis.CacheService, hash string, strict bool) (query string, registered bool, found bool, err error) {
+	query, found, err = cacheService.GetRegisteredQuery(ctx, hash)
+	if err != nil || found || strict {
+		return query, found, found, err
+	}
+	query, found, err = cacheService.GetAutomaticPersistedQuery(ctx, hash)
+	return query, false, found, err
+}
+
+// isRegisteredQuery checks a query hash against the allowlist and writes the error response if it is not
+func isRegisteredQuery(ctx context.Context, w http.ResponseWriter, cacheService *redis.CacheService, hash string, logger log.Logger) bool {
+	_, found, err := cacheService.GetRegisteredQuery(ctx, hash)
+	if err != nil {
+		logger.Error("Persisted query lookup failed", log.Error(err))
+		http.Error(w, "Persisted query lookup failed", http.StatusInternalServerError)
+		return false
+	}
+	if !found {
+		logger.Warn("Rejected operation not on the allowlist", log.String("hash", hash))
+		writeGraphQLError(w, http.StatusForbidden, "Operation is not registered", OperationNotAllowedCode)
+		return false
+	}
+	return true
+}
+
+// writeGraphQLError writes a GraphQL response holding a single error
+func writeGraphQLError(w http.ResponseWriter, status int, message string, code string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{
+				"message":    message,
+				"extensions": map[string]interface{}{"code": code},
+			},
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// PersistedQueryManifest is the Apollo persisted query manifest format
+type PersistedQueryManifest struct {
+	Format     string                    `json:"format"`
+	Version    int                       `json:"version"`
+	Operations []PersistedQueryOperation `json:"operations"`
+}
+
+// PersistedQueryOperation is an operation of a persisted query manifest
+type PersistedQueryOperation struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+// queries validates the operations of a manifest and returns their query text by hash
+func (m PersistedQueryManifest) queries() (map[string]string, error) {
+	queries := make(map[string]string, len(m.Operations))
+	for _, operation := range m.Operations {
+		if operation.Body == "" {
+			return nil, fmt.Errorf("operation %q has no body", operation.Name)
+		}
+		hash := queryHash(operation.Body)
+		if operation.ID != "" && !strings.EqualFold(operation.ID, hash) {
+			return nil, fmt.Errorf("operation %q: id does not match the sha256 hash of its body", operation.Name)
+		}
+		queries[hash] = operation.Body
+	}
+	return queries, nil
+}
+
+// LoadPersistedQueryManifest registers the operations of the manifest file at path
+func LoadPersistedQueryManifest(ctx context.Context, path string, cacheService *redis.CacheService) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read persisted query manifest: %w", err)
+	}
+
+	var manifest PersistedQueryManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return 0, fmt.Errorf("parse persisted query manifest: %w", err)
+	}
+
+	queries, err := manifest.queries()
+	if err != nil {
+		return 0, fmt.Errorf("invalid persisted query manifest: %w", err)
+	}
+
+	if err := cacheService.RegisterPersistedQueries(ctx, queries); err != nil {
+		return 0, fmt.Errorf("register persisted queries: %w", err)
+	}
+	return len(queries), nil
+}
+
+// PersistedQueryAdminHandler manages the operation allowlist:
+//
+//	GET    /admin/persisted-queries         lists the registered operations by hash
+//	POST   /admin/persisted-queries         registers the operations of a manifest
+//	DELETE /admin/persisted-queries?id=...  removes an operation
+func PersistedQueryAdminHandler(cacheService *redis.CacheService, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodGet:
+			queries, err := cacheService.ListRegisteredQueries(ctx)
+			if err != nil {
+				http.Error(w, "Failed to list persisted queries", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"operations": queries})
+
+		case http.MethodPost:
+			var manifest PersistedQueryManifest
+			if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+				http.Error(w, "Invalid manifest", http.StatusBadRequest)
+				return
+			}
+			queries, err := manifest.queries()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := cacheService.RegisterPersistedQueries(ctx, queries); err != nil {
+				logger.Error("Failed to register persisted queries", log.Error(err))
+				http.Error(w, "Failed to register persisted queries", http.StatusInternalServerError)
+				return
+			}
+
+			ids := make([]string, 0, len(queries))
+			for hash := range queries {
+				ids = append(ids, hash)
+			}
+			logger.Info("Registered persisted queries", log.Int("count", len(ids)))
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"registered": ids})
+
+		case http.MethodDelete:
+			id := strings.ToLower(r.URL.Query().Get("id"))
+			if id == "" {
+				http.Error(w, "Missing id", http.StatusBadRequest)
+				return
+			}
+			removed, err := cacheService.UnregisterPersistedQuery(ctx, id)
+			if err != nil {
+				http.Error(w, "Failed to remove persisted query", http.StatusInternalServerError)
+				return
+			}
+			if !removed {
+				http.Error(w, "Persisted query not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
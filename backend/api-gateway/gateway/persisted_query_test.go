@@ -0,0 +1,325 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	registeredTestQuery = "query ListOrders { orders { id } }"
+	otherTestQuery      = "query Anything { orders { id userId } }"
+)
+
+// persistedQueryServed is what the persisted query middleware passed on
+type persistedQueryServed struct {
+	query  string
+	hash   string
+	hashOK bool
+}
+
+// persistedQueryNext stands in for the gateway and records the last request it serves
+type persistedQueryNext struct {
+	served *persistedQueryServed
+}
+
+func (n *persistedQueryNext) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var gqlReq GraphQLRequest
+	json.NewDecoder(r.Body).Decode(&gqlReq)
+	hash, ok := PersistedQueryHashFromContext(r.Context())
+	n.served = &persistedQueryServed{query: gqlReq.Query, hash: hash, hashOK: ok}
+	w.Write([]byte(`{"data":{}}`))
+}
+
+func newTestPersistedQueryMiddleware(t *testing.T, config PersistedQueryConfig) (http.Handler, *miniredis.Miniredis, *redis.CacheService, *persistedQueryNext) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cacheService := redis.NewCacheService(client, log.NoopLogger)
+	require.NoError(t, cacheService.RegisterPersistedQueries(context.Background(), map[string]string{
+		queryHash(registeredTestQuery): registeredTestQuery,
+	}))
+
+	next := &persistedQueryNext{}
+	return PersistedQueryMiddleware(next, cacheService, config, log.NoopLogger), server, cacheService, next
+}
+
+// persistedQueryBody is a GraphQL request for query, if any, sent with the persisted query extension
+func persistedQueryBody(t *testing.T, query string, version int, hash string) string {
+	extensions, err := json.Marshal(RequestExtensions{PersistedQuery: &PersistedQueryExtension{Version: version, Sha256Hash: hash}})
+	require.NoError(t, err)
+	body, err := json.Marshal(GraphQLRequest{Query: query, Extensions: extensions})
+	require.NoError(t, err)
+	return string(body)
+}
+
+// graphQLErrorCode returns the code of the single error of a GraphQL response
+func graphQLErrorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var resp struct {
+		Errors []struct {
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	require.Len(t, resp.Errors, 1)
+	code, _ := resp.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func TestPersistedQueryMiddlewareAutomatic(t *testing.T) {
+	handler, server, _, next := newTestPersistedQueryMiddleware(t, PersistedQueryConfig{})
+	hash := queryHash(otherTestQuery)
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		next.served = nil
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/query", strings.NewReader(body)))
+		return w
+	}
+
+	// Unknown hash, the client retries with the full query
+	w := serve(http.MethodPost, persistedQueryBody(t, "", 1, hash))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, PersistedQueryNotFoundCode, graphQLErrorCode(t, w))
+	assert.Contains(t, w.Body.String(), `"PersistedQueryNotFound"`)
+	assert.Nil(t, next.served)
+
+	// A hash that is not the hash of the query is not stored
+	w = serve(http.MethodPost, persistedQueryBody(t, otherTestQuery, 1, queryHash(registeredTestQuery)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, PersistedQueryHashMismatchCode, graphQLErrorCode(t, w))
+	assert.Nil(t, next.served)
+	assert.False(t, server.Exists(redis.APQPrefix+queryHash(registeredTestQuery)))
+
+	// The full query is stored, the hash is compared case insensitively
+	w = serve(http.MethodPost, persistedQueryBody(t, otherTestQuery, 1, strings.ToUpper(hash)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, next.served)
+	assert.Equal(t, persistedQueryServed{query: otherTestQuery, hash: hash, hashOK: true}, *next.served)
+	assert.Equal(t, automaticPersistedQueryTTL, server.TTL(redis.APQPrefix+hash))
+
+	// and then found by its hash
+	w = serve(http.MethodPost, persistedQueryBody(t, "", 1, strings.ToUpper(hash)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, next.served)
+	assert.Equal(t, persistedQueryServed{query: otherTestQuery, hash: hash, hashOK: true}, *next.served)
+
+	// as are registered operations
+	w = serve(http.MethodPost, persistedQueryBody(t, "", 1, queryHash(registeredTestQuery)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, next.served)
+	assert.Equal(t, registeredTestQuery, next.served.query)
+
+	w = serve(http.MethodPost, persistedQueryBody(t, "", 2, hash))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, PersistedQueryNotSupportedCode, graphQLErrorCode(t, w))
+	assert.Nil(t, next.served)
+
+	// Requests without the extension are passed on as they are
+	for _, method := range []string{http.MethodPost, http.MethodGet} {
+		w = serve(method, `{"query":"{ orders { id } }"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		require.NotNil(t, next.served)
+		assert.False(t, next.served.hashOK)
+	}
+}
+
+func TestPersistedQueryMiddlewareStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   func(t *testing.T) string
+		status int
+		code   string
+		// query is the query passed on when the request is served
+		query string
+	}{
+		{
+			name:   "registered query by hash",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				return persistedQueryBody(t, "", 1, queryHash(registeredTestQuery))
+			},
+			status: http.StatusOK,
+			query:  registeredTestQuery,
+		},
+		{
+			name:   "registered query in full",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				body, _ := json.Marshal(GraphQLRequest{Query: registeredTestQuery})
+				return string(body)
+			},
+			status: http.StatusOK,
+			query:  registeredTestQuery,
+		},
+		{
+			name:   "registered query in full with its hash",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				return persistedQueryBody(t, registeredTestQuery, 1, queryHash(registeredTestQuery))
+			},
+			status: http.StatusOK,
+			query:  registeredTestQuery,
+		},
+		{
+			name:   "unregistered query in full",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				body, _ := json.Marshal(GraphQLRequest{Query: otherTestQuery})
+				return string(body)
+			},
+			status: http.StatusForbidden,
+			code:   OperationNotAllowedCode,
+		},
+		{
+			name:   "unregistered query in full with its hash",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				return persistedQueryBody(t, otherTestQuery, 1, queryHash(otherTestQuery))
+			},
+			status: http.StatusForbidden,
+			code:   OperationNotAllowedCode,
+		},
+		{
+			name:   "automatic persisted query by hash",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				return persistedQueryBody(t, "", 1, queryHash(otherTestQuery))
+			},
+			status: http.StatusOK,
+			code:   PersistedQueryNotFoundCode,
+		},
+		{
+			name:   "hash mismatch",
+			method: http.MethodPost,
+			body: func(t *testing.T) string {
+				return persistedQueryBody(t, otherTestQuery, 1, queryHash(registeredTestQuery))
+			},
+			status: http.StatusBadRequest,
+			code:   PersistedQueryHashMismatchCode,
+		},
+		{
+			name:   "GET",
+			method: http.MethodGet,
+			body:   func(t *testing.T) string { return "" },
+			status: http.StatusMethodNotAllowed,
+			code:   BadRequestCode,
+		},
+		{
+			name:   "body that is not a GraphQL request",
+			method: http.MethodPost,
+			body:   func(t *testing.T) string { return `query { orders { id } }` },
+			status: http.StatusBadRequest,
+			code:   BadRequestCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, server, cacheService, next := newTestPersistedQueryMiddleware(t, PersistedQueryConfig{Strict: true})
+			// Stored by a client before strict mode was turned on
+			require.NoError(t, cacheService.SetAutomaticPersistedQuery(context.Background(), queryHash(otherTestQuery), otherTestQuery, automaticPersistedQueryTTL))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/query", strings.NewReader(tt.body(t))))
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.code != "" {
+				assert.Equal(t, tt.code, graphQLErrorCode(t, w))
+				assert.Nil(t, next.served)
+				return
+			}
+			require.NotNil(t, next.served)
+			assert.Equal(t, persistedQueryServed{query: tt.query, hash: queryHash(tt.query), hashOK: true}, *next.served)
+			assert.Equal(t, []string{redis.APQPrefix + queryHash(otherTestQuery)}, filterKeys(server.Keys(), redis.APQPrefix))
+		})
+	}
+
+	t.Run("GET is told to POST", func(t *testing.T) {
+		handler, _, _, _ := newTestPersistedQueryMiddleware(t, PersistedQueryConfig{Strict: true})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/query?query={orders{id}}", nil))
+		assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+	})
+}
+
+// filterKeys returns the keys starting with prefix
+func filterKeys(keys []string, prefix string) []string {
+	var filtered []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+func TestLoadPersistedQueryManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		err      string
+		count    int
+	}{
+		{
+			name:     "operations with and without ids",
+			manifest: `{"format":"apollo-persisted-query-manifest","version":1,"operations":[{"id":"` + strings.ToUpper(queryHash(registeredTestQuery)) + `","name":"ListOrders","type":"query","body":"` + registeredTestQuery + `"},{"name":"Anything","type":"query","body":"` + otherTestQuery + `"}]}`,
+			count:    2,
+		},
+		{
+			name:     "id that is not the hash of the body",
+			manifest: `{"operations":[{"id":"` + queryHash(otherTestQuery) + `","name":"ListOrders","body":"` + registeredTestQuery + `"}]}`,
+			err:      `operation "ListOrders": id does not match`,
+		},
+		{
+			name:     "operation without a body",
+			manifest: `{"operations":[{"name":"ListOrders"}]}`,
+			err:      `operation "ListOrders" has no body`,
+		},
+		{
+			name:     "not a manifest",
+			manifest: `[`,
+			err:      "parse persisted query manifest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { client.Close() })
+			cacheService := redis.NewCacheService(client, log.NoopLogger)
+
+			path := filepath.Join(t.TempDir(), "manifest.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.manifest), 0o600))
+
+			count, err := LoadPersistedQueryManifest(context.Background(), path, cacheService)
+			queries, listErr := cacheService.ListRegisteredQueries(context.Background())
+			require.NoError(t, listErr)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				assert.Empty(t, queries)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.count, count)
+			assert.Equal(t, map[string]string{
+				queryHash(registeredTestQuery): registeredTestQuery,
+				queryHash(otherTestQuery):      otherTestQuery,
+			}, queries)
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"api-gateway/tracing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+)
+
+// paginationArguments are the arguments bounding the size of a list field
+var paginationArguments = [][]byte{[]byte("first"), []byte("last"), []byte("limit")}
+
+// QueryLimits bounds the operations accepted by the gateway. A zero limit is not enforced.
+type QueryLimits struct {
+	MaxDepth   int
+	MaxAliases int
+	MaxCost    int
+	// DefaultListSize is the assumed size of list fields without a pagination argument
+	DefaultListSize int
+}
+
+// QueryLimitsFromEnv reads the query limits from QUERY_MAX_DEPTH, QUERY_MAX_ALIASES,
+// QUERY_MAX_COST and QUERY_DEFAULT_LIST_SIZE
+func QueryLimitsFromEnv() QueryLimits {
+	return QueryLimits{
+		MaxDepth:        envInt("QUERY_MAX_DEPTH", 10),
+		MaxAliases:      envInt("QUERY_MAX_ALIASES", 30),
+		MaxCost:         envInt("QUERY_MAX_COST", 5000),
+		DefaultListSize: envInt("QUERY_DEFAULT_LIST_SIZE", 10),
+	}
+}
+
+// envInt reads an integer environment variable
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envDuration reads a duration environment variable such as "15m"
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// QueryCost is the static analysis of an operation
+type QueryCost struct {
+	Depth   int
+	Aliases int
+	// Cost is the estimated number of objects resolved, list fields multiplied by their size
+	Cost int
+}
+
+// AnalyzeOperation computes the depth, alias count and cost of a normalized operation.
+// Each object resolved costs one point; list fields multiply the cost of their selections
+// by their first/last/limit argument, or by DefaultListSize. On connections, the argument
+// of the connection field sizes the list directly below it (e.g. edges).
+func AnalyzeOperation(schema *graphql.Schema, op *Operation, limits QueryLimits) QueryCost {
+	analyzer := &queryCostAnalyzer{
+		operation:       op.Document,
+		definition:      schema.Document(),
+		variables:       op.Variables,
+		defaultListSize: limits.DefaultListSize,
+	}
+
+	var rootType string
+	switch op.Type {
+	case ast.OperationTypeMutation:
+		rootType = schema.MutationTypeName()
+	case ast.OperationTypeSubscription:
+		rootType = schema.SubscriptionTypeName()
+	default:
+		rootType = schema.QueryTypeName()
+	}
+
+	operation := op.Document.OperationDefinitions[op.Ref]
+	if operation.HasSelections {
+		analyzer.walk(operation.SelectionSet, rootType, 1, 1, 0)
+	}
+
+	return analyzer.cost
+}
+
+type queryCostAnalyzer struct {
+	operation       *ast.Document
+	definition      *ast.Document
+	variables       []byte
+	defaultListSize int
+
+	cost QueryCost
+}
+
+// walk adds up the cost of a selection set resolved multiplier times, at the given depth.
+// pageSize is the pagination argument of the parent field, if it was not a list itself.
+func (a *queryCostAnalyzer) walk(selectionSet int, typeName string, depth int, multiplier int, pageSize int) {
+	for _, selectionRef := range a.operation.SelectionSets[selectionSet].SelectionRefs {
+		selection := a.operation.Selections[selectionRef]
+
+		switch selection.Kind {
+		case ast.SelectionKindField:
+			a.walkField(selection.Ref, typeName, depth, multiplier, pageSize)
+		case ast.SelectionKindInlineFragment:
+			fragment := a.operation.InlineFragments[selection.Ref]
+			fragmentType := typeName
+			if fragment.TypeCondition.Type != -1 {
+				fragmentType = a.operation.InlineFragmentTypeConditionNameString(selection.Ref)
+			}
+			if fragment.HasSelections {
+				a.walk(fragment.SelectionSet, fragmentType, depth, multiplier, pageSize)
+			}
+		}
+	}
+}
+
+func (a *queryCostAnalyzer) walkField(fieldRef int, typeName string, depth int, multiplier int, pageSize int) {
+	name := a.operation.FieldNameString(fieldRef)
+	// Introspection is not federated and does not count against the limits
+	if strings.HasPrefix(name, "__") {
+		return
+	}
+
+	if a.operation.FieldAliasIsDefined(fieldRef) {
+		a.cost.Aliases++
+	}
+	if depth > a.cost.Depth {
+		a.cost.Depth = depth
+	}
+
+	field := a.operation.Fields[fieldRef]
+	if !field.HasSelections {
+		return
+	}
+
+	fieldType, isList := a.fieldType(typeName, name)
+	size := a.paginationSize(fieldRef)
+
+	childPageSize := 0
+	if isList {
+		switch {
+		case size > 0:
+		case pageSize > 0:
+			size = pageSize
+		default:
+			size = a.defaultListSize
+		}
+		multiplier = saturatingMul(multiplier, size)
+	} else {
+		childPageSize = size
+	}
+
+	a.cost.Cost = saturatingAdd(a.cost.Cost, multiplier)
+	a.walk(field.SelectionSet, fieldType, depth+1, multiplier, childPageSize)
+}
+
+// fieldType returns the named type of a field in the schema and whether it is a list
+func (a *queryCostAnalyzer) fieldType(typeName string, fieldName string) (string, bool) {
+	node, ok := a.definition.NodeByNameStr(typeName)
+	if !ok {
+		return "", false
+	}
+	ref, ok := a.definition.NodeFieldDefinitionByName(node, []byte(fieldName))
+	if !ok {
+		return "", false
+	}
+	typeRef := a.definition.FieldDefinitionType(ref)
+	return a.definition.ResolveTypeNameString(typeRef), a.definition.TypeIsList(typeRef)
+}
+
+// paginationSize returns the value of the pagination argument of a field, or 0
+func (a *queryCostAnalyzer) paginationSize(fieldRef int) int {
+	for _, name := range paginationArguments {
+		argRef, ok := a.operation.FieldArgument(fieldRef, name)
+		if !ok {
+			continue
+		}
+
+		value := a.operation.ArgumentValue(argRef)
+		switch value.Kind {
+		case ast.ValueKindInteger:
+			return int(a.operation.IntValueAsInt(value.Ref))
+		case ast.ValueKindVariable:
+			// Normalization extracts literals into variables
+			var size int
+			raw := variableValue(a.variables, a.operation.VariableValueNameString(value.Ref))
+			if raw != nil && json.Unmarshal(raw, &size) == nil {
+				return size
+			}
+		}
+	}
+	return 0
+}
+
+// variableValue returns the raw JSON value of a variable
+func variableValue(variables []byte, name string) json.RawMessage {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(variables, &values); err != nil {
+		return nil
+	}
+	return values[name]
+}
+
+func saturatingMul(a, b int) int {
+	if a != 0 && b > (1<<31)/a {
+		return 1 << 31
+	}
+	return a * b
+}
+
+func saturatingAdd(a, b int) int {
+	if a+b > 1<<31 {
+		return 1 << 31
+	}
+	return a + b
+}
+
+// queryLimitError describes the first limit exceeded by an operation
+func queryLimitError(cost QueryCost, limits QueryLimits) (code string, message string, exceeded bool) {
+	switch {
+	case limits.MaxDepth > 0 && cost.Depth > limits.MaxDepth:
+		return "QUERY_TOO_DEEP", fmt.Sprintf("Query depth %d exceeds the maximum depth of %d", cost.Depth, limits.MaxDepth), true
+	case limits.MaxAliases > 0 && cost.Aliases > limits.MaxAliases:
+		return "QUERY_TOO_MANY_ALIASES", fmt.Sprintf("Query uses %d aliases, the maximum is %d", cost.Aliases, limits.MaxAliases), true
+	case limits.MaxCost > 0 && cost.Cost > limits.MaxCost:
+		return "QUERY_TOO_COMPLEX", fmt.Sprintf("Query cost %d exceeds the maximum cost of %d", cost.Cost, limits.MaxCost), true
+	}
+	return "", "", false
+}
+
+const (
+	operationContextKey contextKey = "operation"
+	queryCostContextKey contextKey = "query_cost"
+)
+
+// OperationFromContext returns the operation parsed by QueryCostMiddleware, if any
+func OperationFromContext(ctx context.Context) (*Operation, bool) {
+	op, ok := ctx.Value(operationContextKey).(*Operation)
+	return op, ok
+}
+
+// QueryCostFromContext returns the cost computed by QueryCostMiddleware, if any
+func QueryCostFromContext(ctx context.Context) (QueryCost, bool) {
+	cost, ok := ctx.Value(queryCostContextKey).(QueryCost)
+	return cost, ok
+}
+
+// QueryCostMiddleware rejects operations exceeding the depth, alias or cost limits.
+// The operation is parsed against the composed schema of the gateway; the parsed operation
+// and its cost are put in the request context for the rate limiter, the authorization
+// and the cache. Requests that are not a POST, or whose operation cannot be parsed, are
+// rejected: the limits and the authorization could not be applied to them.
+func QueryCostMiddleware(next http.Handler, schemaProvider SchemaProvider, limits QueryLimits, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartRequest(r, "gateway.query_cost")
+		defer span.End()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeGraphQLError(w, http.StatusMethodNotAllowed, "Operations must be sent with POST", BadRequestCode)
+			return
+		}
+
+		schema := schemaProvider.Schema()
+		if schema == nil {
+			writeGraphQLError(w, http.StatusServiceUnavailable, "Supergraph is not composed yet", SubgraphUnavailableCode)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			logger.Error("Failed to read request body", log.Error(err))
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		var gqlReq GraphQLRequest
+		if err := json.Unmarshal(body, &gqlReq); err != nil {
+			writeGraphQLError(w, http.StatusBadRequest, "Request body is not a GraphQL request", BadRequestCode)
+			return
+		}
+
+		op, err := ParseOperation(schema, gqlReq)
+		if err != nil {
+			logger.Debug("Rejected operation that could not be parsed", log.Error(err))
+			writeGraphQLError(w, http.StatusBadRequest, err.Error(), GraphQLParseFailedCode)
+			return
+		}
+		recordOperation(r.Context(), op)
+
+		cost := AnalyzeOperation(schema, op, limits)
+		if code, message, exceeded := queryLimitError(cost, limits); exceeded {
+			logger.Warn("Rejected operation over query limits",
+				log.String("operation", op.Name),
+				log.String("code", code),
+				log.Int("depth", cost.Depth),
+				log.Int("aliases", cost.Aliases),
+				log.Int("cost", cost.Cost),
+			)
+
+			response, _ := json.Marshal(map[string]interface{}{
+				"errors": []map[string]interface{}{
+					{
+						"message": message,
+						"extensions": map[string]interface{}{
+							"code":    code,
+							"depth":   cost.Depth,
+							"aliases": cost.Aliases,
+							"cost":    cost.Cost,
+						},
+					},
+				},
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(response)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), operationContextKey, op)
+		ctx = context.WithValue(ctx, queryCostContextKey, cost)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
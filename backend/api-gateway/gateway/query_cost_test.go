@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+)
+
+const queryCostTestSchema = `
+	type Query {
+		order(id: ID!): Order
+		orders(first: Int): OrderConnection!
+		products(limit: Int): [Product!]!
+	}
+
+	type OrderConnection {
+		edges: [OrderEdge!]!
+	}
+
+	type OrderEdge {
+		node: Order!
+	}
+
+	type Order {
+		id: ID!
+		details: [OrderDetail!]!
+	}
+
+	type OrderDetail {
+		id: ID!
+		product: Product
+	}
+
+	type Product {
+		id: ID!
+		name: String
+		related(limit: Int): [Product!]!
+	}
+`
+
+// staticSchema provides a fixed supergraph schema
+type staticSchema struct {
+	schema *graphql.Schema
+}
+
+func (s staticSchema) Schema() *graphql.Schema {
+	return s.schema
+}
+
+func newQueryCostTestSchema(t *testing.T) *graphql.Schema {
+	schema, err := graphql.NewSchemaFromString(queryCostTestSchema)
+	require.NoError(t, err)
+	return schema
+}
+
+func TestAnalyzeOperation(t *testing.T) {
+	schema := newQueryCostTestSchema(t)
+	limits := QueryLimits{DefaultListSize: 10}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables string
+		cost      QueryCost
+	}{
+		{
+			name:  "single object",
+			query: `{ order(id: "1") { id } }`,
+			cost:  QueryCost{Depth: 2, Cost: 1},
+		},
+		{
+			name:  "list sized by its argument",
+			query: `{ products(limit: 5) { id } }`,
+			cost:  QueryCost{Depth: 2, Cost: 5},
+		},
+		{
+			name:      "list sized by a variable",
+			query:     `query Products($limit: Int) { products(limit: $limit) { id } }`,
+			variables: `{"limit": 3}`,
+			cost:      QueryCost{Depth: 2, Cost: 3},
+		},
+		{
+			name:  "connection sizing the list below it",
+			query: `{ orders(first: 20) { edges { node { id details { id product { name } } } } } }`,
+			// orders 1 + edges 20 + nodes 20 + details 20*10 + products 200
+			cost: QueryCost{Depth: 6, Cost: 441},
+		},
+		{
+			name:  "aliases",
+			query: `{ a: order(id: "1") { id } b: order(id: "2") { ref: id } }`,
+			cost:  QueryCost{Depth: 2, Aliases: 3, Cost: 2},
+		},
+		{
+			name:  "introspection fields",
+			query: `{ __typename order(id: "1") { __typename id } }`,
+			cost:  QueryCost{Depth: 2, Cost: 1},
+		},
+		{
+			name:  "lists added up",
+			query: `{ products(limit: 100000) { id } a: products(limit: 100000) { id } b: products(limit: 100000) { id } }`,
+			cost:  QueryCost{Depth: 2, Aliases: 2, Cost: 300000},
+		},
+		{
+			name:  "overflowing cost",
+			query: `{ products(limit: 100000) { related(limit: 100000) { related(limit: 100000) { id } } } }`,
+			cost:  QueryCost{Depth: 4, Cost: 1 << 31},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := ParseOperation(schema, GraphQLRequest{Query: tt.query, Variables: json.RawMessage(tt.variables)})
+			require.NoError(t, err)
+			assert.Equal(t, tt.cost, AnalyzeOperation(schema, op, limits))
+		})
+	}
+}
+
+func TestQueryLimitError(t *testing.T) {
+	limits := QueryLimits{MaxDepth: 5, MaxAliases: 2, MaxCost: 100}
+
+	tests := []struct {
+		name string
+		cost QueryCost
+		code string
+	}{
+		{name: "within the limits", cost: QueryCost{Depth: 5, Aliases: 2, Cost: 100}},
+		{name: "too deep", cost: QueryCost{Depth: 6, Aliases: 3, Cost: 101}, code: "QUERY_TOO_DEEP"},
+		{name: "too many aliases", cost: QueryCost{Depth: 5, Aliases: 3, Cost: 101}, code: "QUERY_TOO_MANY_ALIASES"},
+		{name: "too complex", cost: QueryCost{Depth: 5, Aliases: 2, Cost: 101}, code: "QUERY_TOO_COMPLEX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, exceeded := queryLimitError(tt.cost, limits)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.code != "", exceeded)
+		})
+	}
+
+	_, _, exceeded := queryLimitError(QueryCost{Depth: 100, Aliases: 100, Cost: 1 << 31}, QueryLimits{})
+	assert.False(t, exceeded, "zero limits are not enforced")
+}
+
+func TestQueryCostMiddleware(t *testing.T) {
+	limits := QueryLimits{MaxDepth: 4, MaxAliases: 2, MaxCost: 50, DefaultListSize: 10}
+
+	var served *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r
+		w.Write([]byte(`{"data":{}}`))
+	})
+	handler := QueryCostMiddleware(next, staticSchema{newQueryCostTestSchema(t)}, limits, log.NoopLogger)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		code   string
+	}{
+		{
+			name:   "within the limits",
+			method: http.MethodPost,
+			body:   `{"query":"{ products(limit: 5) { id name } }"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "GET",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+			code:   BadRequestCode,
+		},
+		{
+			name:   "body that is not a GraphQL request",
+			method: http.MethodPost,
+			body:   `query { products { id } }`,
+			status: http.StatusBadRequest,
+			code:   BadRequestCode,
+		},
+		{
+			name:   "query that does not parse",
+			method: http.MethodPost,
+			body:   `{"query":"{ products(limit: 5) { id "}`,
+			status: http.StatusBadRequest,
+			code:   GraphQLParseFailedCode,
+		},
+		{
+			name:   "unknown operation name",
+			method: http.MethodPost,
+			body:   `{"query":"query A { order(id: \"1\") { id } }","operationName":"B"}`,
+			status: http.StatusBadRequest,
+			code:   GraphQLParseFailedCode,
+		},
+		{
+			name:   "too deep",
+			method: http.MethodPost,
+			body:   `{"query":"{ orders(first: 1) { edges { node { details { id } } } } }"}`,
+			status: http.StatusBadRequest,
+			code:   "QUERY_TOO_DEEP",
+		},
+		{
+			name:   "too many aliases",
+			method: http.MethodPost,
+			body:   `{"query":"{ a: order(id: \"1\") { id } b: order(id: \"2\") { id } c: order(id: \"3\") { id } }"}`,
+			status: http.StatusBadRequest,
+			code:   "QUERY_TOO_MANY_ALIASES",
+		},
+		{
+			name:   "too complex",
+			method: http.MethodPost,
+			body:   `{"query":"{ products(limit: 51) { id } }"}`,
+			status: http.StatusBadRequest,
+			code:   "QUERY_TOO_COMPLEX",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = nil
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/query", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.code == "" {
+				require.NotNil(t, served)
+				op, ok := OperationFromContext(served.Context())
+				require.True(t, ok)
+				assert.True(t, op.IsQuery())
+				cost, ok := QueryCostFromContext(served.Context())
+				require.True(t, ok)
+				assert.Equal(t, QueryCost{Depth: 2, Cost: 5}, cost)
+				return
+			}
+
+			assert.Nil(t, served)
+			var resp struct {
+				Errors []struct {
+					Extensions map[string]interface{} `json:"extensions"`
+				} `json:"errors"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+			require.Len(t, resp.Errors, 1)
+			assert.Equal(t, tt.code, resp.Errors[0].Extensions["code"])
+		})
+	}
+
+	t.Run("GET is told to POST", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/query?query={products{id}}", nil))
+		assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+	})
+
+	t.Run("before the supergraph is composed", func(t *testing.T) {
+		handler := QueryCostMiddleware(next, staticSchema{}, limits, log.NoopLogger)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"{ products { id } }"}`)))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
@@ -1,87 +1,307 @@
 package main
 
 import (
+	"api-gateway/metrics"
 	"api-gateway/redis"
+	"api-gateway/tracing"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/astparser"
 )
 
-// RateLimitMiddleware implements rate limiting using Redis
-func RateLimitMiddleware(next http.Handler, rateLimiter *redis.RateLimiter, logger log.Logger) http.Handler {
+// APIKeyHeader carries the API key of a client
+const APIKeyHeader = "X-API-Key"
+
+// appliedRateLimit is the result of one policy for the current request
+type appliedRateLimit struct {
+	policy     RateLimitPolicy
+	identifier string
+	cost       int
+	result     redis.RateLimitResult
+}
+
+// RateLimitMiddleware implements rate limiting using Redis.
+// Every policy matching the route and GraphQL operation is checked for the client it is keyed by,
+// as well as the rate limit of the API key the request was authenticated with, if it has one.
+// A request rejected by a policy is refunded to the policies checked before, so it only
+// counts against the limit it exceeded. Clients are identified by their address, as
+// forwarded by trustedProxies.
+// It must run after JWTMiddleware for policies keyed by user to apply, and after
+// QueryCostMiddleware for policies charging the query cost.
+func RateLimitMiddleware(next http.Handler, rateLimiter *redis.RateLimiter, policies []RateLimitPolicy, trustedProxies TrustedProxies, logger log.Logger) http.Handler {
+	needsOperation := false
+	for _, policy := range policies {
+		if policy.Operation != "" {
+			needsOperation = true
+		}
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract identifier (IP address or user ID)
-		identifier := getClientIdentifier(r)
+		r, span := tracing.StartRequest(r, "gateway.rate_limit")
+		defer span.End()
 
-		// Check rate limit: 100 requests per minute
-		allowed, remaining, resetTime, err := rateLimiter.CheckRateLimit(
-			r.Context(),
-			identifier,
-			100,           // max requests
-			1*time.Minute, // window
-		)
+		operation := ""
+		if op, ok := OperationFromContext(r.Context()); ok {
+			operation = op.Name
+		} else if needsOperation && r.Method == http.MethodPost {
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				logger.Error("Failed to read request body", log.Error(err))
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+			operation = requestOperationName(body)
+		}
 
-		if err != nil {
-			logger.Error("Rate limit check failed", log.Error(err))
-			// On error, allow the request (fail open)
-			next.ServeHTTP(w, r)
-			return
+		requestPolicies := policies
+		if key, ok := APIKeyFromContext(r.Context()); ok {
+			if policy, ok := apiKeyRateLimitPolicy(key); ok {
+				requestPolicies = append(policies[:len(policies):len(policies)], policy)
+			}
 		}
 
-		// Add rate limit headers
-		w.Header().Set("X-RateLimit-Limit", "100")
-		w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		w.Header().Set("X-RateLimit-Reset", resetTime.Format(time.RFC3339))
-
-		if !allowed {
-			logger.Warn("Rate limit exceeded",
-				log.String("identifier", identifier),
-				log.String("endpoint", r.URL.Path),
-			)
-
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(time.Until(resetTime).Seconds())))
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte(`{
-				"errors": [{
-					"message": "Rate limit exceeded. Please try again later.",
-					"extensions": {
-						"code": "RATE_LIMIT_EXCEEDED",
-						"limit": 100,
-						"remaining": 0,
-						"reset": "` + resetTime.Format(time.RFC3339) + `"
-					}
-				}]
-			}`))
-			return
+		var applied []appliedRateLimit
+		for _, policy := range requestPolicies {
+			if !policy.matches(r.URL.Path, operation) {
+				continue
+			}
+
+			identifier, ok := rateLimitIdentifier(r, policy.Key, trustedProxies)
+			if !ok {
+				continue
+			}
+
+			cost := 1
+			if policy.ChargeCost {
+				if queryCost, ok := QueryCostFromContext(r.Context()); ok && queryCost.Cost > 1 {
+					cost = queryCost.Cost
+				}
+			}
+
+			result, err := rateLimiter.Allow(r.Context(), policy.Algorithm, policy.Name+":"+identifier, policy.RateLimit(), cost)
+			if err != nil {
+				// On error, skip the policy (fail open)
+				logger.Error("Rate limit check failed", log.String("policy", policy.Name), log.Error(err))
+				continue
+			}
+
+			applied = append(applied, appliedRateLimit{policy: policy, identifier: identifier, cost: cost, result: result})
+
+			if !result.Allowed {
+				refundRateLimits(r, rateLimiter, applied[:len(applied)-1], logger)
+				metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+				logger.Warn("Rate limit exceeded",
+					log.String("policy", policy.Name),
+					log.String("identifier", identifier),
+					log.String("endpoint", r.URL.Path),
+				)
+				writeRateLimitHeaders(w, applied, result)
+				writeRateLimitExceeded(w, policy, result)
+				return
+			}
 		}
 
-		logger.Debug("Rate limit check passed",
-			log.String("identifier", identifier),
-			log.Int("remaining", remaining),
-		)
+		if len(applied) > 0 {
+			// Report the policy closest to its limit
+			closest := applied[0].result
+			for _, a := range applied[1:] {
+				if a.result.Remaining < closest.Remaining {
+					closest = a.result
+				}
+			}
+			writeRateLimitHeaders(w, applied, closest)
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// getClientIdentifier extracts a unique identifier for rate limiting
-func getClientIdentifier(r *http.Request) string {
-	// Try to get user ID from JWT claims (if available)
-	// For now, use IP address as identifier
+// refundRateLimits gives back the quota a rejected request consumed under other policies
+func refundRateLimits(r *http.Request, rateLimiter *redis.RateLimiter, applied []appliedRateLimit, logger log.Logger) {
+	for _, a := range applied {
+		err := rateLimiter.Refund(r.Context(), a.policy.Algorithm, a.policy.Name+":"+a.identifier, a.policy.RateLimit(), a.cost, a.result)
+		if err != nil {
+			logger.Error("Rate limit refund failed", log.String("policy", a.policy.Name), log.Error(err))
+		}
+	}
+}
+
+// writeRateLimitHeaders sets the RateLimit-* headers of the IETF rate limit headers draft
+func writeRateLimitHeaders(w http.ResponseWriter, applied []appliedRateLimit, result redis.RateLimitResult) {
+	policies := make([]string, 0, len(applied))
+	for _, a := range applied {
+		policies = append(policies, fmt.Sprintf("%d;w=%d;comment=%q",
+			a.result.Limit, int(time.Duration(a.policy.Window).Seconds()), a.policy.Name))
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	w.Header().Set("RateLimit-Policy", strings.Join(policies, ", "))
+}
+
+// writeRateLimitExceeded writes the 429 response of a rejected request
+func writeRateLimitExceeded(w http.ResponseWriter, policy RateLimitPolicy, result redis.RateLimitResult) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{
+				"message": "Rate limit exceeded. Please try again later.",
+				"extensions": map[string]interface{}{
+					"code":        "RATE_LIMIT_EXCEEDED",
+					"policy":      policy.Name,
+					"limit":       result.Limit,
+					"remaining":   result.Remaining,
+					"retry_after": ceilSeconds(result.RetryAfter),
+				},
+			},
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
 
-	// Check for X-Forwarded-For header (if behind proxy)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
+// rateLimitIdentifier returns who a request is counted for under a policy key
+func rateLimitIdentifier(r *http.Request, key RateLimitKey, trustedProxies TrustedProxies) (string, bool) {
+	switch key {
+	case RateLimitKeyUser:
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok || claims.Username == "" {
+			return "", false
+		}
+		return "user:" + claims.Username, true
+	case RateLimitKeyAPIKey:
+		apiKey := r.Header.Get(APIKeyHeader)
+		if apiKey == "" {
+			return "", false
+		}
+		// Never keep raw API keys in Redis key names
+		hash := sha256.Sum256([]byte(apiKey))
+		return "apikey:" + hex.EncodeToString(hash[:]), true
+	default:
+		return "ip:" + trustedProxies.ClientIP(r), true
+	}
+}
+
+// requestOperationName returns the name of the operation a GraphQL request executes.
+// Without operationName, the name of the only operation in the document is used.
+func requestOperationName(body []byte) string {
+	var gqlReq GraphQLRequest
+	if err := json.Unmarshal(body, &gqlReq); err != nil {
+		return ""
+	}
+	if gqlReq.OperationName != "" {
+		return gqlReq.OperationName
+	}
+
+	doc, report := astparser.ParseGraphqlDocumentString(gqlReq.Query)
+	if report.HasErrors() {
+		return ""
+	}
+
+	name := ""
+	operations := 0
+	for _, rootNode := range doc.RootNodes {
+		if rootNode.Kind == ast.NodeKindOperationDefinition {
+			operations++
+			name = doc.OperationDefinitionNameString(rootNode.Ref)
+		}
+	}
+	if operations != 1 {
+		return ""
+	}
+	return name
+}
+
+// TrustedProxies are the networks of the proxies in front of the gateway, whose
+// X-Forwarded-For and X-Real-IP headers are trusted
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or IP addresses
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// trusts reports whether ip belongs to a trusted proxy
+func (p TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client of a request. The forwarding headers are only
+// read from trusted proxies, X-Forwarded-For from the right, so a client cannot pick the
+// address it is rate limited by.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	remoteIP := net.ParseIP(remote)
+	if remoteIP == nil || !p.trusts(remoteIP) {
+		return remote
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				return remote
+			}
+			if !p.trusts(hop) || i == 0 {
+				return hop.String()
+			}
+		}
 	}
 
-	// Check for X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	if xri := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); xri != nil {
+		return xri.String()
 	}
 
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
+	return remote
 }
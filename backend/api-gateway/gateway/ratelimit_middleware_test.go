@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		clientIP   string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:51234",
+			clientIP:   "203.0.113.7",
+		},
+		{
+			name:       "direct client spoofing the headers",
+			remoteAddr: "203.0.113.7:51234",
+			forwarded:  []string{"198.51.100.1"},
+			realIP:     "198.51.100.2",
+			clientIP:   "203.0.113.7",
+		},
+		{
+			name:       "client behind a trusted proxy",
+			remoteAddr: "10.1.2.3:443",
+			forwarded:  []string{"203.0.113.7"},
+			clientIP:   "203.0.113.7",
+		},
+		{
+			name:       "client prepending a forged hop",
+			remoteAddr: "10.1.2.3:443",
+			forwarded:  []string{"198.51.100.1, 203.0.113.7"},
+			clientIP:   "203.0.113.7",
+		},
+		{
+			name:       "client behind a chain of trusted proxies",
+			remoteAddr: "10.1.2.3:443",
+			forwarded:  []string{"203.0.113.7, 192.168.1.1", "10.4.5.6"},
+			clientIP:   "203.0.113.7",
+		},
+		{
+			name:       "forged hop that is not an address",
+			remoteAddr: "10.1.2.3:443",
+			forwarded:  []string{"unknown"},
+			clientIP:   "10.1.2.3",
+		},
+		{
+			name:       "real ip from a trusted proxy",
+			remoteAddr: "192.168.1.1:443",
+			realIP:     "203.0.113.7",
+			clientIP:   "203.0.113.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/query", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.clientIP, proxies.ClientIP(r))
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("")
+	require.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	proxies, err = ParseTrustedProxies("10.0.0.0/8,::1")
+	require.NoError(t, err)
+	assert.Len(t, proxies, 2)
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RateLimitKey selects who a rate limit policy counts requests for
+type RateLimitKey string
+
+const (
+	RateLimitKeyIP     RateLimitKey = "ip"
+	RateLimitKeyUser   RateLimitKey = "user"
+	RateLimitKeyAPIKey RateLimitKey = "api_key"
+)
+
+// Duration is a time.Duration read from a string such as "1m" in JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// RateLimitPolicy limits the requests of each client matching the route and operation.
+// An empty Route or Operation matches any, a Route ending in * matches by prefix. Policies keyed by user or API key only apply
+// to requests carrying one. With ChargeCost, GraphQL operations consume their query cost
+// instead of a single unit.
+type RateLimitPolicy struct {
+	Name       string       `json:"name"`
+	Route      string       `json:"route"`
+	Operation  string       `json:"operation"`
+	Key        RateLimitKey `json:"key"`
+	Algorithm  string       `json:"algorithm"`
+	Limit      int          `json:"limit"`
+	Window     Duration     `json:"window"`
+	Burst      int          `json:"burst"`
+	ChargeCost bool         `json:"charge_cost"`
+}
+
+// RateLimit returns the limit enforced by the policy
+func (p RateLimitPolicy) RateLimit() redis.RateLimit {
+	return redis.RateLimit{Limit: p.Limit, Window: time.Duration(p.Window), Burst: p.Burst}
+}
+
+// matches reports whether the policy applies to a request
+func (p RateLimitPolicy) matches(route string, operation string) bool {
+	if prefix, ok := strings.CutSuffix(p.Route, "*"); ok {
+		if !strings.HasPrefix(route, prefix) {
+			return false
+		}
+	} else if p.Route != "" && p.Route != route {
+		return false
+	}
+	if p.Operation != "" && p.Operation != operation {
+		return false
+	}
+	return true
+}
+
+// validate checks a policy against the algorithms known to the rate limiter
+func (p RateLimitPolicy) validate(rateLimiter *redis.RateLimiter) error {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("rate limit policy without name")
+	case p.Key != RateLimitKeyIP && p.Key != RateLimitKeyUser && p.Key != RateLimitKeyAPIKey:
+		return fmt.Errorf("rate limit policy %s: unknown key %q", p.Name, p.Key)
+	case !rateLimiter.HasAlgorithm(p.Algorithm):
+		return fmt.Errorf("rate limit policy %s: unknown algorithm %q", p.Name, p.Algorithm)
+	case p.Limit <= 0 || p.Window <= 0:
+		return fmt.Errorf("rate limit policy %s: limit and window must be positive", p.Name)
+	case p.ChargeCost && p.Algorithm == redis.AlgorithmSlidingWindow:
+		// The log records an entry per unit, a query cost in the thousands per request
+		return fmt.Errorf("rate limit policy %s: charge_cost is not supported by %s", p.Name, p.Algorithm)
+	}
+	return nil
+}
+
+// DefaultRateLimitPolicies are used when no policy file is configured
+func DefaultRateLimitPolicies() []RateLimitPolicy {
+	return []RateLimitPolicy{
+		{Name: "query-ip", Route: "/query", Key: RateLimitKeyIP, Algorithm: redis.AlgorithmSlidingWindow, Limit: 100, Window: Duration(time.Minute)},
+		{Name: "query-user", Route: "/query", Key: RateLimitKeyUser, Algorithm: redis.AlgorithmTokenBucket, Limit: 100, Window: Duration(time.Minute), Burst: 20},
+		{Name: "query-api-key", Route: "/query", Key: RateLimitKeyAPIKey, Algorithm: redis.AlgorithmGCRA, Limit: 600, Window: Duration(time.Minute), Burst: 50},
+		{Name: "query-cost-user", Route: "/query", Key: RateLimitKeyUser, Algorithm: redis.AlgorithmTokenBucket, Limit: 20000, Window: Duration(time.Minute), Burst: 10000, ChargeCost: true},
+		{Name: "login-ip", Route: "/login", Key: RateLimitKeyIP, Algorithm: redis.AlgorithmSlidingWindow, Limit: 10, Window: Duration(time.Minute)},
+		{Name: "refresh-ip", Route: "/refresh", Key: RateLimitKeyIP, Algorithm: redis.AlgorithmSlidingWindow, Limit: 30, Window: Duration(time.Minute)},
+		{Name: "oidc-ip", Route: "/auth/*", Key: RateLimitKeyIP, Algorithm: redis.AlgorithmSlidingWindow, Limit: 20, Window: Duration(time.Minute)},
+		{Name: "register-ip", Route: "/register", Key: RateLimitKeyIP, Algorithm: redis.AlgorithmSlidingWindow, Limit: 5, Window: Duration(time.Minute)},
+	}
+}
+
+// LoadRateLimitPolicies reads policies from the JSON file at path, or returns the defaults if path is empty
+func LoadRateLimitPolicies(path string, rateLimiter *redis.RateLimiter) ([]RateLimitPolicy, error) {
+	policies := DefaultRateLimitPolicies()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read rate limit policies: %w", err)
+		}
+		policies = nil
+		if err := json.Unmarshal(data, &policies); err != nil {
+			return nil, fmt.Errorf("parse rate limit policies: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		if err := policy.validate(rateLimiter); err != nil {
+			return nil, err
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("duplicate rate limit policy %s", policy.Name)
+		}
+		names[policy.Name] = true
+	}
+
+	return policies, nil
+}
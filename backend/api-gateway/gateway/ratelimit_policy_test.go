@@ -0,0 +1,51 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"testing"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitPolicyValidate(t *testing.T) {
+	rateLimiter := redis.NewRateLimiter(nil, log.NoopLogger)
+	valid := RateLimitPolicy{Name: "query-user", Key: RateLimitKeyUser, Algorithm: redis.AlgorithmTokenBucket, Limit: 10, Window: Duration(time.Minute)}
+
+	tests := []struct {
+		name   string
+		modify func(p *RateLimitPolicy)
+		err    string
+	}{
+		{name: "valid", modify: func(p *RateLimitPolicy) {}},
+		{name: "charging cost with a token bucket", modify: func(p *RateLimitPolicy) { p.ChargeCost = true }},
+		{name: "charging cost with GCRA", modify: func(p *RateLimitPolicy) { p.ChargeCost, p.Algorithm = true, redis.AlgorithmGCRA }},
+		{
+			name:   "charging cost with a sliding window",
+			modify: func(p *RateLimitPolicy) { p.ChargeCost, p.Algorithm = true, redis.AlgorithmSlidingWindow },
+			err:    "charge_cost is not supported by sliding_window",
+		},
+		{name: "without name", modify: func(p *RateLimitPolicy) { p.Name = "" }, err: "without name"},
+		{name: "unknown key", modify: func(p *RateLimitPolicy) { p.Key = "session" }, err: "unknown key"},
+		{name: "unknown algorithm", modify: func(p *RateLimitPolicy) { p.Algorithm = "leaky_bucket" }, err: "unknown algorithm"},
+		{name: "no window", modify: func(p *RateLimitPolicy) { p.Window = 0 }, err: "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := valid
+			tt.modify(&policy)
+			err := policy.validate(rateLimiter)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+
+	for _, policy := range DefaultRateLimitPolicies() {
+		assert.NoError(t, policy.validate(rateLimiter), policy.Name)
+	}
+}
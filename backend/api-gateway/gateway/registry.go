@@ -0,0 +1,145 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"api-gateway/schemadiff"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+)
+
+// publishSchemaRequest publishes a schema version of a subgraph
+type publishSchemaRequest struct {
+	Version string `json:"version"`
+	SDL     string `json:"sdl"`
+	// AllowBreaking publishes the schema although it breaks the supergraph
+	AllowBreaking bool `json:"allow_breaking"`
+}
+
+// publishSchemaResponse reports the outcome of a publish, and why it was refused
+type publishSchemaResponse struct {
+	Name      string              `json:"name"`
+	Version   string              `json:"version"`
+	Published bool                `json:"published"`
+	Error     string              `json:"error,omitempty"`
+	Changes   []schemadiff.Change `json:"changes,omitempty"`
+}
+
+// RegistryHandler serves the schema registry subgraphs publish their SDL to:
+//
+//	POST /registry/subgraphs/{name}  publish {version, sdl, allow_breaking}
+//	GET  /registry/subgraphs         latest schema of every subgraph
+//	GET  /registry/subgraphs/{name}  schema history of a subgraph, newest first
+//
+// A schema is only stored when the supergraph composes with it and it has no breaking
+// changes, unless allowed. Every gateway replica is notified of stored schemas.
+func RegistryHandler(registry *redis.SchemaRegistry, validator *SupergraphValidator, services []ServiceConfig, logger log.Logger) http.Handler {
+	servicesByName := make(map[string]ServiceConfig, len(services))
+	for _, service := range services {
+		servicesByName[service.Name] = service
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /registry/subgraphs/{name}", func(w http.ResponseWriter, r *http.Request) {
+		service, ok := servicesByName[r.PathValue("name")]
+		if !ok {
+			http.Error(w, "Unknown subgraph", http.StatusNotFound)
+			return
+		}
+
+		var req publishSchemaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version == "" || req.SDL == "" {
+			http.Error(w, "Invalid request, version and sdl are required", http.StatusBadRequest)
+			return
+		}
+
+		response := publishSchemaResponse{Name: service.Name, Version: req.Version}
+
+		changes, err := validator.Check(engine.SubgraphConfiguration{
+			Name:            service.Name,
+			URL:             service.URL,
+			SubscriptionUrl: service.WS,
+			SDL:             req.SDL,
+		})
+		if err != nil {
+			response.Error = err.Error()
+			writeRegistryResponse(w, http.StatusUnprocessableEntity, response)
+			return
+		}
+		response.Changes = changes
+		if len(schemadiff.BreakingChanges(changes)) > 0 && !req.AllowBreaking && !validator.AllowsBreaking() {
+			response.Error = "schema has breaking changes"
+			writeRegistryResponse(w, http.StatusUnprocessableEntity, response)
+			return
+		}
+
+		published, err := registry.Publish(r.Context(), &redis.RegisteredSchema{
+			Name:          service.Name,
+			Version:       req.Version,
+			SDL:           req.SDL,
+			AllowBreaking: req.AllowBreaking,
+			PublishedAt:   time.Now(),
+		})
+		if errors.Is(err, redis.ErrSchemaVersionExists) {
+			response.Error = err.Error()
+			writeRegistryResponse(w, http.StatusConflict, response)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to publish schema", log.String("subgraph", service.Name), log.Error(err))
+			http.Error(w, "Failed to publish schema", http.StatusInternalServerError)
+			return
+		}
+
+		response.Published = published
+		if !published {
+			writeRegistryResponse(w, http.StatusOK, response)
+			return
+		}
+		logger.Info("Schema published",
+			log.String("subgraph", service.Name),
+			log.String("version", req.Version),
+			log.Int("changes", len(changes)),
+		)
+		writeRegistryResponse(w, http.StatusCreated, response)
+	})
+
+	mux.HandleFunc("GET /registry/subgraphs", func(w http.ResponseWriter, r *http.Request) {
+		schemas, err := registry.LatestAll(r.Context())
+		if err != nil {
+			logger.Error("Failed to read schema registry", log.Error(err))
+			http.Error(w, "Failed to read schema registry", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"subgraphs": schemas})
+	})
+
+	mux.HandleFunc("GET /registry/subgraphs/{name}", func(w http.ResponseWriter, r *http.Request) {
+		history, err := registry.History(r.Context(), r.PathValue("name"))
+		if err != nil {
+			logger.Error("Failed to read schema history", log.Error(err))
+			http.Error(w, "Failed to read schema history", http.StatusInternalServerError)
+			return
+		}
+		if len(history) == 0 {
+			http.Error(w, "No schema published", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"history": history})
+	})
+
+	return mux
+}
+
+func writeRegistryResponse(w http.ResponseWriter, status int, response publishSchemaResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"api-gateway/schemadiff"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistryHandler(t *testing.T, allowBreaking bool) (http.Handler, *redis.SchemaRegistry) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	registry := redis.NewSchemaRegistry(client, log.NoopLogger)
+
+	services := []ServiceConfig{
+		{Name: "orders", URL: "http://orders.example.com/query"},
+		{Name: "products", URL: "http://products.example.com/query"},
+	}
+	return RegistryHandler(registry, newTestValidator(t, allowBreaking), services, log.NoopLogger), registry
+}
+
+func publishRequest(version, sdl string, allowBreaking bool) string {
+	body, _ := json.Marshal(publishSchemaRequest{Version: version, SDL: sdl, AllowBreaking: allowBreaking})
+	return string(body)
+}
+
+func TestRegistryHandlerPublish(t *testing.T) {
+	tests := []struct {
+		name          string
+		subgraph      string
+		body          string
+		allowBreaking bool
+		status        int
+		published     bool
+		error         string
+		breaking      int
+	}{
+		{
+			name:      "safe change",
+			subgraph:  "orders",
+			body:      publishRequest("2", withTotal, false),
+			status:    http.StatusCreated,
+			published: true,
+		},
+		{
+			name:     "unknown subgraph",
+			subgraph: "billing",
+			body:     publishRequest("1", ordersSDL, false),
+			status:   http.StatusNotFound,
+		},
+		{
+			name:     "missing version",
+			subgraph: "orders",
+			body:     publishRequest("", ordersSDL, false),
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "not JSON",
+			subgraph: "orders",
+			body:     ordersSDL,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "supergraph that does not compose",
+			subgraph: "orders",
+			body:     publishRequest("2", `type Order @key(fields: "id") { id: ID! customer: Customer! }`, false),
+			status:   http.StatusUnprocessableEntity,
+			error:    "compose",
+		},
+		{
+			name:     "breaking change",
+			subgraph: "orders",
+			body:     publishRequest("2", withoutNote, false),
+			status:   http.StatusUnprocessableEntity,
+			error:    "schema has breaking changes",
+			breaking: 1,
+		},
+		{
+			name:      "breaking change allowed by the request",
+			subgraph:  "orders",
+			body:      publishRequest("2", withoutNote, true),
+			status:    http.StatusCreated,
+			published: true,
+			breaking:  1,
+		},
+		{
+			name:          "breaking change allowed by the gateway",
+			subgraph:      "orders",
+			body:          publishRequest("2", withoutNote, false),
+			allowBreaking: true,
+			status:        http.StatusCreated,
+			published:     true,
+			breaking:      1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, registry := newTestRegistryHandler(t, tt.allowBreaking)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/registry/subgraphs/"+tt.subgraph, strings.NewReader(tt.body)))
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+
+			latest, ok, err := registry.Latest(context.Background(), tt.subgraph)
+			require.NoError(t, err)
+			assert.Equal(t, tt.published, ok)
+
+			if w.Header().Get("Content-Type") != "application/json" {
+				return
+			}
+			var response publishSchemaResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.published, response.Published)
+			assert.Contains(t, response.Error, tt.error)
+			assert.Len(t, schemadiff.BreakingChanges(response.Changes), tt.breaking)
+
+			if tt.published {
+				var req publishSchemaRequest
+				require.NoError(t, json.Unmarshal([]byte(tt.body), &req))
+				assert.Equal(t, req.SDL, latest.SDL)
+				assert.Equal(t, req.AllowBreaking, latest.AllowBreaking)
+			}
+		})
+	}
+}
+
+func TestRegistryHandlerRepublish(t *testing.T) {
+	handler, _ := newTestRegistryHandler(t, false)
+
+	publish := func(body string) (int, publishSchemaResponse) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/registry/subgraphs/orders", strings.NewReader(body)))
+		var response publishSchemaResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+
+	status, response := publish(publishRequest("2", withTotal, false))
+	assert.Equal(t, http.StatusCreated, status)
+	assert.True(t, response.Published)
+
+	status, response = publish(publishRequest("2", withTotal, false))
+	assert.Equal(t, http.StatusOK, status)
+	assert.False(t, response.Published)
+
+	status, response = publish(publishRequest("2", ordersSDL, false))
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, redis.ErrSchemaVersionExists.Error(), response.Error)
+}
+
+func TestRegistryHandlerRead(t *testing.T) {
+	handler, _ := newTestRegistryHandler(t, false)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/registry/subgraphs/orders")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	for _, body := range []string{publishRequest("2", withTotal, false), publishRequest("3", withoutNote, true)} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/registry/subgraphs/orders", strings.NewReader(body)))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	w = get("/registry/subgraphs")
+	require.Equal(t, http.StatusOK, w.Code)
+	var latest struct {
+		Subgraphs map[string]redis.RegisteredSchema `json:"subgraphs"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &latest))
+	require.Len(t, latest.Subgraphs, 1)
+	assert.Equal(t, "3", latest.Subgraphs["orders"].Version)
+
+	w = get("/registry/subgraphs/orders")
+	require.Equal(t, http.StatusOK, w.Code)
+	var history struct {
+		History []redis.RegisteredSchema `json:"history"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history.History, 2)
+	assert.Equal(t, "3", history.History[0].Version)
+	assert.Equal(t, "2", history.History[1].Version)
+}
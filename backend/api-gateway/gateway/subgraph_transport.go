@@ -0,0 +1,382 @@
+package main
+
+import (
+	"api-gateway/metrics"
+	"api-gateway/redis"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// subgraphResilienceName is the name under which the breaker and retry of a
+// subgraph are registered. The datasource poller uses the same name, so schema
+// fetches and query fetches share one view of the subgraph's health.
+func subgraphResilienceName(serviceName string) string {
+	return "subgraph-" + serviceName
+}
+
+// subgraphCircuitBreakerConfig is the breaker configuration used for every subgraph
+func subgraphCircuitBreakerConfig() redis.CircuitBreakerConfig {
+	return redis.CircuitBreakerConfig{
+		MaxFailures:      3,                // More sensitive for subgraphs
+		Timeout:          30 * time.Second, // Shorter recovery time
+		MaxRequests:      2,                // Only 2 test requests
+		ResetTimeout:     20 * time.Second,
+		FailureThreshold: 60.0, // 60% failure rate
+	}
+}
+
+// subgraphRetryConfig is the retry configuration used for every subgraph
+func subgraphRetryConfig() redis.RetryConfig {
+	return redis.RetryConfig{
+		MaxAttempts:    2,                     // 2 attempts per subgraph fetch
+		InitialDelay:   50 * time.Millisecond, // Start with 50ms
+		MaxDelay:       1 * time.Second,       // Cap at 1 second
+		Multiplier:     2.0,                   // Double delay
+		Jitter:         0.1,                   // 10% jitter
+		RetryOnTimeout: true,
+		RetryOn5xx:     true,
+	}
+}
+
+// SubgraphUnavailableCode is the error extension code returned for fields of an unavailable subgraph
+const SubgraphUnavailableCode = "SUBGRAPH_UNAVAILABLE"
+
+// subgraphErrorExtensionFields are the error extension fields passed through to clients
+var subgraphErrorExtensionFields = []string{"code", "service", "reason"}
+
+// subgraphRoute holds the resilience handlers of a single subgraph
+type subgraphRoute struct {
+	name    string
+	breaker *redis.CircuitBreaker
+	retry   *redis.Retry
+
+	// unhealthy mirrors the last status written with CacheService.SetSubgraphStatus
+	unhealthy atomic.Bool
+}
+
+// subgraphStatusError is returned for a subgraph response that counts as a failure
+type subgraphStatusError struct {
+	service    string
+	statusCode int
+}
+
+func (e *subgraphStatusError) Error() string {
+	return fmt.Sprintf("subgraph %s returned status %d", e.service, e.statusCode)
+}
+
+// SubgraphTransport is the RoundTripper used by the federation engine for subgraph fetches.
+// Each request is matched to its subgraph by URL and executed through that subgraph's own
+// circuit breaker and retry, so an outage in one subgraph only degrades the fields it owns.
+//
+// When a subgraph cannot be reached the transport answers in its place with a GraphQL
+// response carrying null data and a SUBGRAPH_UNAVAILABLE error, so the rest of the
+// federated query still resolves.
+type SubgraphTransport struct {
+	next         http.RoundTripper
+	routes       map[string]*subgraphRoute
+	cacheService *redis.CacheService
+	logger       log.Logger
+}
+
+// NewSubgraphTransport creates a transport with one breaker and retry per configured service
+func NewSubgraphTransport(
+	next http.RoundTripper,
+	services []ServiceConfig,
+	cacheService *redis.CacheService,
+	cbManager *CircuitBreakerManager,
+	retryManager *redis.RetryManager,
+	logger log.Logger,
+) *SubgraphTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	t := &SubgraphTransport{
+		next:         next,
+		routes:       make(map[string]*subgraphRoute),
+		cacheService: cacheService,
+		logger:       logger,
+	}
+
+	for _, service := range services {
+		key, err := subgraphRouteKey(service.URL)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Invalid URL for subgraph %s, fetches will not be protected", service.Name), log.Error(err))
+			continue
+		}
+
+		route := &subgraphRoute{name: service.Name}
+		if cbManager != nil {
+			route.breaker = cbManager.GetOrCreateBreaker(subgraphResilienceName(service.Name), subgraphCircuitBreakerConfig())
+		}
+		if retryManager != nil {
+			route.retry = retryManager.GetOrCreateRetry(subgraphResilienceName(service.Name), subgraphRetryConfig())
+		}
+		t.routes[key] = route
+	}
+
+	return t
+}
+
+// subgraphRouteKey normalizes a subgraph URL to the key used for route lookups
+func subgraphRouteKey(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host in %q", rawURL)
+	}
+	return u.Host + u.Path, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *SubgraphTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route, ok := t.routes[req.URL.Host+req.URL.Path]
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	// Buffer the body so it can be replayed on retry
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read subgraph request body: %w", err)
+		}
+	}
+
+	ctx := req.Context()
+	var resp *http.Response
+	var lastErr error
+	mutation := isMutationRequest(body)
+
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		metrics.SubgraphFetchDuration.WithLabelValues(route.name, result).Observe(time.Since(start).Seconds())
+	}()
+
+	// A single attempt: circuit breaker around the actual fetch
+	try := func(_ int) error {
+		fetch := func() error {
+			attemptReq := req.Clone(ctx)
+			if body != nil {
+				attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+				attemptReq.ContentLength = int64(len(body))
+			}
+
+			attemptResp, err := t.next.RoundTrip(attemptReq)
+			if err != nil {
+				return err
+			}
+
+			if attemptResp.StatusCode >= 500 || isRetryableStatusCode(attemptResp.StatusCode) {
+				attemptResp.Body.Close()
+				return &subgraphStatusError{service: route.name, statusCode: attemptResp.StatusCode}
+			}
+
+			resp = attemptResp
+			return nil
+		}
+
+		if route.breaker != nil {
+			lastErr = route.breaker.Execute(ctx, fetch)
+		} else {
+			lastErr = fetch()
+		}
+		return lastErr
+	}
+
+	var err error
+	if route.retry != nil {
+		err = route.retry.ExecuteWithCondition(ctx, try, func(err error, attempt int) bool {
+			return shouldRetrySubgraphFetch(err, mutation)
+		})
+	} else {
+		err = try(1)
+	}
+
+	if err == nil {
+		t.setStatus(ctx, route, true)
+		return resp, nil
+	}
+
+	// The client went away, this says nothing about the subgraph
+	if ctx.Err() != nil {
+		result = "canceled"
+		return nil, ctx.Err()
+	}
+	result = "unavailable"
+
+	reason := "subgraph request failed"
+	var statusErr *subgraphStatusError
+	switch {
+	case errors.Is(lastErr, redis.ErrCircuitOpen) || errors.Is(lastErr, redis.ErrTooManyRequests):
+		t.logger.Warn(fmt.Sprintf("Circuit breaker for subgraph %s rejected fetch", route.name), log.Error(lastErr))
+		reason = "circuit breaker is " + circuitStateOf(lastErr)
+	case errors.As(lastErr, &statusErr):
+		t.logger.Error(fmt.Sprintf("Fetch from subgraph %s failed", route.name), log.Error(err))
+		reason = "subgraph responded with status " + strconv.Itoa(statusErr.statusCode)
+	default:
+		t.logger.Error(fmt.Sprintf("Fetch from subgraph %s failed", route.name), log.Error(err))
+	}
+
+	t.setStatus(ctx, route, false)
+	return unavailableResponse(req, route.name, reason), nil
+}
+
+// setStatus records the health of a subgraph whenever it changes
+func (t *SubgraphTransport) setStatus(ctx context.Context, route *subgraphRoute, healthy bool) {
+	if t.cacheService == nil {
+		return
+	}
+	// Failures are always recorded to keep the status fresh, recoveries only once
+	if healthy && !route.unhealthy.Swap(false) {
+		return
+	}
+	if !healthy {
+		route.unhealthy.Store(true)
+	}
+
+	if err := t.cacheService.SetSubgraphStatus(ctx, route.name, healthy); err != nil {
+		t.logger.Error(fmt.Sprintf("Failed to set status for subgraph %s", route.name), log.Error(err))
+	}
+}
+
+// circuitStateOf maps a circuit breaker rejection to the state that caused it
+func circuitStateOf(err error) string {
+	if errors.Is(err, redis.ErrTooManyRequests) {
+		return string(redis.StateHalfOpen)
+	}
+	return string(redis.StateOpen)
+}
+
+// unavailableResponse builds the GraphQL response used in place of an unreachable subgraph
+func unavailableResponse(req *http.Request, serviceName string, reason string) *http.Response {
+	body, _ := json.Marshal(map[string]interface{}{
+		"data": nil,
+		"errors": []map[string]interface{}{
+			{
+				"message": fmt.Sprintf("Subgraph '%s' is unavailable", serviceName),
+				"extensions": map[string]interface{}{
+					"code":    SubgraphUnavailableCode,
+					"service": serviceName,
+					"reason":  reason,
+				},
+			},
+		},
+	})
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// shouldRetrySubgraphFetch reports whether a failed subgraph fetch may be attempted again.
+// Queries are retried on transport errors and retryable statuses. A mutation may have been
+// applied once its request was sent, whatever the error, so it is only retried when the
+// connection to the subgraph could not be established.
+func shouldRetrySubgraphFetch(err error, mutation bool) bool {
+	if errors.Is(err, redis.ErrCircuitOpen) || errors.Is(err, redis.ErrTooManyRequests) {
+		return false
+	}
+	if mutation {
+		return isDialError(err)
+	}
+
+	var statusErr *subgraphStatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatusCode(statusErr.statusCode)
+	}
+
+	return true
+}
+
+// isDialError reports whether err happened while connecting, before anything was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isMutationRequest reports whether a subgraph request body holds a mutation. Bodies that
+// cannot be read are treated as mutations, so they are not replayed.
+func isMutationRequest(body []byte) bool {
+	var gqlReq struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(body, &gqlReq); err != nil {
+		return true
+	}
+	return strings.HasPrefix(strings.TrimSpace(gqlReq.Query), "mutation")
+}
+
+// SubgraphHealthHandler reports the status and circuit state of every subgraph
+func SubgraphHealthHandler(services []ServiceConfig, cacheService *redis.CacheService, cbManager *CircuitBreakerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		allHealthy := true
+		subgraphs := make(map[string]interface{}, len(services))
+
+		for _, service := range services {
+			healthy, err := cacheService.GetSubgraphStatus(ctx, service.Name)
+			if err != nil {
+				healthy = false
+			}
+
+			state := redis.StateClosed
+			if breaker, ok := cbManager.GetBreaker(subgraphResilienceName(service.Name)); ok {
+				if s, err := breaker.GetState(ctx); err == nil {
+					state = s
+				}
+			}
+
+			if !healthy || state != redis.StateClosed {
+				allHealthy = false
+			}
+
+			subgraphs[service.Name] = map[string]interface{}{
+				"healthy":       healthy,
+				"circuit_state": string(state),
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if allHealthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    getHealthStatus(allHealthy),
+			"subgraphs": subgraphs,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	}
+}
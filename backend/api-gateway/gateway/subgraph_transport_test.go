@@ -0,0 +1,248 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	subgraphTestURL  = "http://orders.example.com/query"
+	subgraphQuery    = `{"query":"{getOrdersByUserId(userId: \"1\"){edges{cursor}}}"}`
+	subgraphMutation = `{"query":"mutation($a: UUID!){cancelOrder(id: $a){id}}","variables":{"a":"1"}}`
+)
+
+// roundTripFunc stands in for the transport to the subgraphs
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeSubgraph answers subgraph fetches with the responses or errors it is given, one per
+// attempt, and records the bodies it received
+type fakeSubgraph struct {
+	results []interface{}
+	bodies  []string
+}
+
+func (f *fakeSubgraph) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	f.bodies = append(f.bodies, string(body))
+
+	result := f.results[0]
+	if len(f.results) > 1 {
+		f.results = f.results[1:]
+	}
+	switch result := result.(type) {
+	case int:
+		w := httptest.NewRecorder()
+		w.WriteHeader(result)
+		w.WriteString(`{"data":{}}`)
+		return w.Result(), nil
+	default:
+		return nil, result.(error)
+	}
+}
+
+func newTestSubgraphTransport(t *testing.T, next http.RoundTripper) (*SubgraphTransport, *redis.CacheService) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cacheService := redis.NewCacheService(client, log.NoopLogger)
+
+	transport := NewSubgraphTransport(next,
+		[]ServiceConfig{{Name: "orders", URL: subgraphTestURL}},
+		cacheService,
+		NewCircuitBreakerManager(client, log.NoopLogger),
+		redis.NewRetryManager(log.NoopLogger),
+		log.NoopLogger,
+	)
+	return transport, cacheService
+}
+
+func fetchSubgraph(t *testing.T, transport http.RoundTripper, url string, body string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// unavailableReason returns the reason of the SUBGRAPH_UNAVAILABLE error in a response
+func unavailableReason(t *testing.T, resp *http.Response) string {
+	var body struct {
+		Data   interface{} `json:"data"`
+		Errors []struct {
+			Extensions map[string]string `json:"extensions"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Nil(t, body.Data)
+	require.Len(t, body.Errors, 1)
+	assert.Equal(t, SubgraphUnavailableCode, body.Errors[0].Extensions["code"])
+	assert.Equal(t, "orders", body.Errors[0].Extensions["service"])
+	return body.Errors[0].Extensions["reason"]
+}
+
+func TestSubgraphTransportRetriesQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []interface{}
+	}{
+		{name: "unavailable", results: []interface{}{http.StatusServiceUnavailable, http.StatusOK}},
+		{name: "connection reset", results: []interface{}{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, http.StatusOK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subgraph := &fakeSubgraph{results: tt.results}
+			transport, cacheService := newTestSubgraphTransport(t, subgraph)
+
+			resp := fetchSubgraph(t, transport, subgraphTestURL, subgraphQuery)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			// The body is replayed on the retry
+			assert.Equal(t, []string{subgraphQuery, subgraphQuery}, subgraph.bodies)
+
+			healthy, err := cacheService.GetSubgraphStatus(context.Background(), "orders")
+			require.NoError(t, err)
+			assert.True(t, healthy)
+		})
+	}
+}
+
+func TestSubgraphTransportDoesNotRetrySentMutations(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []interface{}
+		attempts int
+		status   int
+		reason   string
+	}{
+		{
+			name:     "connection reset",
+			results:  []interface{}{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, http.StatusOK},
+			attempts: 1,
+			reason:   "subgraph request failed",
+		},
+		{
+			name:     "unavailable",
+			results:  []interface{}{http.StatusServiceUnavailable, http.StatusOK},
+			attempts: 1,
+			reason:   "subgraph responded with status 503",
+		},
+		{
+			name:     "connection refused",
+			results:  []interface{}{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, http.StatusOK},
+			attempts: 2,
+			status:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subgraph := &fakeSubgraph{results: tt.results}
+			transport, _ := newTestSubgraphTransport(t, subgraph)
+
+			resp := fetchSubgraph(t, transport, subgraphTestURL, subgraphMutation)
+			assert.Len(t, subgraph.bodies, tt.attempts)
+			if tt.reason == "" {
+				assert.Equal(t, tt.status, resp.StatusCode)
+				return
+			}
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.reason, unavailableReason(t, resp))
+		})
+	}
+}
+
+func TestSubgraphTransportCircuitOpen(t *testing.T) {
+	subgraph := &fakeSubgraph{results: []interface{}{http.StatusInternalServerError}}
+	transport, cacheService := newTestSubgraphTransport(t, subgraph)
+
+	// Failed queries are not retried on a 500, each counts once against the breaker
+	maxFailures := int(subgraphCircuitBreakerConfig().MaxFailures)
+	for i := 0; i < maxFailures; i++ {
+		resp := fetchSubgraph(t, transport, subgraphTestURL, subgraphQuery)
+		assert.Equal(t, "subgraph responded with status 500", unavailableReason(t, resp))
+	}
+	require.Len(t, subgraph.bodies, maxFailures)
+
+	resp := fetchSubgraph(t, transport, subgraphTestURL, subgraphQuery)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "circuit breaker is open", unavailableReason(t, resp))
+	assert.Len(t, subgraph.bodies, maxFailures, "the open breaker does not reach the subgraph")
+
+	healthy, err := cacheService.GetSubgraphStatus(context.Background(), "orders")
+	require.NoError(t, err)
+	assert.False(t, healthy)
+}
+
+func TestSubgraphTransportPassesThroughOtherHosts(t *testing.T) {
+	var fetched []string
+	transport, _ := newTestSubgraphTransport(t, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		fetched = append(fetched, req.URL.String())
+		return nil, errors.New("connection refused")
+	}))
+
+	req, err := http.NewRequest(http.MethodPost, "http://products.example.com/query", strings.NewReader(subgraphQuery))
+	require.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.Error(t, err, "fetches outside the subgraphs are neither retried nor answered for")
+	assert.Equal(t, []string{"http://products.example.com/query"}, fetched)
+}
+
+func TestShouldRetrySubgraphFetch(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	unavailable := &subgraphStatusError{service: "orders", statusCode: http.StatusServiceUnavailable}
+	failed := &subgraphStatusError{service: "orders", statusCode: http.StatusInternalServerError}
+
+	tests := []struct {
+		name     string
+		err      error
+		mutation bool
+		retry    bool
+	}{
+		{"query refused", refused, false, true},
+		{"query reset", reset, false, true},
+		{"query timed out", errors.New("context deadline exceeded"), false, true},
+		{"query unavailable", unavailable, false, true},
+		{"query failed", failed, false, false},
+		{"query rejected by the breaker", redis.ErrCircuitOpen, false, false},
+		{"mutation refused", refused, true, true},
+		{"mutation reset", reset, true, false},
+		{"mutation timed out", errors.New("context deadline exceeded"), true, false},
+		{"mutation unavailable", unavailable, true, false},
+		{"mutation rejected by the breaker", redis.ErrCircuitOpen, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.retry, shouldRetrySubgraphFetch(tt.err, tt.mutation))
+		})
+	}
+}
+
+func TestIsMutationRequest(t *testing.T) {
+	assert.True(t, isMutationRequest([]byte(`{"query":"mutation($a: UUID!){cancelOrder(id: $a){id}}"}`)))
+	assert.True(t, isMutationRequest([]byte(`{"query":" mutation Cancel {cancelOrder(id: \"1\"){id}}"}`)))
+	assert.True(t, isMutationRequest([]byte(`not json`)))
+	assert.True(t, isMutationRequest(nil))
+	assert.False(t, isMutationRequest([]byte(`{"query":"{getOrdersByUserId(userId: \"1\"){edges{cursor}}}"}`)))
+	assert.False(t, isMutationRequest([]byte(`{"query":"query($representations: [_Any!]!){_entities(representations: $representations){__typename}}"}`)))
+}
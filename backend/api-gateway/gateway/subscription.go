@@ -0,0 +1,396 @@
+package main
+
+import (
+	"api-gateway/auth"
+	"api-gateway/models"
+	"api-gateway/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/gobwas/ws"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+	"github.com/wundergraph/graphql-go-tools/execution/subscription"
+	"github.com/wundergraph/graphql-go-tools/execution/subscription/websocket"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/engine/datasource/graphql_datasource"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/engine/plan"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/engine/resolve"
+)
+
+var (
+	errSubscriptionsOnly = errors.New("only subscriptions are served over websockets, post queries and mutations to /query")
+	errTokenExpired      = errors.New("token has expired")
+)
+
+// revocationCheckInterval is how often a connection checks that its token was not revoked
+const revocationCheckInterval = 30 * time.Second
+
+// SubscriptionMiddleware serves GraphQL subscriptions over WebSocket, with the legacy
+// graphql-ws protocol or graphql-transport-ws, whichever the client asks for. Other
+// requests are passed on.
+//
+// The connection is authenticated with the token of the upgrade request, or the
+// Authorization of the connection_init payload, which browsers have to use. Each
+// subscription is authorized against the claims like the operations posted to /query.
+// The connection is closed once the token expires or is revoked.
+func SubscriptionMiddleware(
+	next http.Handler,
+	gateway *Gateway,
+	policy *AuthorizationPolicy,
+	keyRing *auth.KeyRing,
+	sessions *redis.SessionManager,
+	logger log.Logger,
+) http.Handler {
+	upgrader := ws.HTTPUpgrader{
+		Protocol: func(protocol string) bool {
+			return protocol == string(websocket.ProtocolGraphQLWS) || protocol == string(websocket.ProtocolGraphQLTransportWS)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		executionEngine := gateway.Engine()
+		if executionEngine == nil {
+			http.Error(w, "Supergraph is not composed yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		// The request context ends with the upgrade, the connection lives until it is closed
+		ctx, cancel := context.WithCancel(context.Background())
+		conn := &subscriptionConnection{
+			ctx:    ctx,
+			schema: gateway.Schema(),
+			policy: policy,
+			verify: func(ctx context.Context, token string) (*models.Claims, error) {
+				return verifyToken(ctx, keyRing, sessions, token)
+			},
+			revoked:       sessions.IsTokenRevoked,
+			checkInterval: revocationCheckInterval,
+			pool:          subscription.NewExecutorV2Pool(executionEngine, r.Context()),
+		}
+
+		if token := upgradeToken(r); token != "" {
+			claims, err := conn.verify(r.Context(), token)
+			switch {
+			case errors.Is(err, errInvalidToken) || errors.Is(err, errTokenRevoked):
+				cancel()
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			case err != nil:
+				cancel()
+				http.Error(w, "Could not verify token", http.StatusServiceUnavailable)
+				return
+			}
+			conn.setClaims(claims)
+		}
+
+		netConn, _, handshake, err := upgrader.Upgrade(r, w)
+		if err != nil {
+			cancel()
+			logger.Error("Failed to upgrade subscription connection", log.Error(err))
+			return
+		}
+		watchedConn := &closeNotifyConn{Conn: netConn, closed: cancel}
+		go conn.watch(ctx, func() { watchedConn.Close() })
+
+		// Clients that do not negotiate a protocol speak the legacy one
+		protocol := websocket.Protocol(handshake.Protocol)
+		if protocol == websocket.ProtocolUndefined {
+			protocol = websocket.ProtocolGraphQLWS
+		}
+
+		done := make(chan bool)
+		errChan := make(chan error)
+		go websocket.Handle(done, errChan, watchedConn, conn,
+			websocket.WithLogger(logger),
+			websocket.WithProtocol(protocol),
+			websocket.WithInitFunc(conn.init),
+		)
+
+		select {
+		case err := <-errChan:
+			logger.Error("Failed to handle subscription connection", log.Error(err))
+		case <-done:
+		}
+	})
+}
+
+// upgradeToken returns the token of an upgrade request, from the Authorization header or
+// the token cookie
+func upgradeToken(r *http.Request) string {
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie("token"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// closeNotifyConn calls closed when the connection is closed
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
+
+// subscriptionConnection is the executor pool of a WebSocket connection. It holds the
+// claims the connection was authenticated with and authorizes each operation against them.
+type subscriptionConnection struct {
+	// ctx ends when the connection is closed
+	ctx    context.Context
+	schema *graphql.Schema
+	policy *AuthorizationPolicy
+	verify func(ctx context.Context, token string) (*models.Claims, error)
+	// revoked reports whether a token was revoked after the connection was authenticated
+	revoked func(ctx context.Context, tokenID string) (bool, error)
+	// checkInterval is the time between checking that the token was not revoked
+	checkInterval time.Duration
+	pool          *subscription.ExecutorV2Pool
+
+	mu     sync.Mutex
+	claims *models.Claims
+}
+
+func (c *subscriptionConnection) setClaims(claims *models.Claims) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.claims = claims
+}
+
+func (c *subscriptionConnection) getClaims() *models.Claims {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.claims
+}
+
+// init authenticates the connection with the Authorization of the connection_init payload.
+// A connection without one keeps the claims of its upgrade request.
+func (c *subscriptionConnection) init(ctx context.Context, payload websocket.InitPayload) (context.Context, error) {
+	authorization := payload.Authorization()
+	if authorization == "" {
+		return ctx, nil
+	}
+
+	claims, err := c.verify(ctx, strings.TrimPrefix(authorization, "Bearer "))
+	if err != nil {
+		return ctx, err
+	}
+	c.setClaims(claims)
+	return ctx, nil
+}
+
+// checkClaims returns why the claims no longer authenticate the connection, if they do not
+func (c *subscriptionConnection) checkClaims(ctx context.Context, claims *models.Claims) error {
+	if claims == nil {
+		return nil
+	}
+	if claims.ExpiresAt != 0 && !time.Now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return errTokenExpired
+	}
+	if claims.Id == "" {
+		return nil
+	}
+	revoked, err := c.revoked(ctx, claims.Id)
+	if err != nil {
+		return fmt.Errorf("could not verify token: %w", err)
+	}
+	if revoked {
+		return errTokenRevoked
+	}
+	return nil
+}
+
+// watch calls closeConn once the claims of the connection expire or are revoked, until ctx
+// is done. The claims replaced by connection_init are watched from the next check.
+func (c *subscriptionConnection) watch(ctx context.Context, closeConn func()) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		claims := c.getClaims()
+		err := c.checkClaims(ctx, claims)
+		if errors.Is(err, errTokenExpired) || errors.Is(err, errTokenRevoked) {
+			closeConn()
+			return
+		}
+
+		// A failed revocation check is retried with the next one
+		wait := c.checkInterval
+		if claims != nil && claims.ExpiresAt != 0 {
+			wait = min(wait, time.Until(time.Unix(claims.ExpiresAt, 0)))
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Get implements subscription.ExecutorPool. Operations the claims do not allow, or that
+// arrive after the claims expired or were revoked, are rejected when they are executed,
+// so the client receives the error.
+func (c *subscriptionConnection) Get(payload []byte) (subscription.Executor, error) {
+	executor, err := c.pool.Get(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := c.getClaims()
+	if err := c.checkClaims(c.ctx, claims); err != nil {
+		return &rejectedExecutor{Executor: executor, err: err}, nil
+	}
+	if err := c.authorize(payload, claims); err != nil {
+		return &rejectedExecutor{Executor: executor, err: err}, nil
+	}
+	return &authenticatedExecutor{Executor: executor, claims: claims}, nil
+}
+
+// Put implements subscription.ExecutorPool
+func (c *subscriptionConnection) Put(executor subscription.Executor) error {
+	switch e := executor.(type) {
+	case *authenticatedExecutor:
+		return c.pool.Put(e.Executor)
+	case *rejectedExecutor:
+		return c.pool.Put(e.Executor)
+	}
+	return c.pool.Put(executor)
+}
+
+// authorize only lets subscriptions through, queries and mutations are posted to /query
+// where they are rate limited and their cost is checked
+func (c *subscriptionConnection) authorize(payload []byte, claims *models.Claims) error {
+	var req GraphQLRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+	op, err := ParseOperation(c.schema, req)
+	if err != nil {
+		return err
+	}
+	if op.Type != ast.OperationTypeSubscription {
+		return errSubscriptionsOnly
+	}
+
+	denied := c.policy.Check(op, claims)
+	if len(denied) == 0 {
+		return nil
+	}
+	if denied[0].Code == UnauthenticatedCode {
+		return errors.New("Authentication required to query field " + strings.Join(denied[0].Path, "."))
+	}
+	return errors.New("Not authorized to query field " + strings.Join(denied[0].Path, "."))
+}
+
+// authenticatedExecutor executes an operation with the claims of its connection in the
+// context, so they are forwarded to the subgraphs
+type authenticatedExecutor struct {
+	subscription.Executor
+	claims *models.Claims
+}
+
+func (e *authenticatedExecutor) SetContext(ctx context.Context) {
+	if e.claims != nil {
+		ctx = context.WithValue(ctx, claimsContextKey, e.claims)
+	}
+	e.Executor.SetContext(ctx)
+}
+
+// rejectedExecutor reports why an operation was rejected. Subscriptions are executed
+// again until they are stopped, so the error is only reported once.
+type rejectedExecutor struct {
+	subscription.Executor
+	err      error
+	ctx      context.Context
+	reported bool
+}
+
+func (e *rejectedExecutor) SetContext(ctx context.Context) {
+	e.ctx = ctx
+}
+
+func (e *rejectedExecutor) Execute(_ resolve.SubscriptionResponseWriter) error {
+	if !e.reported {
+		e.reported = true
+		return e.err
+	}
+	<-e.ctx.Done()
+	return nil
+}
+
+// userScopedSubscriptions rebuilds the GraphQL data sources of an engine configuration
+// with a subscription client that keeps the subscriptions of different users apart
+func userScopedSubscriptions(ctx context.Context, config *engine.Configuration, httpClient, streamingClient *http.Client) error {
+	factory, err := graphql_datasource.NewFactory(ctx, httpClient, &userScopedSubscriptionClient{
+		GraphQLSubscriptionClient: graphql_datasource.NewGraphQLSubscriptionClient(streamingClient, streamingClient, ctx),
+	})
+	if err != nil {
+		return err
+	}
+
+	type graphqlDataSource interface {
+		plan.DataSourceConfiguration[graphql_datasource.Configuration]
+		plan.NodesAccess
+	}
+
+	dataSources := config.DataSources()
+	scoped := make([]plan.DataSource, len(dataSources))
+	for i, dataSource := range dataSources {
+		ds, ok := dataSource.(graphqlDataSource)
+		if !ok {
+			scoped[i] = dataSource
+			continue
+		}
+		scoped[i], err = plan.NewDataSourceConfigurationWithName(ds.Id(), ds.Name(), factory, &plan.DataSourceMetadata{
+			FederationMetaData: ds.FederationConfiguration(),
+			RootNodes:          ds.ListRootNodes(),
+			ChildNodes:         ds.ListChildNodes(),
+			Directives:         ds.DirectiveConfigurations(),
+		}, ds.CustomConfiguration())
+		if err != nil {
+			return err
+		}
+	}
+	config.SetDataSources(scoped)
+	return nil
+}
+
+// userScopedSubscriptionClient adds the user to the hash the engine deduplicates
+// subscriptions by. Identical subscriptions share one upstream subscription, which would
+// deliver the events of one user to every user subscribed with the same query.
+type userScopedSubscriptionClient struct {
+	graphql_datasource.GraphQLSubscriptionClient
+}
+
+func (c *userScopedSubscriptionClient) UniqueRequestID(ctx *resolve.Context, options graphql_datasource.GraphQLSubscriptionOptions, hash *xxhash.Digest) error {
+	if err := c.GraphQLSubscriptionClient.UniqueRequestID(ctx, options, hash); err != nil {
+		return err
+	}
+	claims, ok := ClaimsFromContext(ctx.Context())
+	if !ok {
+		return nil
+	}
+	_, err := hash.WriteString("user:" + claimsUserID(claims) + ",client:" + claims.ClientID)
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"api-gateway/models"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+	"github.com/wundergraph/graphql-go-tools/execution/subscription"
+)
+
+const subscriptionTestSchema = `
+type Query { orders: [String] }
+type Subscription { orderStatusChanged: String }
+`
+
+// newTestSubscriptionConnection returns a connection authenticated with claims, whose
+// tokens are revoked when revoked is set
+func newTestSubscriptionConnection(t *testing.T, claims *models.Claims, revoked *atomic.Bool) *subscriptionConnection {
+	schema, err := graphql.NewSchemaFromString(subscriptionTestSchema)
+	require.NoError(t, err)
+
+	conn := &subscriptionConnection{
+		ctx:    context.Background(),
+		schema: schema,
+		policy: NewAuthorizationPolicy(),
+		revoked: func(ctx context.Context, tokenID string) (bool, error) {
+			return revoked.Load(), nil
+		},
+		checkInterval: 10 * time.Millisecond,
+		pool:          subscription.NewExecutorV2Pool(nil, context.Background()),
+	}
+	conn.setClaims(claims)
+	return conn
+}
+
+func testClaims(expiresIn time.Duration) *models.Claims {
+	return &models.Claims{
+		UserID: "user-1",
+		StandardClaims: jwt.StandardClaims{
+			Id:        "token-1",
+			ExpiresAt: time.Now().Add(expiresIn).Unix(),
+		},
+	}
+}
+
+func TestSubscriptionConnectionGet(t *testing.T) {
+	const subscribe = `{"query":"subscription { orderStatusChanged }"}`
+
+	tests := []struct {
+		name    string
+		claims  *models.Claims
+		revoked bool
+		payload string
+		err     error
+	}{
+		{
+			name:    "valid token",
+			claims:  testClaims(time.Hour),
+			payload: subscribe,
+		},
+		{
+			name:    "no token",
+			payload: subscribe,
+		},
+		{
+			name:    "expired token",
+			claims:  testClaims(-time.Second),
+			payload: subscribe,
+			err:     errTokenExpired,
+		},
+		{
+			name:    "revoked token",
+			claims:  testClaims(time.Hour),
+			revoked: true,
+			payload: subscribe,
+			err:     errTokenRevoked,
+		},
+		{
+			name:    "query",
+			claims:  testClaims(time.Hour),
+			payload: `{"query":"{ orders }"}`,
+			err:     errSubscriptionsOnly,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked atomic.Bool
+			revoked.Store(tt.revoked)
+			conn := newTestSubscriptionConnection(t, tt.claims, &revoked)
+
+			executor, err := conn.Get([]byte(tt.payload))
+			require.NoError(t, err)
+			defer conn.Put(executor)
+
+			if tt.err == nil {
+				authenticated, ok := executor.(*authenticatedExecutor)
+				require.True(t, ok, "operation rejected: %v", executor)
+				assert.Equal(t, tt.claims, authenticated.claims)
+				return
+			}
+			rejected, ok := executor.(*rejectedExecutor)
+			require.True(t, ok, "operation not rejected")
+			assert.ErrorIs(t, rejected.err, tt.err)
+		})
+	}
+}
+
+func TestSubscriptionConnectionGetWhenRevocationUnknown(t *testing.T) {
+	conn := newTestSubscriptionConnection(t, testClaims(time.Hour), &atomic.Bool{})
+	conn.revoked = func(ctx context.Context, tokenID string) (bool, error) {
+		return false, errors.New("redis unavailable")
+	}
+
+	executor, err := conn.Get([]byte(`{"query":"subscription { orderStatusChanged }"}`))
+	require.NoError(t, err)
+	_, rejected := executor.(*rejectedExecutor)
+	assert.True(t, rejected)
+}
+
+func TestSubscriptionConnectionWatch(t *testing.T) {
+	// watch runs until it closes the connection or ctx is done, and reports which
+	watch := func(conn *subscriptionConnection, ctx context.Context) bool {
+		closed := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			conn.watch(ctx, func() { close(closed) })
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection still watched")
+		}
+		select {
+		case <-closed:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Run("closed when the token expires", func(t *testing.T) {
+		conn := newTestSubscriptionConnection(t, testClaims(2*time.Second), &atomic.Bool{})
+		started := time.Now()
+		assert.True(t, watch(conn, context.Background()))
+		assert.Greater(t, time.Since(started), 500*time.Millisecond, "closed before the token expired")
+	})
+
+	t.Run("closed when the token is revoked", func(t *testing.T) {
+		var revoked atomic.Bool
+		conn := newTestSubscriptionConnection(t, testClaims(time.Hour), &revoked)
+		time.AfterFunc(50*time.Millisecond, func() { revoked.Store(true) })
+		assert.True(t, watch(conn, context.Background()))
+	})
+
+	t.Run("open until the connection ends", func(t *testing.T) {
+		conn := newTestSubscriptionConnection(t, testClaims(time.Hour), &atomic.Bool{})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.False(t, watch(conn, ctx))
+	})
+}
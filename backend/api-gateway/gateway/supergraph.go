@@ -0,0 +1,377 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"api-gateway/schemadiff"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+	"github.com/wundergraph/graphql-go-tools/execution/graphql"
+)
+
+var (
+	errNoRejectedSupergraph  = errors.New("no rejected supergraph")
+	errSupergraphNotComposed = errors.New("rejected supergraph does not compose")
+)
+
+// RejectedSupergraph is the last supergraph that was not swapped in, because it did not
+// compose or had breaking changes
+type RejectedSupergraph struct {
+	Subgraphs  []string            `json:"subgraphs"`
+	Error      string              `json:"error,omitempty"`
+	Changes    []schemadiff.Change `json:"changes,omitempty"`
+	RejectedAt time.Time           `json:"rejected_at"`
+
+	configs []engine.SubgraphConfiguration
+	schema  *graphql.Schema
+}
+
+// SupergraphValidator composes the subgraphs of every poll before they reach the
+// observers. A supergraph is only swapped in when it composes and has no breaking changes
+// against the one being served, unless breaking changes are allowed. The last supergraph
+// swapped in is kept in Redis, so a restarted gateway can serve it while subgraphs are down.
+type SupergraphValidator struct {
+	engineCtx     context.Context
+	cacheService  *redis.CacheService
+	allowBreaking bool
+	logger        log.Logger
+
+	mu         *sync.Mutex
+	current    []engine.SubgraphConfiguration
+	schema     *graphql.Schema
+	composedAt time.Time
+	rejected   *RejectedSupergraph
+}
+
+func NewSupergraphValidator(ctx context.Context, cacheService *redis.CacheService, allowBreaking bool, logger log.Logger) *SupergraphValidator {
+	return &SupergraphValidator{
+		engineCtx:     ctx,
+		cacheService:  cacheService,
+		allowBreaking: allowBreaking,
+		logger:        logger,
+		mu:            &sync.Mutex{},
+	}
+}
+
+// LastKnownGood returns the subgraphs of the supergraph stored in Redis
+func (v *SupergraphValidator) LastKnownGood(ctx context.Context) []engine.SubgraphConfiguration {
+	if v.cacheService == nil {
+		return nil
+	}
+	supergraph, ok, err := v.cacheService.GetSupergraph(ctx)
+	if err != nil {
+		v.logger.Error("Failed to load last known good supergraph", log.Error(err))
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	configs := make([]engine.SubgraphConfiguration, len(supergraph.Subgraphs))
+	for i, subgraph := range supergraph.Subgraphs {
+		configs[i] = engine.SubgraphConfiguration{
+			Name:            subgraph.Name,
+			URL:             subgraph.URL,
+			SubscriptionUrl: subgraph.SubscriptionURL,
+			SDL:             subgraph.SDL,
+		}
+	}
+	v.logger.Info("Loaded last known good supergraph", log.String("composed_at", supergraph.ComposedAt.Format(time.RFC3339)))
+	return configs
+}
+
+// Validate returns the subgraphs to swap in, and false when the supergraph being served
+// stays. Subgraphs whose SDL could not be fetched keep the SDL of the current supergraph,
+// an unreachable subgraph does not remove its part of the schema. allowBreaking names the
+// subgraphs whose breaking changes are let through for this update only.
+func (v *SupergraphValidator) Validate(ctx context.Context, configs []engine.SubgraphConfiguration, allowBreaking map[string]bool) ([]engine.SubgraphConfiguration, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	configs = v.withCurrentSDLs(configs)
+	if v.current != nil && sameSubgraphs(v.current, configs) {
+		return nil, false
+	}
+
+	schema, err := v.compose(configs)
+	if err != nil {
+		v.reject(configs, nil, nil, err)
+		return nil, false
+	}
+
+	var changes []schemadiff.Change
+	if v.schema != nil {
+		changes = schemadiff.Diff(v.schema.Document(), schema.Document())
+		if breaking := schemadiff.BreakingChanges(changes); len(breaking) > 0 {
+			if !v.allowBreaking {
+				if err := v.checkBreaking(configs, breaking, allowBreaking); err != nil {
+					v.reject(configs, schema, changes, err)
+					return nil, false
+				}
+			}
+			v.logger.Warn("Swapping in supergraph with breaking changes", log.Int("breaking", len(breaking)))
+		}
+	}
+
+	v.accept(ctx, configs, schema, changes)
+	return configs, true
+}
+
+// checkBreaking returns an error unless every breaking change comes from the subgraphs in
+// allowed. The changes of the other subgraphs are found by composing configs with the
+// allowed subgraphs kept on their current SDL.
+func (v *SupergraphValidator) checkBreaking(configs []engine.SubgraphConfiguration, breaking []schemadiff.Change, allowed map[string]bool) error {
+	if len(allowed) == 0 {
+		return fmt.Errorf("%d breaking changes", len(breaking))
+	}
+
+	current := make(map[string]engine.SubgraphConfiguration, len(v.current))
+	for _, config := range v.current {
+		current[config.Name] = config
+	}
+	others := make([]engine.SubgraphConfiguration, 0, len(configs))
+	for _, config := range configs {
+		if allowed[config.Name] {
+			var ok bool
+			if config, ok = current[config.Name]; !ok {
+				continue
+			}
+		}
+		others = append(others, config)
+	}
+
+	schema, err := v.compose(others)
+	if err != nil {
+		return fmt.Errorf("%d breaking changes, not only from %s: %w", len(breaking), strings.Join(sortedNames(allowed), ", "), err)
+	}
+	if notAllowed := schemadiff.BreakingChanges(schemadiff.Diff(v.schema.Document(), schema.Document())); len(notAllowed) > 0 {
+		return fmt.Errorf("%d breaking changes, %d not from %s", len(breaking), len(notAllowed), strings.Join(sortedNames(allowed), ", "))
+	}
+	return nil
+}
+
+// Check composes the current supergraph with the SDL of one subgraph replaced and returns
+// the changes it makes, without swapping it in
+func (v *SupergraphValidator) Check(config engine.SubgraphConfiguration) ([]schemadiff.Change, error) {
+	v.mu.Lock()
+	current, currentSchema := v.current, v.schema
+	v.mu.Unlock()
+
+	configs := make([]engine.SubgraphConfiguration, 0, len(current)+1)
+	for _, c := range current {
+		if c.Name != config.Name {
+			configs = append(configs, c)
+		}
+	}
+	configs = append(configs, config)
+
+	schema, err := v.compose(configs)
+	if err != nil {
+		return nil, err
+	}
+	if currentSchema == nil {
+		return nil, nil
+	}
+	return schemadiff.Diff(currentSchema.Document(), schema.Document()), nil
+}
+
+// AllowsBreaking reports whether breaking changes are let through for every update
+func (v *SupergraphValidator) AllowsBreaking() bool {
+	return v.allowBreaking
+}
+
+// Promote swaps in the last rejected supergraph despite its breaking changes
+func (v *SupergraphValidator) Promote(ctx context.Context) ([]engine.SubgraphConfiguration, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	rejected := v.rejected
+	if rejected == nil {
+		return nil, errNoRejectedSupergraph
+	}
+	if rejected.schema == nil {
+		return nil, errSupergraphNotComposed
+	}
+
+	v.logger.Warn("Promoting rejected supergraph", log.Int("changes", len(rejected.Changes)))
+	v.accept(ctx, rejected.configs, rejected.schema, rejected.Changes)
+	return rejected.configs, nil
+}
+
+// compose builds the federation engine configuration of the subgraphs and validates the
+// resulting schema
+func (v *SupergraphValidator) compose(configs []engine.SubgraphConfiguration) (*graphql.Schema, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no subgraphs")
+	}
+
+	engineConfig, err := engine.NewFederationEngineConfigFactory(v.engineCtx, configs).BuildEngineConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+
+	schema := engineConfig.Schema()
+	result, err := schema.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	if !result.Valid {
+		return nil, fmt.Errorf("validate: %s", result.Errors.Error())
+	}
+	return schema, nil
+}
+
+func (v *SupergraphValidator) accept(ctx context.Context, configs []engine.SubgraphConfiguration, schema *graphql.Schema, changes []schemadiff.Change) {
+	v.current = configs
+	v.schema = schema
+	v.composedAt = time.Now()
+	v.rejected = nil
+
+	for _, change := range changes {
+		v.logger.Info("Supergraph change", log.String("criticality", string(change.Criticality)), log.String("change", change.Message))
+	}
+
+	if v.cacheService == nil {
+		return
+	}
+	supergraph := &redis.Supergraph{ComposedAt: v.composedAt}
+	for _, config := range configs {
+		supergraph.Subgraphs = append(supergraph.Subgraphs, redis.SupergraphSubgraph{
+			Name:            config.Name,
+			URL:             config.URL,
+			SubscriptionURL: config.SubscriptionUrl,
+			SDL:             config.SDL,
+		})
+	}
+	if err := v.cacheService.SaveSupergraph(ctx, supergraph); err != nil {
+		v.logger.Error("Failed to save last known good supergraph", log.Error(err))
+	}
+}
+
+func (v *SupergraphValidator) reject(configs []engine.SubgraphConfiguration, schema *graphql.Schema, changes []schemadiff.Change, err error) {
+	v.rejected = &RejectedSupergraph{
+		Subgraphs:  subgraphNames(configs),
+		Error:      err.Error(),
+		Changes:    changes,
+		RejectedAt: time.Now(),
+		configs:    configs,
+		schema:     schema,
+	}
+
+	v.logger.Error("Supergraph rejected, keeping the current one", log.Error(err))
+	for _, change := range schemadiff.BreakingChanges(changes) {
+		v.logger.Error("Breaking supergraph change", log.String("path", change.Path), log.String("change", change.Message))
+	}
+}
+
+// withCurrentSDLs adds the subgraphs of the current supergraph missing from configs
+func (v *SupergraphValidator) withCurrentSDLs(configs []engine.SubgraphConfiguration) []engine.SubgraphConfiguration {
+	names := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		names[config.Name] = true
+	}
+
+	merged := configs[:len(configs):len(configs)]
+	for _, config := range v.current {
+		if !names[config.Name] {
+			v.logger.Warn("Subgraph SDL unavailable, keeping the current one", log.String("subgraph", config.Name))
+			merged = append(merged, config)
+		}
+	}
+	return merged
+}
+
+// SupergraphStatus is reported by the supergraph admin endpoint
+type SupergraphStatus struct {
+	Subgraphs     []string            `json:"subgraphs"`
+	ComposedAt    *time.Time          `json:"composed_at,omitempty"`
+	AllowBreaking bool                `json:"allow_breaking"`
+	Rejected      *RejectedSupergraph `json:"rejected,omitempty"`
+}
+
+// Status returns the supergraph being served and the last rejected one
+func (v *SupergraphValidator) Status() SupergraphStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	status := SupergraphStatus{
+		Subgraphs:     subgraphNames(v.current),
+		AllowBreaking: v.allowBreaking,
+		Rejected:      v.rejected,
+	}
+	if v.schema != nil {
+		composedAt := v.composedAt
+		status.ComposedAt = &composedAt
+	}
+	return status
+}
+
+func sameSubgraphs(a, b []engine.SubgraphConfiguration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byName := make(map[string]engine.SubgraphConfiguration, len(a))
+	for _, config := range a {
+		byName[config.Name] = config
+	}
+	for _, config := range b {
+		if other, ok := byName[config.Name]; !ok || other != config {
+			return false
+		}
+	}
+	return true
+}
+
+func subgraphNames(configs []engine.SubgraphConfiguration) []string {
+	names := make([]string, len(configs))
+	for i, config := range configs {
+		names[i] = config.Name
+	}
+	return names
+}
+
+// sortedNames returns the subgraph names of a set in order
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SupergraphAdminHandler reports the supergraph being served and the last rejected one
+// on GET /admin/supergraph, POST /admin/supergraph/promote swaps in the rejected one
+func SupergraphAdminHandler(poller *DatasourcePollerPoller, validator *SupergraphValidator, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/admin/supergraph":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(validator.Status())
+		case r.Method == http.MethodPost && r.URL.Path == "/admin/supergraph/promote":
+			err := poller.Promote(r.Context())
+			if errors.Is(err, errNoRejectedSupergraph) || errors.Is(err, errSupergraphNotComposed) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to promote supergraph", log.Error(err))
+				http.Error(w, "Failed to promote supergraph", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(validator.Status())
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"api-gateway/redis"
+	"api-gateway/schemadiff"
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wundergraph/graphql-go-tools/execution/engine"
+)
+
+const (
+	ordersSDL = `
+		type Query {
+			orders: [Order!]!
+		}
+
+		type Order @key(fields: "id") {
+			id: ID!
+			status: String!
+			note: String
+		}
+	`
+	productsSDL = `
+		type Query {
+			products: [Product!]!
+		}
+
+		type Product @key(fields: "id") {
+			id: ID!
+			name: String!
+		}
+	`
+)
+
+func ordersSubgraph(sdl string) engine.SubgraphConfiguration {
+	return engine.SubgraphConfiguration{Name: "orders", URL: "http://orders.example.com/query", SDL: sdl}
+}
+
+func productsSubgraph(sdl string) engine.SubgraphConfiguration {
+	return engine.SubgraphConfiguration{Name: "products", URL: "http://products.example.com/query", SDL: sdl}
+}
+
+// withoutNote is ordersSDL with the note field removed, a breaking change
+const withoutNote = `
+	type Query {
+		orders: [Order!]!
+	}
+
+	type Order @key(fields: "id") {
+		id: ID!
+		status: String!
+	}
+`
+
+// withTotal is ordersSDL with a total field added, a safe change
+const withTotal = `
+	type Query {
+		orders: [Order!]!
+	}
+
+	type Order @key(fields: "id") {
+		id: ID!
+		status: String!
+		note: String
+		total: Float
+	}
+`
+
+func newTestValidator(t *testing.T, allowBreaking bool) *SupergraphValidator {
+	v := NewSupergraphValidator(context.Background(), nil, allowBreaking, log.NoopLogger)
+	configs, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(ordersSDL), productsSubgraph(productsSDL)}, nil)
+	require.True(t, ok)
+	require.Len(t, configs, 2)
+	return v
+}
+
+func TestSupergraphValidatorAcceptsSafeChanges(t *testing.T) {
+	v := newTestValidator(t, false)
+
+	configs, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withTotal), productsSubgraph(productsSDL)}, nil)
+	require.True(t, ok)
+	assert.Equal(t, withTotal, configs[0].SDL)
+
+	status := v.Status()
+	assert.Equal(t, []string{"orders", "products"}, status.Subgraphs)
+	assert.NotNil(t, status.ComposedAt)
+	assert.Nil(t, status.Rejected)
+}
+
+func TestSupergraphValidatorSkipsUnchangedSupergraph(t *testing.T) {
+	v := newTestValidator(t, false)
+
+	_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(ordersSDL), productsSubgraph(productsSDL)}, nil)
+	assert.False(t, ok)
+	assert.Nil(t, v.Status().Rejected)
+}
+
+func TestSupergraphValidatorRejectsBreakingChanges(t *testing.T) {
+	v := newTestValidator(t, false)
+
+	_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withoutNote), productsSubgraph(productsSDL)}, nil)
+	assert.False(t, ok)
+
+	rejected := v.Status().Rejected
+	require.NotNil(t, rejected)
+	assert.Equal(t, "1 breaking changes", rejected.Error)
+	assert.Equal(t, []schemadiff.Change{
+		{Criticality: schemadiff.Breaking, Path: "Order.note", Message: "field Order.note was removed"},
+	}, schemadiff.BreakingChanges(rejected.Changes))
+
+	// The supergraph being served stays, an unchanged poll does not swap anything in
+	_, ok = v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(ordersSDL), productsSubgraph(productsSDL)}, nil)
+	assert.False(t, ok)
+
+	// Promoting swaps the rejected supergraph in
+	configs, err := v.Promote(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, withoutNote, configs[0].SDL)
+	assert.Nil(t, v.Status().Rejected)
+
+	_, err = v.Promote(context.Background())
+	assert.ErrorIs(t, err, errNoRejectedSupergraph)
+}
+
+func TestSupergraphValidatorAllowsBreakingChanges(t *testing.T) {
+	t.Run("for every update", func(t *testing.T) {
+		v := newTestValidator(t, true)
+		_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withoutNote), productsSubgraph(productsSDL)}, nil)
+		assert.True(t, ok)
+	})
+
+	t.Run("for one update of a subgraph", func(t *testing.T) {
+		v := newTestValidator(t, false)
+		_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withoutNote), productsSubgraph(productsSDL)}, map[string]bool{"orders": true})
+		assert.True(t, ok)
+	})
+
+	t.Run("not from another subgraph", func(t *testing.T) {
+		v := newTestValidator(t, false)
+		_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withoutNote), productsSubgraph(productsSDL)}, map[string]bool{"products": true})
+		assert.False(t, ok)
+		require.NotNil(t, v.Status().Rejected)
+		assert.Equal(t, "1 breaking changes, 1 not from products", v.Status().Rejected.Error)
+	})
+
+	t.Run("not alongside another subgraph", func(t *testing.T) {
+		const withoutName = `
+			type Query {
+				products: [Product!]!
+			}
+
+			type Product @key(fields: "id") {
+				id: ID!
+			}
+		`
+		v := newTestValidator(t, false)
+		_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withoutNote), productsSubgraph(withoutName)}, map[string]bool{"orders": true})
+		assert.False(t, ok)
+		require.NotNil(t, v.Status().Rejected)
+		assert.Equal(t, "2 breaking changes, 1 not from orders", v.Status().Rejected.Error)
+	})
+}
+
+func TestSupergraphValidatorRejectsSupergraphThatDoesNotCompose(t *testing.T) {
+	v := newTestValidator(t, true)
+
+	broken := `
+		type Query {
+			orders: [Order!]!
+		}
+
+		type Order @key(fields: "id") {
+			id: ID!
+			customer: Customer!
+		}
+	`
+	_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(broken), productsSubgraph(productsSDL)}, nil)
+	assert.False(t, ok)
+
+	rejected := v.Status().Rejected
+	require.NotNil(t, rejected)
+	assert.NotEmpty(t, rejected.Error)
+	assert.Empty(t, rejected.Changes)
+
+	_, err := v.Promote(context.Background())
+	assert.ErrorIs(t, err, errSupergraphNotComposed)
+
+	_, ok = v.Validate(context.Background(), nil, nil)
+	assert.False(t, ok, "no subgraphs keeps the current supergraph")
+}
+
+func TestSupergraphValidatorKeepsSDLOfUnreachableSubgraph(t *testing.T) {
+	v := newTestValidator(t, false)
+
+	// The products subgraph did not answer the poll
+	configs, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(withTotal)}, nil)
+	require.True(t, ok)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "products", configs[1].Name)
+	assert.Equal(t, productsSDL, configs[1].SDL)
+}
+
+func TestSupergraphValidatorCheck(t *testing.T) {
+	v := newTestValidator(t, false)
+
+	changes, err := v.Check(ordersSubgraph(withoutNote))
+	require.NoError(t, err)
+	assert.Len(t, schemadiff.BreakingChanges(changes), 1)
+
+	changes, err = v.Check(ordersSubgraph(withTotal))
+	require.NoError(t, err)
+	assert.Equal(t, []schemadiff.Change{
+		{Criticality: schemadiff.Safe, Path: "Order.total", Message: "field Order.total was added"},
+	}, changes)
+
+	// Checking does not swap anything in
+	_, ok := v.Validate(context.Background(), []engine.SubgraphConfiguration{ordersSubgraph(ordersSDL), productsSubgraph(productsSDL)}, nil)
+	assert.False(t, ok)
+}
+
+func TestSupergraphValidatorLastKnownGood(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cacheService := redis.NewCacheService(client, log.NoopLogger)
+
+	v := NewSupergraphValidator(context.Background(), cacheService, false, log.NoopLogger)
+	assert.Empty(t, v.LastKnownGood(context.Background()))
+
+	configs := []engine.SubgraphConfiguration{ordersSubgraph(ordersSDL), productsSubgraph(productsSDL)}
+	_, ok := v.Validate(context.Background(), configs, nil)
+	require.True(t, ok)
+
+	// A restarted gateway serves the supergraph swapped in last
+	restarted := NewSupergraphValidator(context.Background(), cacheService, false, log.NoopLogger)
+	assert.Equal(t, configs, restarted.LastKnownGood(context.Background()))
+}
@@ -3,24 +3,54 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
+	"api-gateway/auth"
 	"api-gateway/models"
 	"api-gateway/redis"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	log "github.com/jensneuse/abstractlogger"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	mySigningKey = []byte(os.Getenv("JWT_SECRET"))
-	ctx          = context.Background()
-	logger       = log.Noop{}
+	keyRing  *auth.KeyRing
+	sessions *redis.SessionManager
+	ctx                 = context.Background()
+	logger   log.Logger = log.Noop{}
 )
 
+var dummyPasswordHash, _ = HashPassword("no user has this password")
+
+// lockoutPolicy locks a username out for 15 minutes after 5 failed logins within 15 minutes
+var lockoutPolicy = redis.LockoutPolicy{MaxAttempts: 5, Window: 15 * time.Minute, Duration: 15 * time.Minute}
+
+// SetLockoutPolicy sets when usernames are locked out after failed logins
+func SetLockoutPolicy(policy redis.LockoutPolicy) {
+	lockoutPolicy = policy
+}
+
+// UseKeyRing sets the key ring signing and verifying tokens
+func UseKeyRing(kr *auth.KeyRing) {
+	keyRing = kr
+}
+
+// UseLogger sets the logger of the handlers
+func UseLogger(l log.Logger) {
+	logger = l
+}
+
+// UseSessionManager sets the store of refresh sessions
+func UseSessionManager(sm *redis.SessionManager) {
+	sessions = sm
+}
+
 func comparePassword(hashedPassword, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	return err == nil
@@ -46,15 +76,30 @@ func respondWithJSON(w http.ResponseWriter, code int, payload any) {
 	w.Write(response)
 }
 
-func GenerateToken(username string) (string, error) {
+// GenerateToken issues an access token for a session, identified by the returned jti.
+// The token carries the roles of the user and the scopes they grant.
+func GenerateToken(user *redis.User, sessionID string) (string, *models.Claims, error) {
+	now := time.Now()
 	claims := &models.Claims{
-		Username: username,
+		UserID:    user.ID,
+		Username:  user.Username,
+		SessionID: sessionID,
+		Roles:     user.Roles,
+		Scopes:    roleScopes.Scopes(user.Roles),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(72 * time.Hour).Unix(),
+			Id:        uuid.NewString(),
+			Subject:   user.ID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(mySigningKey)
+	token, err := keyRing.Sign(claims)
+	return token, claims, err
+}
+
+func respondWithLockout(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too many failed logins, try again later", http.StatusLocked)
 }
 
 type LoginResponse struct {
@@ -63,9 +108,10 @@ type LoginResponse struct {
 }
 
 type UserResponse struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	Token    string `json:"token"`
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -75,53 +121,65 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := redis.Client()
+	ctx := r.Context()
 
-	storedPassword, err := client.HGet(ctx, creds.Username, "password").Result()
+	// Locked out usernames are rejected before the password is checked
+	lockedFor, err := users.LockedFor(ctx, creds.Username)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "User not found", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not check account", err)
 		return
 	}
-
-	if !comparePassword(storedPassword, creds.Password) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if lockedFor > 0 {
+		respondWithLockout(w, lockedFor)
 		return
 	}
 
-	token, err := GenerateToken(creds.Username)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not generate token", err)
+	user, err := users.GetUserByUsername(ctx, creds.Username)
+	if err != nil && !errors.Is(err, redis.ErrUserNotFound) {
+		respondWithError(w, http.StatusInternalServerError, "Could not look up user", err)
 		return
 	}
 
-	// Set token as HTTP-only cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  time.Now().Add(72 * time.Hour),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	// Unknown users are checked against a dummy hash so they answer as slowly as wrong passwords
+	passwordHash := dummyPasswordHash
+	if user != nil {
+		passwordHash = user.PasswordHash
+	}
+	if !comparePassword(passwordHash, creds.Password) || user == nil {
+		locked, err := users.RecordFailedLogin(ctx, creds.Username, lockoutPolicy)
+		if err != nil {
+			logger.Error("Could not record failed login", log.Error(err))
+		}
+		if locked {
+			respondWithLockout(w, lockoutPolicy.Duration)
+			return
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	if err := users.ResetFailedLogins(ctx, creds.Username); err != nil {
+		logger.Error("Could not reset failed logins", log.Error(err))
+	}
 
-	userId, err := client.HGet(ctx, creds.Username, "user_id").Result()
+	tokens, err := startSession(user)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "User id not found", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not generate token", err)
 		return
 	}
+	setTokenCookies(w, tokens)
+
 	response := LoginResponse{
 		Message: "Logged in successfully",
 		Data: UserResponse{
-			ID:       userId,
-			Username: creds.Username,
-			Token:    token,
+			ID:           user.ID,
+			Username:     user.Username,
+			Token:        tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
 		},
 	}
 
-	json.NewEncoder(w).Encode(response)
+	respondWithJSON(w, http.StatusOK, response)
 }
 
 func TokenValid(next http.Handler) http.Handler {
@@ -133,15 +191,21 @@ func TokenValid(next http.Handler) http.Handler {
 		}
 
 		claims := &models.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-			return mySigningKey, nil
-		})
+		token, err := keyRing.Parse(tokenString, claims)
 
 		if err != nil || !token.Valid {
 			respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
 			return
 		}
 
+		if claims.Id != "" {
+			revoked, err := sessions.IsTokenRevoked(r.Context(), claims.Id)
+			if err != nil || revoked {
+				respondWithError(w, http.StatusUnauthorized, "Invalid token", err)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
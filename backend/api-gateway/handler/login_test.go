@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"api-gateway/models"
+	"api-gateway/redis"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useLockoutPolicy(t *testing.T, policy redis.LockoutPolicy) {
+	previous := lockoutPolicy
+	SetLockoutPolicy(policy)
+	t.Cleanup(func() { SetLockoutPolicy(previous) })
+}
+
+func loginAs(username string, password string) int {
+	return postJSON(LoginHandler, "/login", models.Credentials{Username: username, Password: password}, nil).Code
+}
+
+func TestLoginLockout(t *testing.T) {
+	server := setupHandlers(t)
+	createTestUser(t)
+	useLockoutPolicy(t, redis.LockoutPolicy{MaxAttempts: 3, Window: time.Minute, Duration: 10 * time.Minute})
+
+	assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+	assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+
+	w := postJSON(LoginHandler, "/login", models.Credentials{Username: "ALICE", Password: "Wrong-Horse-42"}, nil)
+	require.Equal(t, http.StatusLocked, w.Code, "failures count across cases of the username")
+	assert.Equal(t, strconv.Itoa(int((10 * time.Minute).Seconds())), w.Header().Get("Retry-After"))
+
+	// The right password does not get past the lock
+	assert.Equal(t, http.StatusLocked, loginAs(testUsername, testPassword))
+
+	server.FastForward(10 * time.Minute)
+	assert.Equal(t, http.StatusOK, loginAs(testUsername, testPassword))
+}
+
+func TestLoginResetsFailures(t *testing.T) {
+	server := setupHandlers(t)
+	createTestUser(t)
+	useLockoutPolicy(t, redis.LockoutPolicy{MaxAttempts: 3, Window: time.Minute, Duration: 10 * time.Minute})
+
+	t.Run("after a successful login", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+		require.Equal(t, http.StatusOK, loginAs(testUsername, testPassword))
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+	})
+
+	t.Run("after the window", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, loginAs(testUsername, testPassword))
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+		server.FastForward(time.Minute)
+		assert.Equal(t, http.StatusUnauthorized, loginAs(testUsername, "Wrong-Horse-42"))
+	})
+}
+
+func TestLoginLockoutOfUnknownUsers(t *testing.T) {
+	setupHandlers(t)
+	useLockoutPolicy(t, redis.LockoutPolicy{MaxAttempts: 2, Window: time.Minute, Duration: time.Minute})
+
+	// Unknown usernames lock out like known ones, not revealing which exist
+	assert.Equal(t, http.StatusUnauthorized, loginAs("nobody", testPassword))
+	assert.Equal(t, http.StatusLocked, loginAs("nobody", testPassword))
+}
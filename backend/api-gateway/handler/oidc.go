@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"api-gateway/auth"
+	"api-gateway/redis"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+const (
+	// OIDCLoginTTL is how long a user has to sign in at the identity provider
+	OIDCLoginTTL = 10 * time.Minute
+
+	oidcStateCookie = "oidc_state"
+	// usernameAttempts is how many generated usernames are tried for a new federated user
+	usernameAttempts = 5
+)
+
+var (
+	oidcProviders     = map[string]*auth.OIDCProvider{}
+	postLoginRedirect string
+
+	errEmailRegistered = errors.New("email registered to an unverified account")
+	invalidUsernameRun = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// UseOIDCProviders sets the identity providers users can sign in with at /auth/{provider}/login
+func UseOIDCProviders(providers ...*auth.OIDCProvider) {
+	oidcProviders = make(map[string]*auth.OIDCProvider, len(providers))
+	for _, provider := range providers {
+		oidcProviders[provider.Name()] = provider
+	}
+}
+
+// SetPostLoginRedirect sets where users are sent after signing in with an identity
+// provider. Without one, the callback responds with the tokens like LoginHandler.
+func SetPostLoginRedirect(url string) {
+	postLoginRedirect = url
+}
+
+// OIDCLoginHandler sends the user to the identity provider named in the path, with a
+// state bound to the browser by a cookie, a nonce and a PKCE challenge
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := oidcProviders[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	state, err := auth.RandomToken(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not start sign in", err)
+		return
+	}
+	nonce, err := auth.RandomToken(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not start sign in", err)
+		return
+	}
+	verifier, err := auth.RandomToken(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not start sign in", err)
+		return
+	}
+
+	err = users.SaveLoginState(r.Context(), state, redis.LoginState{
+		Provider:     provider.Name(),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		CreatedAt:    time.Now().Unix(),
+	}, OIDCLoginTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not start sign in", err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(verifier))
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Identity provider unavailable", err)
+		return
+	}
+
+	// Lax, the cookie has to come back with the top level redirect from the provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		MaxAge:   int(OIDCLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/auth/",
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler completes a sign in: it checks the state, redeems the code with
+// the PKCE verifier, verifies the ID token, links or creates the local user and issues
+// the same tokens and cookies as LoginHandler
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := oidcProviders[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	state := query.Get("state")
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "Invalid sign in state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", MaxAge: -1, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode, Path: "/auth/"})
+
+	login, err := users.ConsumeLoginState(ctx, state)
+	if errors.Is(err, redis.ErrLoginStateNotFound) {
+		http.Error(w, "Sign in expired, try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not complete sign in", err)
+		return
+	}
+	if login.Provider != provider.Name() {
+		http.Error(w, "Invalid sign in state", http.StatusBadRequest)
+		return
+	}
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		logger.Warn("Identity provider rejected sign in",
+			log.String("provider", provider.Name()),
+			log.String("error", providerErr),
+			log.String("description", query.Get("error_description")),
+		)
+		http.Error(w, "Sign in failed", http.StatusUnauthorized)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := provider.Exchange(ctx, code, login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Sign in failed", err)
+		return
+	}
+	claims, err := provider.VerifyIDToken(ctx, tokens.IDToken, login.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Sign in failed", err)
+		return
+	}
+
+	user, err := federatedUser(ctx, provider.Name(), claims)
+	if errors.Is(err, errEmailRegistered) {
+		http.Error(w, "An account with this email already exists, sign in with your password to link it", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not complete sign in", err)
+		return
+	}
+
+	session, err := startSession(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not generate token", err)
+		return
+	}
+	setTokenCookies(w, session)
+
+	logger.Info("User signed in with identity provider", log.String("provider", provider.Name()), log.String("user_id", user.ID))
+
+	if postLoginRedirect != "" {
+		http.Redirect(w, r, postLoginRedirect, http.StatusSeeOther)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, LoginResponse{
+		Message: "Logged in successfully",
+		Data: UserResponse{
+			ID:           user.ID,
+			Username:     user.Username,
+			Token:        session.AccessToken,
+			RefreshToken: session.RefreshToken,
+		},
+	})
+}
+
+// federatedUser returns the local user of an identity. Identities seen for the first
+// time are linked to the user with the same email address when both the provider and
+// the user verified it, otherwise a user without password is created.
+func federatedUser(ctx context.Context, provider string, claims *auth.IDTokenClaims) (*redis.User, error) {
+	user, err := users.GetUserByIdentity(ctx, provider, claims.Subject)
+	if !errors.Is(err, redis.ErrUserNotFound) {
+		return user, err
+	}
+
+	email := ""
+	if claims.Email != "" && claims.EmailVerified {
+		email = claims.Email
+
+		existing, err := users.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil && !existing.EmailVerified:
+			// Whoever registered the address never proved owning it
+			return nil, errEmailRegistered
+		case err == nil:
+			if err := users.LinkIdentity(ctx, provider, claims.Subject, existing.ID); err != nil {
+				return nil, err
+			}
+			logger.Info("Linked identity to existing user", log.String("provider", provider), log.String("user_id", existing.ID))
+			return existing, nil
+		case !errors.Is(err, redis.ErrUserNotFound):
+			return nil, err
+		}
+	}
+
+	base := federatedUsername(claims)
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return nil, err
+			}
+			username = base + "-" + hex.EncodeToString(suffix)
+		}
+
+		user = &redis.User{
+			ID:            uuid.New().String(),
+			Username:      username,
+			Email:         email,
+			EmailVerified: email != "",
+			CreatedAt:     time.Now(),
+		}
+		err := users.CreateUserWithIdentity(ctx, user, provider, claims.Subject)
+		switch {
+		case err == nil:
+			logger.Info("Created user for identity", log.String("provider", provider), log.String("user_id", user.ID))
+			return user, nil
+		case errors.Is(err, redis.ErrIdentityLinked):
+			// A concurrent callback for the same identity created the user
+			return users.GetUserByIdentity(ctx, provider, claims.Subject)
+		case errors.Is(err, redis.ErrEmailTaken):
+			// Registered since the lookup above
+			return nil, errEmailRegistered
+		case !errors.Is(err, redis.ErrUsernameTaken):
+			return nil, err
+		}
+	}
+	return nil, redis.ErrUsernameTaken
+}
+
+// federatedUsername derives a username matching usernamePattern from the claims of an ID token
+func federatedUsername(claims *auth.IDTokenClaims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name = strings.Trim(invalidUsernameRun.ReplaceAllString(name, "_"), "_.-")
+	if len(name) > 24 {
+		name = name[:24]
+	}
+	if len(name) < 3 {
+		name = "user"
+	}
+	return name
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"api-gateway/auth"
+	"api-gateway/auth/oidctest"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testProvider = "test"
+	testClientID = "gateway"
+)
+
+// setupOIDC wires the handlers to a Redis and an identity provider of their own
+func setupOIDC(t *testing.T) *oidctest.Provider {
+	setupHandlers(t)
+	SetPostLoginRedirect("")
+
+	idp := oidctest.NewProvider(testClientID)
+	t.Cleanup(idp.Close)
+	UseOIDCProviders(auth.NewOIDCProvider(auth.OIDCProviderConfig{
+		Name:        testProvider,
+		Issuer:      idp.Issuer(),
+		ClientID:    testClientID,
+		RedirectURL: "https://gateway.example.com/auth/test/callback",
+	}, idp.Server.Client()))
+	return idp
+}
+
+// startLogin calls OIDCLoginHandler and returns the URL of the provider and the state cookie
+func startLogin(t *testing.T) (string, *http.Cookie) {
+	r := httptest.NewRequest(http.MethodGet, "/auth/test/login", nil)
+	r.SetPathValue("provider", testProvider)
+	w := httptest.NewRecorder()
+
+	OIDCLoginHandler(w, r)
+
+	require.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, oidcStateCookie, cookies[0].Name)
+	return w.Header().Get("Location"), cookies[0]
+}
+
+// callback calls OIDCCallbackHandler as the browser redirected by the provider would
+func callback(code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	query := url.Values{"code": {code}, "state": {state}}
+	r := httptest.NewRequest(http.MethodGet, "/auth/test/callback?"+query.Encode(), nil)
+	r.SetPathValue("provider", testProvider)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+
+	OIDCCallbackHandler(w, r)
+	return w
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	idp := setupOIDC(t)
+
+	authURL, cookie := startLogin(t)
+	code, state, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, cookie.Value, state)
+
+	w := callback(code, state, cookie)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, idp.Identity.Username, resp.Data.Username)
+	assert.NotEmpty(t, resp.Data.Token)
+	assert.NotEmpty(t, resp.Data.RefreshToken)
+
+	// The identity is linked, signing in again finds the same user
+	authURL, cookie = startLogin(t)
+	code, state, err = idp.Authorize(authURL)
+	require.NoError(t, err)
+	w = callback(code, state, cookie)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var again LoginResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&again))
+	assert.Equal(t, resp.Data.ID, again.Data.ID)
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	idp := setupOIDC(t)
+
+	t.Run("state of another browser", func(t *testing.T) {
+		_, cookie := startLogin(t)
+		otherURL, _ := startLogin(t)
+		code, otherState, err := idp.Authorize(otherURL)
+		require.NoError(t, err)
+
+		w := callback(code, otherState, cookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Invalid sign in state")
+	})
+
+	t.Run("missing state cookie", func(t *testing.T) {
+		authURL, _ := startLogin(t)
+		code, state, err := idp.Authorize(authURL)
+		require.NoError(t, err)
+
+		w := callback(code, state, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("missing state", func(t *testing.T) {
+		authURL, cookie := startLogin(t)
+		code, _, err := idp.Authorize(authURL)
+		require.NoError(t, err)
+
+		w := callback(code, "", cookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("replayed callback", func(t *testing.T) {
+		authURL, cookie := startLogin(t)
+		code, state, err := idp.Authorize(authURL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, callback(code, state, cookie).Code)
+
+		w := callback(code, state, cookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Sign in expired")
+	})
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	idp := setupOIDC(t)
+	idp.Nonce = "nonce-of-another-sign-in"
+
+	authURL, cookie := startLogin(t)
+	code, state, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+
+	w := callback(code, state, cookie)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Result().Cookies()[1:], "no token cookies are set")
+}
+
+func TestOIDCCallbackRejectsFailedCodeExchange(t *testing.T) {
+	idp := setupOIDC(t)
+
+	t.Run("forged code", func(t *testing.T) {
+		authURL, cookie := startLogin(t)
+		_, state, err := idp.Authorize(authURL)
+		require.NoError(t, err)
+
+		w := callback("forged-code", state, cookie)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("code of another sign in", func(t *testing.T) {
+		// The code was issued for the PKCE challenge of another sign in
+		otherURL, _ := startLogin(t)
+		code, _, err := idp.Authorize(otherURL)
+		require.NoError(t, err)
+
+		authURL, cookie := startLogin(t)
+		_, state, err := idp.Authorize(authURL)
+		require.NoError(t, err)
+
+		w := callback(code, state, cookie)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// bcryptMaxLength is the number of bytes of a password bcrypt takes into account
+const bcryptMaxLength = 72
+
+// PasswordPolicy is the set of rules a new password must satisfy
+type PasswordPolicy struct {
+	MinLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	RejectUsername bool
+}
+
+// DefaultPasswordPolicy requires 12 characters mixing letter cases and digits
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      12,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireDigit:   true,
+		RejectUsername: true,
+	}
+}
+
+// PasswordPolicyFromEnv reads PASSWORD_MIN_LENGTH and PASSWORD_REQUIRE_UPPER, _LOWER,
+// _DIGIT and _SYMBOL, falling back to DefaultPasswordPolicy
+func PasswordPolicyFromEnv() PasswordPolicy {
+	policy := DefaultPasswordPolicy()
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		policy.MinLength = n
+	}
+	envBool("PASSWORD_REQUIRE_UPPER", &policy.RequireUpper)
+	envBool("PASSWORD_REQUIRE_LOWER", &policy.RequireLower)
+	envBool("PASSWORD_REQUIRE_DIGIT", &policy.RequireDigit)
+	envBool("PASSWORD_REQUIRE_SYMBOL", &policy.RequireSymbol)
+	return policy
+}
+
+func envBool(name string, value *bool) {
+	if b, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+		*value = b
+	}
+}
+
+// Validate returns the rules a password breaks, or nil if it is acceptable
+func (p PasswordPolicy) Validate(password string, username string) []string {
+	var violations []string
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > bcryptMaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", bcryptMaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+	if p.RejectUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "must not contain the username")
+	}
+
+	return violations
+}
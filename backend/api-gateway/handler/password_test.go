@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	withSymbol := policy
+	withSymbol.RequireSymbol = true
+
+	tests := []struct {
+		name       string
+		policy     PasswordPolicy
+		password   string
+		username   string
+		violations []string
+	}{
+		{
+			name:     "acceptable",
+			policy:   policy,
+			password: "Correct-Horse-42",
+			username: "alice",
+		},
+		{
+			name:       "too short",
+			policy:     policy,
+			password:   "Short-42",
+			violations: []string{"must be at least 12 characters long"},
+		},
+		{
+			name:       "longer than bcrypt reads",
+			policy:     policy,
+			password:   "Aa1" + strings.Repeat("x", bcryptMaxLength),
+			violations: []string{"must be at most 72 bytes long"},
+		},
+		{
+			name:     "single character class",
+			policy:   policy,
+			password: "correcthorsebattery",
+			violations: []string{
+				"must contain an uppercase letter",
+				"must contain a digit",
+			},
+		},
+		{
+			name:       "no symbol where one is required",
+			policy:     withSymbol,
+			password:   "CorrectHorse42",
+			violations: []string{"must contain a symbol"},
+		},
+		{
+			name:       "username in another case",
+			policy:     policy,
+			password:   "my-ALICE-password-42",
+			username:   "alice",
+			violations: []string{"must not contain the username"},
+		},
+		{
+			name:     "length counted in characters",
+			policy:   PasswordPolicy{MinLength: 4},
+			password: "äöüß",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.violations, tt.policy.Validate(tt.password, tt.username))
+		})
+	}
+}
+
+func TestPasswordPolicyFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "16")
+	t.Setenv("PASSWORD_REQUIRE_SYMBOL", "true")
+	t.Setenv("PASSWORD_REQUIRE_DIGIT", "false")
+
+	policy := PasswordPolicyFromEnv()
+	assert.Equal(t, 16, policy.MinLength)
+	assert.True(t, policy.RequireSymbol)
+	assert.False(t, policy.RequireDigit)
+	assert.True(t, policy.RequireUpper)
+}
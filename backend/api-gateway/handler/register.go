@@ -3,43 +3,180 @@ package handler
 import (
 	"api-gateway/models"
 	"api-gateway/redis"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	log "github.com/jensneuse/abstractlogger"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// EmailVerificationTTL is how long an email verification token can be used
+const EmailVerificationTTL = 24 * time.Hour
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// VerificationSender delivers an email verification token to a user
+type VerificationSender func(ctx context.Context, user *redis.User, token string) error
+
+var (
+	users              *redis.UserStore
+	passwordPolicy     = DefaultPasswordPolicy()
+	verificationSender = VerificationSender(discardVerification)
+)
+
+// discardVerification is the VerificationSender used until one is configured
+func discardVerification(ctx context.Context, user *redis.User, token string) error {
+	logger.Warn("No email verification sender configured", log.String("user_id", user.ID))
+	return nil
+}
+
+// UseUserStore sets the store of registered users
+func UseUserStore(us *redis.UserStore) {
+	users = us
+}
+
+// SetPasswordPolicy sets the rules new passwords must satisfy
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicy = policy
+}
+
+// SetVerificationSender sets how email verification tokens are delivered
+func SetVerificationSender(sender VerificationSender) {
+	verificationSender = sender
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(bytes), err
 }
 
+// hashVerificationToken returns the key under which a verification token is stored
+func hashVerificationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// issueVerificationToken creates an email verification token for a user and sends it
+func issueVerificationToken(ctx context.Context, user *redis.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	if err := users.CreateVerificationToken(ctx, user.ID, hashVerificationToken(token), EmailVerificationTTL); err != nil {
+		return err
+	}
+	return verificationSender(ctx, user, token)
+}
+
+type validationErrorResponse struct {
+	Message string              `json:"message"`
+	Errors  map[string][]string `json:"errors"`
+}
+
+// validateRegistration checks the username, email and password of a registration
+func validateRegistration(reg *models.Registration) map[string][]string {
+	errs := map[string][]string{}
+
+	if !usernamePattern.MatchString(reg.Username) {
+		errs["username"] = []string{"must be 3 to 32 letters, digits, dots, dashes or underscores"}
+	}
+	if reg.Email != "" {
+		if addr, err := mail.ParseAddress(reg.Email); err != nil || addr.Address != reg.Email {
+			errs["email"] = []string{"must be a valid email address"}
+		}
+	}
+	if violations := passwordPolicy.Validate(reg.Password, reg.Username); len(violations) > 0 {
+		errs["password"] = violations
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	var creds models.Credentials
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+	var reg models.Registration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	reg.Username = strings.TrimSpace(reg.Username)
+	reg.Email = strings.TrimSpace(reg.Email)
 
-	userID := uuid.New().String()
+	if errs := validateRegistration(&reg); errs != nil {
+		respondWithJSON(w, http.StatusBadRequest, validationErrorResponse{Message: "Invalid registration", Errors: errs})
+		return
+	}
 
-	hashedPassword, err := HashPassword(creds.Password)
+	hashedPassword, err := HashPassword(reg.Password)
 	if err != nil {
 		http.Error(w, "Could not hash password", http.StatusInternalServerError)
 		return
 	}
 
-	client := redis.Client()
-	err = client.HSet(ctx, creds.Username, map[string]any{
-		"user_id":  userID,
-		"password": hashedPassword,
-	}).Err()
+	user := &redis.User{
+		ID:           uuid.New().String(),
+		Username:     reg.Username,
+		Email:        reg.Email,
+		PasswordHash: hashedPassword,
+		CreatedAt:    time.Now(),
+	}
+
+	err = users.CreateUser(r.Context(), user)
+	switch {
+	case errors.Is(err, redis.ErrUsernameTaken):
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	case errors.Is(err, redis.ErrEmailTaken):
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Could not register user", err)
+		return
+	}
+
+	if user.Email != "" {
+		if err := issueVerificationToken(r.Context(), user); err != nil {
+			// Registration still succeeded, the email stays unverified
+			logger.Error("Could not issue email verification token", log.String("user_id", user.ID), log.Error(err))
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "User registered successfully"})
+}
+
+// VerifyEmailHandler marks the email of a user verified with the token sent to it
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := users.VerifyEmail(r.Context(), hashVerificationToken(token))
+	if errors.Is(err, redis.ErrInvalidVerificationToken) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Could not register user", http.StatusInternalServerError)
+		respondWithError(w, http.StatusInternalServerError, "Could not verify email", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+	logger.Info("Email verified", log.String("user_id", userID))
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Email verified"})
 }
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"api-gateway/models"
+	"api-gateway/redis"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureVerifications keeps the email verification tokens sent to users, by user ID
+func captureVerifications(t *testing.T) map[string]string {
+	sent := map[string]string{}
+	SetVerificationSender(func(ctx context.Context, user *redis.User, token string) error {
+		sent[user.ID] = token
+		return nil
+	})
+	t.Cleanup(func() { SetVerificationSender(discardVerification) })
+	return sent
+}
+
+func register(username, email, password string) *httptest.ResponseRecorder {
+	return postJSON(RegisterHandler, "/register", models.Registration{Username: username, Email: email, Password: password}, nil)
+}
+
+func TestRegister(t *testing.T) {
+	setupHandlers(t)
+	sent := captureVerifications(t)
+
+	w := register("bob", "bob@example.com", testPassword)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	user, err := users.GetUserByUsername(context.Background(), "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", user.Email)
+	assert.False(t, user.EmailVerified)
+	assert.Equal(t, []string{redis.DefaultUserRole}, user.Roles)
+	assert.True(t, comparePassword(user.PasswordHash, testPassword))
+
+	// The token sent to the user verifies the email, once
+	token := sent[user.ID]
+	require.NotEmpty(t, token)
+	verify := func(token string) int {
+		w := httptest.NewRecorder()
+		VerifyEmailHandler(w, httptest.NewRequest(http.MethodGet, "/verify-email?token="+token, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, verify(token))
+	user, err = users.GetUser(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, http.StatusBadRequest, verify(token))
+}
+
+func TestRegisterDuplicates(t *testing.T) {
+	setupHandlers(t)
+	captureVerifications(t)
+	require.Equal(t, http.StatusOK, register("bob", "bob@example.com", testPassword).Code)
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		message  string
+	}{
+		{
+			name:     "username",
+			username: "bob",
+			message:  "Username already taken",
+		},
+		{
+			name:     "username in another case",
+			username: "BOB",
+			message:  "Username already taken",
+		},
+		{
+			name:     "email in another case",
+			username: "robert",
+			email:    "Bob@Example.com",
+			message:  "Email already registered",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := register(tt.username, tt.email, testPassword)
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Contains(t, w.Body.String(), tt.message)
+		})
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	setupHandlers(t)
+	captureVerifications(t)
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		password string
+		fields   []string
+	}{
+		{
+			name:     "weak password",
+			username: "bob",
+			password: "password",
+			fields:   []string{"password"},
+		},
+		{
+			name:     "password holding the username",
+			username: "bob",
+			password: "Bob-Is-Great-42",
+			fields:   []string{"password"},
+		},
+		{
+			name:     "invalid username and email",
+			username: "b",
+			email:    "Bob <bob@example.com>",
+			password: testPassword,
+			fields:   []string{"username", "email"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := register(tt.username, tt.email, tt.password)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+
+			var resp validationErrorResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			for _, field := range tt.fields {
+				assert.NotEmpty(t, resp.Errors[field], field)
+			}
+			assert.Len(t, resp.Errors, len(tt.fields))
+
+			_, err := users.GetUserByUsername(context.Background(), tt.username)
+			assert.ErrorIs(t, err, redis.ErrUserNotFound)
+		})
+	}
+}
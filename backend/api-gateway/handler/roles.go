@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"api-gateway/redis"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Roles assigned to users
+const (
+	RoleCustomer = redis.DefaultUserRole
+	RoleSupport  = "support"
+)
+
+// RoleScopes maps a role to the scopes it grants. Subgraphs require scopes with
+// @requiresScopes, the gateway checks them against the scopes in the access token.
+type RoleScopes map[string][]string
+
+// DefaultRoleScopes lets customers read and change their orders and support staff
+// administer any order
+func DefaultRoleScopes() RoleScopes {
+	return RoleScopes{
+		RoleCustomer: {"orders:read", "orders:write"},
+		RoleSupport:  {"orders:read", "orders:write", "orders:admin"},
+	}
+}
+
+// LoadRoleScopes reads role scopes from the JSON file at path, or returns the defaults if path is empty
+func LoadRoleScopes(path string) (RoleScopes, error) {
+	if path == "" {
+		return DefaultRoleScopes(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read role scopes: %w", err)
+	}
+	var roleScopes RoleScopes
+	if err := json.Unmarshal(data, &roleScopes); err != nil {
+		return nil, fmt.Errorf("parse role scopes: %w", err)
+	}
+	return roleScopes, nil
+}
+
+// Scopes returns the sorted union of the scopes granted by roles. Unknown roles grant nothing.
+func (rs RoleScopes) Scopes(roles []string) []string {
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, role := range roles {
+		for _, scope := range rs[role] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+var roleScopes = DefaultRoleScopes()
+
+// SetRoleScopes sets the scopes granted by each role
+func SetRoleScopes(rs RoleScopes) {
+	roleScopes = rs
+}
@@ -0,0 +1,317 @@
+package handler
+
+import (
+	"api-gateway/models"
+	"api-gateway/redis"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+)
+
+const (
+	// AccessTokenTTL is the lifetime of access tokens. They cannot be refreshed once
+	// expired, so it bounds how long a token outlives a revocation that failed.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long a session lasts without being refreshed
+	RefreshTokenTTL = 7 * 24 * time.Hour
+
+	accessTokenCookie  = "token"
+	refreshTokenCookie = "refresh_token"
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// tokenPair is an access token and the refresh token of its session
+type tokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// newRefreshToken returns a refresh token of the form <session id>.<secret> and the hash of the secret
+func newRefreshToken(sessionID string) (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	return sessionID + "." + encoded, hashRefreshSecret(encoded), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// parseRefreshToken splits a refresh token into its session ID and the hash of its secret
+func parseRefreshToken(token string) (string, string, error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", errInvalidRefreshToken
+	}
+	return sessionID, hashRefreshSecret(secret), nil
+}
+
+// startSession creates a refresh session for a user and issues its first tokens
+func startSession(user *redis.User) (*tokenPair, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	sessionID := hex.EncodeToString(id)
+
+	accessToken, claims, err := GenerateToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = sessions.CreateSession(ctx, sessionID, redis.SessionData{
+		UserID:               user.ID,
+		Username:             user.Username,
+		CreatedAt:            now.Unix(),
+		ExpiresAt:            now.Add(RefreshTokenTTL).Unix(),
+		RefreshTokenHash:     refreshHash,
+		AccessTokenID:        claims.Id,
+		AccessTokenExpiresAt: claims.ExpiresAt,
+	}, RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: claims.ExpiresAt}, nil
+}
+
+// refreshSession exchanges a refresh token for new tokens, rotating the refresh token.
+// The token is checked before any access token is issued, and the user is reloaded so
+// that role changes apply from the next refresh.
+func refreshSession(refreshToken string) (*tokenPair, error) {
+	sessionID, refreshHash, err := parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := sessions.CheckRefreshToken(ctx, sessionID, refreshHash)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := users.GetUser(ctx, session.UserID)
+	if errors.Is(err, redis.ErrUserNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, claims, err := GenerateToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	nextRefreshToken, nextRefreshHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = sessions.RotateRefreshToken(ctx, sessionID, refreshHash, redis.SessionData{
+		ExpiresAt:            time.Now().Add(RefreshTokenTTL).Unix(),
+		RefreshTokenHash:     nextRefreshHash,
+		AccessTokenID:        claims.Id,
+		AccessTokenExpiresAt: claims.ExpiresAt,
+	}, RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous access token of the session is not needed anymore
+	if session.AccessTokenID != "" {
+		if err := sessions.RevokeToken(ctx, session.AccessTokenID, time.Unix(session.AccessTokenExpiresAt, 0)); err != nil {
+			logger.Error("Failed to revoke replaced access token", log.Error(err))
+		}
+	}
+
+	return &tokenPair{AccessToken: accessToken, RefreshToken: nextRefreshToken, ExpiresAt: claims.ExpiresAt}, nil
+}
+
+func setTokenCookies(w http.ResponseWriter, tokens *tokenPair) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    tokens.AccessToken,
+		Expires:  time.Unix(tokens.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    tokens.RefreshToken,
+		Expires:  time.Now().Add(RefreshTokenTTL),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+}
+
+func clearTokenCookies(w http.ResponseWriter) {
+	for _, name := range []string{accessTokenCookie, refreshTokenCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+			Path:     "/",
+		})
+	}
+}
+
+// refreshTokenFromRequest reads the refresh token from the JSON body or the cookie
+func refreshTokenFromRequest(r *http.Request) string {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err == nil && body.RefreshToken != "" {
+		return body.RefreshToken
+	}
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// sessionFromRequest identifies the session of a request by its refresh token or,
+// without one, by its access token
+func sessionFromRequest(r *http.Request) (sessionID string, userID string, err error) {
+	if refreshToken := refreshTokenFromRequest(r); refreshToken != "" {
+		sessionID, refreshHash, err := parseRefreshToken(refreshToken)
+		if err != nil {
+			return "", "", err
+		}
+		session, err := sessions.CheckRefreshToken(ctx, sessionID, refreshHash)
+		if err != nil {
+			return "", "", err
+		}
+		return sessionID, session.UserID, nil
+	}
+
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" {
+		if cookie, err := r.Cookie(accessTokenCookie); err == nil {
+			tokenString = cookie.Value
+		}
+	}
+
+	claims := &models.Claims{}
+	token, err := keyRing.Parse(tokenString, claims)
+	if err != nil || !token.Valid || claims.SessionID == "" {
+		return "", "", errInvalidRefreshToken
+	}
+	revoked, err := sessions.IsTokenRevoked(ctx, claims.Id)
+	if err != nil {
+		return "", "", err
+	}
+	if revoked {
+		return "", "", errInvalidRefreshToken
+	}
+	return claims.SessionID, claims.UserID, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and refresh token.
+// Presenting a refresh token that was already exchanged revokes its whole session, other
+// tokens are rejected without touching it.
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken := refreshTokenFromRequest(r)
+	if refreshToken == "" {
+		respondWithError(w, http.StatusUnauthorized, "Missing refresh token", nil)
+		return
+	}
+
+	tokens, err := refreshSession(refreshToken)
+	switch {
+	case errors.Is(err, redis.ErrRefreshTokenReused):
+		clearTokenCookies(w)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token reused, session revoked", err)
+		return
+	case errors.Is(err, redis.ErrRefreshTokenRotated):
+		// Another client of the session refreshed first and owns the cookies now
+		respondWithError(w, http.StatusUnauthorized, "Refresh token already rotated", err)
+		return
+	case errors.Is(err, errInvalidRefreshToken), errors.Is(err, redis.ErrRefreshTokenInvalid), errors.Is(err, redis.ErrSessionNotFound):
+		clearTokenCookies(w)
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Could not refresh session", err)
+		return
+	}
+
+	setTokenCookies(w, tokens)
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// LogoutHandler ends the session of the request and revokes its access token
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, _, err := sessionFromRequest(r)
+	if err != nil {
+		clearTokenCookies(w)
+		respondWithError(w, http.StatusUnauthorized, "Invalid session", err)
+		return
+	}
+
+	if err := sessions.DeleteSession(ctx, sessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not end session", err)
+		return
+	}
+
+	clearTokenCookies(w)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// LogoutAllHandler ends every session of the user of the request
+func LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, userID, err := sessionFromRequest(r)
+	if err != nil {
+		clearTokenCookies(w)
+		respondWithError(w, http.StatusUnauthorized, "Invalid session", err)
+		return
+	}
+
+	count, err := sessions.DeleteAllUserSessions(ctx, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not end sessions", err)
+		return
+	}
+
+	clearTokenCookies(w)
+	respondWithJSON(w, http.StatusOK, map[string]any{"message": "Logged out of all sessions", "sessions": count})
+}
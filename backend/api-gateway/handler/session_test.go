@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"api-gateway/auth"
+	"api-gateway/models"
+	"api-gateway/redis"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUsername = "alice"
+	testPassword = "Correct-Horse-42"
+)
+
+// setupHandlers wires the handlers to a Redis and a key ring of their own
+func setupHandlers(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store, err := auth.NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+	kr, err := auth.NewKeyRing(context.Background(), store, auth.KeyRingConfig{
+		Algorithm:        auth.AlgorithmES256,
+		RotationInterval: time.Hour,
+		TokenTTL:         AccessTokenTTL,
+	}, log.NoopLogger)
+	require.NoError(t, err)
+
+	UseKeyRing(kr)
+	UseUserStore(redis.NewUserStore(client, log.NoopLogger))
+	UseSessionManager(redis.NewSessionManager(client, log.NoopLogger))
+	return server
+}
+
+// createTestUser stores the user signing in with testUsername and testPassword
+func createTestUser(t *testing.T) *redis.User {
+	hash, err := HashPassword(testPassword)
+	require.NoError(t, err)
+	user := &redis.User{ID: "user-1", Username: testUsername, PasswordHash: hash, CreatedAt: time.Now()}
+	require.NoError(t, users.CreateUser(context.Background(), user))
+	return user
+}
+
+func postJSON(handler http.HandlerFunc, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// login signs the test user in and returns the issued tokens
+func login(t *testing.T) UserResponse {
+	w := postJSON(LoginHandler, "/login", models.Credentials{Username: testUsername, Password: testPassword}, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp.Data
+}
+
+func refresh(refreshToken string) *httptest.ResponseRecorder {
+	return postJSON(RefreshHandler, "/refresh", map[string]string{"refresh_token": refreshToken}, nil)
+}
+
+func accessClaims(t *testing.T, token string) *models.Claims {
+	claims := &models.Claims{}
+	_, err := keyRing.Parse(token, claims)
+	require.NoError(t, err)
+	return claims
+}
+
+// ageRefreshToken makes a replaced refresh token look replaced before the reuse grace
+func ageRefreshToken(t *testing.T, server *miniredis.Miniredis, refreshToken string) {
+	sessionID, hash, err := parseRefreshToken(refreshToken)
+	require.NoError(t, err)
+	replacedAt := time.Now().Add(-2 * redis.RefreshTokenReuseGrace).Unix()
+	server.HSet(redis.RefreshTokenHistoryPrefix+sessionID, hash, strconv.FormatInt(replacedAt, 10))
+}
+
+func TestLogin(t *testing.T) {
+	setupHandlers(t)
+	user := createTestUser(t)
+
+	w := postJSON(LoginHandler, "/login", models.Credentials{Username: testUsername, Password: testPassword}, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp LoginResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, user.ID, resp.Data.ID)
+	assert.NotEmpty(t, resp.Data.RefreshToken)
+
+	claims := accessClaims(t, resp.Data.Token)
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.NotEmpty(t, claims.SessionID)
+
+	cookies := map[string]string{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	assert.Equal(t, resp.Data.Token, cookies[accessTokenCookie])
+	assert.Equal(t, resp.Data.RefreshToken, cookies[refreshTokenCookie])
+
+	w = postJSON(LoginHandler, "/login", models.Credentials{Username: testUsername, Password: "Wrong-Horse-42"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	setupHandlers(t)
+	createTestUser(t)
+	first := login(t)
+
+	w := refresh(first.RefreshToken)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var second tokenPair
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&second))
+
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.Equal(t, accessClaims(t, first.Token).SessionID, accessClaims(t, second.AccessToken).SessionID)
+
+	// The replaced access token is revoked, the new one is not
+	revoked, err := sessions.IsTokenRevoked(context.Background(), accessClaims(t, first.Token).Id)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = sessions.IsTokenRevoked(context.Background(), accessClaims(t, second.AccessToken).Id)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// The new refresh token rotates again
+	assert.Equal(t, http.StatusOK, refresh(second.RefreshToken).Code)
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	server := setupHandlers(t)
+	createTestUser(t)
+	first := login(t)
+
+	w := refresh(first.RefreshToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var second tokenPair
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&second))
+
+	ageRefreshToken(t, server, first.RefreshToken)
+	w = refresh(first.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "session revoked")
+
+	// The session is gone with the tokens issued to whoever refreshed it
+	_, err := sessions.GetSession(context.Background(), accessClaims(t, second.AccessToken).SessionID)
+	assert.ErrorIs(t, err, redis.ErrSessionNotFound)
+	revoked, err := sessions.IsTokenRevoked(context.Background(), accessClaims(t, second.AccessToken).Id)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	assert.Equal(t, http.StatusUnauthorized, refresh(second.RefreshToken).Code)
+}
+
+func TestRefreshKeepsSession(t *testing.T) {
+	setupHandlers(t)
+	createTestUser(t)
+
+	t.Run("token rotated by a concurrent refresh", func(t *testing.T) {
+		first := login(t)
+		w := refresh(first.RefreshToken)
+		require.Equal(t, http.StatusOK, w.Code)
+		var second tokenPair
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&second))
+
+		w = refresh(first.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, w.Result().Cookies(), "the cookies of the winning refresh are kept")
+		assert.Equal(t, http.StatusOK, refresh(second.RefreshToken).Code)
+	})
+
+	t.Run("secret the session never issued", func(t *testing.T) {
+		first := login(t)
+		sessionID := accessClaims(t, first.Token).SessionID
+
+		w := refresh(sessionID + ".garbage")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "Invalid refresh token")
+		assert.Equal(t, http.StatusOK, refresh(first.RefreshToken).Code)
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, refresh("no-separator").Code)
+		assert.Equal(t, http.StatusUnauthorized, refresh("unknown-session.secret").Code)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	setupHandlers(t)
+	createTestUser(t)
+
+	t.Run("with the refresh token", func(t *testing.T) {
+		tokens := login(t)
+		w := postJSON(LogoutHandler, "/logout", map[string]string{"refresh_token": tokens.RefreshToken}, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		claims := accessClaims(t, tokens.Token)
+		_, err := sessions.GetSession(context.Background(), claims.SessionID)
+		assert.ErrorIs(t, err, redis.ErrSessionNotFound)
+		revoked, err := sessions.IsTokenRevoked(context.Background(), claims.Id)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		assert.Equal(t, http.StatusUnauthorized, refresh(tokens.RefreshToken).Code)
+	})
+
+	t.Run("with the access token", func(t *testing.T) {
+		tokens := login(t)
+		header := http.Header{"Authorization": {"Bearer " + tokens.Token}}
+		w := postJSON(LogoutHandler, "/logout", nil, header)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// The revoked access token cannot end another session
+		w = postJSON(LogoutHandler, "/logout", nil, header)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("everywhere", func(t *testing.T) {
+		first, second := login(t), login(t)
+		w := postJSON(LogoutAllHandler, "/logout/all", map[string]string{"refresh_token": first.RefreshToken}, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		assert.Equal(t, http.StatusUnauthorized, refresh(first.RefreshToken).Code)
+		assert.Equal(t, http.StatusUnauthorized, refresh(second.RefreshToken).Code)
+	})
+}
@@ -0,0 +1,80 @@
+// Package metrics holds the Prometheus metrics of the gateway, served at /metrics.
+//
+// The metrics are per replica. Counters only go up, ratios such as the cache hit ratio
+// are computed in queries:
+//
+//	sum(rate(gateway_cache_lookups_total{result="hit"}[5m])) / sum(rate(gateway_cache_lookups_total[5m]))
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gateway"
+
+var (
+	// OperationDuration is the time to serve a GraphQL operation posted to /query, by
+	// operation name and type and response status. Only the operations on the allowlist
+	// are labeled with their name, the others with "other".
+	OperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "graphql_operation_duration_seconds",
+		Help:      "Time to serve a GraphQL operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "type", "status"})
+
+	// SubgraphFetchDuration is the time of a subgraph fetch including its retries, by
+	// subgraph and result: ok, unavailable when the gateway answered for the subgraph, or
+	// canceled when the client went away
+	SubgraphFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "subgraph_fetch_duration_seconds",
+		Help:      "Time of a subgraph fetch, including retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subgraph", "result"})
+
+	// CacheLookups counts response cache lookups, by result: hit or miss
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Response cache lookups.",
+	}, []string{"result"})
+
+	// RateLimitRejections counts the requests rejected by a rate limit policy
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by a rate limit policy.",
+	}, []string{"policy"})
+
+	// CircuitBreakerState is the last state a replica saw a breaker in: 0 closed,
+	// 1 half-open, 2 open
+	CircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of a circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, []string{"breaker"})
+
+	// RetryAttempts counts every attempt made by a retry handler, including the first
+	RetryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retry_attempts_total",
+		Help:      "Attempts made by a retry handler, including the first.",
+	}, []string{"name"})
+
+	// RetryOperations counts the operations of a retry handler by result: success or failure
+	RetryOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retry_operations_total",
+		Help:      "Operations of a retry handler, after all their attempts.",
+	}, []string{"name", "result"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
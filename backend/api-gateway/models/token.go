@@ -3,6 +3,14 @@ package models
 import "github.com/golang-jwt/jwt"
 
 type Claims struct {
+	UserID   string `json:"user_id"`
 	Username string `json:"username"`
+	// SessionID is the refresh session the token was issued for
+	SessionID string `json:"sid,omitempty"`
+	// ClientID is the API key of a machine client, which has no user
+	ClientID string `json:"client_id,omitempty"`
+	// Roles of the user, and the scopes they grant, checked against @requiresScopes
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
 	jwt.StandardClaims
 }
@@ -0,0 +1,395 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// API key store keys
+const (
+	// APIKeyPrefix holds a hash per API key, keyed by key ID
+	APIKeyPrefix = "apikey:"
+	// APIKeyIndex is the set of all API key IDs
+	APIKeyIndex = "apikeys"
+	// APIKeyUsagePrefix counts the requests made with an API key, in total and per UTC day
+	APIKeyUsagePrefix = "apikey_usage:"
+
+	// apiKeyTokenPrefix starts every API key, so leaked keys are easy to recognize
+	apiKeyTokenPrefix = "gk_"
+	// apiKeyUsageTTL is how long the usage counters of an unused key are kept
+	apiKeyUsageTTL = 90 * 24 * time.Hour
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyRevoked  = errors.New("API key revoked")
+	ErrAPIKeyExpired  = errors.New("API key expired")
+)
+
+// APIKeyRateLimit is the rate limit applied to the requests of a single API key
+type APIKeyRateLimit struct {
+	Algorithm     string `json:"algorithm"`
+	Limit         int    `json:"limit"`
+	WindowSeconds int    `json:"window_seconds"`
+	Burst         int    `json:"burst,omitempty"`
+}
+
+// APIKey is a key a machine client authenticates with. Only the SHA-256 hash of the
+// secret is stored, the key itself is shown once when created.
+type APIKey struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Owner     string           `json:"owner"`
+	Scopes    []string         `json:"scopes"`
+	RateLimit *APIKeyRateLimit `json:"rate_limit,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	// ExpiresAt is nil for keys that do not expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// RotatedTo is the ID of the key that replaced this one
+	RotatedTo string `json:"rotated_to,omitempty"`
+
+	hash string
+}
+
+// Active reports whether the key can be used at the given time
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// APIKeyUsage counts the requests made with an API key
+type APIKeyUsage struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Total      int64      `json:"total"`
+	Today      int64      `json:"today"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIKeyStore keeps hashed API keys and their usage counters
+type APIKeyStore struct {
+	client *redis.Client
+	logger log.Logger
+}
+
+// NewAPIKeyStore creates an API key store
+func NewAPIKeyStore(client *redis.Client, logger log.Logger) *APIKeyStore {
+	return &APIKeyStore{client: client, logger: logger}
+}
+
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// parseAPIKey splits a key of the form gk_<id>_<secret>
+func parseAPIKey(key string) (string, string, error) {
+	rest, ok := strings.CutPrefix(key, apiKeyTokenPrefix)
+	if !ok {
+		return "", "", ErrInvalidAPIKey
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", ErrInvalidAPIKey
+	}
+	return id, secret, nil
+}
+
+// Create stores a new API key from the given settings and returns the key, which cannot be recovered later
+func (ks *APIKeyStore) Create(ctx context.Context, key *APIKey) (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	// The ID is hex, so the key is split at the first "_" after the prefix
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	key.ID = hex.EncodeToString(id)
+	key.hash = hashAPIKeySecret(secret)
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	fields, err := key.fields()
+	if err != nil {
+		return "", err
+	}
+	_, err = ks.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, APIKeyPrefix+key.ID, fields)
+		pipe.SAdd(ctx, APIKeyIndex, key.ID)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return apiKeyTokenPrefix + key.ID + "_" + secret, nil
+}
+
+func (k *APIKey) fields() (map[string]interface{}, error) {
+	rateLimit := ""
+	if k.RateLimit != nil {
+		data, err := json.Marshal(k.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		rateLimit = string(data)
+	}
+	return map[string]interface{}{
+		"id":         k.ID,
+		"name":       k.Name,
+		"owner":      k.Owner,
+		"scopes":     strings.Join(k.Scopes, ","),
+		"rate_limit": rateLimit,
+		"hash":       k.hash,
+		"created_at": k.CreatedAt.Unix(),
+		"expires_at": unixOrZero(k.ExpiresAt),
+		"revoked_at": unixOrZero(k.RevokedAt),
+		"rotated_to": k.RotatedTo,
+	}, nil
+}
+
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// timeOrNil parses a stored unix time, 0 or missing for none
+func timeOrNil(s string) *time.Time {
+	unix, _ := strconv.ParseInt(s, 10, 64)
+	if unix == 0 {
+		return nil
+	}
+	t := time.Unix(unix, 0)
+	return &t
+}
+
+func apiKeyFromHash(values map[string]string) *APIKey {
+	key := &APIKey{
+		ID:        values["id"],
+		Name:      values["name"],
+		Owner:     values["owner"],
+		ExpiresAt: timeOrNil(values["expires_at"]),
+		RevokedAt: timeOrNil(values["revoked_at"]),
+		RotatedTo: values["rotated_to"],
+		hash:      values["hash"],
+	}
+	if createdAt := timeOrNil(values["created_at"]); createdAt != nil {
+		key.CreatedAt = *createdAt
+	}
+	if values["scopes"] != "" {
+		key.Scopes = strings.Split(values["scopes"], ",")
+	}
+	if values["rate_limit"] != "" {
+		var rateLimit APIKeyRateLimit
+		if json.Unmarshal([]byte(values["rate_limit"]), &rateLimit) == nil {
+			key.RateLimit = &rateLimit
+		}
+	}
+	return key
+}
+
+// Get returns the API key with the given ID
+func (ks *APIKeyStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	values, err := ks.client.HGetAll(ctx, APIKeyPrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+	return apiKeyFromHash(values), nil
+}
+
+// Verify returns the API key a client presented, if it is known, not revoked and not expired
+func (ks *APIKeyStore) Verify(ctx context.Context, rawKey string) (*APIKey, error) {
+	id, secret, err := parseAPIKey(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ks.Get(ctx, id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.hash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if !key.Active(time.Now()) {
+		return nil, ErrAPIKeyExpired
+	}
+	return key, nil
+}
+
+// List returns the API keys of owner, or every key if owner is empty, oldest first
+func (ks *APIKeyStore) List(ctx context.Context, owner string) ([]*APIKey, error) {
+	ids, err := ks.client.SMembers(ctx, APIKeyIndex).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := ks.client.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, APIKeyPrefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(ids))
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		if len(values) == 0 {
+			continue
+		}
+		key := apiKeyFromHash(values)
+		if owner == "" || key.Owner == owner {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Revoke disables an API key immediately. The record is kept for auditing.
+func (ks *APIKeyStore) Revoke(ctx context.Context, id string) (*APIKey, error) {
+	key, err := ks.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := ks.client.HSet(ctx, APIKeyPrefix+id, "revoked_at", now.Unix()).Err(); err != nil {
+			return nil, err
+		}
+		ks.logger.Info("API key revoked", log.String("id", id), log.String("owner", key.Owner))
+	}
+	return key, nil
+}
+
+// Rotate creates a key with the settings of an existing one, which stays usable for
+// gracePeriod so clients can switch over. It returns the new key and its secret.
+func (ks *APIKeyStore) Rotate(ctx context.Context, id string, gracePeriod time.Duration) (*APIKey, string, error) {
+	old, err := ks.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if !old.Active(time.Now()) {
+		return nil, "", ErrAPIKeyRevoked
+	}
+
+	next := &APIKey{
+		Name:      old.Name,
+		Owner:     old.Owner,
+		Scopes:    old.Scopes,
+		RateLimit: old.RateLimit,
+	}
+	if old.ExpiresAt != nil {
+		// The new key lives as long as the old one was meant to
+		next.CreatedAt = time.Now()
+		expiresAt := next.CreatedAt.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		next.ExpiresAt = &expiresAt
+	}
+	secret, err := ks.Create(ctx, next)
+	if err != nil {
+		return nil, "", err
+	}
+
+	expiresAt := time.Now().Add(gracePeriod)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(expiresAt) {
+		expiresAt = *old.ExpiresAt
+	}
+	err = ks.client.HSet(ctx, APIKeyPrefix+id, "expires_at", expiresAt.Unix(), "rotated_to", next.ID).Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ks.logger.Info("API key rotated", log.String("id", id), log.String("new_id", next.ID), log.String("owner", old.Owner))
+	return next, secret, nil
+}
+
+// dailyUsageKey counts the requests made with an API key on the UTC day of t
+func dailyUsageKey(id string, t time.Time) string {
+	return APIKeyUsagePrefix + id + ":" + t.UTC().Format("2006-01-02")
+}
+
+// RecordUsage counts a request made with an API key
+func (ks *APIKeyStore) RecordUsage(ctx context.Context, id string) error {
+	key := APIKeyUsagePrefix + id
+	dailyKey := dailyUsageKey(id, time.Now())
+	_, err := ks.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, "total", 1)
+		pipe.HSet(ctx, key, "last_used_at", time.Now().Unix())
+		pipe.Expire(ctx, key, apiKeyUsageTTL)
+		pipe.Incr(ctx, dailyKey)
+		pipe.Expire(ctx, dailyKey, 48*time.Hour)
+		return nil
+	})
+	return err
+}
+
+// Usage returns the usage counters of every active API key
+func (ks *APIKeyStore) Usage(ctx context.Context) ([]APIKeyUsage, error) {
+	keys, err := ks.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	pipe := ks.client.Pipeline()
+	totals := make([]*redis.StringStringMapCmd, len(keys))
+	daily := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		totals[i] = pipe.HGetAll(ctx, APIKeyUsagePrefix+key.ID)
+		daily[i] = pipe.Get(ctx, dailyUsageKey(key.ID, now))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	usage := make([]APIKeyUsage, 0, len(keys))
+	for i, key := range keys {
+		if !key.Active(now) {
+			continue
+		}
+		values := totals[i].Val()
+		total, _ := strconv.ParseInt(values["total"], 10, 64)
+		today, _ := daily[i].Int64()
+		usage = append(usage, APIKeyUsage{
+			ID:         key.ID,
+			Name:       key.Name,
+			Total:      total,
+			Today:      today,
+			LastUsedAt: timeOrNil(values["last_used_at"]),
+		})
+	}
+	return usage, nil
+}
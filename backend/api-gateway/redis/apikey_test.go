@@ -0,0 +1,215 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyStoreCreate(t *testing.T) {
+	server, client := newTestClient(t)
+	ks := NewAPIKeyStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	key := &APIKey{Name: "billing", Owner: "team-billing", Scopes: []string{"orders:read", "orders:write"}, RateLimit: &APIKeyRateLimit{Algorithm: "gcra", Limit: 10, WindowSeconds: 60}}
+	rawKey, err := ks.Create(ctx, key)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(rawKey, "gk_"+key.ID+"_"), rawKey)
+	secret := strings.TrimPrefix(rawKey, "gk_"+key.ID+"_")
+
+	verified, err := ks.Verify(ctx, rawKey)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, verified.ID)
+	assert.Equal(t, "team-billing", verified.Owner)
+	assert.Equal(t, []string{"orders:read", "orders:write"}, verified.Scopes)
+	assert.Equal(t, key.RateLimit, verified.RateLimit)
+	assert.Nil(t, verified.ExpiresAt)
+
+	// Only the hash of the secret is stored, so the key cannot be shown again
+	assert.Equal(t, hashAPIKeySecret(secret), server.HGet(APIKeyPrefix+key.ID, "hash"))
+	stored, err := client.HGetAll(ctx, APIKeyPrefix+key.ID).Result()
+	require.NoError(t, err)
+	for field, value := range stored {
+		assert.NotContains(t, value, secret, field)
+	}
+	listed, err := ks.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	data, err := json.Marshal(listed)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+	assert.NotContains(t, string(data), hashAPIKeySecret(secret))
+
+	// Every key gets its own ID and secret
+	other := &APIKey{Name: "billing", Owner: "team-billing"}
+	otherRawKey, err := ks.Create(ctx, other)
+	require.NoError(t, err)
+	assert.NotEqual(t, key.ID, other.ID)
+	assert.NotEqual(t, rawKey, otherRawKey)
+}
+
+func TestAPIKeyStoreVerify(t *testing.T) {
+	server, client := newTestClient(t)
+	ks := NewAPIKeyStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	key := &APIKey{Name: "billing", Owner: "team-billing"}
+	rawKey, err := ks.Create(ctx, key)
+	require.NoError(t, err)
+
+	revoked := &APIKey{Name: "revoked", Owner: "team-billing"}
+	revokedRawKey, err := ks.Create(ctx, revoked)
+	require.NoError(t, err)
+	_, err = ks.Revoke(ctx, revoked.ID)
+	require.NoError(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	expired := &APIKey{Name: "expired", Owner: "team-billing", ExpiresAt: &expiresAt}
+	expiredRawKey, err := ks.Create(ctx, expired)
+	require.NoError(t, err)
+	_, err = ks.Verify(ctx, expiredRawKey)
+	require.NoError(t, err)
+	server.HSet(APIKeyPrefix+expired.ID, "expires_at", fmt.Sprint(time.Now().Add(-time.Second).Unix()))
+
+	tests := []struct {
+		name   string
+		rawKey string
+		err    error
+	}{
+		{name: "revoked", rawKey: revokedRawKey, err: ErrAPIKeyRevoked},
+		{name: "expired", rawKey: expiredRawKey, err: ErrAPIKeyExpired},
+		{name: "wrong secret", rawKey: "gk_" + key.ID + "_" + strings.Repeat("A", 43), err: ErrInvalidAPIKey},
+		{name: "secret of another key", rawKey: "gk_" + key.ID + "_" + strings.TrimPrefix(revokedRawKey, "gk_"+revoked.ID+"_"), err: ErrInvalidAPIKey},
+		{name: "unknown ID", rawKey: "gk_0000000000000000_" + strings.TrimPrefix(rawKey, "gk_"+key.ID+"_"), err: ErrInvalidAPIKey},
+		{name: "without prefix", rawKey: strings.TrimPrefix(rawKey, "gk_"), err: ErrInvalidAPIKey},
+		{name: "without secret", rawKey: "gk_" + key.ID + "_", err: ErrInvalidAPIKey},
+		{name: "without ID", rawKey: "gk_", err: ErrInvalidAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verified, err := ks.Verify(ctx, tt.rawKey)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Nil(t, verified)
+		})
+	}
+
+	// Revoking again keeps the time the key was revoked
+	revokedAt := time.Now().Add(-time.Hour).Unix()
+	server.HSet(APIKeyPrefix+revoked.ID, "revoked_at", fmt.Sprint(revokedAt))
+	again, err := ks.Revoke(ctx, revoked.ID)
+	require.NoError(t, err)
+	assert.Equal(t, revokedAt, again.RevokedAt.Unix())
+	assert.Equal(t, fmt.Sprint(revokedAt), server.HGet(APIKeyPrefix+revoked.ID, "revoked_at"))
+
+	_, err = ks.Revoke(ctx, "0000000000000000")
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyStoreRotate(t *testing.T) {
+	server, client := newTestClient(t)
+	ks := NewAPIKeyStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	old := &APIKey{Name: "billing", Owner: "team-billing", Scopes: []string{"orders:read"}, RateLimit: &APIKeyRateLimit{Algorithm: "gcra", Limit: 10, WindowSeconds: 60}}
+	oldRawKey, err := ks.Create(ctx, old)
+	require.NoError(t, err)
+
+	next, nextRawKey, err := ks.Rotate(ctx, old.ID, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, old.ID, next.ID)
+	assert.Equal(t, old.Owner, next.Owner)
+	assert.Equal(t, old.Scopes, next.Scopes)
+	assert.Equal(t, old.RateLimit, next.RateLimit)
+	assert.Nil(t, next.ExpiresAt)
+
+	verified, err := ks.Verify(ctx, nextRawKey)
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, verified.ID)
+
+	// The old key works for the grace period
+	verified, err = ks.Verify(ctx, oldRawKey)
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, verified.RotatedTo)
+	require.NotNil(t, verified.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *verified.ExpiresAt, 2*time.Second)
+
+	// and not after
+	server.HSet(APIKeyPrefix+old.ID, "expires_at", fmt.Sprint(time.Now().Add(-time.Second).Unix()))
+	_, err = ks.Verify(ctx, oldRawKey)
+	assert.ErrorIs(t, err, ErrAPIKeyExpired)
+	_, err = ks.Verify(ctx, nextRawKey)
+	assert.NoError(t, err)
+
+	// A key no longer in use cannot be rotated
+	_, _, err = ks.Rotate(ctx, old.ID, time.Hour)
+	assert.ErrorIs(t, err, ErrAPIKeyRevoked)
+	_, err = ks.Revoke(ctx, next.ID)
+	require.NoError(t, err)
+	_, _, err = ks.Rotate(ctx, next.ID, time.Hour)
+	assert.ErrorIs(t, err, ErrAPIKeyRevoked)
+	_, _, err = ks.Rotate(ctx, "0000000000000000", time.Hour)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
+
+func TestAPIKeyStoreRotateExpiring(t *testing.T) {
+	_, client := newTestClient(t)
+	ks := NewAPIKeyStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	createdAt := time.Now().Add(-20 * 24 * time.Hour)
+	expiresAt := createdAt.Add(30 * 24 * time.Hour)
+	old := &APIKey{Name: "billing", Owner: "team-billing", CreatedAt: createdAt, ExpiresAt: &expiresAt}
+	oldRawKey, err := ks.Create(ctx, old)
+	require.NoError(t, err)
+
+	// The new key lives as long as the old one
+	next, _, err := ks.Rotate(ctx, old.ID, 30*24*time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, next.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), *next.ExpiresAt, 2*time.Second)
+
+	// A grace period longer than the old key has left does not extend it
+	verified, err := ks.Verify(ctx, oldRawKey)
+	require.NoError(t, err)
+	assert.Equal(t, expiresAt.Unix(), verified.ExpiresAt.Unix())
+}
+
+func TestAPIKeyStoreUsage(t *testing.T) {
+	_, client := newTestClient(t)
+	ks := NewAPIKeyStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	used := &APIKey{Name: "used", Owner: "team-billing", CreatedAt: time.Now().Add(-time.Minute)}
+	_, err := ks.Create(ctx, used)
+	require.NoError(t, err)
+	unused := &APIKey{Name: "unused", Owner: "team-billing"}
+	_, err = ks.Create(ctx, unused)
+	require.NoError(t, err)
+	revoked := &APIKey{Name: "revoked", Owner: "team-billing"}
+	_, err = ks.Create(ctx, revoked)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, ks.RecordUsage(ctx, used.ID))
+	}
+	require.NoError(t, ks.RecordUsage(ctx, revoked.ID))
+	_, err = ks.Revoke(ctx, revoked.ID)
+	require.NoError(t, err)
+
+	usage, err := ks.Usage(ctx)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	assert.Equal(t, used.ID, usage[0].ID)
+	assert.Equal(t, int64(3), usage[0].Total)
+	assert.Equal(t, int64(3), usage[0].Today)
+	assert.NotNil(t, usage[0].LastUsedAt)
+	assert.Equal(t, APIKeyUsage{ID: unused.ID, Name: "unused"}, usage[1])
+}
@@ -29,6 +29,9 @@ func NewCacheService(client *redis.Client, logger log.Logger) *CacheService {
 // GraphQL Query Cache Keys
 const (
 	QueryCachePrefix     = "gql:query:"
+	EntityTagPrefix      = "gql:tag:"
+	APQPrefix            = "gql:pq:apq:"
+	RegisteredQueryKey   = "gql:pq:registered"
 	SchemaCache          = "gql:schema:"
 	SubgraphStatusPrefix = "gql:subgraph:status:"
 	RateLimitPrefix      = "ratelimit:"
@@ -52,12 +55,16 @@ func DefaultCacheOptions() CacheOptions {
 	}
 }
 
-// GenerateQueryCacheKey creates a consistent cache key from query and variables
-func (cs *CacheService) GenerateQueryCacheKey(query string, variables map[string]interface{}) string {
-	// Include both query and variables in the key
-	data := fmt.Sprintf("%s:%v", query, variables)
-	hash := sha256.Sum256([]byte(data))
-	return QueryCachePrefix + hex.EncodeToString(hash[:])
+// GenerateQueryCacheKey creates a consistent cache key from a normalized query, its canonical
+// variables and the cache scope ("public" or "private:<subject>")
+func (cs *CacheService) GenerateQueryCacheKey(query string, variables []byte, scope string) string {
+	hash := sha256.New()
+	hash.Write([]byte(scope))
+	hash.Write([]byte{0})
+	hash.Write([]byte(query))
+	hash.Write([]byte{0})
+	hash.Write(variables)
+	return QueryCachePrefix + hex.EncodeToString(hash.Sum(nil))
 }
 
 // GetQueryCache retrieves a cached GraphQL query result
@@ -88,6 +95,83 @@ func (cs *CacheService) SetQueryCache(ctx context.Context, cacheKey string, data
 	return nil
 }
 
+// setTaggedQueryScript stores a query result and adds its key to the set of every entity tag.
+// A tag set lives as long as the longest lived entry it indexes.
+//
+// KEYS[1] = cache key, KEYS[2..] = tag set keys
+// ARGV[1] = data, ARGV[2] = ttl in milliseconds
+var setTaggedQueryScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if redis.call('PTTL', KEYS[i]) < ttl then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return 1
+`)
+
+// invalidateTagsScript deletes every cached entry indexed by the given tag sets, and the sets themselves.
+//
+// KEYS = tag set keys
+var invalidateTagsScript = redis.NewScript(`
+local evicted = 0
+for i = 1, #KEYS do
+	local members = redis.call('SMEMBERS', KEYS[i])
+	for _, key in ipairs(members) do
+		evicted = evicted + redis.call('DEL', key)
+	end
+	redis.call('DEL', KEYS[i])
+end
+return evicted
+`)
+
+// SetQueryCacheWithTags stores a GraphQL query result in cache and indexes it by entity tags
+// (e.g. "Order:<id>"), so it can be evicted with InvalidateEntityTags
+func (cs *CacheService) SetQueryCacheWithTags(ctx context.Context, cacheKey string, data []byte, ttl time.Duration, tags []string) error {
+	if len(tags) == 0 {
+		return cs.SetQueryCache(ctx, cacheKey, data, ttl)
+	}
+
+	keys := make([]string, 0, len(tags)+1)
+	keys = append(keys, cacheKey)
+	for _, tag := range tags {
+		keys = append(keys, EntityTagPrefix+tag)
+	}
+
+	err := setTaggedQueryScript.Run(ctx, cs.client, keys, data, ttl.Milliseconds()).Err()
+	if err != nil {
+		cs.logger.Error("Failed to set tagged cache", log.Error(err), log.String("key", cacheKey))
+		return err
+	}
+
+	cs.logger.Debug("Cache set", log.String("key", cacheKey), log.Any("ttl", ttl), log.Int("tags", len(tags)))
+	return nil
+}
+
+// InvalidateEntityTags evicts all cached query results indexed by the given entity tags
+// and returns the number of evicted entries
+func (cs *CacheService) InvalidateEntityTags(ctx context.Context, tags ...string) (int, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, EntityTagPrefix+tag)
+	}
+
+	evicted, err := invalidateTagsScript.Run(ctx, cs.client, keys).Int()
+	if err != nil {
+		cs.logger.Error("Failed to invalidate entity tags", log.Error(err), log.Strings("tags", tags))
+		return 0, err
+	}
+
+	cs.logger.Debug("Cache invalidated", log.Strings("tags", tags), log.Int("evicted", evicted))
+	return evicted, nil
+}
+
 // InvalidateQueryPattern invalidates all queries matching a pattern
 func (cs *CacheService) InvalidateQueryPattern(ctx context.Context, pattern string) error {
 	// Use SCAN to find all matching keys (better than KEYS for production)
@@ -128,6 +212,60 @@ func (cs *CacheService) GetCachedSchema(ctx context.Context, serviceName string)
 	return result, true, nil
 }
 
+// SetAutomaticPersistedQuery stores the query text of an automatic persisted query by its SHA-256 hash
+func (cs *CacheService) SetAutomaticPersistedQuery(ctx context.Context, hash string, query string, ttl time.Duration) error {
+	return cs.client.Set(ctx, APQPrefix+hash, query, ttl).Err()
+}
+
+// GetAutomaticPersistedQuery retrieves the query text of an automatic persisted query
+func (cs *CacheService) GetAutomaticPersistedQuery(ctx context.Context, hash string) (string, bool, error) {
+	result, err := cs.client.Get(ctx, APQPrefix+hash).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return result, true, nil
+}
+
+// RegisterPersistedQueries adds operations to the allowlist, keyed by the SHA-256 hash of their query text.
+// Registered operations never expire.
+func (cs *CacheService) RegisterPersistedQueries(ctx context.Context, queries map[string]string) error {
+	if len(queries) == 0 {
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(queries))
+	for hash, query := range queries {
+		values[hash] = query
+	}
+	return cs.client.HSet(ctx, RegisteredQueryKey, values).Err()
+}
+
+// GetRegisteredQuery retrieves the query text of an operation on the allowlist
+func (cs *CacheService) GetRegisteredQuery(ctx context.Context, hash string) (string, bool, error) {
+	result, err := cs.client.HGet(ctx, RegisteredQueryKey, hash).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return result, true, nil
+}
+
+// ListRegisteredQueries returns all operations on the allowlist by hash
+func (cs *CacheService) ListRegisteredQueries(ctx context.Context) (map[string]string, error) {
+	return cs.client.HGetAll(ctx, RegisteredQueryKey).Result()
+}
+
+// UnregisterPersistedQuery removes an operation from the allowlist
+func (cs *CacheService) UnregisterPersistedQuery(ctx context.Context, hash string) (bool, error) {
+	removed, err := cs.client.HDel(ctx, RegisteredQueryKey, hash).Result()
+	return removed > 0, err
+}
+
 // SetSubgraphStatus stores the health status of a subgraph
 func (cs *CacheService) SetSubgraphStatus(ctx context.Context, serviceName string, isHealthy bool) error {
 	key := SubgraphStatusPrefix + serviceName
@@ -170,157 +308,3 @@ func (cs *CacheService) GetSubgraphStatus(ctx context.Context, serviceName strin
 
 	return healthy, nil
 }
-
-// RateLimiter implements token bucket rate limiting
-type RateLimiter struct {
-	client *redis.Client
-	logger log.Logger
-}
-
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(client *redis.Client, logger log.Logger) *RateLimiter {
-	return &RateLimiter{
-		client: client,
-		logger: logger,
-	}
-}
-
-// CheckRateLimit checks if a request should be allowed based on rate limiting
-// Returns: allowed (bool), remaining (int), resetTime (time.Time), error
-func (rl *RateLimiter) CheckRateLimit(ctx context.Context, identifier string, maxRequests int, window time.Duration) (bool, int, time.Time, error) {
-	key := RateLimitPrefix + identifier
-	now := time.Now()
-
-	// Use Redis pipeline for atomic operations
-	pipe := rl.client.Pipeline()
-
-	// Remove old entries outside the window
-	windowStart := now.Add(-window).Unix()
-	pipe.ZRemRangeByScore(ctx, key, "0", fmt.Sprintf("%d", windowStart))
-
-	// Count current requests
-	countCmd := pipe.ZCard(ctx, key)
-
-	// Add current request
-	pipe.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(now.Unix()),
-		Member: fmt.Sprintf("%d", now.UnixNano()),
-	})
-
-	// Set expiry on key
-	pipe.Expire(ctx, key, window)
-
-	_, err := pipe.Exec(ctx)
-	if err != nil {
-		return false, 0, time.Time{}, err
-	}
-
-	count := int(countCmd.Val())
-	remaining := maxRequests - count - 1
-	resetTime := now.Add(window)
-
-	allowed := count < maxRequests
-
-	if !allowed {
-		rl.logger.Warn("Rate limit exceeded",
-			log.String("identifier", identifier),
-			log.Int("count", count),
-			log.Int("max", maxRequests),
-		)
-	}
-
-	return allowed, remaining, resetTime, nil
-}
-
-// SessionManager handles user sessions
-type SessionManager struct {
-	client *redis.Client
-	logger log.Logger
-}
-
-// NewSessionManager creates a new session manager
-func NewSessionManager(client *redis.Client, logger log.Logger) *SessionManager {
-	return &SessionManager{
-		client: client,
-		logger: logger,
-	}
-}
-
-// SessionData represents a user session
-type SessionData struct {
-	UserID    string                 `json:"user_id"`
-	Username  string                 `json:"username"`
-	CreatedAt int64                  `json:"created_at"`
-	ExpiresAt int64                  `json:"expires_at"`
-	Metadata  map[string]interface{} `json:"metadata,omitempty"`
-}
-
-// CreateSession stores a new session
-func (sm *SessionManager) CreateSession(ctx context.Context, sessionID string, data SessionData, ttl time.Duration) error {
-	key := SessionPrefix + sessionID
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	return sm.client.Set(ctx, key, jsonData, ttl).Err()
-}
-
-// GetSession retrieves a session
-func (sm *SessionManager) GetSession(ctx context.Context, sessionID string) (*SessionData, error) {
-	key := SessionPrefix + sessionID
-
-	result, err := sm.client.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return nil, fmt.Errorf("session not found")
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	var data SessionData
-	if err := json.Unmarshal([]byte(result), &data); err != nil {
-		return nil, err
-	}
-
-	return &data, nil
-}
-
-// DeleteSession removes a session (logout)
-func (sm *SessionManager) DeleteSession(ctx context.Context, sessionID string) error {
-	key := SessionPrefix + sessionID
-	return sm.client.Del(ctx, key).Err()
-}
-
-// ExtendSession extends the TTL of a session
-func (sm *SessionManager) ExtendSession(ctx context.Context, sessionID string, ttl time.Duration) error {
-	key := SessionPrefix + sessionID
-	return sm.client.Expire(ctx, key, ttl).Err()
-}
-
-// GetAllUserSessions retrieves all sessions for a user
-func (sm *SessionManager) GetAllUserSessions(ctx context.Context, userID string) ([]string, error) {
-	pattern := SessionPrefix + "*"
-	iter := sm.client.Scan(ctx, 0, pattern, 0).Iterator()
-	var sessionIDs []string
-
-	for iter.Next(ctx) {
-		key := iter.Val()
-		result, err := sm.client.Get(ctx, key).Result()
-		if err != nil {
-			continue
-		}
-
-		var data SessionData
-		if err := json.Unmarshal([]byte(result), &data); err != nil {
-			continue
-		}
-
-		if data.UserID == userID {
-			sessionIDs = append(sessionIDs, key[len(SessionPrefix):])
-		}
-	}
-
-	return sessionIDs, iter.Err()
-}
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"api-gateway/metrics"
 	"context"
 	"errors"
 	"fmt"
@@ -197,6 +198,7 @@ func (cb *CircuitBreaker) GetState(ctx context.Context) (CircuitState, error) {
 	val, err := cb.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		// No state set, default to closed
+		cb.reportState(StateClosed)
 		return StateClosed, nil
 	}
 	if err != nil {
@@ -204,6 +206,7 @@ func (cb *CircuitBreaker) GetState(ctx context.Context) (CircuitState, error) {
 	}
 
 	state := CircuitState(val)
+	cb.reportState(state)
 
 	// Update cache
 	cb.mu.Lock()
@@ -228,9 +231,22 @@ func (cb *CircuitBreaker) SetState(ctx context.Context, state CircuitState) erro
 	cb.lastStateCheck = time.Now()
 	cb.mu.Unlock()
 
+	cb.reportState(state)
 	return nil
 }
 
+// reportState exports the state the breaker was last seen in by this replica
+func (cb *CircuitBreaker) reportState(state CircuitState) {
+	value := 0.0
+	switch state {
+	case StateHalfOpen:
+		value = 1
+	case StateOpen:
+		value = 2
+	}
+	metrics.CircuitBreakerState.WithLabelValues(cb.name).Set(value)
+}
+
 // GetFailureCount returns the current failure count
 func (cb *CircuitBreaker) GetFailureCount(ctx context.Context) (uint32, error) {
 	key := cb.failureCountKey()
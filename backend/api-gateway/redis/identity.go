@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// OIDCStatePrefix holds the pending sign in of a user at an identity provider, by state
+const OIDCStatePrefix = "oidc:state:"
+
+var ErrLoginStateNotFound = errors.New("login state not found or expired")
+
+// LoginState is kept between sending a user to an identity provider and its callback
+type LoginState struct {
+	Provider     string `json:"provider"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+func identityKey(provider string, subject string) string {
+	return UserIdentityPrefix + provider + ":" + subject
+}
+
+// SaveLoginState stores the state of a sign in until the provider calls back
+func (us *UserStore) SaveLoginState(ctx context.Context, state string, data LoginState, ttl time.Duration) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return us.client.Set(ctx, OIDCStatePrefix+state, jsonData, ttl).Err()
+}
+
+// ConsumeLoginState returns and deletes the state of a sign in, so a callback cannot be replayed
+func (us *UserStore) ConsumeLoginState(ctx context.Context, state string) (*LoginState, error) {
+	result, err := us.client.GetDel(ctx, OIDCStatePrefix+state).Result()
+	if err == redis.Nil {
+		return nil, ErrLoginStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data LoginState
+	if err := json.Unmarshal([]byte(result), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// GetUserByIdentity returns the user linked to the subject of an identity provider
+func (us *UserStore) GetUserByIdentity(ctx context.Context, provider string, subject string) (*User, error) {
+	userID, err := us.client.Get(ctx, identityKey(provider, subject)).Result()
+	if err == redis.Nil {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return us.GetUser(ctx, userID)
+}
+
+// GetUserByEmail returns the user registered with the given email address
+func (us *UserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	userID, err := us.client.Get(ctx, EmailIndexPrefix+normalize(email)).Result()
+	if err == redis.Nil {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return us.GetUser(ctx, userID)
+}
+
+// LinkIdentity links the subject of an identity provider to an existing user, failing
+// with ErrIdentityLinked if it is linked to another user
+func (us *UserStore) LinkIdentity(ctx context.Context, provider string, subject string, userID string) error {
+	key := identityKey(provider, subject)
+	ok, err := us.client.SetNX(ctx, key, userID, 0).Result()
+	if err != nil || ok {
+		return err
+	}
+
+	linked, err := us.client.Get(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if linked != userID {
+		return ErrIdentityLinked
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// Rate limit algorithm names used in policies
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmGCRA          = "gcra"
+	AlgorithmSlidingWindow = "sliding_window"
+)
+
+// instanceID distinguishes the sliding window entries written by this gateway from other replicas
+var instanceID = func() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// RateLimit describes how many requests are allowed per window.
+// Burst is the number of requests that may be made at once, it defaults to Limit.
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Limit
+}
+
+// RateLimitResult is the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is the time until the full quota is available again
+	ResetAfter time.Duration
+	// RetryAfter is the time until a rejected request may succeed
+	RetryAfter time.Duration
+	// reservation identifies the quota consumed by an allowed request, for algorithms that
+	// need it to refund the request
+	reservation string
+}
+
+// RateLimitAlgorithm checks and consumes quota for a key.
+// Implementations run as a single Lua script, so concurrent gateways never over-admit,
+// and use the Redis clock, so gateway clock skew does not matter.
+type RateLimitAlgorithm interface {
+	// Allow consumes cost units of the quota of key if enough are left
+	Allow(ctx context.Context, key string, limit RateLimit, cost int) (RateLimitResult, error)
+	// Refund gives back the quota consumed by an allowed request
+	Refund(ctx context.Context, key string, limit RateLimit, cost int, result RateLimitResult) error
+}
+
+// redisNowMillis is the Lua snippet reading the Redis server time in milliseconds
+const redisNowMillis = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+`
+
+// runRateLimitScript runs a rate limit script returning {allowed, remaining, retry_after_ms, reset_after_ms}
+func runRateLimitScript(ctx context.Context, client *redis.Client, script *redis.Script, key string, limit RateLimit, args ...interface{}) (RateLimitResult, error) {
+	values, err := script.Run(ctx, client, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      limit.burst(),
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		ResetAfter: time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// tokenBucketScript refills the bucket at limit/window tokens per millisecond up to burst
+//
+// KEYS[1] = bucket key
+// ARGV[1] = burst, ARGV[2] = limit, ARGV[3] = window in milliseconds, ARGV[4] = cost
+var tokenBucketScript = redis.NewScript(redisNowMillis + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) / tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or capacity
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry_after = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+else
+	retry_after = math.ceil((cost - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate))
+
+return {allowed, math.floor(tokens), retry_after, math.ceil((capacity - tokens) / rate)}
+`)
+
+// TokenBucket allows bursts of up to Burst requests, refilled continuously at Limit per Window
+type TokenBucket struct {
+	client *redis.Client
+}
+
+// NewTokenBucket creates a token bucket algorithm
+func NewTokenBucket(client *redis.Client) *TokenBucket {
+	return &TokenBucket{client: client}
+}
+
+// Allow implements RateLimitAlgorithm
+func (tb *TokenBucket) Allow(ctx context.Context, key string, limit RateLimit, cost int) (RateLimitResult, error) {
+	return runRateLimitScript(ctx, tb.client, tokenBucketScript, key, limit,
+		limit.burst(), limit.Limit, limit.Window.Milliseconds(), cost)
+}
+
+// tokenBucketRefundScript puts tokens back in the bucket, up to burst
+//
+// KEYS[1] = bucket key
+// ARGV[1] = burst, ARGV[2] = limit, ARGV[3] = window in milliseconds, ARGV[4] = cost
+var tokenBucketRefundScript = redis.NewScript(redisNowMillis + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) / tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+if not bucket[1] then
+	return 0
+end
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate + cost)
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate))
+return 1
+`)
+
+// Refund implements RateLimitAlgorithm
+func (tb *TokenBucket) Refund(ctx context.Context, key string, limit RateLimit, cost int, result RateLimitResult) error {
+	return tokenBucketRefundScript.Run(ctx, tb.client, []string{key},
+		limit.burst(), limit.Limit, limit.Window.Milliseconds(), cost).Err()
+}
+
+// gcraScript implements the generic cell rate algorithm. Only the theoretical arrival
+// time (TAT) of the next request is stored.
+//
+// KEYS[1] = TAT key
+// ARGV[1] = burst, ARGV[2] = emission interval in milliseconds, ARGV[3] = cost
+var gcraScript = redis.NewScript(redisNowMillis + `
+local burst = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local tolerance = emission * burst
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+tat = math.max(tat, now)
+
+local new_tat = tat + emission * cost
+local allow_at = new_tat - tolerance
+
+if allow_at > now then
+	local remaining = math.floor((tolerance - (tat - now)) / emission)
+	return {0, math.max(0, remaining), math.ceil(allow_at - now), math.ceil(tat - now)}
+end
+
+redis.call('SET', KEYS[1], tostring(new_tat), 'PX', math.max(1, math.ceil(new_tat - now)))
+
+return {1, math.floor((tolerance - (new_tat - now)) / emission), 0, math.ceil(new_tat - now)}
+`)
+
+// GCRA spaces requests evenly at Limit per Window while tolerating bursts of Burst requests
+type GCRA struct {
+	client *redis.Client
+}
+
+// NewGCRA creates a GCRA algorithm
+func NewGCRA(client *redis.Client) *GCRA {
+	return &GCRA{client: client}
+}
+
+// Allow implements RateLimitAlgorithm
+func (g *GCRA) Allow(ctx context.Context, key string, limit RateLimit, cost int) (RateLimitResult, error) {
+	return runRateLimitScript(ctx, g.client, gcraScript, key, limit,
+		limit.burst(), gcraEmission(limit), cost)
+}
+
+// gcraRefundScript moves the TAT back by the emission of the refunded cost
+//
+// KEYS[1] = TAT key
+// ARGV[1] = emission interval in milliseconds, ARGV[2] = cost
+var gcraRefundScript = redis.NewScript(redisNowMillis + `
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat then
+	return 0
+end
+
+local new_tat = tat - tonumber(ARGV[1]) * tonumber(ARGV[2])
+if new_tat <= now then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('SET', KEYS[1], tostring(new_tat), 'PX', math.max(1, math.ceil(new_tat - now)))
+end
+return 1
+`)
+
+// Refund implements RateLimitAlgorithm
+func (g *GCRA) Refund(ctx context.Context, key string, limit RateLimit, cost int, result RateLimitResult) error {
+	return gcraRefundScript.Run(ctx, g.client, []string{key}, gcraEmission(limit), cost).Err()
+}
+
+// gcraEmission returns the interval between two requests in milliseconds
+func gcraEmission(limit RateLimit) string {
+	emission := float64(limit.Window.Milliseconds()) / float64(limit.Limit)
+	return strconv.FormatFloat(emission, 'f', -1, 64)
+}
+
+// slidingWindowScript keeps a log of request timestamps and only records admitted requests
+//
+// KEYS[1] = log key
+// ARGV[1] = limit, ARGV[2] = window in milliseconds, ARGV[3] = cost, ARGV[4] = unique member prefix
+var slidingWindowScript = redis.NewScript(redisNowMillis + `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local function expires_in(index)
+	local entry = redis.call('ZRANGE', KEYS[1], index, index, 'WITHSCORES')
+	if #entry == 0 then
+		return 0
+	end
+	return math.max(0, tonumber(entry[2]) + window - now)
+end
+
+if count + cost > limit then
+	-- The request fits once enough of the oldest entries have left the window
+	local retry_after = window
+	if cost <= limit then
+		retry_after = expires_in(count + cost - limit - 1)
+	end
+	return {0, math.max(0, limit - count), retry_after, expires_in(-1)}
+end
+
+for i = 1, cost do
+	redis.call('ZADD', KEYS[1], now, ARGV[4] .. ':' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+return {1, limit - count - cost, 0, window}
+`)
+
+// SlidingWindowLog admits at most Limit requests in any Window, measured precisely
+type SlidingWindowLog struct {
+	client  *redis.Client
+	counter atomic.Uint64
+}
+
+// NewSlidingWindowLog creates a sliding window log algorithm
+func NewSlidingWindowLog(client *redis.Client) *SlidingWindowLog {
+	return &SlidingWindowLog{client: client}
+}
+
+// Allow implements RateLimitAlgorithm
+func (sw *SlidingWindowLog) Allow(ctx context.Context, key string, limit RateLimit, cost int) (RateLimitResult, error) {
+	// Members must be unique across gateways and within the same millisecond
+	member := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(sw.counter.Add(1), 36) + "-" + instanceID
+
+	result, err := runRateLimitScript(ctx, sw.client, slidingWindowScript, key, limit,
+		limit.Limit, limit.Window.Milliseconds(), cost, member)
+	result.Limit = limit.Limit
+	result.reservation = member
+	return result, err
+}
+
+// slidingWindowRefundScript removes the entries recorded for a request
+//
+// KEYS[1] = log key
+// ARGV[1] = cost, ARGV[2] = member prefix of the request
+var slidingWindowRefundScript = redis.NewScript(`
+for i = 1, tonumber(ARGV[1]) do
+	redis.call('ZREM', KEYS[1], ARGV[2] .. ':' .. i)
+end
+return 1
+`)
+
+// Refund implements RateLimitAlgorithm
+func (sw *SlidingWindowLog) Refund(ctx context.Context, key string, limit RateLimit, cost int, result RateLimitResult) error {
+	if result.reservation == "" {
+		return nil
+	}
+	return slidingWindowRefundScript.Run(ctx, sw.client, []string{key}, cost, result.reservation).Err()
+}
+
+// RateLimiter checks rate limits with the algorithm selected by name
+type RateLimiter struct {
+	logger     log.Logger
+	algorithms map[string]RateLimitAlgorithm
+}
+
+// NewRateLimiter creates a rate limiter with the token bucket, GCRA and sliding window algorithms
+func NewRateLimiter(client *redis.Client, logger log.Logger) *RateLimiter {
+	return &RateLimiter{
+		logger: logger,
+		algorithms: map[string]RateLimitAlgorithm{
+			AlgorithmTokenBucket:   NewTokenBucket(client),
+			AlgorithmGCRA:          NewGCRA(client),
+			AlgorithmSlidingWindow: NewSlidingWindowLog(client),
+		},
+	}
+}
+
+// Register adds or replaces an algorithm
+func (rl *RateLimiter) Register(name string, algorithm RateLimitAlgorithm) {
+	rl.algorithms[name] = algorithm
+}
+
+// HasAlgorithm reports whether an algorithm is registered under name
+func (rl *RateLimiter) HasAlgorithm(name string) bool {
+	_, ok := rl.algorithms[name]
+	return ok
+}
+
+// Allow consumes cost units of the quota of identifier with the given algorithm
+func (rl *RateLimiter) Allow(ctx context.Context, algorithm string, identifier string, limit RateLimit, cost int) (RateLimitResult, error) {
+	alg, ok := rl.algorithms[algorithm]
+	if !ok {
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	if limit.Limit <= 0 || limit.Window <= 0 {
+		return RateLimitResult{}, fmt.Errorf("invalid rate limit %d per %s", limit.Limit, limit.Window)
+	}
+
+	result, err := alg.Allow(ctx, RateLimitPrefix+algorithm+":"+identifier, limit, cost)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	if !result.Allowed {
+		rl.logger.Warn("Rate limit exceeded",
+			log.String("identifier", identifier),
+			log.String("algorithm", algorithm),
+			log.Int("limit", result.Limit),
+		)
+	}
+
+	return result, nil
+}
+
+// Refund gives back the quota consumed by an allowed request, when a later check rejected it
+func (rl *RateLimiter) Refund(ctx context.Context, algorithm string, identifier string, limit RateLimit, cost int, result RateLimitResult) error {
+	alg, ok := rl.algorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	if !result.Allowed {
+		return nil
+	}
+	return alg.Refund(ctx, RateLimitPrefix+algorithm+":"+identifier, limit, cost, result)
+}
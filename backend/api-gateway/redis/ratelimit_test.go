@@ -0,0 +1,222 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock drives the Redis clock the rate limit scripts read, and the expiry of keys
+type testClock struct {
+	server *miniredis.Miniredis
+	now    time.Time
+}
+
+func newTestClock(server *miniredis.Miniredis) *testClock {
+	c := &testClock{server: server, now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	server.SetTime(c.now)
+	return c
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.server.SetTime(c.now)
+	c.server.FastForward(d)
+}
+
+// allowN runs Allow n times and returns the results
+func allowN(t *testing.T, algorithm RateLimitAlgorithm, key string, limit RateLimit, n int) []RateLimitResult {
+	results := make([]RateLimitResult, n)
+	for i := range results {
+		result, err := algorithm.Allow(context.Background(), key, limit, 1)
+		require.NoError(t, err)
+		results[i] = result
+	}
+	return results
+}
+
+func TestTokenBucket(t *testing.T) {
+	server, client := newTestClient(t)
+	clock := newTestClock(server)
+	tb := NewTokenBucket(client)
+	limit := RateLimit{Limit: 4, Window: time.Second, Burst: 2}
+	ctx := context.Background()
+
+	results := allowN(t, tb, "bucket", limit, 3)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, 1, results[0].Remaining)
+	assert.Equal(t, 2, results[0].Limit)
+	assert.True(t, results[1].Allowed)
+	assert.Equal(t, 0, results[1].Remaining)
+	assert.Equal(t, 500*time.Millisecond, results[1].ResetAfter)
+	assert.False(t, results[2].Allowed)
+	assert.Equal(t, 250*time.Millisecond, results[2].RetryAfter)
+
+	// Tokens refill at Limit per Window
+	clock.advance(250 * time.Millisecond)
+	results = allowN(t, tb, "bucket", limit, 2)
+	assert.True(t, results[0].Allowed)
+	assert.False(t, results[1].Allowed)
+
+	// A cost above the tokens left is rejected without consuming them
+	clock.advance(500 * time.Millisecond)
+	result, err := tb.Allow(ctx, "bucket", limit, 3)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, 250*time.Millisecond, result.RetryAfter)
+
+	result, err = tb.Allow(ctx, "bucket", limit, 2)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// Refunds put the tokens back, but not above the burst
+	require.NoError(t, tb.Refund(ctx, "bucket", limit, 2, result))
+	require.NoError(t, tb.Refund(ctx, "bucket", limit, 2, result))
+	results = allowN(t, tb, "bucket", limit, 3)
+	assert.True(t, results[0].Allowed)
+	assert.True(t, results[1].Allowed)
+	assert.False(t, results[2].Allowed)
+
+	// Refunding an expired bucket does not create one
+	clock.advance(time.Second)
+	require.NoError(t, tb.Refund(ctx, "bucket", limit, 2, result))
+	assert.False(t, server.Exists("bucket"))
+}
+
+func TestGCRA(t *testing.T) {
+	server, client := newTestClient(t)
+	clock := newTestClock(server)
+	gcra := NewGCRA(client)
+	limit := RateLimit{Limit: 2, Window: time.Second}
+	ctx := context.Background()
+
+	results := allowN(t, gcra, "tat", limit, 3)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, 1, results[0].Remaining)
+	assert.Equal(t, 500*time.Millisecond, results[0].ResetAfter)
+	assert.True(t, results[1].Allowed)
+	assert.Equal(t, 0, results[1].Remaining)
+	assert.Equal(t, time.Second, results[1].ResetAfter)
+	assert.False(t, results[2].Allowed)
+	assert.Equal(t, 500*time.Millisecond, results[2].RetryAfter)
+
+	// Requests are admitted again one emission interval apart
+	clock.advance(499 * time.Millisecond)
+	assert.False(t, allowN(t, gcra, "tat", limit, 1)[0].Allowed)
+	clock.advance(time.Millisecond)
+	results = allowN(t, gcra, "tat", limit, 2)
+	assert.True(t, results[0].Allowed)
+	assert.False(t, results[1].Allowed)
+
+	// A refund moves the TAT back by the emission of its cost
+	require.NoError(t, gcra.Refund(ctx, "tat", limit, 1, results[0]))
+	assert.True(t, allowN(t, gcra, "tat", limit, 1)[0].Allowed)
+
+	// Refunding down to the present clears the key
+	require.NoError(t, gcra.Refund(ctx, "tat", limit, 2, results[0]))
+	assert.False(t, server.Exists("tat"))
+	require.NoError(t, gcra.Refund(ctx, "tat", limit, 1, results[0]))
+	assert.False(t, server.Exists("tat"))
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	server, client := newTestClient(t)
+	clock := newTestClock(server)
+	sw := NewSlidingWindowLog(client)
+	limit := RateLimit{Limit: 3, Window: time.Second}
+	ctx := context.Background()
+
+	first := allowN(t, sw, "log", limit, 1)[0]
+	require.True(t, first.Allowed)
+	assert.Equal(t, 2, first.Remaining)
+	assert.Equal(t, 3, first.Limit)
+
+	clock.advance(400 * time.Millisecond)
+	results := allowN(t, sw, "log", limit, 3)
+	assert.True(t, results[0].Allowed)
+	assert.True(t, results[1].Allowed)
+	assert.Equal(t, 0, results[1].Remaining)
+	assert.False(t, results[2].Allowed)
+	// The oldest entry leaves the window first
+	assert.Equal(t, 600*time.Millisecond, results[2].RetryAfter)
+	assert.Equal(t, time.Second, results[2].ResetAfter)
+
+	// Rejected requests are not recorded
+	members, err := server.ZMembers("log")
+	require.NoError(t, err)
+	assert.Len(t, members, 3)
+
+	// A cost above the limit never fits
+	result, err := sw.Allow(ctx, "log", limit, 4)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	// A refund removes the entries of its request only
+	require.NoError(t, sw.Refund(ctx, "log", limit, 1, results[0]))
+	require.NoError(t, sw.Refund(ctx, "log", limit, 1, RateLimitResult{Allowed: true}))
+	members, err = server.ZMembers("log")
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+
+	result, err = sw.Allow(ctx, "log", limit, 1)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.False(t, allowN(t, sw, "log", limit, 1)[0].Allowed)
+
+	clock.advance(600 * time.Millisecond)
+	results = allowN(t, sw, "log", limit, 2)
+	assert.True(t, results[0].Allowed)
+	assert.False(t, results[1].Allowed)
+}
+
+func TestRateLimiter(t *testing.T) {
+	server, client := newTestClient(t)
+	newTestClock(server)
+	rl := NewRateLimiter(client, log.NoopLogger)
+	limit := RateLimit{Limit: 1, Window: time.Minute}
+	ctx := context.Background()
+
+	for _, algorithm := range []string{AlgorithmTokenBucket, AlgorithmGCRA, AlgorithmSlidingWindow} {
+		t.Run(algorithm, func(t *testing.T) {
+			allowed, err := rl.Allow(ctx, algorithm, "user:1", limit, 1)
+			require.NoError(t, err)
+			assert.True(t, allowed.Allowed)
+			assert.True(t, server.Exists(RateLimitPrefix+algorithm+":user:1"))
+
+			rejected, err := rl.Allow(ctx, algorithm, "user:1", limit, 1)
+			require.NoError(t, err)
+			assert.False(t, rejected.Allowed)
+
+			// Refunding a rejected request gives nothing back
+			require.NoError(t, rl.Refund(ctx, algorithm, "user:1", limit, 1, rejected))
+			result, err := rl.Allow(ctx, algorithm, "user:1", limit, 1)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+
+			require.NoError(t, rl.Refund(ctx, algorithm, "user:1", limit, 1, allowed))
+			result, err = rl.Allow(ctx, algorithm, "user:1", limit, 1)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			// Identifiers have their own quota
+			result, err = rl.Allow(ctx, algorithm, "user:2", limit, 1)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+
+	_, err := rl.Allow(ctx, "leaky_bucket", "user:1", limit, 1)
+	assert.ErrorContains(t, err, "unknown rate limit algorithm")
+	assert.ErrorContains(t, rl.Refund(ctx, "leaky_bucket", "user:1", limit, 1, RateLimitResult{Allowed: true}), "unknown rate limit algorithm")
+
+	_, err = rl.Allow(ctx, AlgorithmGCRA, "user:1", RateLimit{Limit: 0, Window: time.Minute}, 1)
+	assert.ErrorContains(t, err, "invalid rate limit")
+}
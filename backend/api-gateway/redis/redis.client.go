@@ -11,9 +11,14 @@ import (
 
 var (
 	client *redis.Client
-	logger = log.Noop{}
+	logger log.Logger = log.Noop{}
 )
 
+// UseLogger sets the logger of the stores and managers
+func UseLogger(l log.Logger) {
+	logger = l
+}
+
 func Init() error {
 	addr := os.Getenv("REDIS_ADDR")
 	password := os.Getenv("REDIS_PASSWORD")
@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// Schema registry keys
+const (
+	// RegistrySubgraphPrefix holds the latest schema published by a subgraph
+	RegistrySubgraphPrefix = "registry:subgraph:"
+	// RegistryHistoryPrefix holds the schemas published by a subgraph, newest first
+	RegistryHistoryPrefix = "registry:history:"
+	// RegistryVersionsPrefix maps the versions published by a subgraph to the hash of their SDL
+	RegistryVersionsPrefix = "registry:versions:"
+	// RegistrySubgraphs is the set of subgraphs that published a schema
+	RegistrySubgraphs = "registry:subgraphs"
+	// RegistryChannel notifies every gateway replica of published schemas
+	RegistryChannel = "registry:updates"
+
+	// RegistryHistoryLimit is how many schemas are kept per subgraph
+	RegistryHistoryLimit = 50
+)
+
+var ErrSchemaVersionExists = errors.New("schema version already published with a different SDL")
+
+// RegisteredSchema is a schema version published by a subgraph
+type RegisteredSchema struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	SDL     string `json:"sdl"`
+	// AllowBreaking lets the schema through although it breaks the supergraph
+	AllowBreaking bool      `json:"allow_breaking,omitempty"`
+	PublishedAt   time.Time `json:"published_at"`
+}
+
+// SchemaPublished is the message sent on RegistryChannel
+type SchemaPublished struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// SchemaRegistry stores the schemas published by subgraphs
+type SchemaRegistry struct {
+	client *redis.Client
+	logger log.Logger
+}
+
+// NewSchemaRegistry creates a schema registry
+func NewSchemaRegistry(client *redis.Client, logger log.Logger) *SchemaRegistry {
+	return &SchemaRegistry{client: client, logger: logger}
+}
+
+// publishSchemaScript stores a schema version unless the version exists, returning 0 for
+// new versions, 1 for versions published before with the same SDL and 2 otherwise
+//
+// KEYS[1] = latest schema, KEYS[2] = history, KEYS[3] = versions, KEYS[4] = subgraph set
+// ARGV = version, SDL hash, schema JSON, history limit, subgraph name
+var publishSchemaScript = redis.NewScript(`
+local existing = redis.call('HGET', KEYS[3], ARGV[1])
+if existing then
+	if existing == ARGV[2] then
+		return 1
+	end
+	return 2
+end
+
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+redis.call('SET', KEYS[1], ARGV[3])
+redis.call('LPUSH', KEYS[2], ARGV[3])
+redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[4]) - 1)
+redis.call('SADD', KEYS[4], ARGV[5])
+return 0
+`)
+
+// Publish stores a schema version as the latest schema of its subgraph and notifies the
+// gateway replicas. Publishing a version again with the same SDL changes nothing and
+// returns false.
+func (sr *SchemaRegistry) Publish(ctx context.Context, schema *RegisteredSchema) (bool, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return false, err
+	}
+	hash := sha256.Sum256([]byte(schema.SDL))
+
+	result, err := publishSchemaScript.Run(ctx, sr.client,
+		[]string{RegistrySubgraphPrefix + schema.Name, RegistryHistoryPrefix + schema.Name, RegistryVersionsPrefix + schema.Name, RegistrySubgraphs},
+		schema.Version, hex.EncodeToString(hash[:]), data, RegistryHistoryLimit, schema.Name,
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	switch result {
+	case 1:
+		return false, nil
+	case 2:
+		return false, ErrSchemaVersionExists
+	}
+
+	message, err := json.Marshal(SchemaPublished{Name: schema.Name, Version: schema.Version})
+	if err != nil {
+		return true, err
+	}
+	if err := sr.client.Publish(ctx, RegistryChannel, message).Err(); err != nil {
+		// Replicas still pick the schema up on their next poll
+		sr.logger.Error("Failed to notify schema update", log.String("subgraph", schema.Name), log.Error(err))
+	}
+	return true, nil
+}
+
+// Latest returns the latest schema published by a subgraph
+func (sr *SchemaRegistry) Latest(ctx context.Context, name string) (*RegisteredSchema, bool, error) {
+	data, err := sr.client.Get(ctx, RegistrySubgraphPrefix+name).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var schema RegisteredSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, false, err
+	}
+	return &schema, true, nil
+}
+
+// LatestAll returns the latest schema of every subgraph that published one, by name
+func (sr *SchemaRegistry) LatestAll(ctx context.Context) (map[string]*RegisteredSchema, error) {
+	names, err := sr.client.SMembers(ctx, RegistrySubgraphs).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make(map[string]*RegisteredSchema, len(names))
+	for _, name := range names {
+		schema, ok, err := sr.Latest(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			schemas[name] = schema
+		}
+	}
+	return schemas, nil
+}
+
+// History returns the schemas published by a subgraph, newest first
+func (sr *SchemaRegistry) History(ctx context.Context, name string) ([]*RegisteredSchema, error) {
+	entries, err := sr.client.LRange(ctx, RegistryHistoryPrefix+name, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*RegisteredSchema, 0, len(entries))
+	for _, entry := range entries {
+		var schema RegisteredSchema
+		if err := json.Unmarshal([]byte(entry), &schema); err != nil {
+			sr.logger.Error("Skipping unreadable schema history entry", log.String("subgraph", name), log.Error(err))
+			continue
+		}
+		history = append(history, &schema)
+	}
+	return history, nil
+}
+
+// Subscribe returns the schema updates published to any replica until ctx is done.
+// The subscription reconnects on its own after Redis connection errors.
+func (sr *SchemaRegistry) Subscribe(ctx context.Context) <-chan SchemaPublished {
+	pubsub := sr.client.Subscribe(ctx, RegistryChannel)
+	updates := make(chan SchemaPublished)
+
+	go func() {
+		defer close(updates)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var update SchemaPublished
+				if err := json.Unmarshal([]byte(message.Payload), &update); err != nil {
+					sr.logger.Error("Skipping unreadable schema update", log.Error(err))
+					continue
+				}
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return updates
+}
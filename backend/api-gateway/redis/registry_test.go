@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSchema(name, version, sdl string) *RegisteredSchema {
+	return &RegisteredSchema{
+		Name:        name,
+		Version:     version,
+		SDL:         sdl,
+		PublishedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestSchemaRegistryPublish(t *testing.T) {
+	_, client := newTestClient(t)
+	sr := NewSchemaRegistry(client, log.NoopLogger)
+	ctx := context.Background()
+
+	_, ok, err := sr.Latest(ctx, "orders")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	published, err := sr.Publish(ctx, testSchema("orders", "1.0.0", "type Query { orders: [ID!]! }"))
+	require.NoError(t, err)
+	assert.True(t, published)
+
+	// Publishing a version again with the same SDL changes nothing
+	published, err = sr.Publish(ctx, testSchema("orders", "1.0.0", "type Query { orders: [ID!]! }"))
+	require.NoError(t, err)
+	assert.False(t, published)
+
+	// A version cannot be published again with another SDL
+	_, err = sr.Publish(ctx, testSchema("orders", "1.0.0", "type Query { order: ID }"))
+	assert.ErrorIs(t, err, ErrSchemaVersionExists)
+
+	v2 := testSchema("orders", "1.1.0", "type Query { orders: [ID!]! order: ID }")
+	v2.AllowBreaking = true
+	published, err = sr.Publish(ctx, v2)
+	require.NoError(t, err)
+	assert.True(t, published)
+
+	latest, ok, err := sr.Latest(ctx, "orders")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, v2, latest)
+
+	history, err := sr.History(ctx, "orders")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "1.1.0", history[0].Version)
+	assert.Equal(t, "1.0.0", history[1].Version)
+
+	_, err = sr.Publish(ctx, testSchema("products", "1", "type Query { products: [ID!]! }"))
+	require.NoError(t, err)
+
+	all, err := sr.LatestAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, "1.1.0", all["orders"].Version)
+	assert.Equal(t, "1", all["products"].Version)
+}
+
+func TestSchemaRegistryHistoryLimit(t *testing.T) {
+	server, client := newTestClient(t)
+	sr := NewSchemaRegistry(client, log.NoopLogger)
+	ctx := context.Background()
+
+	for i := 0; i < RegistryHistoryLimit+5; i++ {
+		_, err := sr.Publish(ctx, testSchema("orders", fmt.Sprint(i), fmt.Sprintf("type Query { v%d: ID }", i)))
+		require.NoError(t, err)
+	}
+
+	history, err := sr.History(ctx, "orders")
+	require.NoError(t, err)
+	require.Len(t, history, RegistryHistoryLimit)
+	assert.Equal(t, fmt.Sprint(RegistryHistoryLimit+4), history[0].Version)
+
+	// Unreadable entries are skipped
+	_, err = server.Lpush(RegistryHistoryPrefix+"orders", "{")
+	require.NoError(t, err)
+	history, err = sr.History(ctx, "orders")
+	require.NoError(t, err)
+	assert.Len(t, history, RegistryHistoryLimit)
+}
+
+func TestSchemaRegistrySubscribe(t *testing.T) {
+	_, client := newTestClient(t)
+	sr := NewSchemaRegistry(client, log.NoopLogger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := sr.Subscribe(ctx)
+	// Wait for the subscription, messages published before it are lost
+	require.Eventually(t, func() bool {
+		channels, err := client.PubSubNumSub(ctx, RegistryChannel).Result()
+		return err == nil && channels[RegistryChannel] > 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := sr.Publish(ctx, testSchema("orders", "1.0.0", "type Query { orders: [ID!]! }"))
+	require.NoError(t, err)
+	// Republishing does not notify
+	_, err = sr.Publish(ctx, testSchema("orders", "1.0.0", "type Query { orders: [ID!]! }"))
+	require.NoError(t, err)
+	_, err = sr.Publish(ctx, testSchema("orders", "1.1.0", "type Query { orders: [ID!]! order: ID }"))
+	require.NoError(t, err)
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		select {
+		case update := <-updates:
+			assert.Equal(t, SchemaPublished{Name: "orders", Version: version}, update)
+		case <-time.After(time.Second):
+			t.Fatalf("no update for version %s", version)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("updates not closed")
+	}
+}
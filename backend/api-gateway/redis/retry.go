@@ -1,25 +1,20 @@
 package redis
 
 import (
+	"api-gateway/metrics"
 	"context"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	log "github.com/jensneuse/abstractlogger"
 )
 
-// Retry configuration constants
-const (
-	RetryMetricsPrefix = "retry:metrics:"
-	RetryAttemptsKey   = ":attempts"
-	RetrySuccessKey    = ":success"
-	RetryFailureKey    = ":failure"
-)
-
 var (
 	ErrMaxRetriesExceeded = errors.New("max retries exceeded")
 	ErrNonRetryableError  = errors.New("non-retryable error")
@@ -76,16 +71,19 @@ type ShouldRetryFunc func(err error, attempt int) bool
 type Retry struct {
 	name   string
 	config RetryConfig
-	client *redis.Client
 	logger log.Logger
+
+	// Counters of this replica, exported to Prometheus as well
+	attempts atomic.Int64
+	success  atomic.Int64
+	failure  atomic.Int64
 }
 
 // NewRetry creates a new retry handler
-func NewRetry(name string, config RetryConfig, client *redis.Client, logger log.Logger) *Retry {
+func NewRetry(name string, config RetryConfig, logger log.Logger) *Retry {
 	return &Retry{
 		name:   name,
 		config: config,
-		client: client,
 		logger: logger,
 	}
 }
@@ -101,14 +99,14 @@ func (r *Retry) ExecuteWithCondition(ctx context.Context, fn RetryableFunc, shou
 
 	for attempt := 1; attempt <= r.config.MaxAttempts; attempt++ {
 		// Record attempt
-		r.recordAttempt(ctx, attempt)
+		r.recordAttempt()
 
 		// Execute the function
 		err := fn(attempt)
 
 		if err == nil {
 			// Success!
-			r.recordSuccess(ctx)
+			r.recordSuccess()
 			if attempt > 1 {
 				r.logger.Info(fmt.Sprintf("Retry %s succeeded on attempt %d/%d", r.name, attempt, r.config.MaxAttempts))
 			}
@@ -119,14 +117,14 @@ func (r *Retry) ExecuteWithCondition(ctx context.Context, fn RetryableFunc, shou
 
 		// Check if we should retry
 		if !shouldRetry(err, attempt) {
-			r.recordFailure(ctx)
+			r.recordFailure()
 			r.logger.Warn(fmt.Sprintf("Retry %s non-retryable error on attempt %d: %v", r.name, attempt, err))
 			return err
 		}
 
 		// Check if we've exhausted retries
 		if attempt >= r.config.MaxAttempts {
-			r.recordFailure(ctx)
+			r.recordFailure()
 			r.logger.Error(fmt.Sprintf("Retry %s max attempts (%d) exceeded", r.name, r.config.MaxAttempts), log.Error(err))
 			return fmt.Errorf("%w: %v", ErrMaxRetriesExceeded, err)
 		}
@@ -205,60 +203,28 @@ func (r *Retry) defaultShouldRetry(err error, attempt int) bool {
 }
 
 // recordAttempt records a retry attempt
-func (r *Retry) recordAttempt(ctx context.Context, attempt int) {
-	key := r.metricsKey(RetryAttemptsKey)
-	pipe := r.client.Pipeline()
-	pipe.Incr(ctx, key)
-	pipe.Expire(ctx, key, 24*time.Hour)
-
-	if _, err := pipe.Exec(ctx); err != nil {
-		r.logger.Error("Failed to record retry attempt", log.Error(err))
-	}
+func (r *Retry) recordAttempt() {
+	r.attempts.Add(1)
+	metrics.RetryAttempts.WithLabelValues(r.name).Inc()
 }
 
 // recordSuccess records a successful retry
-func (r *Retry) recordSuccess(ctx context.Context) {
-	key := r.metricsKey(RetrySuccessKey)
-	pipe := r.client.Pipeline()
-	pipe.Incr(ctx, key)
-	pipe.Expire(ctx, key, 24*time.Hour)
-
-	if _, err := pipe.Exec(ctx); err != nil {
-		r.logger.Error("Failed to record retry success", log.Error(err))
-	}
+func (r *Retry) recordSuccess() {
+	r.success.Add(1)
+	metrics.RetryOperations.WithLabelValues(r.name, "success").Inc()
 }
 
 // recordFailure records a failed retry (after all attempts)
-func (r *Retry) recordFailure(ctx context.Context) {
-	key := r.metricsKey(RetryFailureKey)
-	pipe := r.client.Pipeline()
-	pipe.Incr(ctx, key)
-	pipe.Expire(ctx, key, 24*time.Hour)
-
-	if _, err := pipe.Exec(ctx); err != nil {
-		r.logger.Error("Failed to record retry failure", log.Error(err))
-	}
+func (r *Retry) recordFailure() {
+	r.failure.Add(1)
+	metrics.RetryOperations.WithLabelValues(r.name, "failure").Inc()
 }
 
-// GetMetrics returns current retry metrics
-func (r *Retry) GetMetrics(ctx context.Context) (map[string]interface{}, error) {
-	attemptsKey := r.metricsKey(RetryAttemptsKey)
-	successKey := r.metricsKey(RetrySuccessKey)
-	failureKey := r.metricsKey(RetryFailureKey)
-
-	pipe := r.client.Pipeline()
-	attemptsCmd := pipe.Get(ctx, attemptsKey)
-	successCmd := pipe.Get(ctx, successKey)
-	failureCmd := pipe.Get(ctx, failureKey)
-
-	_, err := pipe.Exec(ctx)
-	if err != nil && !errors.Is(err, redis.Nil) {
-		return nil, err
-	}
-
-	attempts, _ := attemptsCmd.Int64()
-	success, _ := successCmd.Int64()
-	failure, _ := failureCmd.Int64()
+// GetMetrics returns the retry metrics of this replica since it started or was reset
+func (r *Retry) GetMetrics() map[string]interface{} {
+	attempts := r.attempts.Load()
+	success := r.success.Load()
+	failure := r.failure.Load()
 
 	// Calculate success rate
 	successRate := 0.0
@@ -283,77 +249,78 @@ func (r *Retry) GetMetrics(ctx context.Context) (map[string]interface{}, error)
 		"max_attempts":       r.config.MaxAttempts,
 		"initial_delay_ms":   r.config.InitialDelay.Milliseconds(),
 		"max_delay_ms":       r.config.MaxDelay.Milliseconds(),
-	}, nil
-}
-
-// Reset clears retry metrics
-func (r *Retry) Reset(ctx context.Context) error {
-	keys := []string{
-		r.metricsKey(RetryAttemptsKey),
-		r.metricsKey(RetrySuccessKey),
-		r.metricsKey(RetryFailureKey),
 	}
-
-	return r.client.Del(ctx, keys...).Err()
 }
 
-// metricsKey generates Redis key for metrics
-func (r *Retry) metricsKey(suffix string) string {
-	return RetryMetricsPrefix + r.name + suffix
+// Reset clears the retry metrics of this replica. The Prometheus counters keep counting.
+func (r *Retry) Reset() {
+	r.attempts.Store(0)
+	r.success.Store(0)
+	r.failure.Store(0)
 }
 
 // RetryManager manages multiple retry handlers
 type RetryManager struct {
+	mu      sync.RWMutex
 	retries map[string]*Retry
-	client  *redis.Client
 	logger  log.Logger
 }
 
 // NewRetryManager creates a new retry manager
-func NewRetryManager(client *redis.Client, logger log.Logger) *RetryManager {
+func NewRetryManager(logger log.Logger) *RetryManager {
 	return &RetryManager{
 		retries: make(map[string]*Retry),
-		client:  client,
 		logger:  logger,
 	}
 }
 
 // GetOrCreateRetry gets or creates a retry handler
 func (rm *RetryManager) GetOrCreateRetry(name string, config RetryConfig) *Retry {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
 	if retry, exists := rm.retries[name]; exists {
 		return retry
 	}
 
-	retry := NewRetry(name, config, rm.client, rm.logger)
+	retry := NewRetry(name, config, rm.logger)
 	rm.retries[name] = retry
 	return retry
 }
 
 // GetRetry returns a retry handler by name
 func (rm *RetryManager) GetRetry(name string) (*Retry, bool) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
 	retry, exists := rm.retries[name]
 	return retry, exists
 }
 
 // GetAllMetrics returns metrics for all retry handlers
-func (rm *RetryManager) GetAllMetrics(ctx context.Context) map[string]interface{} {
-	metrics := make(map[string]interface{})
-
-	for name, retry := range rm.retries {
-		if retryMetrics, err := retry.GetMetrics(ctx); err == nil {
-			metrics[name] = retryMetrics
-		}
+func (rm *RetryManager) GetAllMetrics() map[string]interface{} {
+	all := make(map[string]interface{})
+	for name, retry := range rm.snapshot() {
+		all[name] = retry.GetMetrics()
 	}
-
-	return metrics
+	return all
 }
 
 // ResetAll resets all retry metrics
-func (rm *RetryManager) ResetAll(ctx context.Context) error {
-	for _, retry := range rm.retries {
-		if err := retry.Reset(ctx); err != nil {
-			return err
-		}
+func (rm *RetryManager) ResetAll() {
+	for _, retry := range rm.snapshot() {
+		retry.Reset()
+	}
+}
+
+// snapshot returns a copy of the registered retry handlers that is safe to iterate
+func (rm *RetryManager) snapshot() map[string]*Retry {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	retries := make(map[string]*Retry, len(rm.retries))
+	for name, retry := range rm.retries {
+		retries[name] = retry
 	}
-	return nil
+	return retries
 }
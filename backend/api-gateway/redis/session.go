@@ -0,0 +1,328 @@
+package redis
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+const (
+	// UserSessionsPrefix indexes the session IDs of a user in a set
+	UserSessionsPrefix = "user_sessions:"
+	// RevokedTokenPrefix marks access tokens revoked before their expiry, by jti
+	RevokedTokenPrefix = "revoked:jti:"
+	// RefreshTokenHistoryPrefix maps the hashes of the refresh tokens a session replaced
+	// to when they were replaced
+	RefreshTokenHistoryPrefix = "session_refresh_tokens:"
+
+	// RefreshTokenReuseGrace is how long a replaced refresh token is answered as rotated
+	// rather than reused, so that clients refreshing concurrently with the same token,
+	// e.g. two tabs, do not revoke their session
+	RefreshTokenReuseGrace = 30 * time.Second
+
+	maxRotateAttempts = 3
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrRefreshTokenReused is returned for a refresh token the session replaced before,
+	// the session is revoked
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrRefreshTokenRotated is returned for a refresh token the session replaced within
+	// RefreshTokenReuseGrace, the session is left alone
+	ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+	// ErrRefreshTokenInvalid is returned for a refresh token the session never issued
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+)
+
+// SessionManager handles user sessions
+type SessionManager struct {
+	client *redis.Client
+	logger log.Logger
+}
+
+// NewSessionManager creates a new session manager
+func NewSessionManager(client *redis.Client, logger log.Logger) *SessionManager {
+	return &SessionManager{
+		client: client,
+		logger: logger,
+	}
+}
+
+// SessionData represents a user session
+type SessionData struct {
+	UserID    string                 `json:"user_id"`
+	Username  string                 `json:"username"`
+	CreatedAt int64                  `json:"created_at"`
+	ExpiresAt int64                  `json:"expires_at"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+
+	// RefreshTokenHash is the SHA-256 hash of the only refresh token currently valid
+	RefreshTokenHash string `json:"refresh_token_hash,omitempty"`
+	// AccessTokenID is the jti of the last access token issued, revoked with the session
+	AccessTokenID        string `json:"access_token_id,omitempty"`
+	AccessTokenExpiresAt int64  `json:"access_token_expires_at,omitempty"`
+}
+
+// CreateSession stores a new session and adds it to the session index of the user
+func (sm *SessionManager) CreateSession(ctx context.Context, sessionID string, data SessionData, ttl time.Duration) error {
+	key := SessionPrefix + sessionID
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	indexKey := UserSessionsPrefix + data.UserID
+	_, err = sm.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, jsonData, ttl)
+		pipe.SAdd(ctx, indexKey, sessionID)
+		// The index lives as long as the longest session of the user
+		pipe.ExpireNX(ctx, indexKey, ttl)
+		pipe.ExpireGT(ctx, indexKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// GetSession retrieves a session
+func (sm *SessionManager) GetSession(ctx context.Context, sessionID string) (*SessionData, error) {
+	key := SessionPrefix + sessionID
+
+	result, err := sm.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data SessionData
+	if err := json.Unmarshal([]byte(result), &data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// CheckRefreshToken returns the session of a refresh token if refreshTokenHash is the
+// current one. A refresh token the session replaced before revokes the session.
+func (sm *SessionManager) CheckRefreshToken(ctx context.Context, sessionID string, refreshTokenHash string) (*SessionData, error) {
+	data, err := sm.GetSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.checkRefreshToken(ctx, sm.client, sessionID, data, refreshTokenHash); err != nil {
+		return nil, sm.revokeReused(ctx, sessionID, err)
+	}
+	return data, nil
+}
+
+// RotateRefreshToken replaces the refresh token of a session if refreshTokenHash is the
+// current one, and records the access token issued with it. The replaced token is kept
+// in the history of the session: presenting it again revokes the session and returns
+// ErrRefreshTokenReused, unless it was replaced within RefreshTokenReuseGrace. Any other
+// token returns ErrRefreshTokenInvalid and leaves the session alone.
+func (sm *SessionManager) RotateRefreshToken(ctx context.Context, sessionID string, refreshTokenHash string, next SessionData, ttl time.Duration) (*SessionData, error) {
+	key := SessionPrefix + sessionID
+	historyKey := RefreshTokenHistoryPrefix + sessionID
+	var rotated *SessionData
+
+	rotate := func(tx *redis.Tx) error {
+		result, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return ErrSessionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var data SessionData
+		if err := json.Unmarshal([]byte(result), &data); err != nil {
+			return err
+		}
+		if err := sm.checkRefreshToken(ctx, tx, sessionID, &data, refreshTokenHash); err != nil {
+			return err
+		}
+
+		data.RefreshTokenHash = next.RefreshTokenHash
+		data.AccessTokenID = next.AccessTokenID
+		data.AccessTokenExpiresAt = next.AccessTokenExpiresAt
+		data.ExpiresAt = next.ExpiresAt
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, jsonData, ttl)
+			pipe.HSet(ctx, historyKey, refreshTokenHash, time.Now().Unix())
+			pipe.Expire(ctx, historyKey, ttl)
+			pipe.ExpireGT(ctx, UserSessionsPrefix+data.UserID, ttl)
+			return nil
+		})
+		if err == nil {
+			rotated = &data
+		}
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt < maxRotateAttempts; attempt++ {
+		// A concurrent refresh changed the session, the next attempt finds the token
+		// rotated or rotates it
+		if err = sm.client.Watch(ctx, rotate, key); err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err == redis.TxFailedErr {
+		err = ErrRefreshTokenRotated
+	}
+	if err != nil {
+		return nil, sm.revokeReused(ctx, sessionID, err)
+	}
+	return rotated, nil
+}
+
+// checkRefreshToken tells the current refresh token of a session from the ones it
+// replaced and from tokens it never issued
+func (sm *SessionManager) checkRefreshToken(ctx context.Context, client redis.Cmdable, sessionID string, data *SessionData, refreshTokenHash string) error {
+	if subtle.ConstantTimeCompare([]byte(data.RefreshTokenHash), []byte(refreshTokenHash)) == 1 {
+		return nil
+	}
+
+	replacedAt, err := client.HGet(ctx, RefreshTokenHistoryPrefix+sessionID, refreshTokenHash).Int64()
+	if err == redis.Nil {
+		return ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(time.Unix(replacedAt, 0)) < RefreshTokenReuseGrace {
+		return ErrRefreshTokenRotated
+	}
+	return ErrRefreshTokenReused
+}
+
+// revokeReused revokes the session when err reports a reused refresh token
+func (sm *SessionManager) revokeReused(ctx context.Context, sessionID string, err error) error {
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		return err
+	}
+	sm.logger.Warn("Refresh token reuse detected, revoking session", log.String("session", sessionID))
+	if deleteErr := sm.DeleteSession(ctx, sessionID); deleteErr != nil {
+		sm.logger.Error("Failed to revoke session", log.String("session", sessionID), log.Error(deleteErr))
+	}
+	return err
+}
+
+// DeleteSession removes a session (logout) and revokes its last access token
+func (sm *SessionManager) DeleteSession(ctx context.Context, sessionID string) error {
+	data, err := sm.GetSession(ctx, sessionID)
+	if err == ErrSessionNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, SessionPrefix+sessionID, RefreshTokenHistoryPrefix+sessionID)
+		pipe.SRem(ctx, UserSessionsPrefix+data.UserID, sessionID)
+		sm.revokeAccessToken(ctx, pipe, data)
+		return nil
+	})
+	return err
+}
+
+// DeleteAllUserSessions removes every session of a user (logout everywhere)
+func (sm *SessionManager) DeleteAllUserSessions(ctx context.Context, userID string) (int, error) {
+	sessionIDs, err := sm.GetAllUserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := sm.DeleteSession(ctx, sessionID); err != nil {
+			return 0, err
+		}
+	}
+	return len(sessionIDs), sm.client.Del(ctx, UserSessionsPrefix+userID).Err()
+}
+
+// ExtendSession extends the TTL of a session
+func (sm *SessionManager) ExtendSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	key := SessionPrefix + sessionID
+	return sm.client.Expire(ctx, key, ttl).Err()
+}
+
+// GetAllUserSessions retrieves all sessions for a user from the session index of the
+// user, dropping the IDs of sessions that have expired
+func (sm *SessionManager) GetAllUserSessions(ctx context.Context, userID string) ([]string, error) {
+	indexKey := UserSessionsPrefix + userID
+
+	members, err := sm.client.SMembers(ctx, indexKey).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(members))
+	for i, sessionID := range members {
+		keys[i] = SessionPrefix + sessionID
+	}
+	values, err := sm.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionIDs, expired []string
+	for i, value := range values {
+		if value == nil {
+			expired = append(expired, members[i])
+			continue
+		}
+		sessionIDs = append(sessionIDs, members[i])
+	}
+
+	if len(expired) > 0 {
+		if err := sm.client.SRem(ctx, indexKey, expired).Err(); err != nil {
+			sm.logger.Error("Failed to prune session index", log.String("user", userID), log.Error(err))
+		}
+	}
+
+	return sessionIDs, nil
+}
+
+// revokeAccessToken queues the revocation of the last access token of a session
+func (sm *SessionManager) revokeAccessToken(ctx context.Context, pipe redis.Pipeliner, data *SessionData) {
+	if data.AccessTokenID == "" {
+		return
+	}
+	if ttl := time.Until(time.Unix(data.AccessTokenExpiresAt, 0)); ttl > 0 {
+		pipe.Set(ctx, RevokedTokenPrefix+data.AccessTokenID, 1, ttl)
+	}
+}
+
+// RevokeToken adds an access token to the revocation list until it expires
+func (sm *SessionManager) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if tokenID == "" || ttl <= 0 {
+		return nil
+	}
+	return sm.client.Set(ctx, RevokedTokenPrefix+tokenID, 1, ttl).Err()
+}
+
+// IsTokenRevoked reports whether an access token is on the revocation list
+func (sm *SessionManager) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	n, err := sm.client.Exists(ctx, RevokedTokenPrefix+tokenID).Result()
+	if err != nil {
+		return false, fmt.Errorf("check revoked token: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func newTestSession(t *testing.T, sm *SessionManager, sessionID string) {
+	err := sm.CreateSession(context.Background(), sessionID, SessionData{
+		UserID:           "user-1",
+		RefreshTokenHash: "hash-0",
+	}, time.Hour)
+	require.NoError(t, err)
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	server, client := newTestClient(t)
+	sm := NewSessionManager(client, log.NoopLogger)
+	ctx := context.Background()
+	newTestSession(t, sm, "s1")
+
+	rotated, err := sm.RotateRefreshToken(ctx, "s1", "hash-0", SessionData{RefreshTokenHash: "hash-1"}, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "hash-1", rotated.RefreshTokenHash)
+
+	_, err = sm.CheckRefreshToken(ctx, "s1", "hash-1")
+	assert.NoError(t, err)
+
+	// A token the session never issued leaves it alone
+	_, err = sm.RotateRefreshToken(ctx, "s1", "forged", SessionData{RefreshTokenHash: "hash-2"}, time.Hour)
+	assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+	_, err = sm.CheckRefreshToken(ctx, "s1", "forged")
+	assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+
+	// A token replaced moments ago was presented by a concurrent refresh
+	_, err = sm.CheckRefreshToken(ctx, "s1", "hash-0")
+	assert.ErrorIs(t, err, ErrRefreshTokenRotated)
+
+	_, err = sm.GetSession(ctx, "s1")
+	require.NoError(t, err)
+
+	// Past the grace it is reuse, and the session is revoked
+	server.HSet(RefreshTokenHistoryPrefix+"s1", "hash-0", fmt.Sprint(time.Now().Add(-2*RefreshTokenReuseGrace).Unix()))
+	_, err = sm.RotateRefreshToken(ctx, "s1", "hash-0", SessionData{RefreshTokenHash: "hash-2"}, time.Hour)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	_, err = sm.GetSession(ctx, "s1")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	assert.False(t, server.Exists(RefreshTokenHistoryPrefix+"s1"))
+}
+
+func TestRotateRefreshTokenConcurrently(t *testing.T) {
+	_, client := newTestClient(t)
+	sm := NewSessionManager(client, log.NoopLogger)
+	ctx := context.Background()
+	newTestSession(t, sm, "s1")
+
+	const refreshes = 8
+	errs := make([]error, refreshes)
+	var wg sync.WaitGroup
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = sm.RotateRefreshToken(ctx, "s1", "hash-0", SessionData{RefreshTokenHash: fmt.Sprintf("hash-%d", i+1)}, time.Hour)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrRefreshTokenRotated)
+	}
+	assert.Equal(t, 1, succeeded)
+
+	// The losing refreshes did not revoke the session
+	_, err := sm.GetSession(ctx, "s1")
+	assert.NoError(t, err)
+}
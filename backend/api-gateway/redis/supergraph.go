@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// SupergraphKey holds the last supergraph the gateway composed and served, without TTL
+const SupergraphKey = "gql:supergraph"
+
+// SupergraphSubgraph is one subgraph of a stored supergraph
+type SupergraphSubgraph struct {
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	SubscriptionURL string `json:"subscription_url,omitempty"`
+	SDL             string `json:"sdl"`
+}
+
+// Supergraph is the set of subgraph SDLs a supergraph was composed from
+type Supergraph struct {
+	Subgraphs  []SupergraphSubgraph `json:"subgraphs"`
+	ComposedAt time.Time            `json:"composed_at"`
+}
+
+// SaveSupergraph stores the last known good supergraph, so a restarted gateway can serve
+// it while subgraphs are down
+func (cs *CacheService) SaveSupergraph(ctx context.Context, supergraph *Supergraph) error {
+	data, err := json.Marshal(supergraph)
+	if err != nil {
+		return err
+	}
+	return cs.client.Set(ctx, SupergraphKey, data, 0).Err()
+}
+
+// GetSupergraph returns the last known good supergraph
+func (cs *CacheService) GetSupergraph(ctx context.Context) (*Supergraph, bool, error) {
+	data, err := cs.client.Get(ctx, SupergraphKey).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var supergraph Supergraph
+	if err := json.Unmarshal(data, &supergraph); err != nil {
+		return nil, false, err
+	}
+	return &supergraph, true, nil
+}
@@ -0,0 +1,375 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/jensneuse/abstractlogger"
+)
+
+// User store keys
+const (
+	// UserPrefix holds a hash per user, keyed by user ID
+	UserPrefix = "user:"
+	// UsernameIndexPrefix maps a lowercased username to its user ID
+	UsernameIndexPrefix = "user:name:"
+	// EmailIndexPrefix maps a lowercased email address to its user ID
+	EmailIndexPrefix = "user:email:"
+	// EmailVerificationPrefix maps the hash of an email verification token to its user ID
+	EmailVerificationPrefix = "user:verify:"
+	// FailedLoginPrefix counts recent failed logins per lowercased username
+	FailedLoginPrefix = "user:failed:"
+	// LoginLockPrefix marks a lowercased username as locked out
+	LoginLockPrefix = "user:locked:"
+	// UserIdentityPrefix maps an identity provider and subject to the linked user ID
+	UserIdentityPrefix = "user:identity:"
+)
+
+// DefaultUserRole is the role of users registered without one
+const DefaultUserRole = "customer"
+
+var (
+	ErrUserNotFound             = errors.New("user not found")
+	ErrUsernameTaken            = errors.New("username already taken")
+	ErrEmailTaken               = errors.New("email already registered")
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrIdentityLinked           = errors.New("identity already linked to a user")
+)
+
+// User is a registered user
+type User struct {
+	ID            string
+	Username      string
+	Email         string
+	PasswordHash  string
+	EmailVerified bool
+	// Roles are stored comma separated
+	Roles     []string
+	CreatedAt time.Time
+}
+
+// LockoutPolicy locks a username out after MaxAttempts failed logins within Window
+type LockoutPolicy struct {
+	MaxAttempts int
+	Window      time.Duration
+	Duration    time.Duration
+}
+
+// UserStore keeps users under the user: prefix. Usernames and email addresses are unique,
+// case insensitively, through index keys written in the same script as the user.
+type UserStore struct {
+	client *redis.Client
+	logger log.Logger
+}
+
+// NewUserStore creates a user store
+func NewUserStore(client *redis.Client, logger log.Logger) *UserStore {
+	return &UserStore{client: client, logger: logger}
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// createUserScript creates a user unless its username or email is taken.
+// The bare username key is checked too, so users not migrated yet are not shadowed.
+//
+// KEYS[1] = username index, KEYS[2] = email index (empty for none), KEYS[3] = user hash, KEYS[4] = legacy username hash,
+// KEYS[5] = identity link (empty for none)
+// ARGV = user id, username, email, password hash, created at, roles, email verified
+var createUserScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 or redis.call('HEXISTS', KEYS[4], 'password') == 1 then
+	return 1
+end
+if KEYS[2] ~= '' and redis.call('EXISTS', KEYS[2]) == 1 then
+	return 2
+end
+if KEYS[5] ~= '' and redis.call('EXISTS', KEYS[5]) == 1 then
+	return 3
+end
+
+redis.call('SET', KEYS[1], ARGV[1])
+if KEYS[2] ~= '' then
+	redis.call('SET', KEYS[2], ARGV[1])
+end
+if KEYS[5] ~= '' then
+	redis.call('SET', KEYS[5], ARGV[1])
+end
+redis.call('HSET', KEYS[3],
+	'user_id', ARGV[1],
+	'username', ARGV[2],
+	'email', ARGV[3],
+	'password', ARGV[4],
+	'email_verified', ARGV[7],
+	'roles', ARGV[6],
+	'created_at', ARGV[5])
+return 0
+`)
+
+// CreateUser stores a new user, failing with ErrUsernameTaken or ErrEmailTaken on duplicates
+func (us *UserStore) CreateUser(ctx context.Context, user *User) error {
+	return us.createUser(ctx, user, "")
+}
+
+// CreateUserWithIdentity stores a new user signed in with an identity provider and links
+// the identity to it, failing with ErrIdentityLinked if another user got it first
+func (us *UserStore) CreateUserWithIdentity(ctx context.Context, user *User, provider string, subject string) error {
+	return us.createUser(ctx, user, identityKey(provider, subject))
+}
+
+func (us *UserStore) createUser(ctx context.Context, user *User, identityKey string) error {
+	if len(user.Roles) == 0 {
+		user.Roles = []string{DefaultUserRole}
+	}
+
+	emailKey := ""
+	if user.Email != "" {
+		emailKey = EmailIndexPrefix + normalize(user.Email)
+	}
+
+	result, err := createUserScript.Run(ctx, us.client,
+		[]string{UsernameIndexPrefix + normalize(user.Username), emailKey, UserPrefix + user.ID, user.Username, identityKey},
+		user.ID, user.Username, user.Email, user.PasswordHash, user.CreatedAt.Unix(), strings.Join(user.Roles, ","), boolFlag(user.EmailVerified),
+	).Int()
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case 1:
+		return ErrUsernameTaken
+	case 2:
+		return ErrEmailTaken
+	case 3:
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+func boolFlag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// GetUser returns the user with the given ID
+func (us *UserStore) GetUser(ctx context.Context, userID string) (*User, error) {
+	values, err := us.client.HGetAll(ctx, UserPrefix+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	createdAt, _ := strconv.ParseInt(values["created_at"], 10, 64)
+	return &User{
+		ID:            values["user_id"],
+		Username:      values["username"],
+		Email:         values["email"],
+		PasswordHash:  values["password"],
+		EmailVerified: values["email_verified"] == "1",
+		Roles:         parseRoles(values["roles"]),
+		CreatedAt:     time.Unix(createdAt, 0),
+	}, nil
+}
+
+// parseRoles splits the stored roles of a user. Users stored before roles existed get the default role.
+func parseRoles(stored string) []string {
+	if stored == "" {
+		return []string{DefaultUserRole}
+	}
+	return strings.Split(stored, ",")
+}
+
+// SetRoles replaces the roles of a user. Tokens issued before carry the old roles until refreshed.
+func (us *UserStore) SetRoles(ctx context.Context, userID string, roles []string) error {
+	key := UserPrefix + userID
+	n, err := us.client.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return us.client.HSet(ctx, key, "roles", strings.Join(roles, ",")).Err()
+}
+
+// GetUserByUsername returns the user with the given username, migrating it first if
+// it was registered before the user store existed
+func (us *UserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	indexKey := UsernameIndexPrefix + normalize(username)
+	userID, err := us.client.Get(ctx, indexKey).Result()
+	if err == redis.Nil {
+		migrated, migrateErr := us.migrateLegacyUser(ctx, username)
+		if migrateErr != nil {
+			return nil, migrateErr
+		}
+		if !migrated {
+			return nil, ErrUserNotFound
+		}
+		userID, err = us.client.Get(ctx, indexKey).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return us.GetUser(ctx, userID)
+}
+
+// migrateLegacyUserScript moves a user stored as a hash named after the username into the user store
+//
+// KEYS[1] = legacy username hash, KEYS[2] = username index
+// ARGV[1] = user hash prefix, ARGV[2] = username, ARGV[3] = migration time, ARGV[4] = default role
+var migrateLegacyUserScript = redis.NewScript(`
+local legacy = redis.call('HMGET', KEYS[1], 'user_id', 'password')
+if not legacy[1] or not legacy[2] then
+	return 0
+end
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	-- Another user claimed the name, keep the legacy hash for manual review
+	return -1
+end
+
+redis.call('SET', KEYS[2], legacy[1])
+redis.call('HSET', ARGV[1] .. legacy[1],
+	'user_id', legacy[1],
+	'username', ARGV[2],
+	'email', '',
+	'password', legacy[2],
+	'email_verified', '0',
+	'roles', ARGV[4],
+	'created_at', ARGV[3])
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// migrateLegacyUser migrates the bare username hash of a user, if there is one
+func (us *UserStore) migrateLegacyUser(ctx context.Context, username string) (bool, error) {
+	// Keys of the user store and other services all contain a colon, usernames never do
+	if username == "" || strings.Contains(username, ":") {
+		return false, nil
+	}
+
+	result, err := migrateLegacyUserScript.Run(ctx, us.client,
+		[]string{username, UsernameIndexPrefix + normalize(username)},
+		UserPrefix, username, time.Now().Unix(), DefaultUserRole,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("migrate user %s: %w", username, err)
+	}
+
+	switch result {
+	case 1:
+		us.logger.Info("Migrated legacy user", log.String("username", username))
+		return true, nil
+	case -1:
+		us.logger.Warn("Legacy user conflicts with an existing user, not migrated", log.String("username", username))
+	}
+	return false, nil
+}
+
+// MigrateLegacyUsers moves every user stored as a hash named after the username into the user store
+func (us *UserStore) MigrateLegacyUsers(ctx context.Context) (int, error) {
+	migrated := 0
+	iter := us.client.ScanType(ctx, 0, "*", 100, "hash").Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if strings.Contains(key, ":") {
+			continue
+		}
+		ok, err := us.migrateLegacyUser(ctx, key)
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated++
+		}
+	}
+	return migrated, iter.Err()
+}
+
+// CreateVerificationToken stores the hash of an email verification token for a user
+func (us *UserStore) CreateVerificationToken(ctx context.Context, userID string, tokenHash string, ttl time.Duration) error {
+	return us.client.Set(ctx, EmailVerificationPrefix+tokenHash, userID, ttl).Err()
+}
+
+// verifyEmailScript consumes a verification token and marks the email of its user verified
+//
+// KEYS[1] = verification token key
+// ARGV[1] = user hash prefix
+var verifyEmailScript = redis.NewScript(`
+local user_id = redis.call('GET', KEYS[1])
+if not user_id then
+	return false
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', ARGV[1] .. user_id, 'email_verified', '1')
+return user_id
+`)
+
+// VerifyEmail consumes a verification token and returns the ID of the verified user
+func (us *UserStore) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	userID, err := verifyEmailScript.Run(ctx, us.client, []string{EmailVerificationPrefix + tokenHash}, UserPrefix).Text()
+	if err == redis.Nil {
+		return "", ErrInvalidVerificationToken
+	}
+	return userID, err
+}
+
+// recordFailedLoginScript counts a failed login and locks the username out at the limit
+//
+// KEYS[1] = failure counter, KEYS[2] = lock
+// ARGV[1] = max attempts, ARGV[2] = window in milliseconds, ARGV[3] = lockout in milliseconds
+var recordFailedLoginScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if failures >= tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[2], 1, 'PX', ARGV[3])
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+return 0
+`)
+
+// RecordFailedLogin counts a failed login for username and reports whether it is now locked out.
+// Failures are counted for unknown usernames too, so lockouts do not reveal which exist.
+func (us *UserStore) RecordFailedLogin(ctx context.Context, username string, policy LockoutPolicy) (bool, error) {
+	name := normalize(username)
+	locked, err := recordFailedLoginScript.Run(ctx, us.client,
+		[]string{FailedLoginPrefix + name, LoginLockPrefix + name},
+		policy.MaxAttempts, policy.Window.Milliseconds(), policy.Duration.Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	if locked == 1 {
+		us.logger.Warn("Username locked out after failed logins", log.String("username", name))
+	}
+	return locked == 1, nil
+}
+
+// LockedFor returns how long username remains locked out, or 0
+func (us *UserStore) LockedFor(ctx context.Context, username string) (time.Duration, error) {
+	ttl, err := us.client.PTTL(ctx, LoginLockPrefix+normalize(username)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// ResetFailedLogins clears the failed login counter of username after a successful login
+func (us *UserStore) ResetFailedLogins(ctx context.Context, username string) error {
+	return us.client.Del(ctx, FailedLoginPrefix+normalize(username)).Err()
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/jensneuse/abstractlogger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateLegacyUsers(t *testing.T) {
+	server, client := newTestClient(t)
+	us := NewUserStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	// Users registered before the user store were hashes named after the username
+	server.HSet("carol", "user_id", "user-carol", "password", "hash-carol")
+	server.HSet("Dave", "user_id", "user-dave", "password", "hash-dave")
+	// A legacy user whose name was registered again since
+	server.HSet("erin", "user_id", "user-erin-legacy", "password", "hash-erin")
+	require.NoError(t, us.CreateUser(ctx, &User{ID: "user-erin", Username: "Erin", PasswordHash: "hash"}))
+	// Hashes that are not legacy users
+	server.HSet("settings", "theme", "dark")
+	server.HSet("session:abc", "user_id", "user-carol", "password", "not a user")
+
+	migrated, err := us.MigrateLegacyUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	carol, err := us.GetUserByUsername(ctx, "CAROL")
+	require.NoError(t, err)
+	assert.Equal(t, "user-carol", carol.ID)
+	assert.Equal(t, "hash-carol", carol.PasswordHash)
+	assert.Equal(t, []string{DefaultUserRole}, carol.Roles)
+	assert.False(t, server.Exists("carol"))
+
+	dave, err := us.GetUserByUsername(ctx, "dave")
+	require.NoError(t, err)
+	assert.Equal(t, "Dave", dave.Username)
+
+	erin, err := us.GetUserByUsername(ctx, "erin")
+	require.NoError(t, err)
+	assert.Equal(t, "user-erin", erin.ID)
+	assert.True(t, server.Exists("erin"), "the conflicting legacy user is kept for review")
+
+	assert.True(t, server.Exists("settings"))
+	assert.True(t, server.Exists("session:abc"))
+
+	// Migrating again finds nothing left to move
+	migrated, err = us.MigrateLegacyUsers(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, migrated)
+}
+
+func TestGetUserByUsernameMigratesLegacyUser(t *testing.T) {
+	server, client := newTestClient(t)
+	us := NewUserStore(client, log.NoopLogger)
+	ctx := context.Background()
+
+	server.HSet("frank", "user_id", "user-frank", "password", "hash-frank")
+
+	frank, err := us.GetUserByUsername(ctx, "frank")
+	require.NoError(t, err)
+	assert.Equal(t, "user-frank", frank.ID)
+	assert.False(t, server.Exists("frank"))
+
+	// The name is taken once migrated
+	err = us.CreateUser(ctx, &User{ID: "user-2", Username: "Frank", PasswordHash: "hash"})
+	assert.ErrorIs(t, err, ErrUsernameTaken)
+
+	_, err = us.GetUserByUsername(ctx, "grace")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
@@ -0,0 +1,73 @@
+package schemadiff
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ServiceDefinitionQuery asks a federation subgraph for its SDL
+const ServiceDefinitionQuery = `
+	{
+		"query": "query __ApolloGetServiceDefinition__ { _service { sdl } }",
+		"operationName": "__ApolloGetServiceDefinition__",
+		"variables": {}
+	}`
+
+// FetchSDL returns the SDL a running subgraph serves through _service { sdl }.
+// With raw, the URL is fetched with GET and returns the SDL itself.
+func FetchSDL(ctx context.Context, client *http.Client, url string, raw bool) (string, error) {
+	var req *http.Request
+	var err error
+	if raw {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(ServiceDefinitionQuery))
+	}
+	if err != nil {
+		return "", fmt.Errorf("create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if raw {
+		return string(body), nil
+	}
+
+	var result struct {
+		Data struct {
+			Service struct {
+				SDL string `json:"sdl"`
+			} `json:"_service"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors,omitempty"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		return "", fmt.Errorf("response error: %s", result.Errors[0].Message)
+	}
+	if result.Data.Service.SDL == "" {
+		return "", fmt.Errorf("response has no SDL")
+	}
+	return result.Data.Service.SDL, nil
+}
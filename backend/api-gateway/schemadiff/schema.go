@@ -0,0 +1,193 @@
+package schemadiff
+
+import (
+	"strings"
+
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+)
+
+// Kinds of named types, as used in change messages
+const (
+	kindObject    = "type"
+	kindInterface = "interface"
+	kindUnion     = "union"
+	kindEnum      = "enum"
+	kindInput     = "input"
+	kindScalar    = "scalar"
+)
+
+// schema is the part of a schema document that clients depend on
+type schema struct {
+	types      map[string]*typeDef
+	directives map[string]*directiveDef
+}
+
+type typeDef struct {
+	name        string
+	kind        string
+	fields      map[string]field
+	inputFields map[string]inputValue
+	interfaces  map[string]bool
+	members     map[string]bool
+	values      map[string]bool
+}
+
+type field struct {
+	typ        string
+	args       map[string]inputValue
+	deprecated bool
+}
+
+type inputValue struct {
+	typ          string
+	defaultValue string
+}
+
+// required reports whether clients have to provide the value
+func (v inputValue) required() bool {
+	return strings.HasSuffix(v.typ, "!") && v.defaultValue == ""
+}
+
+type directiveDef struct {
+	args map[string]inputValue
+}
+
+func buildSchema(doc *ast.Document) *schema {
+	b := &schemaBuilder{doc: doc, schema: &schema{
+		types:      map[string]*typeDef{},
+		directives: map[string]*directiveDef{},
+	}}
+
+	for _, node := range doc.RootNodes {
+		switch node.Kind {
+		case ast.NodeKindObjectTypeDefinition:
+			b.object(doc.ObjectTypeDefinitions[node.Ref])
+		case ast.NodeKindObjectTypeExtension:
+			b.object(doc.ObjectTypeExtensions[node.Ref].ObjectTypeDefinition)
+		case ast.NodeKindInterfaceTypeDefinition:
+			b.iface(doc.InterfaceTypeDefinitions[node.Ref])
+		case ast.NodeKindInterfaceTypeExtension:
+			b.iface(doc.InterfaceTypeExtensions[node.Ref].InterfaceTypeDefinition)
+		case ast.NodeKindUnionTypeDefinition:
+			b.union(doc.UnionTypeDefinitions[node.Ref])
+		case ast.NodeKindUnionTypeExtension:
+			b.union(doc.UnionTypeExtensions[node.Ref].UnionTypeDefinition)
+		case ast.NodeKindEnumTypeDefinition:
+			b.enum(doc.EnumTypeDefinitions[node.Ref])
+		case ast.NodeKindEnumTypeExtension:
+			b.enum(doc.EnumTypeExtensions[node.Ref].EnumTypeDefinition)
+		case ast.NodeKindInputObjectTypeDefinition:
+			b.input(doc.InputObjectTypeDefinitions[node.Ref])
+		case ast.NodeKindInputObjectTypeExtension:
+			b.input(doc.InputObjectTypeExtensions[node.Ref].InputObjectTypeDefinition)
+		case ast.NodeKindScalarTypeDefinition:
+			b.typeDef(kindScalar, doc.Input.ByteSliceString(doc.ScalarTypeDefinitions[node.Ref].Name))
+		case ast.NodeKindScalarTypeExtension:
+			b.typeDef(kindScalar, doc.Input.ByteSliceString(doc.ScalarTypeExtensions[node.Ref].Name))
+		case ast.NodeKindDirectiveDefinition:
+			definition := doc.DirectiveDefinitions[node.Ref]
+			b.schema.directives[doc.Input.ByteSliceString(definition.Name)] = &directiveDef{
+				args: b.inputValues(definition.ArgumentsDefinition.Refs),
+			}
+		}
+	}
+	return b.schema
+}
+
+type schemaBuilder struct {
+	doc    *ast.Document
+	schema *schema
+}
+
+// typeDef returns the type with the given name, extensions add to the type they extend
+func (b *schemaBuilder) typeDef(kind, name string) *typeDef {
+	if t, ok := b.schema.types[name]; ok {
+		return t
+	}
+	t := &typeDef{
+		name:        name,
+		kind:        kind,
+		fields:      map[string]field{},
+		inputFields: map[string]inputValue{},
+		interfaces:  map[string]bool{},
+		members:     map[string]bool{},
+		values:      map[string]bool{},
+	}
+	b.schema.types[name] = t
+	return t
+}
+
+func (b *schemaBuilder) object(definition ast.ObjectTypeDefinition) {
+	t := b.typeDef(kindObject, b.doc.Input.ByteSliceString(definition.Name))
+	b.fields(t, definition.FieldsDefinition.Refs)
+	for _, ref := range definition.ImplementsInterfaces.Refs {
+		t.interfaces[b.doc.TypeNameString(ref)] = true
+	}
+}
+
+func (b *schemaBuilder) iface(definition ast.InterfaceTypeDefinition) {
+	t := b.typeDef(kindInterface, b.doc.Input.ByteSliceString(definition.Name))
+	b.fields(t, definition.FieldsDefinition.Refs)
+	for _, ref := range definition.ImplementsInterfaces.Refs {
+		t.interfaces[b.doc.TypeNameString(ref)] = true
+	}
+}
+
+func (b *schemaBuilder) union(definition ast.UnionTypeDefinition) {
+	t := b.typeDef(kindUnion, b.doc.Input.ByteSliceString(definition.Name))
+	for _, ref := range definition.UnionMemberTypes.Refs {
+		t.members[b.doc.TypeNameString(ref)] = true
+	}
+}
+
+func (b *schemaBuilder) enum(definition ast.EnumTypeDefinition) {
+	t := b.typeDef(kindEnum, b.doc.Input.ByteSliceString(definition.Name))
+	for _, ref := range definition.EnumValuesDefinition.Refs {
+		t.values[b.doc.EnumValueDefinitionNameString(ref)] = true
+	}
+}
+
+func (b *schemaBuilder) input(definition ast.InputObjectTypeDefinition) {
+	t := b.typeDef(kindInput, b.doc.Input.ByteSliceString(definition.Name))
+	for name, value := range b.inputValues(definition.InputFieldsDefinition.Refs) {
+		t.inputFields[name] = value
+	}
+}
+
+func (b *schemaBuilder) fields(t *typeDef, refs []int) {
+	for _, ref := range refs {
+		name := b.doc.FieldDefinitionNameString(ref)
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		_, deprecated := b.doc.FieldDefinitionDirectiveByName(ref, []byte("deprecated"))
+		t.fields[name] = field{
+			typ:        b.printType(b.doc.FieldDefinitionType(ref)),
+			args:       b.inputValues(b.doc.FieldDefinitionArgumentsDefinitions(ref)),
+			deprecated: deprecated,
+		}
+	}
+}
+
+func (b *schemaBuilder) inputValues(refs []int) map[string]inputValue {
+	values := make(map[string]inputValue, len(refs))
+	for _, ref := range refs {
+		value := inputValue{typ: b.printType(b.doc.InputValueDefinitionType(ref))}
+		if b.doc.InputValueDefinitionHasDefaultValue(ref) {
+			printed, err := b.doc.PrintValueBytes(b.doc.InputValueDefinitionDefaultValue(ref), nil)
+			if err == nil {
+				value.defaultValue = string(printed)
+			}
+		}
+		values[b.doc.InputValueDefinitionNameString(ref)] = value
+	}
+	return values
+}
+
+func (b *schemaBuilder) printType(ref int) string {
+	printed, err := b.doc.PrintTypeBytes(ref, nil)
+	if err != nil {
+		return b.doc.ResolveTypeNameString(ref)
+	}
+	return string(printed)
+}
@@ -0,0 +1,267 @@
+package schemadiff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/ast"
+	"github.com/wundergraph/graphql-go-tools/v2/pkg/astparser"
+)
+
+// Criticality is how a change affects existing clients
+type Criticality string
+
+const (
+	// Breaking changes fail operations that were valid before
+	Breaking Criticality = "BREAKING"
+	// Dangerous changes keep operations valid but may change what clients get back
+	Dangerous Criticality = "DANGEROUS"
+	// Safe changes cannot affect existing clients
+	Safe Criticality = "SAFE"
+)
+
+// Change is a single difference between two schemas
+type Change struct {
+	Criticality Criticality `json:"criticality"`
+	// Path of the changed element, e.g. Order.status or Query.orders(userId:)
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s: %s", c.Criticality, c.Path, c.Message)
+}
+
+// BreakingChanges returns the breaking changes of a diff
+func BreakingChanges(changes []Change) []Change {
+	var breaking []Change
+	for _, change := range changes {
+		if change.Criticality == Breaking {
+			breaking = append(breaking, change)
+		}
+	}
+	return breaking
+}
+
+// DiffSDL parses two schemas and compares them
+func DiffSDL(oldSDL, newSDL string) ([]Change, error) {
+	oldDoc, report := astparser.ParseGraphqlDocumentString(oldSDL)
+	if report.HasErrors() {
+		return nil, fmt.Errorf("parse old schema: %s", report.Error())
+	}
+	newDoc, report := astparser.ParseGraphqlDocumentString(newSDL)
+	if report.HasErrors() {
+		return nil, fmt.Errorf("parse new schema: %s", report.Error())
+	}
+	return Diff(&oldDoc, &newDoc), nil
+}
+
+// Diff compares two schema documents. Extensions are merged into the types they extend,
+// so subgraph SDLs can be compared as well as composed supergraphs.
+// Changes are sorted by path.
+func Diff(oldDoc, newDoc *ast.Document) []Change {
+	d := &differ{}
+	d.diffSchemas(buildSchema(oldDoc), buildSchema(newDoc))
+
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Path < d.changes[j].Path
+	})
+	return d.changes
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(criticality Criticality, path string, format string, args ...interface{}) {
+	d.changes = append(d.changes, Change{Criticality: criticality, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *differ) diffSchemas(oldSchema, newSchema *schema) {
+	for _, name := range sortedKeys(oldSchema.types) {
+		oldType := oldSchema.types[name]
+		newType, ok := newSchema.types[name]
+		switch {
+		case !ok:
+			d.add(Breaking, name, "%s %s was removed", oldType.kind, name)
+		case oldType.kind != newType.kind:
+			d.add(Breaking, name, "%s changed from %s to %s", name, oldType.kind, newType.kind)
+		default:
+			d.diffTypes(oldType, newType)
+		}
+	}
+	for _, name := range sortedKeys(newSchema.types) {
+		if _, ok := oldSchema.types[name]; !ok {
+			d.add(Safe, name, "%s %s was added", newSchema.types[name].kind, name)
+		}
+	}
+
+	for _, name := range sortedKeys(oldSchema.directives) {
+		path := "@" + name
+		newDirective, ok := newSchema.directives[name]
+		if !ok {
+			d.add(Breaking, path, "directive %s was removed", path)
+			continue
+		}
+		d.diffInputValues(path, oldSchema.directives[name].args, newDirective.args, "argument")
+	}
+	for _, name := range sortedKeys(newSchema.directives) {
+		if _, ok := oldSchema.directives[name]; !ok {
+			d.add(Safe, "@"+name, "directive @%s was added", name)
+		}
+	}
+}
+
+func (d *differ) diffTypes(oldType, newType *typeDef) {
+	name := oldType.name
+
+	d.diffSet(name, oldType.interfaces, newType.interfaces, "interface")
+	d.diffSet(name, oldType.members, newType.members, "union member")
+	d.diffSet(name, oldType.values, newType.values, "enum value")
+
+	for _, fieldName := range sortedKeys(oldType.fields) {
+		path := name + "." + fieldName
+		oldField := oldType.fields[fieldName]
+		newField, ok := newType.fields[fieldName]
+		if !ok {
+			if oldField.deprecated {
+				d.add(Breaking, path, "deprecated field %s was removed", path)
+			} else {
+				d.add(Breaking, path, "field %s was removed", path)
+			}
+			continue
+		}
+		if oldField.typ != newField.typ {
+			if safeOutputChange(oldField.typ, newField.typ) {
+				d.add(Safe, path, "field %s changed type from %s to %s", path, oldField.typ, newField.typ)
+			} else {
+				d.add(Breaking, path, "field %s changed type from %s to %s", path, oldField.typ, newField.typ)
+			}
+		}
+		if !oldField.deprecated && newField.deprecated {
+			d.add(Safe, path, "field %s was deprecated", path)
+		}
+		d.diffInputValues(path, oldField.args, newField.args, "argument")
+	}
+	for _, fieldName := range sortedKeys(newType.fields) {
+		if _, ok := oldType.fields[fieldName]; !ok {
+			d.add(Safe, name+"."+fieldName, "field %s.%s was added", name, fieldName)
+		}
+	}
+
+	d.diffInputValues(name, oldType.inputFields, newType.inputFields, "input field")
+}
+
+// diffSet compares interfaces, union members or enum values. Adding one is dangerous,
+// clients switching over them may not handle the new case.
+func (d *differ) diffSet(name string, oldSet, newSet map[string]bool, what string) {
+	for _, value := range sortedKeys(oldSet) {
+		if _, ok := newSet[value]; !ok {
+			d.add(Breaking, name+"."+value, "%s %s was removed from %s", what, value, name)
+		}
+	}
+	for _, value := range sortedKeys(newSet) {
+		if _, ok := oldSet[value]; !ok {
+			d.add(Dangerous, name+"."+value, "%s %s was added to %s", what, value, name)
+		}
+	}
+}
+
+// diffInputValues compares arguments of a field or directive, or fields of an input type
+func (d *differ) diffInputValues(parent string, oldValues, newValues map[string]inputValue, what string) {
+	path := func(name string) string {
+		if what == "argument" {
+			return parent + "(" + name + ":)"
+		}
+		return parent + "." + name
+	}
+
+	for _, name := range sortedKeys(oldValues) {
+		oldValue := oldValues[name]
+		newValue, ok := newValues[name]
+		if !ok {
+			d.add(Breaking, path(name), "%s %s was removed from %s", what, name, parent)
+			continue
+		}
+		if oldValue.typ != newValue.typ {
+			if safeInputChange(oldValue.typ, newValue.typ) {
+				d.add(Safe, path(name), "%s %s changed type from %s to %s", what, name, oldValue.typ, newValue.typ)
+			} else {
+				d.add(Breaking, path(name), "%s %s changed type from %s to %s", what, name, oldValue.typ, newValue.typ)
+			}
+		}
+		if oldValue.defaultValue != newValue.defaultValue {
+			d.add(Dangerous, path(name), "default value of %s %s changed from %q to %q", what, name, oldValue.defaultValue, newValue.defaultValue)
+		}
+	}
+	for _, name := range sortedKeys(newValues) {
+		if _, ok := oldValues[name]; ok {
+			continue
+		}
+		newValue := newValues[name]
+		if newValue.required() {
+			d.add(Breaking, path(name), "required %s %s was added to %s", what, name, parent)
+		} else {
+			d.add(Dangerous, path(name), "optional %s %s was added to %s", what, name, parent)
+		}
+	}
+}
+
+// safeOutputChange reports whether clients reading a field of type oldType can read newType,
+// which holds when newType only adds non-null guarantees
+func safeOutputChange(oldType, newType string) bool {
+	if inner, ok := strings.CutSuffix(newType, "!"); ok {
+		if oldInner, ok := strings.CutSuffix(oldType, "!"); ok {
+			return safeOutputChange(oldInner, inner)
+		}
+		return safeOutputChange(oldType, inner)
+	}
+	if strings.HasSuffix(oldType, "!") {
+		return false
+	}
+	if oldInner, ok := listOf(oldType); ok {
+		if inner, ok := listOf(newType); ok {
+			return safeOutputChange(oldInner, inner)
+		}
+		return false
+	}
+	return oldType == newType
+}
+
+// safeInputChange reports whether values clients send for oldType are accepted as newType,
+// which holds when newType only drops non-null requirements
+func safeInputChange(oldType, newType string) bool {
+	if oldInner, ok := strings.CutSuffix(oldType, "!"); ok {
+		if inner, ok := strings.CutSuffix(newType, "!"); ok {
+			return safeInputChange(oldInner, inner)
+		}
+		return safeInputChange(oldInner, newType)
+	}
+	if strings.HasSuffix(newType, "!") {
+		return false
+	}
+	if oldInner, ok := listOf(oldType); ok {
+		if inner, ok := listOf(newType); ok {
+			return safeInputChange(oldInner, inner)
+		}
+		return false
+	}
+	return oldType == newType
+}
+
+func listOf(typ string) (string, bool) {
+	if strings.HasPrefix(typ, "[") && strings.HasSuffix(typ, "]") {
+		return typ[1 : len(typ)-1], true
+	}
+	return "", false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
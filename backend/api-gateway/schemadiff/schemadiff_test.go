@@ -0,0 +1,279 @@
+package schemadiff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const baseSchema = `
+	type Query {
+		orders(userId: ID!, first: Int): [Order!]!
+	}
+
+	type Order {
+		id: ID!
+		status: OrderStatus!
+		total: Float
+		note: String
+	}
+
+	enum OrderStatus {
+		PENDING
+		COMPLETED
+		CANCELLED
+	}
+
+	input OrderInput {
+		productId: ID!
+		quantity: Int
+	}
+`
+
+func TestDiffSDL(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		changes []Change
+	}{
+		{
+			name: "no changes",
+			old:  baseSchema,
+			new:  baseSchema,
+		},
+		{
+			name: "field removed",
+			old:  `type Order { id: ID! note: String }`,
+			new:  `type Order { id: ID! }`,
+			changes: []Change{
+				{Breaking, "Order.note", "field Order.note was removed"},
+			},
+		},
+		{
+			name: "deprecated field removed",
+			old:  `type Order { id: ID! note: String @deprecated(reason: "unused") }`,
+			new:  `type Order { id: ID! }`,
+			changes: []Change{
+				{Breaking, "Order.note", "deprecated field Order.note was removed"},
+			},
+		},
+		{
+			name: "field added",
+			old:  `type Order { id: ID! }`,
+			new:  `type Order { id: ID! note: String }`,
+			changes: []Change{
+				{Safe, "Order.note", "field Order.note was added"},
+			},
+		},
+		{
+			name: "field type changed",
+			old:  `type Order { total: Float }`,
+			new:  `type Order { total: String }`,
+			changes: []Change{
+				{Breaking, "Order.total", "field Order.total changed type from Float to String"},
+			},
+		},
+		{
+			name: "field changed to a list",
+			old:  `type Order { note: String }`,
+			new:  `type Order { note: [String] }`,
+			changes: []Change{
+				{Breaking, "Order.note", "field Order.note changed type from String to [String]"},
+			},
+		},
+		{
+			name: "field made non-null",
+			old:  `type Order { total: Float }`,
+			new:  `type Order { total: Float! }`,
+			changes: []Change{
+				{Safe, "Order.total", "field Order.total changed type from Float to Float!"},
+			},
+		},
+		{
+			name: "list items made non-null",
+			old:  `type Query { orders: [ID] }`,
+			new:  `type Query { orders: [ID!]! }`,
+			changes: []Change{
+				{Safe, "Query.orders", "field Query.orders changed type from [ID] to [ID!]!"},
+			},
+		},
+		{
+			name: "field made nullable",
+			old:  `type Order { status: String! }`,
+			new:  `type Order { status: String }`,
+			changes: []Change{
+				{Breaking, "Order.status", "field Order.status changed type from String! to String"},
+			},
+		},
+		{
+			name: "list items made nullable",
+			old:  `type Query { orders: [ID!]! }`,
+			new:  `type Query { orders: [ID]! }`,
+			changes: []Change{
+				{Breaking, "Query.orders", "field Query.orders changed type from [ID!]! to [ID]!"},
+			},
+		},
+		{
+			name: "argument made nullable",
+			old:  `type Query { orders(userId: ID!): [ID] }`,
+			new:  `type Query { orders(userId: ID): [ID] }`,
+			changes: []Change{
+				{Safe, "Query.orders(userId:)", "argument userId changed type from ID! to ID"},
+			},
+		},
+		{
+			name: "argument made non-null",
+			old:  `type Query { orders(userId: ID): [ID] }`,
+			new:  `type Query { orders(userId: ID!): [ID] }`,
+			changes: []Change{
+				{Breaking, "Query.orders(userId:)", "argument userId changed type from ID to ID!"},
+			},
+		},
+		{
+			name: "input field made non-null",
+			old:  `input OrderInput { quantity: Int }`,
+			new:  `input OrderInput { quantity: Int! }`,
+			changes: []Change{
+				{Breaking, "OrderInput.quantity", "input field quantity changed type from Int to Int!"},
+			},
+		},
+		{
+			name: "required argument added",
+			old:  `type Query { orders: [ID] }`,
+			new:  `type Query { orders(userId: ID!): [ID] }`,
+			changes: []Change{
+				{Breaking, "Query.orders(userId:)", "required argument userId was added to Query.orders"},
+			},
+		},
+		{
+			name: "optional argument added",
+			old:  `type Query { orders: [ID] }`,
+			new:  `type Query { orders(first: Int): [ID] }`,
+			changes: []Change{
+				{Dangerous, "Query.orders(first:)", "optional argument first was added to Query.orders"},
+			},
+		},
+		{
+			name: "non-null argument with a default added",
+			old:  `type Query { orders: [ID] }`,
+			new:  `type Query { orders(first: Int! = 10): [ID] }`,
+			changes: []Change{
+				{Dangerous, "Query.orders(first:)", "optional argument first was added to Query.orders"},
+			},
+		},
+		{
+			name: "argument removed",
+			old:  `type Query { orders(first: Int): [ID] }`,
+			new:  `type Query { orders: [ID] }`,
+			changes: []Change{
+				{Breaking, "Query.orders(first:)", "argument first was removed from Query.orders"},
+			},
+		},
+		{
+			name: "required input field added",
+			old:  `input OrderInput { productId: ID! }`,
+			new:  `input OrderInput { productId: ID! quantity: Int! }`,
+			changes: []Change{
+				{Breaking, "OrderInput.quantity", "required input field quantity was added to OrderInput"},
+			},
+		},
+		{
+			name: "enum value removed",
+			old:  `enum OrderStatus { PENDING COMPLETED CANCELLED }`,
+			new:  `enum OrderStatus { PENDING COMPLETED }`,
+			changes: []Change{
+				{Breaking, "OrderStatus.CANCELLED", "enum value CANCELLED was removed from OrderStatus"},
+			},
+		},
+		{
+			name: "enum value added",
+			old:  `enum OrderStatus { PENDING COMPLETED }`,
+			new:  `enum OrderStatus { PENDING COMPLETED CANCELLED }`,
+			changes: []Change{
+				{Dangerous, "OrderStatus.CANCELLED", "enum value CANCELLED was added to OrderStatus"},
+			},
+		},
+		{
+			name: "type removed",
+			old:  baseSchema,
+			new:  `type Query { orders(userId: ID!, first: Int): [ID] } enum OrderStatus { PENDING COMPLETED CANCELLED } input OrderInput { productId: ID! quantity: Int }`,
+			changes: []Change{
+				{Breaking, "Order", "type Order was removed"},
+				{Breaking, "Query.orders", "field Query.orders changed type from [Order!]! to [ID]"},
+			},
+		},
+		{
+			name: "type kind changed",
+			old:  `type Money { amount: Float }`,
+			new:  `scalar Money`,
+			changes: []Change{
+				{Breaking, "Money", "Money changed from type to scalar"},
+			},
+		},
+		{
+			name: "extension merged into the type it extends",
+			old:  `type Order { id: ID! note: String }`,
+			new:  `type Order { id: ID! } extend type Order { note: String }`,
+		},
+		{
+			name: "changes sorted by path",
+			old:  `type Order { id: ID! total: Float } enum OrderStatus { PENDING COMPLETED }`,
+			new:  `type Order { id: ID } enum OrderStatus { PENDING }`,
+			changes: []Change{
+				{Breaking, "Order.id", "field Order.id changed type from ID! to ID"},
+				{Breaking, "Order.total", "field Order.total was removed"},
+				{Breaking, "OrderStatus.COMPLETED", "enum value COMPLETED was removed from OrderStatus"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffSDL(tt.old, tt.new)
+			require.NoError(t, err)
+			assert.Equal(t, tt.changes, changes)
+		})
+	}
+}
+
+func TestDiffSDLRejectsInvalidSchema(t *testing.T) {
+	_, err := DiffSDL(`type Order {`, baseSchema)
+	assert.ErrorContains(t, err, "parse old schema")
+
+	_, err = DiffSDL(baseSchema, `type Order {`)
+	assert.ErrorContains(t, err, "parse new schema")
+}
+
+func TestBreakingChanges(t *testing.T) {
+	changes, err := DiffSDL(baseSchema, `
+		type Query {
+			orders(userId: ID!, first: Int, after: String): [Order!]!
+		}
+
+		type Order {
+			id: ID!
+			status: OrderStatus!
+			total: Float!
+		}
+
+		enum OrderStatus {
+			PENDING
+			COMPLETED
+			CANCELLED
+			REFUNDED
+		}
+
+		input OrderInput {
+			productId: ID!
+			quantity: Int
+		}
+	`)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+
+	assert.Equal(t, []Change{
+		{Breaking, "Order.note", "field Order.note was removed"},
+	}, BreakingChanges(changes))
+	assert.Empty(t, BreakingChanges(nil))
+}
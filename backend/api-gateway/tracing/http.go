@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartRequest starts the span of a middleware handling r, and returns r with the span in
+// its context. The span covers the middlewares and handlers after it.
+func StartRequest(r *http.Request, name string) (*http.Request, trace.Span) {
+	ctx, span := Tracer().Start(r.Context(), name)
+	return r.WithContext(ctx), span
+}
+
+// Handler traces the requests served by next, continuing the trace of the caller. A
+// WebSocket connection would be a single span as long as the connection, so upgrades are
+// not traced.
+func Handler(next http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(next, operation,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// Transport traces the requests made through base, and sends the trace context along so
+// the servers continue the trace
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Host + r.URL.Path
+		}),
+	)
+}
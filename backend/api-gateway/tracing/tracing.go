@@ -0,0 +1,13 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "api-gateway"
+
+// Tracer returns the tracer the gateway instruments its own code with
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
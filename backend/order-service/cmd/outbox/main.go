@@ -0,0 +1,111 @@
+// Command outbox replays events of the transactional outbox, which the relay of a running
+// order service then publishes again:
+//
+//	go run ./cmd/outbox replay -failed
+//	go run ./cmd/outbox replay -since 2024-05-01T00:00:00Z -type order_placed
+//	go run ./cmd/outbox replay -id 3f2b...,9c1d... -dry-run
+//
+// It connects to the database of the order service configured by its environment.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"orderservice/internal/db"
+	"orderservice/internal/outbox"
+	"orderservice/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "replay" {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	ids := flags.String("id", "", "Comma separated IDs of the messages to replay")
+	since := flags.String("since", "", "Replay messages written at or after this RFC 3339 time")
+	until := flags.String("until", "", "Replay messages written before this RFC 3339 time")
+	detailType := flags.String("type", "", "Replay messages of this event detail type only")
+	failed := flags.Bool("failed", false, "Replay messages the relay gave up on")
+	dryRun := flags.Bool("dry-run", false, "Count the matching messages without replaying them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [flags]\n\nAt least one of -id, -since, -until or -failed is required.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[2:])
+
+	filter, err := parseFilter(*ids, *since, *until, *detailType, *failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid flags: %v\n", err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	utils.LoadEnvFile(ctx)
+	pool, err := db.NewDBPool(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to the database: %v\n", err)
+		os.Exit(1)
+	}
+	defer pool.Close()
+
+	if *dryRun {
+		count, err := outbox.Count(ctx, pool.DB, filter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d messages would be replayed\n", count)
+		return
+	}
+
+	count, err := outbox.Replay(ctx, pool.DB, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d messages queued for replay\n", count)
+}
+
+func parseFilter(ids, since, until, detailType string, failed bool) (outbox.ReplayFilter, error) {
+	filter := outbox.ReplayFilter{DetailType: detailType, FailedOnly: failed}
+
+	if ids != "" {
+		for _, raw := range strings.Split(ids, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(raw))
+			if err != nil {
+				return filter, fmt.Errorf("invalid message id %q: %w", raw, err)
+			}
+			filter.IDs = append(filter.IDs, id)
+		}
+	}
+
+	var err error
+	if since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+
+	if len(filter.IDs) == 0 && filter.Since.IsZero() && filter.Until.IsZero() && !filter.FailedOnly {
+		return filter, errors.New("no messages selected")
+	}
+	return filter, nil
+}
@@ -2,22 +2,29 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/subtle"
 	"log"
 	"net/http"
 	"orderservice/graph"
+	"orderservice/internal/auth"
 	"orderservice/internal/db"
 	eventemitter "orderservice/internal/event_emitter"
 	eventhandler "orderservice/internal/event_handler"
+	"orderservice/internal/inbox"
+	"orderservice/internal/metrics"
+	"orderservice/internal/outbox"
+	"orderservice/internal/pubsub"
 	"orderservice/internal/repository"
+	"orderservice/internal/saga"
 	"orderservice/internal/services"
 	"orderservice/internal/sqs"
+	"orderservice/internal/tracing"
 	"orderservice/internal/utils"
 	"orderservice/internal/validator"
 	"orderservice/pkg/enums"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -27,11 +34,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	eb "github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/demo-micro/backend/pkg/telemetry"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"gorm.io/gorm"
 )
 
 // Helper function to initialize services and repositories
-func initializeServices(ctx context.Context, dbConn *gorm.DB) (services.OrderService, *validator.Validator) {
+func initializeServices(ctx context.Context, dbConn *gorm.DB, sagaConfig saga.Config, statusChanges *pubsub.Broker) (services.OrderService, *validator.Validator, *outbox.Relay) {
 	// Create repositories
 	ordersRepo := repository.NewOrderRepository(dbConn)
 
@@ -52,12 +62,14 @@ func initializeServices(ctx context.Context, dbConn *gorm.DB) (services.OrderSer
 
 	emitter := eventemitter.NewEventBridgeEmitterWithClient(ebClient)
 
-	orderService := services.NewOrderService(ordersRepo, emitter, dbConn)
+	// The service writes its events to the outbox, the relay publishes them
+	orderService := services.NewOrderService(ordersRepo, dbConn, sagaConfig, statusChanges)
+	relay := outbox.NewRelay(dbConn, emitter, outbox.ConfigFromEnv())
 
 	// Create validator
 	v := validator.New()
 
-	return orderService, v
+	return orderService, v, relay
 }
 
 // Set up the GraphQL handler
@@ -67,9 +79,19 @@ func graphqlHandler(orderService services.OrderService) http.HandlerFunc {
 	h.AddTransport(transport.POST{})
 	h.AddTransport(transport.Options{})
 	h.AddTransport(transport.GET{})
+	// Subscriptions, over graphql-ws and graphql-transport-ws
+	h.AddTransport(transport.Websocket{KeepAlivePingInterval: 10 * time.Second})
 	h.Use(extension.Introspection{})
+	h.Use(tracing.GraphQL{})
+	h.Use(metrics.GraphQL{})
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Subscriptions last as long as their connection
+		if isWebsocketUpgrade(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(TIMEOUT)*time.Second)
 		defer cancel()
 
@@ -79,6 +101,10 @@ func graphqlHandler(orderService services.OrderService) http.HandlerFunc {
 	}
 }
 
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
 // setup initializes the services, event handlers, and other components of the application
 func setup(ctx context.Context) (services.OrderService, *validator.Validator, *eventhandler.HandlerRegistry, *eventhandler.EventHandler, func()) {
 	dbPool, err := db.NewDBPool(ctx)
@@ -87,12 +113,23 @@ func setup(ctx context.Context) (services.OrderService, *validator.Validator, *e
 	}
 	// Ensure the pool is closed when the app shuts down (call site handles lifecycle)
 
-	orderService, v := initializeServices(ctx, dbPool.DB)
+	sagaConfig := saga.ConfigFromEnv()
+	// Every replica hears the status changes made by the others through the database
+	statusChanges := pubsub.NewBroker()
+	go statusChanges.Listen(ctx, dbPool.DB)
+	orderService, v, relay := initializeServices(ctx, dbPool.DB, sagaConfig, statusChanges)
+	go relay.Run(ctx)
+	go inbox.RunCleanup(ctx, dbPool.DB, inbox.CleanupConfigFromEnv())
+	go saga.RunTimeouts(ctx, sagaConfig, orderService.ExpireSagas)
 
 	registry := eventhandler.NewHandlerRegistry()
 	eh := eventhandler.NewEventHandler(registry, v)
 
+	// Every event of the saga of an order, see saga.Next
 	registry.Register(enums.EVENT_TYPE.InventoryReserved.String(), eventhandler.NewInventoryReservedHandler(orderService, v))
+	registry.Register(enums.EVENT_TYPE.InventoryReservationFailed.String(), eventhandler.NewInventoryReservationFailedHandler(orderService, v))
+	registry.Register(enums.EVENT_TYPE.NotificationSentSuccess.String(), eventhandler.NewNotificationSentHandler(orderService, v))
+	registry.Register(enums.EVENT_TYPE.NotificationSentFailed.String(), eventhandler.NewNotificationSentFailedHandler(orderService, v))
 
 	closer := func() {
 		if err := dbPool.Close(); err != nil {
@@ -103,53 +140,80 @@ func setup(ctx context.Context) (services.OrderService, *validator.Validator, *e
 	return orderService, v, registry, eh, closer
 }
 
-func setUpLogger() {
-	// Get the current date
-	currentDate := time.Now().Format("2006-01-02") // Format: YYYY-MM-DD
-	logDir := "../../logs"
-	logFilePath := filepath.Join(logDir, currentDate+".log")
-
-	// Create the logs directory if it does not exist
-	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
-		log.Fatal(err)
-	}
-
-	// Open the log file
-	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
+// adminMiddleware protects the /admin endpoints with the ADMIN_TOKEN bearer token
+func adminMiddleware(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
 
-	// Set the log output to the file
-	log.SetOutput(file)
+		next.ServeHTTP(w, r)
+	})
 }
 
 func main() {
-	setUpLogger()
-
 	ctx, cancel := context.WithCancel(context.Background())
 	utils.LoadEnvFile(ctx)
 
 	defer cancel()
 
+	logger, err := logging.New(logging.ConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logger.Close()
+	defer logging.SetGlobal(logger)()
+	utils.ValidateEnvVars(logger.Logger)
+
+	shutdownTracing, err := telemetry.Init(ctx, "order-service")
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
 	orderService, _, _, eh, closeDB := setup(ctx)
 
-	ordersQueueURl := utils.GetEnv("ORDERS_QUEUE_URL", "")
-	maxConsumer := utils.GetEnv("MAX_CONSUMER", 5)
-
-	go sqs.NewSQSConsumer(ctx, *eh, ordersQueueURl, maxConsumer)
+	sqsConfig := sqs.ConfigFromEnv()
+	if err := sqsConfig.Validate(); err != nil {
+		log.Fatalf("Invalid SQS configuration: %v", err)
+	}
+	sqsClient, err := sqs.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create SQS client: %v", err)
+	}
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		sqs.NewSQSConsumer(sqsClient, eh, sqsConfig).Run(ctx)
+	}()
 
+	// The gateway forwards the authenticated user in signed identity headers, and the
+	// trace context its requests are part of, which the access log line of a request
+	// carries. A subscription would be a single span as long as its connection, so
+	// upgrades are not traced.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.Handle("/admin/log-level", adminMiddleware(logger.Level(), adminToken))
+	} else {
+		log.Println("ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+	mux.Handle("/", otelhttp.NewHandler(
+		logging.Middleware(auth.Middleware([]byte(os.Getenv("SUBGRAPH_SIGNING_SECRET")))(graphqlHandler(orderService)), logger.Logger),
+		"order-service",
+		otelhttp.WithFilter(func(r *http.Request) bool { return !isWebsocketUpgrade(r) }),
+	))
 	srv := &http.Server{
 		Addr:    ":" + utils.GetEnv("PORT", "9001"),
-		Handler: http.HandlerFunc(graphqlHandler(orderService)),
+		Handler: mux,
 	}
 
 	go func() {
-		fmt.Printf("Starting server on %s\n", srv.Addr)
+		log.Printf("Starting server on %s", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("HTTP server error: %v", err)
 		}
@@ -163,6 +227,15 @@ func main() {
 	defer shutdownCancel()
 	_ = srv.Shutdown(shutdownCtx)
 	cancel() // this stops the SQS consumer
+	// The messages being handled finish and are acknowledged before the DB is closed
+	select {
+	case <-consumerDone:
+	case <-shutdownCtx.Done():
+		log.Println("SQS consumer did not stop in time")
+	}
 	closeDB()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("error flushing traces: %v", err)
+	}
 
 }
@@ -0,0 +1,70 @@
+package graph
+
+import (
+	"context"
+	"orderservice/internal/auth"
+
+	"github.com/google/uuid"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// Error codes returned when the caller may not access a resolver
+const (
+	UnauthenticatedCode = "UNAUTHENTICATED"
+	ForbiddenCode       = "FORBIDDEN"
+)
+
+// AdminScope grants access to the orders of every user, see @requiresScopes in schema.graphqls
+const AdminScope = "orders:admin"
+
+func unauthenticatedError() error {
+	return &gqlerror.Error{
+		Message:    "authentication required",
+		Extensions: map[string]interface{}{"code": UnauthenticatedCode},
+	}
+}
+
+func forbiddenError() error {
+	return &gqlerror.Error{
+		Message:    "not allowed to access this resource",
+		Extensions: map[string]interface{}{"code": ForbiddenCode},
+	}
+}
+
+// requireUser ensures the request is made for the user with the given id, or by support staff.
+// API key clients have no user, so they need the admin scope.
+func requireUser(ctx context.Context, userID uuid.UUID) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok {
+		return unauthenticatedError()
+	}
+	if identity.UserID != userID && !identity.HasScope(AdminScope) {
+		return forbiddenError()
+	}
+	return nil
+}
+
+// requireOrderOwner ensures the request is made by the owner of the order
+func (r *Resolver) requireOrderOwner(ctx context.Context, orderID uuid.UUID) error {
+	if _, ok := auth.FromContext(ctx); !ok {
+		return unauthenticatedError()
+	}
+	order, err := r.OrderService.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	return requireUser(ctx, order.UserID)
+}
+
+// requireScope ensures the request is made with a scope, checked here as well as by
+// @requiresScopes in the gateway
+func requireScope(ctx context.Context, scope string) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok {
+		return unauthenticatedError()
+	}
+	if !identity.HasScope(scope) {
+		return forbiddenError()
+	}
+	return nil
+}
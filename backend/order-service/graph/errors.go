@@ -0,0 +1,22 @@
+package graph
+
+import (
+	"errors"
+	"orderservice/internal/services"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// OrderNotCancellableCode is returned when cancelling an order that failed or was cancelled already
+const OrderNotCancellableCode = "ORDER_NOT_CANCELLABLE"
+
+// serviceError returns the error the caller sees for an error of the order service
+func serviceError(err error) error {
+	if errors.Is(err, services.ErrOrderNotCancellable) {
+		return &gqlerror.Error{
+			Message:    services.ErrOrderNotCancellable.Error(),
+			Extensions: map[string]interface{}{"code": OrderNotCancellableCode},
+		}
+	}
+	return err
+}
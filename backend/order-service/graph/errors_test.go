@@ -0,0 +1,24 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"orderservice/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestServiceError(t *testing.T) {
+	var gqlErr *gqlerror.Error
+	err := serviceError(fmt.Errorf("transaction failed: %w", services.ErrOrderNotCancellable))
+	require.True(t, errors.As(err, &gqlErr))
+	assert.Equal(t, "order can no longer be cancelled", gqlErr.Message)
+	assert.Equal(t, OrderNotCancellableCode, gqlErr.Extensions["code"])
+
+	other := errors.New("connection refused")
+	assert.Equal(t, other, serviceError(other))
+	assert.NoError(t, serviceError(nil))
+}
@@ -58,6 +58,26 @@ type OrderEdge struct {
 	Cursor time.Time `json:"cursor"`
 }
 
+type OrderSaga struct {
+	OrderID   uuid.UUID        `json:"orderId"`
+	State     OrderSagaState   `json:"state"`
+	LastEvent string           `json:"lastEvent"`
+	Reason    *string          `json:"reason,omitempty"`
+	Deadline  *time.Time       `json:"deadline,omitempty"`
+	Steps     []*OrderSagaStep `json:"steps"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+type OrderSagaStep struct {
+	FromState *OrderSagaState `json:"fromState,omitempty"`
+	ToState   OrderSagaState  `json:"toState"`
+	Trigger   string          `json:"trigger"`
+	EventID   *string         `json:"eventId,omitempty"`
+	Reason    *string         `json:"reason,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
 type PageInfo struct {
 	HasNextPage     bool       `json:"hasNextPage"`
 	HasPreviousPage bool       `json:"hasPreviousPage"`
@@ -68,6 +88,64 @@ type PageInfo struct {
 type Query struct {
 }
 
+type Subscription struct {
+}
+
+type CacheControlScope string
+
+const (
+	CacheControlScopePublic  CacheControlScope = "PUBLIC"
+	CacheControlScopePrivate CacheControlScope = "PRIVATE"
+)
+
+var AllCacheControlScope = []CacheControlScope{
+	CacheControlScopePublic,
+	CacheControlScopePrivate,
+}
+
+func (e CacheControlScope) IsValid() bool {
+	switch e {
+	case CacheControlScopePublic, CacheControlScopePrivate:
+		return true
+	}
+	return false
+}
+
+func (e CacheControlScope) String() string {
+	return string(e)
+}
+
+func (e *CacheControlScope) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CacheControlScope(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CacheControlScope", str)
+	}
+	return nil
+}
+
+func (e CacheControlScope) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *CacheControlScope) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e CacheControlScope) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
 type OrderDetailStatus string
 
 const (
@@ -130,3 +208,62 @@ func (e OrderDetailStatus) MarshalJSON() ([]byte, error) {
 	e.MarshalGQL(&buf)
 	return buf.Bytes(), nil
 }
+
+type OrderSagaState string
+
+const (
+	OrderSagaStateAwaitingNotification OrderSagaState = "awaiting_notification"
+	OrderSagaStateCompleted            OrderSagaState = "completed"
+	OrderSagaStateCancelled            OrderSagaState = "cancelled"
+	OrderSagaStateFailed               OrderSagaState = "failed"
+)
+
+var AllOrderSagaState = []OrderSagaState{
+	OrderSagaStateAwaitingNotification,
+	OrderSagaStateCompleted,
+	OrderSagaStateCancelled,
+	OrderSagaStateFailed,
+}
+
+func (e OrderSagaState) IsValid() bool {
+	switch e {
+	case OrderSagaStateAwaitingNotification, OrderSagaStateCompleted, OrderSagaStateCancelled, OrderSagaStateFailed:
+		return true
+	}
+	return false
+}
+
+func (e OrderSagaState) String() string {
+	return string(e)
+}
+
+func (e *OrderSagaState) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OrderSagaState(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OrderSagaState", str)
+	}
+	return nil
+}
+
+func (e OrderSagaState) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *OrderSagaState) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e OrderSagaState) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
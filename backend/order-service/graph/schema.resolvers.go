@@ -7,6 +7,7 @@ package graph
 import (
 	"context"
 	"orderservice/graph/model"
+	"orderservice/internal/auth"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,29 +15,76 @@ import (
 
 // UpdateOrderDetail is the resolver for the updateOrderDetail field.
 func (r *mutationResolver) UpdateOrderDetail(ctx context.Context, orderDetailID uuid.UUID, quantity *int32, status *model.OrderDetailStatus) (*model.OrderDetail, error) {
+	// Changing quantities and statuses is reserved to support staff, owners included
+	if err := requireScope(ctx, AdminScope); err != nil {
+		return nil, err
+	}
 	return r.OrderService.UpdateOrderDetail(ctx, orderDetailID, quantity, status)
 }
 
 // CancelOrder is the resolver for the cancelOrder field.
 func (r *mutationResolver) CancelOrder(ctx context.Context, id uuid.UUID) (*model.Order, error) {
-	return r.OrderService.CancelOrder(ctx, id)
+	if err := r.requireOrderOwner(ctx, id); err != nil {
+		return nil, err
+	}
+	order, err := r.OrderService.CancelOrder(ctx, id)
+	return order, serviceError(err)
 }
 
 // GetOrdersByUserID is the resolver for the getOrdersByUserId field.
 func (r *queryResolver) GetOrdersByUserID(ctx context.Context, userID uuid.UUID, first *int32, after *time.Time) (*model.OrderConnection, error) {
+	if err := requireUser(ctx, userID); err != nil {
+		return nil, err
+	}
 	return r.OrderService.GetOrdersByUserId(ctx, userID, first, after)
 }
 
 // GetOrderDetailsByOrderID is the resolver for the getOrderDetailsByOrderId field.
 func (r *queryResolver) GetOrderDetailsByOrderID(ctx context.Context, orderID uuid.UUID, first *int32, after *time.Time) (*model.OrderDetailConnection, error) {
+	if err := r.requireOrderOwner(ctx, orderID); err != nil {
+		return nil, err
+	}
 	return r.OrderService.GetOrdersDetailByOrderId(ctx, orderID, first, after)
 }
 
+// OrderSaga is the resolver for the orderSaga field.
+func (r *queryResolver) OrderSaga(ctx context.Context, orderID uuid.UUID) (*model.OrderSaga, error) {
+	if err := r.requireOrderOwner(ctx, orderID); err != nil {
+		return nil, err
+	}
+	return r.OrderService.GetOrderSaga(ctx, orderID)
+}
+
+// OrderStatusChanged is the resolver for the orderStatusChanged field.
+func (r *subscriptionResolver) OrderStatusChanged(ctx context.Context, orderID uuid.UUID) (<-chan *model.OrderDetail, error) {
+	if err := r.requireOrderOwner(ctx, orderID); err != nil {
+		return nil, err
+	}
+	return r.OrderService.SubscribeOrderStatus(ctx, orderID), nil
+}
+
+// OrdersForUser is the resolver for the ordersForUser field.
+func (r *subscriptionResolver) OrdersForUser(ctx context.Context) (<-chan *model.OrderDetail, error) {
+	identity, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, unauthenticatedError()
+	}
+	// API key clients have no orders of their own
+	if identity.UserID == uuid.Nil {
+		return nil, forbiddenError()
+	}
+	return r.OrderService.SubscribeUserOrderStatus(ctx, identity.UserID), nil
+}
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// Subscription returns SubscriptionResolver implementation.
+func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
+
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/google/uuid"
+)
+
+// Headers set by the api-gateway on every subgraph fetch made for an authenticated user
+const (
+	UserIDHeader        = "X-User-Id"
+	UserClaimsHeader    = "X-User-Claims"
+	UserSignatureHeader = "X-User-Signature"
+)
+
+// signatureVersion prefixes the signed payload, so the format can change later
+const signatureVersion = "v1."
+
+var (
+	ErrInvalidSignature = errors.New("invalid identity signature")
+	ErrExpiredClaims    = errors.New("identity claims expired")
+	ErrUserMismatch     = errors.New("user id does not match claims")
+)
+
+// Identity is the authenticated user a request is made for
+type Identity struct {
+	UserID   uuid.UUID
+	Username string
+	// ClientID is set instead of UserID for machine clients using an API key
+	ClientID string
+	// Scopes granted by the roles of the user, or to the API key
+	Scopes []string
+}
+
+// HasScope reports whether the user was granted scope
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// claims is the payload of the X-User-Claims header
+type claims struct {
+	Subject   string   `json:"sub"`
+	Username  string   `json:"username"`
+	Client    string   `json:"client,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a context carrying the identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity of the request, if the gateway forwarded one
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// Verify checks the identity headers signed by the gateway with the shared secret
+func Verify(secret []byte, userID string, encodedClaims string, signature string, now time.Time) (*Identity, error) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signatureVersion + encodedClaims))
+
+	provided, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(provided, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedClaims)
+	if err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("parse claims: %w", err)
+	}
+	if now.Unix() > c.ExpiresAt {
+		return nil, ErrExpiredClaims
+	}
+	if c.Subject != userID {
+		return nil, ErrUserMismatch
+	}
+	if c.Subject == "" && c.Client != "" {
+		return &Identity{ClientID: c.Client, Scopes: c.Scopes}, nil
+	}
+
+	id, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("parse user id: %w", err)
+	}
+
+	return &Identity{UserID: id, Username: c.Username, Scopes: c.Scopes}, nil
+}
+
+// Middleware reads the identity forwarded by the gateway into the request context.
+// Requests without identity headers are passed on anonymously, requests with headers
+// that fail verification are rejected.
+func Middleware(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Header.Get(UserIDHeader)
+			encodedClaims := r.Header.Get(UserClaimsHeader)
+			if userID == "" && encodedClaims == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(secret) == 0 {
+				log.Printf("identity headers received but SUBGRAPH_SIGNING_SECRET is not set")
+				http.Error(w, "Invalid identity", http.StatusUnauthorized)
+				return
+			}
+
+			identity, err := Verify(secret, userID, encodedClaims, r.Header.Get(UserSignatureHeader), time.Now())
+			if err != nil {
+				log.Printf("rejected identity headers: %v", err)
+				http.Error(w, "Invalid identity", http.StatusUnauthorized)
+				return
+			}
+
+			if identity.ClientID != "" {
+				logging.SetUserID(r.Context(), identity.ClientID)
+			} else {
+				logging.SetUserID(r.Context(), identity.UserID.String())
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
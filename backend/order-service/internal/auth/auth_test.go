@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("test-secret")
+
+func sign(t *testing.T, key []byte, c claims) (string, string) {
+	t.Helper()
+	payload, err := json.Marshal(c)
+	require.NoError(t, err)
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signatureVersion + encoded))
+	return encoded, base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now()
+	valid := claims{Subject: userID.String(), Username: "alice", ExpiresAt: now.Add(time.Minute).Unix()}
+
+	t.Run("valid", func(t *testing.T) {
+		encoded, signature := sign(t, secret, valid)
+		identity, err := Verify(secret, userID.String(), encoded, signature, now)
+		require.NoError(t, err)
+		assert.Equal(t, userID, identity.UserID)
+		assert.Equal(t, "alice", identity.Username)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		scoped := valid
+		scoped.Scopes = []string{"orders:read", "orders:admin"}
+		encoded, signature := sign(t, secret, scoped)
+		identity, err := Verify(secret, userID.String(), encoded, signature, now)
+		require.NoError(t, err)
+		assert.True(t, identity.HasScope("orders:admin"))
+		assert.False(t, identity.HasScope("orders:write"))
+	})
+
+	t.Run("api key client", func(t *testing.T) {
+		client := claims{Client: "0123456789abcdef", Scopes: []string{"orders:read"}, ExpiresAt: now.Add(time.Minute).Unix()}
+		encoded, signature := sign(t, secret, client)
+		identity, err := Verify(secret, "", encoded, signature, now)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Nil, identity.UserID)
+		assert.Equal(t, "0123456789abcdef", identity.ClientID)
+		assert.True(t, identity.HasScope("orders:read"))
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		encoded, signature := sign(t, []byte("other"), valid)
+		_, err := Verify(secret, userID.String(), encoded, signature, now)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := valid
+		expired.ExpiresAt = now.Add(-time.Minute).Unix()
+		encoded, signature := sign(t, secret, expired)
+		_, err := Verify(secret, userID.String(), encoded, signature, now)
+		assert.ErrorIs(t, err, ErrExpiredClaims)
+	})
+
+	t.Run("user id header does not match claims", func(t *testing.T) {
+		encoded, signature := sign(t, secret, valid)
+		_, err := Verify(secret, uuid.NewString(), encoded, signature, now)
+		assert.ErrorIs(t, err, ErrUserMismatch)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	userID := uuid.New()
+	var got *Identity
+	handler := Middleware(secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	t.Run("anonymous", func(t *testing.T) {
+		got = nil
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, got)
+	})
+
+	t.Run("signed identity", func(t *testing.T) {
+		got = nil
+		encoded, signature := sign(t, secret, claims{Subject: userID.String(), ExpiresAt: time.Now().Add(time.Minute).Unix()})
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		req.Header.Set(UserIDHeader, userID.String())
+		req.Header.Set(UserClaimsHeader, encoded)
+		req.Header.Set(UserSignatureHeader, signature)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, got)
+		assert.Equal(t, userID, got.UserID)
+	})
+
+	t.Run("forged identity", func(t *testing.T) {
+		got = nil
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		req.Header.Set(UserIDHeader, userID.String())
+		req.Header.Set(UserClaimsHeader, "e30")
+		req.Header.Set(UserSignatureHeader, "forged")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Nil(t, got)
+	})
+}
@@ -9,6 +9,9 @@ import (
 	"strconv"
 	"time"
 
+	"orderservice/internal/metrics"
+	"orderservice/internal/tracing"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -30,10 +33,23 @@ func NewDBPool(ctx context.Context) (*DBPool, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// Every query gets a span, under the span of the request or event it runs for
+	if err := db.Use(tracing.GORM{}); err != nil {
+		return nil, fmt.Errorf("failed to instrument database: %w", err)
+	}
+
 	if err := configureConnectionPool(db); err != nil {
 		return nil, err
 	}
 
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	if err := metrics.RegisterDB(sqlDB, dbName); err != nil {
+		return nil, fmt.Errorf("failed to register database metrics: %w", err)
+	}
+
 	log.Println("Connected to the database")
 
 	return &DBPool{DB: db}, nil
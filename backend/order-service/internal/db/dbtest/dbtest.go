@@ -0,0 +1,95 @@
+// Package dbtest gives tests a Postgres database with the schema of scripts/init.sql.
+// Tests using it are skipped unless TEST_DATABASE_URL points to a Postgres server, e.g.
+//
+//	TEST_DATABASE_URL="host=localhost user=postgres password=postgres dbname=postgres sslmode=disable" go test ./...
+package dbtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open returns a connection to a schema of its own, created from scripts/init.sql and
+// dropped when the test ends
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	adminDB, err := admin.DB()
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	t.Cleanup(func() { adminDB.Close() })
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	// The extension lives in public, where every test schema finds it
+	if _, err := adminDB.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp" WITH SCHEMA public`); err != nil {
+		t.Fatalf("create extension: %v", err)
+	}
+	if _, err := adminDB.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := adminDB.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	db, err := gorm.Open(postgres.Open(withSearchPath(dsn, schema+",public")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("connect to test schema: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("connect to test schema: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	script, err := os.ReadFile(initScript())
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	if _, err := sqlDB.Exec(string(script)); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	return db
+}
+
+// withSearchPath adds a search_path to a URL or key/value connection string
+func withSearchPath(dsn string, searchPath string) string {
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + strings.ReplaceAll(searchPath, ",", "%2C")
+	}
+	return fmt.Sprintf("%s search_path=%s", dsn, searchPath)
+}
+
+// initScript is scripts/init.sql of the order service
+func initScript() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "scripts", "init.sql")
+}
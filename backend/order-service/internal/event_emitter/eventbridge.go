@@ -2,15 +2,25 @@ package eventemitter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"orderservice/internal/tracing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// PutEventsAPI is the part of the EventBridge client the emitter uses
+type PutEventsAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
 type EventBridgeEmitter struct {
-	client *eventbridge.Client
+	client PutEventsAPI
 }
 
 func NewEventBridgeEmitter(cfg aws.Config) *EventBridgeEmitter {
@@ -19,13 +29,32 @@ func NewEventBridgeEmitter(cfg aws.Config) *EventBridgeEmitter {
 	}
 }
 
-func NewEventBridgeEmitterWithClient(client *eventbridge.Client) *EventBridgeEmitter {
+func NewEventBridgeEmitterWithClient(client PutEventsAPI) *EventBridgeEmitter {
 	return &EventBridgeEmitter{
 		client: client,
 	}
 }
 
+// Emit puts an event on its bus. The emitting span travels as an X-Ray trace header in
+// the TraceHeader, unless the event has one already, so the consumers continue the trace.
+// An entry EventBridge did not accept, e.g. throttled, is an error like a failed call.
 func (e *EventBridgeEmitter) Emit(ctx context.Context, ev *Event) error {
+	ctx, span := tracing.Tracer().Start(ctx, "eventbridge.put_events "+aws.ToString(ev.DetailType),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_eventbridge"),
+			attribute.String("messaging.destination.name", aws.ToString(ev.EventBusName)),
+			attribute.String("messaging.eventbridge.detail_type", aws.ToString(ev.DetailType)),
+		),
+	)
+	defer span.End()
+
+	if ev.TraceHeader == nil {
+		if header := tracing.TraceHeader(ctx); header != "" {
+			ev.TraceHeader = aws.String(header)
+		}
+	}
+
 	entry := types.PutEventsRequestEntry{
 		Source:       ev.Source,
 		DetailType:   ev.DetailType,
@@ -38,9 +67,32 @@ func (e *EventBridgeEmitter) Emit(ctx context.Context, ev *Event) error {
 		Entries: []types.PutEventsRequestEntry{entry},
 	}
 
-	_, err := e.client.PutEvents(ctx, input)
+	output, err := e.client.PutEvents(ctx, input)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to send event to EventBridge: %w", err)
+	}
+	if err := failedEntry(output); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return fmt.Errorf("failed to send event to EventBridge: %w", err)
 	}
+	if output.Entries[0].EventId != nil {
+		span.SetAttributes(attribute.String("messaging.message.id", *output.Entries[0].EventId))
+	}
+	return nil
+}
+
+// failedEntry returns the error of the entry of a PutEvents call that was not accepted.
+// PutEvents reports them in its output, without failing the call.
+func failedEntry(output *eventbridge.PutEventsOutput) error {
+	if output == nil || len(output.Entries) == 0 {
+		return errors.New("no result for the event")
+	}
+	entry := output.Entries[0]
+	if output.FailedEntryCount > 0 || entry.ErrorCode != nil {
+		return fmt.Errorf("event rejected: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
 	return nil
 }
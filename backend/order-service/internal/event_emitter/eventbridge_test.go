@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	eb "github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -18,23 +19,27 @@ type MockEventBridgeClient struct {
 
 func (m *MockEventBridgeClient) PutEvents(ctx context.Context, params *eb.PutEventsInput, optFns ...func(*eb.Options)) (*eb.PutEventsOutput, error) {
 	args := m.Called(ctx, params)
-	return args.Get(0).(*eb.PutEventsOutput), args.Error(1)
+	output, _ := args.Get(0).(*eb.PutEventsOutput)
+	return output, args.Error(1)
 }
-func TestSendEvent_Success(t *testing.T) {
-	mockClient := new(MockEventBridgeClient)
-	client = mockClient // Use mock client in tests
 
-	eventEntry := &types.PutEventsRequestEntry{
+func testEvent() *Event {
+	return &Event{
 		Source:       aws.String("my.source"),
 		DetailType:   aws.String("my.detail.type"),
 		Detail:       aws.String("{\"key\":\"value\"}"),
 		EventBusName: aws.String("my.event.bus"),
 	}
+}
 
-	mockClient.On("PutEvents", mock.Anything, mock.AnythingOfType("*github.com/aws/aws-sdk-go-v2/service/eventbridge/types.PutEventsInput")).
-		Return(&types.PutEventsOutput{}, nil)
+func TestSendEvent_Success(t *testing.T) {
+	mockClient := new(MockEventBridgeClient)
+	emitter := NewEventBridgeEmitterWithClient(mockClient)
 
-	err := SendEvent(eventEntry)
+	mockClient.On("PutEvents", mock.Anything, mock.AnythingOfType("*eventbridge.PutEventsInput")).
+		Return(&eb.PutEventsOutput{Entries: []types.PutEventsResultEntry{{EventId: aws.String("1")}}}, nil)
+
+	err := emitter.Emit(context.Background(), testEvent())
 
 	assert.NoError(t, err)
 	mockClient.AssertExpectations(t)
@@ -42,21 +47,54 @@ func TestSendEvent_Success(t *testing.T) {
 
 func TestSendEvent_Failure(t *testing.T) {
 	mockClient := new(MockEventBridgeClient)
-	client = mockClient // Use mock client in tests
-
-	eventEntry := &types.PutEventsRequestEntry{
-		Source:       aws.String("my.source"),
-		DetailType:   aws.String("my.detail.type"),
-		Detail:       aws.String("{\"key\":\"value\"}"),
-		EventBusName: aws.String("my.event.bus"),
-	}
+	emitter := NewEventBridgeEmitterWithClient(mockClient)
 
-	mockClient.On("PutEvents", mock.Anything, mock.AnythingOfType("*github.com/aws/aws-sdk-go-v2/service/eventbridge/types.PutEventsInput")).
+	mockClient.On("PutEvents", mock.Anything, mock.AnythingOfType("*eventbridge.PutEventsInput")).
 		Return(nil, errors.New("failed to send event"))
 
-	err := SendEvent(eventEntry)
+	err := emitter.Emit(context.Background(), testEvent())
 
 	assert.Error(t, err)
 	assert.Equal(t, "failed to send event to EventBridge: failed to send event", err.Error())
 	mockClient.AssertExpectations(t)
 }
+
+func TestSendEvent_FailedEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		output *eb.PutEventsOutput
+	}{
+		{
+			name: "throttled",
+			output: &eb.PutEventsOutput{
+				FailedEntryCount: 1,
+				Entries: []types.PutEventsResultEntry{{
+					ErrorCode:    aws.String("ThrottlingException"),
+					ErrorMessage: aws.String("Rate exceeded"),
+				}},
+			},
+		},
+		{
+			name: "entry error without a failed count",
+			output: &eb.PutEventsOutput{
+				Entries: []types.PutEventsResultEntry{{ErrorCode: aws.String("InternalFailure")}},
+			},
+		},
+		{
+			name:   "no entries",
+			output: &eb.PutEventsOutput{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockEventBridgeClient)
+			emitter := NewEventBridgeEmitterWithClient(mockClient)
+			mockClient.On("PutEvents", mock.Anything, mock.Anything).Return(tt.output, nil)
+
+			err := emitter.Emit(context.Background(), testEvent())
+
+			assert.Error(t, err)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
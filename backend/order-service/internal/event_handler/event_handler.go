@@ -2,9 +2,11 @@ package eventhandler
 
 import (
 	"context"
-	"log"
 	"orderservice/internal/models"
 	"orderservice/internal/validator"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"go.uber.org/zap"
 )
 
 type EventHandler struct {
@@ -19,13 +21,13 @@ func NewEventHandler(registry *HandlerRegistry, v *validator.Validator) *EventHa
 func (h *EventHandler) HandleMessage(ctx context.Context, msg any) error {
 	event := validator.ValidateModel(h.validator, msg, &models.Event{})
 	if event == nil {
-		log.Println("Event validation failed")
+		logging.Ctx(ctx).Warn("Event validation failed")
 		return nil
 	}
 
 	handler := h.registry.GetHandler(event.DetailType)
 	if handler == nil {
-		log.Printf("No handler for event type: %s", event.DetailType)
+		logging.Ctx(ctx).Warn("No handler for event type", zap.String("detail_type", event.DetailType))
 		return nil
 	}
 
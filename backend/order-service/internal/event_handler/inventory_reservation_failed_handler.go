@@ -0,0 +1,72 @@
+package eventhandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orderservice/internal/inbox"
+	"orderservice/internal/models"
+	"orderservice/internal/services"
+	"orderservice/internal/validator"
+	"orderservice/pkg/enums"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// InventoryReservationFailedHandler fails the saga of an order whose inventory could not be
+// reserved, cancelling the order without releasing anything
+type InventoryReservationFailedHandler struct {
+	orderService services.OrderService
+	msgValidator *validator.Validator
+}
+
+type InventoryReservationFailedEventDetail struct {
+	OrderID   string `json:"orderId" validate:"required"`
+	ProductID string `json:"productId,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	// IdempotencyKey identifies the operation of the event, when the producer sets one
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+func NewInventoryReservationFailedHandler(orderService services.OrderService, msgValidator *validator.Validator) *InventoryReservationFailedHandler {
+	return &InventoryReservationFailedHandler{
+		orderService: orderService,
+		msgValidator: msgValidator,
+	}
+}
+
+func (h *InventoryReservationFailedHandler) HandleMessage(ctx context.Context, event *models.Event) error {
+	detail := validator.ValidateModel(h.msgValidator, event.Detail, &InventoryReservationFailedEventDetail{})
+	if detail == nil {
+		return fmt.Errorf("failed to validate event: %s", enums.EVENT_TYPE.InventoryReservationFailed.String())
+	}
+
+	orderID, err := uuid.Parse(detail.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to validate event: %s , uuid not correct", enums.EVENT_TYPE.InventoryReservationFailed.String())
+	}
+
+	logging.Ctx(ctx).Info("Handling InventoryReservationFailed event", zap.String("order_id", orderID.String()))
+
+	reason := detail.Reason
+	if reason == "" {
+		reason = "inventory reservation failed"
+	}
+	orderSaga, err := h.orderService.HandleInventoryReservationFailedEvent(ctx, inbox.FromEvent(event, detail.IdempotencyKey), orderID, reason)
+	if errors.Is(err, inbox.ErrAlreadyProcessed) {
+		logging.Ctx(ctx).Info("Skipping InventoryReservationFailed event handled before")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error failing order saga: %v", err)
+	}
+
+	if orderSaga != nil {
+		logging.Ctx(ctx).Info("Order saga failed", zap.String("order_id", orderID.String()), zap.String("state", orderSaga.State.String()))
+	}
+	return nil
+}
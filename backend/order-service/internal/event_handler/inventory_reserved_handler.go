@@ -2,14 +2,17 @@ package eventhandler
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"orderservice/internal/inbox"
 	"orderservice/internal/models"
 	"orderservice/internal/services"
 	"orderservice/internal/validator"
 	"orderservice/pkg/enums"
 
+	"github.com/demo-micro/backend/pkg/logging"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type InventoryReservedHandler struct {
@@ -30,6 +33,8 @@ type InventoryReservedEventDetail struct {
 	Reason    string                         `json:"reason,omitempty"`
 	CreatedAt string                         `json:"createdAt,omitempty"`
 	UpdatedAt string                         `json:"updatedAt,omitempty"`
+	// IdempotencyKey identifies the operation of the event, when the producer sets one
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }
 
 func NewInventoryReservedHandler(orderService services.OrderService, msgValidator *validator.Validator) *InventoryReservedHandler {
@@ -50,18 +55,22 @@ func (h *InventoryReservedHandler) HandleMessage(ctx context.Context, event *mod
 		return fmt.Errorf("failed to validate items in event: %s , body incorrect", enums.EVENT_TYPE.InventoryReserved.String())
 	}
 
-	log.Println("Handling InventoryReserved event")
+	logging.Ctx(ctx).Info("Handling InventoryReserved event")
 
 	userUUID, err := uuid.Parse(detail.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to validate items in event: %s , uuid not correct", enums.EVENT_TYPE.InventoryReserved.String())
 
 	}
-	order, err := h.orderService.HandleInventoryReservedEvent(ctx, userUUID, *items)
+	order, err := h.orderService.HandleInventoryReservedEvent(ctx, inbox.FromEvent(event, detail.IdempotencyKey), userUUID, *items)
+	if errors.Is(err, inbox.ErrAlreadyProcessed) {
+		logging.Ctx(ctx).Info("Skipping InventoryReserved event handled before")
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("error creating order: %v", err)
 	}
 
-	log.Printf("Order created successfully: %+v\n", order)
+	logging.Ctx(ctx).Info("Order created successfully", zap.String("order_id", order.ID.String()))
 	return nil
 }
@@ -0,0 +1,61 @@
+package eventhandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orderservice/internal/inbox"
+	"orderservice/internal/models"
+	"orderservice/internal/services"
+	"orderservice/internal/validator"
+	"orderservice/pkg/enums"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// NotificationSentFailedHandler compensates the saga of an order whose customer could not
+// be notified, cancelling the order and releasing its inventory
+type NotificationSentFailedHandler struct {
+	orderService services.OrderService
+	msgValidator *validator.Validator
+}
+
+func NewNotificationSentFailedHandler(orderService services.OrderService, msgValidator *validator.Validator) *NotificationSentFailedHandler {
+	return &NotificationSentFailedHandler{
+		orderService: orderService,
+		msgValidator: msgValidator,
+	}
+}
+
+func (h *NotificationSentFailedHandler) HandleMessage(ctx context.Context, event *models.Event) error {
+	detail := validator.ValidateModel(h.msgValidator, event.Detail, &models.NotificationEventDetail{})
+	if detail == nil {
+		return fmt.Errorf("failed to validate event: %s", enums.EVENT_TYPE.NotificationSentFailed.String())
+	}
+
+	// The subject of a failed notification is the id of the event notified, which is not
+	// always an order: the event is then ignored by the saga
+	orderID, err := uuid.Parse(detail.SubjectID)
+	if err != nil {
+		logging.Ctx(ctx).Info("Skipping NotificationSentFailed event of another subject", zap.String("subject_id", detail.SubjectID))
+		return nil
+	}
+
+	logging.Ctx(ctx).Info("Handling NotificationSentFailed event", zap.String("order_id", orderID.String()))
+
+	orderSaga, err := h.orderService.HandleNotificationSentFailedEvent(ctx, inbox.FromEvent(event, detail.IdempotencyKey), orderID, "customer notification failed")
+	if errors.Is(err, inbox.ErrAlreadyProcessed) {
+		logging.Ctx(ctx).Info("Skipping NotificationSentFailed event handled before")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error compensating order saga: %v", err)
+	}
+
+	if orderSaga != nil {
+		logging.Ctx(ctx).Info("Order saga compensated", zap.String("order_id", orderID.String()), zap.String("state", orderSaga.State.String()))
+	}
+	return nil
+}
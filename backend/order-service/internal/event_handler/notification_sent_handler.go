@@ -2,36 +2,27 @@ package eventhandler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"orderservice/internal/inbox"
 	"orderservice/internal/models"
 	"orderservice/internal/services"
 	"orderservice/internal/validator"
 	"orderservice/pkg/enums"
 
+	"github.com/demo-micro/backend/pkg/logging"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+// NotificationSentHandler completes the saga of an order once its customer was notified
+// that it was placed
 type NotificationSentHandler struct {
 	orderService services.OrderService
 	msgValidator *validator.Validator
 }
 
-type NotificationSentItemsInput struct {
-	ProductID string
-	Quantity  int32
-	Price     float64
-	Currency  string
-}
-
-type NotificationSentEventDetail struct {
-	UserID    string                        `json:"userId" validate:"required"`
-	Items     []*NotificationSentItemsInput `json:"items" validate:"required,dive"`
-	Reason    string                        `json:"reason,omitempty"`
-	CreatedAt string                        `json:"createdAt,omitempty"`
-	UpdatedAt string                        `json:"updatedAt,omitempty"`
-}
-
 func NewNotificationSentHandler(orderService services.OrderService, msgValidator *validator.Validator) *NotificationSentHandler {
 	return &NotificationSentHandler{
 		orderService: orderService,
@@ -40,28 +31,39 @@ func NewNotificationSentHandler(orderService services.OrderService, msgValidator
 }
 
 func (h *NotificationSentHandler) HandleMessage(ctx context.Context, event *models.Event) error {
-	detail := validator.ValidateModel(h.msgValidator, event.Detail, &NotificationSentEventDetail{})
+	detail := validator.ValidateModel(h.msgValidator, event.Detail, &models.NotificationEventDetail{})
 	if detail == nil {
 		return fmt.Errorf("failed to validate event: %s", enums.EVENT_TYPE.NotificationSentSuccess.String())
 	}
 
-	items := validator.ValidateModel(h.msgValidator, detail.Items, &[]services.OrderItemInput{})
-	if items == nil {
-		return fmt.Errorf("failed to validate items in event: %s , body incorrect", enums.EVENT_TYPE.NotificationSentSuccess.String())
+	// Customers are notified of every order event, only order_placed is a step of the saga
+	if detail.EventType != enums.EVENT_TYPE.OrderPlaced.String() {
+		logging.Ctx(ctx).Info("Skipping NotificationSent event of another event", zap.String("notified_type", detail.EventType))
+		return nil
 	}
 
-	log.Println("Handling NotificationSent event")
-
-	userUUID, err := uuid.Parse(detail.UserID)
+	var message models.NotificationMessageDetail
+	if err := json.Unmarshal([]byte(detail.Message), &message); err != nil {
+		return fmt.Errorf("failed to validate event: %s , message is not the notified event", enums.EVENT_TYPE.NotificationSentSuccess.String())
+	}
+	orderID, err := uuid.Parse(message.Detail.ID)
 	if err != nil {
-		return fmt.Errorf("failed to validate items in event: %s , uuid not correct", enums.EVENT_TYPE.NotificationSentSuccess.String())
+		return fmt.Errorf("failed to validate event: %s , uuid not correct", enums.EVENT_TYPE.NotificationSentSuccess.String())
+	}
 
+	logging.Ctx(ctx).Info("Handling NotificationSent event", zap.String("order_id", orderID.String()))
+
+	orderSaga, err := h.orderService.HandleOrderProcessingNotificationSentEvent(ctx, inbox.FromEvent(event, detail.IdempotencyKey), orderID)
+	if errors.Is(err, inbox.ErrAlreadyProcessed) {
+		logging.Ctx(ctx).Info("Skipping NotificationSent event handled before")
+		return nil
 	}
-	order, err := h.orderService.HandleOrderProcessingNotificationSentEvent(ctx, userUUID)
 	if err != nil {
-		return fmt.Errorf("error creating order: %v", err)
+		return fmt.Errorf("error advancing order saga: %v", err)
 	}
 
-	log.Printf("Order created successfully: %+v\n", order)
+	if orderSaga != nil {
+		logging.Ctx(ctx).Info("Order saga advanced", zap.String("order_id", orderID.String()), zap.String("state", orderSaga.State.String()))
+	}
 	return nil
 }
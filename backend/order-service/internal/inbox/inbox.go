@@ -0,0 +1,118 @@
+// Package inbox makes the handling of SQS events idempotent. Handlers record the event in
+// the transaction of their changes; a redelivered event finds its record and is skipped,
+// and an event whose transaction rolled back is handled again.
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"orderservice/internal/metrics"
+	"orderservice/internal/models"
+	"orderservice/internal/utils"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrAlreadyProcessed is returned by Record for an event that was handled before
+	ErrAlreadyProcessed = errors.New("event already processed")
+	// ErrMissingEventID is returned by Record for an event without an id
+	ErrMissingEventID = errors.New("event has no id")
+)
+
+// Entry identifies an event to handle once
+type Entry struct {
+	EventID string
+	// IdempotencyKey is the optional key of the business operation of the event
+	IdempotencyKey string
+	DetailType     string
+}
+
+// FromEvent returns the entry of an event, with the idempotency key set by its producer
+func FromEvent(event *models.Event, idempotencyKey string) Entry {
+	return Entry{EventID: event.ID, IdempotencyKey: idempotencyKey, DetailType: event.DetailType}
+}
+
+// Record records entry in tx, and returns ErrAlreadyProcessed when its event, or another
+// event with its idempotency key, was recorded before. While tx is open, a concurrent
+// delivery of the same event waits on the record, and is skipped once tx commits.
+func Record(ctx context.Context, tx *gorm.DB, entry Entry) error {
+	if entry.EventID == "" {
+		return ErrMissingEventID
+	}
+
+	record := &models.ProcessedEvent{EventID: entry.EventID, DetailType: entry.DetailType}
+	if entry.IdempotencyKey != "" {
+		record.IdempotencyKey = &entry.IdempotencyKey
+	}
+
+	// Conflicts on the event id or on the idempotency key both mean the event is a duplicate
+	result := tx.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record event %s: %w", entry.EventID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		metrics.DuplicateEvents.WithLabelValues(entry.DetailType).Inc()
+		return ErrAlreadyProcessed
+	}
+	return nil
+}
+
+// CleanupConfig configures how long events are remembered
+type CleanupConfig struct {
+	// TTL is how long events are remembered after their handling. It must outlast the
+	// retention of the queue, or a late redelivery is handled again.
+	TTL time.Duration
+	// Interval is the time between removing the events past their TTL
+	Interval time.Duration
+}
+
+// CleanupConfigFromEnv reads the configuration from INBOX_TTL and INBOX_CLEANUP_INTERVAL.
+// The TTL defaults to the longest retention of an SQS queue, 14 days.
+func CleanupConfigFromEnv() CleanupConfig {
+	return CleanupConfig{
+		TTL:      utils.GetEnv("INBOX_TTL", 14*24*time.Hour),
+		Interval: utils.GetEnv("INBOX_CLEANUP_INTERVAL", time.Hour),
+	}
+}
+
+// Cleanup removes the events handled longer ago than ttl, and returns how many
+func Cleanup(ctx context.Context, db *gorm.DB, ttl time.Duration) (int64, error) {
+	result := db.WithContext(ctx).
+		Where("processed_at < now() - make_interval(secs => ?)", ttl.Seconds()).
+		Delete(&models.ProcessedEvent{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to remove processed events: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// RunCleanup removes the events past their TTL every interval, until ctx is done
+func RunCleanup(ctx context.Context, db *gorm.DB, config CleanupConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := Cleanup(ctx, db, config.TTL)
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Ctx(ctx).Warn("Failed to clean up the inbox", zap.Error(err))
+			}
+			continue
+		}
+		if removed > 0 {
+			logging.Ctx(ctx).Info("Cleaned up the inbox", zap.Int64("removed", removed))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"orderservice/internal/db/dbtest"
+	"orderservice/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// record records entry in a transaction of its own
+func record(db *gorm.DB, entry Entry) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return Record(context.Background(), tx, entry)
+	})
+}
+
+func TestRecord(t *testing.T) {
+	db := dbtest.Open(t)
+
+	first := Entry{EventID: "event-1", IdempotencyKey: "reservation-1", DetailType: "inventory_reserved"}
+	require.NoError(t, record(db, first))
+
+	tests := []struct {
+		name  string
+		entry Entry
+		err   error
+	}{
+		{
+			name:  "redelivered event",
+			entry: first,
+			err:   ErrAlreadyProcessed,
+		},
+		{
+			name:  "redelivered event without its idempotency key",
+			entry: Entry{EventID: "event-1", DetailType: "inventory_reserved"},
+			err:   ErrAlreadyProcessed,
+		},
+		{
+			name:  "operation published again under another event id",
+			entry: Entry{EventID: "event-2", IdempotencyKey: "reservation-1", DetailType: "inventory_reserved"},
+			err:   ErrAlreadyProcessed,
+		},
+		{
+			name:  "idempotency key of another detail type",
+			entry: Entry{EventID: "event-3", IdempotencyKey: "reservation-1", DetailType: "notification_sent"},
+		},
+		{
+			name:  "events without idempotency keys",
+			entry: Entry{EventID: "event-4", DetailType: "inventory_reserved"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := record(db, tt.entry)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	var count int64
+	require.NoError(t, db.Model(&models.ProcessedEvent{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestRecordRolledBack(t *testing.T) {
+	db := dbtest.Open(t)
+	entry := Entry{EventID: "event-1", DetailType: "inventory_reserved"}
+
+	// The handling of the event failed after recording it
+	errHandling := errors.New("handling failed")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		require.NoError(t, Record(context.Background(), tx, entry))
+		return errHandling
+	})
+	require.ErrorIs(t, err, errHandling)
+
+	// so the redelivery is handled
+	require.NoError(t, record(db, entry))
+	assert.ErrorIs(t, record(db, entry), ErrAlreadyProcessed)
+}
+
+func TestCleanup(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"old-1", "old-2", "recent"} {
+		require.NoError(t, record(db, Entry{EventID: id, DetailType: "inventory_reserved"}))
+	}
+	require.NoError(t, db.Exec(
+		"UPDATE processed_events SET processed_at = now() - interval '2 hours' WHERE event_id IN ?",
+		[]string{"old-1", "old-2"},
+	).Error)
+
+	removed, err := Cleanup(ctx, db, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	var remaining []string
+	require.NoError(t, db.Model(&models.ProcessedEvent{}).Pluck("event_id", &remaining).Error)
+	assert.Equal(t, []string{"recent"}, remaining)
+
+	// An event removed from the inbox is handled again
+	assert.NoError(t, record(db, Entry{EventID: "old-1", DetailType: "inventory_reserved"}))
+	assert.ErrorIs(t, record(db, Entry{EventID: "recent", DetailType: "inventory_reserved"}), ErrAlreadyProcessed)
+}
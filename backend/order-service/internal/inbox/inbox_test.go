@@ -0,0 +1,25 @@
+package inbox
+
+import (
+	"context"
+	"testing"
+
+	"orderservice/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromEvent(t *testing.T) {
+	event := &models.Event{ID: "3f1c2a5e-0000-4000-8000-000000000001", DetailType: "inventory_reserved"}
+
+	assert.Equal(t, Entry{
+		EventID:        "3f1c2a5e-0000-4000-8000-000000000001",
+		IdempotencyKey: "reservation-1",
+		DetailType:     "inventory_reserved",
+	}, FromEvent(event, "reservation-1"))
+}
+
+func TestRecordNeedsEventID(t *testing.T) {
+	err := Record(context.Background(), nil, Entry{DetailType: "inventory_reserved"})
+	assert.ErrorIs(t, err, ErrMissingEventID)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// GraphQL is a gqlgen extension that records how long queries and mutations take.
+// Subscriptions last as long as their client, so they are not measured.
+type GraphQL struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+} = GraphQL{}
+
+func (GraphQL) ExtensionName() string {
+	return "Prometheus"
+}
+
+func (GraphQL) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (GraphQL) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil || oc.Operation.Operation == ast.Subscription {
+		return next(ctx)
+	}
+
+	// Measured from when the request was read, so parsing and validation count too
+	start := oc.Stats.OperationStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	response := next(ctx)
+	OperationDuration.WithLabelValues(oc.OperationName, string(oc.Operation.Operation)).Observe(time.Since(start).Seconds())
+	return response
+}
@@ -0,0 +1,106 @@
+// Package metrics holds the Prometheus metrics of the order service, served at /metrics
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "orderservice"
+
+var (
+	// OperationDuration is the time to execute a GraphQL query or mutation, by operation
+	// name and type
+	OperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "graphql_operation_duration_seconds",
+		Help:      "Time to execute a GraphQL operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "type"})
+
+	// SQSMessages counts the SQS messages handled, by the detail type of their event and
+	// result: processed, retried, dead_lettered, or failed when there is no dead-letter
+	// queue to move them to
+	SQSMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sqs_messages_total",
+		Help:      "SQS messages handled, by event detail type and result.",
+	}, []string{"detail_type", "result"})
+
+	// SQSErrors counts the failed SQS requests of the consumer, by operation: receive,
+	// delete, change_visibility or dead_letter
+	SQSErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sqs_errors_total",
+		Help:      "Failed SQS requests of the consumer, by operation.",
+	}, []string{"operation"})
+
+	// DuplicateEvents counts the redelivered events skipped because they were handled
+	// before, by detail type
+	DuplicateEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_events_total",
+		Help:      "Redelivered events skipped because they were handled before, by event detail type.",
+	}, []string{"detail_type"})
+
+	// SagaTransitions counts the transitions of order sagas, by trigger and the state they
+	// went to
+	SagaTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "saga_transitions_total",
+		Help:      "Transitions of order sagas, by trigger and state reached.",
+	}, []string{"trigger", "state"})
+
+	// OutboxPending is the number of outbox messages waiting to be published
+	OutboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_pending_messages",
+		Help:      "Outbox messages waiting to be published.",
+	})
+
+	// OutboxFailed is the number of outbox messages the relay gave up on, until they are
+	// replayed
+	OutboxFailed = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_failed_messages",
+		Help:      "Outbox messages the relay gave up publishing.",
+	})
+
+	// OutboxLag is the age of the oldest outbox message waiting to be published
+	OutboxLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_lag_seconds",
+		Help:      "Age of the oldest outbox message waiting to be published.",
+	})
+
+	// OutboxPublishDelay is the time from writing an outbox message to publishing it
+	OutboxPublishDelay = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbox_publish_delay_seconds",
+		Help:      "Time from writing an outbox message to publishing it.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900},
+	})
+
+	// OutboxPublishes counts the attempts to publish outbox messages, by detail type and
+	// result: sent, retry or failed
+	OutboxPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_publishes_total",
+		Help:      "Attempts to publish outbox messages, by event detail type and result.",
+	}, []string{"detail_type", "result"})
+)
+
+// RegisterDB exports the connection pool stats of db
+func RegisterDB(db *sql.DB, name string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
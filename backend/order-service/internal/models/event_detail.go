@@ -8,12 +8,18 @@ type NotificationMessageDetail struct {
 	Detail Detail `json:"detail"`
 }
 
+// NotificationEventDetail is the detail of notification_sent_success and
+// notification_sent_failed events. On success, EventType is the detail type of the event
+// notified and Message its JSON; on failure Message is not JSON, and SubjectID is the id
+// in the detail of the event.
 type NotificationEventDetail struct {
 	SubjectID string `json:"subjectId" validate:"required"`
 	EventType string `json:"type"`
 	Message   string `json:"message"`
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
+	// IdempotencyKey identifies the operation of the event, when the producer sets one
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }
 
 type InventoryItem struct {
@@ -47,3 +53,22 @@ type InventoryReservedFailEventDetail struct {
 	UpdatedAt string `json:"updatedAt"`
 	// add more
 }
+
+// OrderCancelledEventDetail is the detail of order_cancelled events. ID and OrderID are both
+// the id of the order, ID identifies it like in the other order events. OrderDetailIDs are
+// the items cancelled with the order. Items is the inventory to release, empty when it was
+// never reserved.
+type OrderCancelledEventDetail struct {
+	ID             string          `json:"id"`
+	OrderID        string          `json:"orderId"`
+	UserID         string          `json:"userId"`
+	OrderDetailIDs []string        `json:"orderDetailIds"`
+	Items          []*ReleasedItem `json:"items"`
+	Reason         string          `json:"reason"`
+	CancelledAt    string          `json:"cancelledAt"`
+}
+
+type ReleasedItem struct {
+	ProductID string `json:"productId"`
+	Quantity  int32  `json:"quantity"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"orderservice/graph/model"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,3 +19,22 @@ type OrderDetail struct {
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
+
+// ToModelOrderDetail converts persistence OrderDetail to GraphQL model.OrderDetail,
+// with the name of its currency
+func (d *OrderDetail) ToModelOrderDetail(currency string) *model.OrderDetail {
+	if d == nil {
+		return nil
+	}
+	return &model.OrderDetail{
+		ID:        d.ID,
+		OrderID:   d.OrderID,
+		ProductID: d.ProductID,
+		Quantity:  int32(d.Quantity),
+		Price:     d.Price,
+		Currency:  currency,
+		Status:    model.OrderDetailStatus(d.Status),
+		CreatedAt: d.CreatedAt,
+		UpdatedAt: d.UpdatedAt,
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"orderservice/graph/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrderSaga is where an order is in its flow across the inventory and notification
+// services, advanced by their events
+type OrderSaga struct {
+	OrderID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	State   string
+	// LastEvent is the trigger of the last transition
+	LastEvent string
+	// Reason explains why the saga failed or was cancelled
+	Reason *string
+	// Deadline is when the step the saga waits on times out, unset once it is over
+	Deadline  *time.Time
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (OrderSaga) TableName() string {
+	return "order_sagas"
+}
+
+// OrderSagaStep records a transition of a saga
+type OrderSagaStep struct {
+	ID      uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrderID uuid.UUID `gorm:"type:uuid"`
+	// FromState is unset for the step starting the saga
+	FromState *string
+	ToState   string
+	Trigger   string
+	// EventID is the id of the event of the transition, unset for cancellations and timeouts
+	EventID   *string
+	Reason    *string
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (OrderSagaStep) TableName() string {
+	return "order_saga_steps"
+}
+
+// ToModelOrderSaga converts persistence OrderSaga to GraphQL model.OrderSaga, with its steps
+func (s *OrderSaga) ToModelOrderSaga(steps []*OrderSagaStep) *model.OrderSaga {
+	if s == nil {
+		return nil
+	}
+	result := &model.OrderSaga{
+		OrderID:   s.OrderID,
+		State:     model.OrderSagaState(s.State),
+		LastEvent: s.LastEvent,
+		Reason:    s.Reason,
+		Deadline:  s.Deadline,
+		Steps:     make([]*model.OrderSagaStep, 0, len(steps)),
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+	}
+	for _, step := range steps {
+		var from *model.OrderSagaState
+		if step.FromState != nil {
+			state := model.OrderSagaState(*step.FromState)
+			from = &state
+		}
+		result.Steps = append(result.Steps, &model.OrderSagaStep{
+			FromState: from,
+			ToState:   model.OrderSagaState(step.ToState),
+			Trigger:   step.Trigger,
+			EventID:   step.EventID,
+			Reason:    step.Reason,
+			CreatedAt: step.CreatedAt,
+		})
+	}
+	return result
+}
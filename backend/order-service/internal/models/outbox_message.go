@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxMessage is an event written in the transaction of the change it announces, and
+// published by the outbox relay once that transaction committed
+type OutboxMessage struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Source       string
+	DetailType   string
+	Detail       string `gorm:"type:jsonb"`
+	EventBusName string
+	// TraceHeader is the trace context of the change, continued by the relay
+	TraceHeader   *string
+	Attempts      int
+	LastError     *string
+	NextAttemptAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	SentAt        *time.Time
+	// FailedAt is set when the relay gave up, until the message is replayed
+	FailedAt  *time.Time
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
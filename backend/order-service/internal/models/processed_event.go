@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ProcessedEvent records an event whose handling committed, so redeliveries of it are
+// recognized and skipped
+type ProcessedEvent struct {
+	// EventID is the id EventBridge gave the event
+	EventID string `gorm:"primaryKey"`
+	// IdempotencyKey identifies the business operation of the event, when its producer
+	// sets one, so the same operation published twice is handled once
+	IdempotencyKey *string
+	DetailType     string
+	ProcessedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (ProcessedEvent) TableName() string {
+	return "processed_events"
+}
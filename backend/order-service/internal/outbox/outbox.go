@@ -0,0 +1,48 @@
+// Package outbox publishes events written in the same transaction as the changes they
+// announce. An event is only published once its transaction committed, and is published
+// again until EventBridge accepted it, so consumers see every event at least once.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	eventemitter "orderservice/internal/event_emitter"
+	"orderservice/internal/models"
+	"orderservice/internal/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gorm.io/gorm"
+)
+
+// Enqueue writes an event to the outbox in tx. The trace context of ctx is kept with it,
+// so its publication continues the trace of the change.
+func Enqueue(ctx context.Context, tx *gorm.DB, event *eventemitter.Event) error {
+	message := &models.OutboxMessage{
+		Source:       aws.ToString(event.Source),
+		DetailType:   aws.ToString(event.DetailType),
+		Detail:       aws.ToString(event.Detail),
+		EventBusName: aws.ToString(event.EventBusName),
+		TraceHeader:  event.TraceHeader,
+	}
+	if message.TraceHeader == nil {
+		if header := tracing.TraceHeader(ctx); header != "" {
+			message.TraceHeader = aws.String(header)
+		}
+	}
+
+	if err := tx.WithContext(ctx).Create(message).Error; err != nil {
+		return fmt.Errorf("failed to write event to outbox: %w", err)
+	}
+	return nil
+}
+
+// toEvent is the event published for a message
+func toEvent(message *models.OutboxMessage) *eventemitter.Event {
+	return &eventemitter.Event{
+		Source:       aws.String(message.Source),
+		DetailType:   aws.String(message.DetailType),
+		Detail:       aws.String(message.Detail),
+		EventBusName: aws.String(message.EventBusName),
+		TraceHeader:  message.TraceHeader,
+	}
+}
@@ -0,0 +1,233 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	eventemitter "orderservice/internal/event_emitter"
+	"orderservice/internal/metrics"
+	"orderservice/internal/models"
+	"orderservice/internal/tracing"
+	"orderservice/internal/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/demo-micro/backend/pkg/logging"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Config configures how often and how persistently the relay publishes messages
+type Config struct {
+	// PollInterval is the time between looking for messages to publish, when the
+	// previous batch was not full
+	PollInterval time.Duration
+	// BatchSize is the number of messages published per transaction
+	BatchSize int
+	// MaxAttempts is the number of attempts to publish a message before giving up on it
+	MaxAttempts int
+	// RetryBackoff is the time before the second attempt, doubling for every later one
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the time between attempts
+	MaxRetryBackoff time.Duration
+	// Retention is how long published messages are kept for replays
+	Retention time.Duration
+}
+
+// ConfigFromEnv reads the configuration from OUTBOX_POLL_INTERVAL, OUTBOX_BATCH_SIZE,
+// OUTBOX_MAX_ATTEMPTS, OUTBOX_RETRY_BACKOFF, OUTBOX_MAX_RETRY_BACKOFF and OUTBOX_RETENTION
+func ConfigFromEnv() Config {
+	return Config{
+		PollInterval:    utils.GetEnv("OUTBOX_POLL_INTERVAL", time.Second),
+		BatchSize:       utils.GetEnv("OUTBOX_BATCH_SIZE", 100),
+		MaxAttempts:     utils.GetEnv("OUTBOX_MAX_ATTEMPTS", 10),
+		RetryBackoff:    utils.GetEnv("OUTBOX_RETRY_BACKOFF", time.Second),
+		MaxRetryBackoff: utils.GetEnv("OUTBOX_MAX_RETRY_BACKOFF", 5*time.Minute),
+		Retention:       utils.GetEnv("OUTBOX_RETENTION", 7*24*time.Hour),
+	}
+}
+
+// cleanupInterval is the time between removing published messages past their retention
+const cleanupInterval = time.Hour
+
+// Relay publishes the messages of the outbox. Replicas share the work: each locks the
+// messages it publishes with FOR UPDATE SKIP LOCKED, so the others pass over them.
+//
+// Messages are published in the order they were written, but a message waiting for a
+// retry does not hold back the ones after it.
+type Relay struct {
+	db      *gorm.DB
+	emitter eventemitter.EventEmitter
+	config  Config
+}
+
+func NewRelay(db *gorm.DB, emitter eventemitter.EventEmitter, config Config) *Relay {
+	return &Relay{db: db, emitter: emitter, config: config}
+}
+
+// Run publishes messages until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	logging.Ctx(ctx).Info("Outbox relay running")
+
+	lastCleanup := time.Time{}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Ctx(ctx).Info("Outbox relay stopped")
+			return
+		case <-timer.C:
+		}
+
+		published, err := r.PublishBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logging.Ctx(ctx).Error("Failed to publish outbox messages", zap.Error(err))
+		}
+		if err := r.updateLag(ctx); err != nil && ctx.Err() == nil {
+			logging.Ctx(ctx).Warn("Failed to measure outbox lag", zap.Error(err))
+		}
+		if r.config.Retention > 0 && time.Since(lastCleanup) >= cleanupInterval {
+			lastCleanup = time.Now()
+			if err := r.cleanup(ctx); err != nil && ctx.Err() == nil {
+				logging.Ctx(ctx).Warn("Failed to remove published outbox messages", zap.Error(err))
+			}
+		}
+
+		// A full batch suggests more are waiting
+		if published == r.config.BatchSize && err == nil {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.config.PollInterval)
+		}
+	}
+}
+
+// PublishBatch publishes the messages that are due, up to a batch, and returns how many
+// it attempted. Their rows stay locked until every outcome is recorded.
+func (r *Relay) PublishBatch(ctx context.Context) (int, error) {
+	attempted := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []*models.OutboxMessage
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()").
+			Order("created_at").
+			Limit(r.config.BatchSize).
+			Find(&messages).Error
+		if err != nil {
+			return fmt.Errorf("failed to read outbox: %w", err)
+		}
+
+		for _, message := range messages {
+			if err := r.publish(ctx, tx, message); err != nil {
+				return err
+			}
+			attempted++
+		}
+		return nil
+	})
+	return attempted, err
+}
+
+// publish emits a message and records the outcome in tx
+func (r *Relay) publish(ctx context.Context, tx *gorm.DB, message *models.OutboxMessage) error {
+	// The emitter span joins the trace of the change that wrote the message
+	ctx = tracing.WithTraceHeader(ctx, aws.ToString(message.TraceHeader))
+	log := logging.Ctx(ctx).With(zap.String("outbox_id", message.ID.String()), zap.String("detail_type", message.DetailType))
+
+	emitErr := r.emitter.Emit(ctx, toEvent(message))
+	result := r.outcome(message.Attempts+1, emitErr)
+
+	var updates map[string]any
+	switch result {
+	case outcomeSent:
+		updates = map[string]any{"attempts": gorm.Expr("attempts + 1"), "sent_at": gorm.Expr("now()"), "last_error": nil}
+	case outcomeRetry:
+		log.Warn("Failed to publish outbox message, retrying", zap.Int("attempt", message.Attempts+1), zap.Error(emitErr))
+		updates = map[string]any{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"last_error":      emitErr.Error(),
+			"next_attempt_at": gorm.Expr("now() + make_interval(secs => ?)", r.backoff(message.Attempts+1).Seconds()),
+		}
+	case outcomeFailed:
+		log.Error("Failed to publish outbox message, giving up", zap.Int("attempts", message.Attempts+1), zap.Error(emitErr))
+		updates = map[string]any{"attempts": gorm.Expr("attempts + 1"), "last_error": emitErr.Error(), "failed_at": gorm.Expr("now()")}
+	}
+
+	if err := tx.Model(message).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record outbox message %s as %s: %w", message.ID, result, err)
+	}
+	metrics.OutboxPublishes.WithLabelValues(message.DetailType, string(result)).Inc()
+	if result == outcomeSent {
+		metrics.OutboxPublishDelay.Observe(time.Since(message.CreatedAt).Seconds())
+	}
+	return nil
+}
+
+type outcome string
+
+const (
+	outcomeSent   outcome = "sent"
+	outcomeRetry  outcome = "retry"
+	outcomeFailed outcome = "failed"
+)
+
+// outcome is what becomes of a message after its attempt-th attempt to publish it
+func (r *Relay) outcome(attempt int, err error) outcome {
+	switch {
+	case err == nil:
+		return outcomeSent
+	case r.config.MaxAttempts > 0 && attempt >= r.config.MaxAttempts:
+		return outcomeFailed
+	default:
+		return outcomeRetry
+	}
+}
+
+// backoff is the time to wait after the attempt-th failed attempt
+func (r *Relay) backoff(attempt int) time.Duration {
+	backoff := r.config.RetryBackoff
+	for i := 1; i < attempt && i < 32; i++ {
+		backoff *= 2
+		if r.config.MaxRetryBackoff > 0 && backoff >= r.config.MaxRetryBackoff {
+			break
+		}
+	}
+	if r.config.MaxRetryBackoff > 0 && backoff > r.config.MaxRetryBackoff {
+		backoff = r.config.MaxRetryBackoff
+	}
+	return backoff
+}
+
+// updateLag reports the messages waiting to be published, and how long the oldest waits
+func (r *Relay) updateLag(ctx context.Context) error {
+	var stats struct {
+		Pending int64
+		Failed  int64
+		Lag     float64
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT
+			count(*) FILTER (WHERE failed_at IS NULL) AS pending,
+			count(*) FILTER (WHERE failed_at IS NOT NULL) AS failed,
+			COALESCE(EXTRACT(EPOCH FROM now() - min(created_at) FILTER (WHERE failed_at IS NULL)), 0) AS lag
+		FROM outbox
+		WHERE sent_at IS NULL
+	`).Scan(&stats).Error
+	if err != nil {
+		return err
+	}
+
+	metrics.OutboxPending.Set(float64(stats.Pending))
+	metrics.OutboxFailed.Set(float64(stats.Failed))
+	metrics.OutboxLag.Set(stats.Lag)
+	return nil
+}
+
+// cleanup removes the messages published longer ago than the retention
+func (r *Relay) cleanup(ctx context.Context) error {
+	return r.db.WithContext(ctx).
+		Where("sent_at < now() - make_interval(secs => ?)", r.config.Retention.Seconds()).
+		Delete(&models.OutboxMessage{}).Error
+}
@@ -0,0 +1,143 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"orderservice/internal/db/dbtest"
+	eventemitter "orderservice/internal/event_emitter"
+	"orderservice/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	eb "github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fakeEventBridge accepts the events put to it, except those of the rejected detail types,
+// which it reports as failed entries the way EventBridge does. When block is set, every put
+// waits for it to be closed after announcing itself on entered.
+type fakeEventBridge struct {
+	mu       sync.Mutex
+	rejected map[string]bool
+	emitted  []string
+	entered  chan string
+	block    chan struct{}
+}
+
+func (f *fakeEventBridge) PutEvents(ctx context.Context, params *eb.PutEventsInput, optFns ...func(*eb.Options)) (*eb.PutEventsOutput, error) {
+	detail := aws.ToString(params.Entries[0].Detail)
+	if f.block != nil {
+		f.entered <- detail
+		<-f.block
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rejected[aws.ToString(params.Entries[0].DetailType)] {
+		return &eb.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries:          []types.PutEventsResultEntry{{ErrorCode: aws.String("ThrottlingException"), ErrorMessage: aws.String("Rate exceeded")}},
+		}, nil
+	}
+	f.emitted = append(f.emitted, detail)
+	return &eb.PutEventsOutput{Entries: []types.PutEventsResultEntry{{EventId: aws.String(detail)}}}, nil
+}
+
+func (f *fakeEventBridge) Emitted() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.emitted...)
+}
+
+// enqueue writes messages of the detail type in the order given, their detail the id
+func enqueue(t *testing.T, db *gorm.DB, detailType string, ids ...string) {
+	createdAt := time.Now().Add(-time.Minute)
+	for i, id := range ids {
+		message := &models.OutboxMessage{
+			Source:       "com.order.service",
+			DetailType:   detailType,
+			Detail:       `"` + id + `"`,
+			EventBusName: "evbus",
+			CreatedAt:    createdAt.Add(time.Duration(i) * time.Millisecond),
+		}
+		require.NoError(t, db.Create(message).Error)
+	}
+}
+
+func outboxMessage(t *testing.T, db *gorm.DB, detail string) models.OutboxMessage {
+	var message models.OutboxMessage
+	require.NoError(t, db.Where("detail = ?::jsonb", `"`+detail+`"`).First(&message).Error)
+	return message
+}
+
+func TestPublishBatchRecordsRejectedEntries(t *testing.T) {
+	db := dbtest.Open(t)
+	enqueue(t, db, "order_placed", "1", "2")
+	enqueue(t, db, "order_cancelled", "3")
+
+	eventBridge := &fakeEventBridge{rejected: map[string]bool{"order_cancelled": true}}
+	relay := NewRelay(db, eventemitter.NewEventBridgeEmitterWithClient(eventBridge), Config{
+		BatchSize:    10,
+		MaxAttempts:  3,
+		RetryBackoff: time.Minute,
+	})
+
+	attempted, err := relay.PublishBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempted)
+	assert.Equal(t, []string{`"1"`, `"2"`}, eventBridge.Emitted())
+
+	for _, id := range []string{"1", "2"} {
+		message := outboxMessage(t, db, id)
+		assert.NotNil(t, message.SentAt, "message %s", id)
+		assert.Nil(t, message.LastError, "message %s", id)
+	}
+
+	rejected := outboxMessage(t, db, "3")
+	assert.Nil(t, rejected.SentAt)
+	assert.Nil(t, rejected.FailedAt)
+	assert.Equal(t, 1, rejected.Attempts)
+	require.NotNil(t, rejected.LastError)
+	assert.Contains(t, *rejected.LastError, "ThrottlingException")
+
+	// Nothing is due until the backoff passed
+	attempted, err = relay.PublishBatch(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+}
+
+func TestPublishBatchSkipsLockedMessages(t *testing.T) {
+	db := dbtest.Open(t)
+	enqueue(t, db, "order_placed", "1", "2", "3", "4")
+
+	blocked := &fakeEventBridge{entered: make(chan string, 2), block: make(chan struct{})}
+	first := NewRelay(db, eventemitter.NewEventBridgeEmitterWithClient(blocked), Config{BatchSize: 2})
+	free := &fakeEventBridge{}
+	second := NewRelay(db, eventemitter.NewEventBridgeEmitterWithClient(free), Config{BatchSize: 10})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := first.PublishBatch(context.Background())
+		done <- err
+	}()
+	// The first relay holds the locks on the oldest batch while it publishes
+	require.Equal(t, `"1"`, <-blocked.entered)
+
+	attempted, err := second.PublishBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempted)
+	assert.Equal(t, []string{`"3"`, `"4"`}, free.Emitted())
+
+	close(blocked.block)
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{`"1"`, `"2"`}, blocked.Emitted())
+
+	var pending int64
+	require.NoError(t, db.Model(&models.OutboxMessage{}).Where("sent_at IS NULL").Count(&pending).Error)
+	assert.Zero(t, pending)
+}
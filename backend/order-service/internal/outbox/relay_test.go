@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"orderservice/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayBackoffDoublesUpToMax(t *testing.T) {
+	relay := NewRelay(nil, nil, Config{RetryBackoff: time.Second, MaxRetryBackoff: 10 * time.Second})
+
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 2*time.Second, relay.backoff(2))
+	assert.Equal(t, 8*time.Second, relay.backoff(4))
+	assert.Equal(t, 10*time.Second, relay.backoff(5))
+	assert.Equal(t, 10*time.Second, relay.backoff(100))
+}
+
+func TestRelayOutcome(t *testing.T) {
+	relay := NewRelay(nil, nil, Config{MaxAttempts: 3})
+	err := errors.New("unavailable")
+
+	assert.Equal(t, outcomeSent, relay.outcome(3, nil))
+	assert.Equal(t, outcomeRetry, relay.outcome(2, err))
+	assert.Equal(t, outcomeFailed, relay.outcome(3, err))
+
+	unlimited := NewRelay(nil, nil, Config{})
+	assert.Equal(t, outcomeRetry, unlimited.outcome(1000, err))
+}
+
+func TestToEventKeepsTraceHeader(t *testing.T) {
+	message := &models.OutboxMessage{
+		Source:       "com.order.service",
+		DetailType:   "order_placed",
+		Detail:       `{"id":"1"}`,
+		EventBusName: "evbus",
+		TraceHeader:  aws.String("Root=1-4bf92f35-77b34da6a3ce929d0e0e4736;Parent=00f067aa0ba902b7;Sampled=1"),
+	}
+
+	event := toEvent(message)
+	assert.Equal(t, "order_placed", aws.ToString(event.DetailType))
+	assert.Equal(t, `{"id":"1"}`, aws.ToString(event.Detail))
+	assert.Equal(t, message.TraceHeader, event.TraceHeader)
+}
+
+func TestReplayNeedsFilter(t *testing.T) {
+	_, err := Replay(context.Background(), nil, ReplayFilter{DetailType: "order_placed"})
+	assert.ErrorIs(t, err, ErrNoReplayFilter)
+}
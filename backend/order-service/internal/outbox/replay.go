@@ -0,0 +1,85 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"orderservice/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrNoReplayFilter is returned by Replay without a filter, which would publish every
+// message again
+var ErrNoReplayFilter = errors.New("replay needs message IDs, a time range or the failed flag")
+
+// ReplayFilter selects the messages to publish again. Messages match when they match
+// every field that is set.
+type ReplayFilter struct {
+	IDs []uuid.UUID
+	// Since and Until bound the time the messages were written
+	Since, Until time.Time
+	DetailType   string
+	// FailedOnly selects the messages the relay gave up on
+	FailedOnly bool
+}
+
+func (f ReplayFilter) empty() bool {
+	return len(f.IDs) == 0 && f.Since.IsZero() && f.Until.IsZero() && !f.FailedOnly
+}
+
+func (f ReplayFilter) apply(db *gorm.DB) *gorm.DB {
+	if len(f.IDs) > 0 {
+		db = db.Where("id IN ?", f.IDs)
+	}
+	if !f.Since.IsZero() {
+		db = db.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("created_at < ?", f.Until)
+	}
+	if f.DetailType != "" {
+		db = db.Where("detail_type = ?", f.DetailType)
+	}
+	if f.FailedOnly {
+		db = db.Where("failed_at IS NOT NULL")
+	}
+	return db
+}
+
+// Replay queues the messages matching filter to be published again by the relay, whether
+// they were sent, failed or are still pending, and returns how many were queued. Only
+// messages still within the retention of the relay can be replayed.
+func Replay(ctx context.Context, db *gorm.DB, filter ReplayFilter) (int64, error) {
+	if filter.empty() {
+		return 0, ErrNoReplayFilter
+	}
+
+	result := filter.apply(db.WithContext(ctx).Model(&models.OutboxMessage{})).Updates(map[string]any{
+		"sent_at":         nil,
+		"failed_at":       nil,
+		"attempts":        0,
+		"last_error":      nil,
+		"next_attempt_at": gorm.Expr("now()"),
+	})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to replay outbox messages: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Count returns how many messages match filter, for a dry run of Replay
+func Count(ctx context.Context, db *gorm.DB, filter ReplayFilter) (int64, error) {
+	if filter.empty() {
+		return 0, ErrNoReplayFilter
+	}
+
+	var count int64
+	if err := filter.apply(db.WithContext(ctx).Model(&models.OutboxMessage{})).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count outbox messages: %w", err)
+	}
+	return count, nil
+}
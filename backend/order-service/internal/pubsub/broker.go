@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"context"
+	"orderservice/graph/model"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// subscriberBuffer is how many status changes a subscriber may lag behind before
+// changes are dropped for it
+const subscriberBuffer = 16
+
+// StatusChange is published when the status of an order detail changes
+type StatusChange struct {
+	// UserID owns the order of the detail
+	UserID      uuid.UUID          `json:"userId"`
+	OrderDetail *model.OrderDetail `json:"orderDetail"`
+}
+
+type subscriber struct {
+	filter  func(StatusChange) bool
+	changes chan *model.OrderDetail
+}
+
+// Broker delivers status changes to the GraphQL subscriptions served by this instance. The
+// changes made by every instance reach it through Notify and Listen.
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Publish delivers a status change to the subscribers it matches. A subscriber that is not
+// keeping up misses the change rather than blocking the publisher.
+func (b *Broker) Publish(change StatusChange) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscribers {
+		if !s.filter(change) {
+			continue
+		}
+		select {
+		case s.changes <- change.OrderDetail:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the order details of the status changes matching filter, until ctx is done
+func (b *Broker) Subscribe(ctx context.Context, filter func(StatusChange) bool) <-chan *model.OrderDetail {
+	s := &subscriber{filter: filter, changes: make(chan *model.OrderDetail, subscriberBuffer)}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, s)
+		b.mu.Unlock()
+		close(s.changes)
+	}()
+
+	return s.changes
+}
+
+// ForOrder matches the status changes of an order
+func ForOrder(orderID uuid.UUID) func(StatusChange) bool {
+	return func(change StatusChange) bool {
+		return change.OrderDetail.OrderID == orderID
+	}
+}
+
+// ForUser matches the status changes of every order of a user
+func ForUser(userID uuid.UUID) func(StatusChange) bool {
+	return func(change StatusChange) bool {
+		return change.UserID == userID
+	}
+}
@@ -0,0 +1,70 @@
+package pubsub
+
+import (
+	"context"
+	"orderservice/graph/model"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func change(userID, orderID uuid.UUID) StatusChange {
+	return StatusChange{
+		UserID:      userID,
+		OrderDetail: &model.OrderDetail{ID: uuid.New(), OrderID: orderID, Status: model.OrderDetailStatusCancelled},
+	}
+}
+
+func TestBrokerDeliversMatchingChanges(t *testing.T) {
+	broker := NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userID, orderID := uuid.New(), uuid.New()
+	forOrder := broker.Subscribe(ctx, ForOrder(orderID))
+	forUser := broker.Subscribe(ctx, ForUser(userID))
+
+	broker.Publish(change(uuid.New(), uuid.New()))
+	own := change(userID, orderID)
+	broker.Publish(own)
+
+	assert.Equal(t, own.OrderDetail, <-forOrder)
+	assert.Equal(t, own.OrderDetail, <-forUser)
+	assert.Empty(t, forOrder)
+	assert.Empty(t, forUser)
+}
+
+func TestBrokerDropsChangesForSlowSubscribers(t *testing.T) {
+	broker := NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userID := uuid.New()
+	changes := broker.Subscribe(ctx, ForUser(userID))
+	for i := 0; i < subscriberBuffer+5; i++ {
+		broker.Publish(change(userID, uuid.New()))
+	}
+
+	assert.Len(t, changes, subscriberBuffer)
+}
+
+func TestBrokerClosesSubscriptionWhenDone(t *testing.T) {
+	broker := NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes := broker.Subscribe(ctx, ForUser(uuid.New()))
+	cancel()
+
+	select {
+	case _, ok := <-changes:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed")
+	}
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	assert.Empty(t, broker.subscribers)
+}
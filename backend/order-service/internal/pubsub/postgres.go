@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// StatusChannel is the Postgres channel the status changes are notified on
+const StatusChannel = "order_status_changes"
+
+const (
+	listenRetryBackoff    = time.Second
+	maxListenRetryBackoff = 30 * time.Second
+)
+
+// Notify announces a status change to every instance listening on the database, this
+// one included. The notification is sent when the transaction of db commits, if any.
+func Notify(ctx context.Context, db *gorm.DB, change StatusChange) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to encode status change: %w", err)
+	}
+	if err := db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", StatusChannel, string(payload)).Error; err != nil {
+		return fmt.Errorf("failed to notify status change: %w", err)
+	}
+	return nil
+}
+
+// Listen publishes the status changes notified by every instance to the subscribers of
+// this one, until ctx is done. It holds a connection of db for as long, and takes another
+// when it is lost; changes notified in between are missed.
+func (b *Broker) Listen(ctx context.Context, db *gorm.DB) {
+	backoff := listenRetryBackoff
+	for {
+		started := time.Now()
+		err := b.listen(ctx, db)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > maxListenRetryBackoff {
+			backoff = listenRetryBackoff
+		}
+		logging.Ctx(ctx).Warn("Stopped listening for status changes, retrying", zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxListenRetryBackoff)
+	}
+}
+
+// listen publishes the status changes notified on a connection of db until it fails
+func (b *Broker) listen(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected database connection %T", driverConn)
+		}
+		pgConn := stdlibConn.Conn()
+
+		if _, err := pgConn.Exec(ctx, "LISTEN "+StatusChannel); err != nil {
+			return fmt.Errorf("failed to listen for status changes: %w", err)
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				// The connection is still listening, it must not go back to the pool
+				return fmt.Errorf("%w: %w", driver.ErrBadConn, err)
+			}
+			b.deliver(ctx, notification.Payload)
+		}
+	})
+}
+
+// deliver publishes a notified status change
+func (b *Broker) deliver(ctx context.Context, payload string) {
+	var change StatusChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil || change.OrderDetail == nil {
+		logging.Ctx(ctx).Warn("Ignoring invalid status change notification", zap.String("payload", payload), zap.Error(err))
+		return
+	}
+	b.Publish(change)
+}
@@ -0,0 +1,87 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"orderservice/graph/model"
+	"orderservice/internal/db/dbtest"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerDeliversNotifiedChanges(t *testing.T) {
+	broker := NewBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userID, orderID := uuid.New(), uuid.New()
+	changes := broker.Subscribe(ctx, ForOrder(orderID))
+
+	own := change(userID, orderID)
+	payload, err := json.Marshal(own)
+	require.NoError(t, err)
+
+	broker.deliver(ctx, "not json")
+	broker.deliver(ctx, `{"userId":"`+userID.String()+`"}`)
+	broker.deliver(ctx, string(payload))
+
+	delivered := <-changes
+	assert.Equal(t, own.OrderDetail.ID, delivered.ID)
+	assert.Equal(t, own.OrderDetail.Status, delivered.Status)
+	assert.Empty(t, changes)
+}
+
+func TestNotifyReachesEveryListeningBroker(t *testing.T) {
+	db := dbtest.Open(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two replicas, each serving a subscription to the order
+	orderID := uuid.New()
+	var subscriptions []<-chan *model.OrderDetail
+	for i := 0; i < 2; i++ {
+		broker := NewBroker()
+		go broker.Listen(ctx, db)
+		subscriptions = append(subscriptions, broker.Subscribe(ctx, ForOrder(orderID)))
+	}
+
+	// The brokers listen once they hold a connection, notifications before are missed
+	for i, changes := range subscriptions {
+		require.Eventually(t, func() bool {
+			if err := Notify(ctx, db, change(uuid.New(), orderID)); err != nil {
+				return false
+			}
+			select {
+			case <-changes:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 50*time.Millisecond, "replica %d", i)
+	}
+	// Room for the change to come, whatever is still in flight is skipped below
+	for _, changes := range subscriptions {
+		for len(changes) > 0 {
+			<-changes
+		}
+	}
+
+	notified := change(uuid.New(), orderID)
+	require.NoError(t, Notify(ctx, db, notified))
+	timeout := time.After(5 * time.Second)
+	for i, changes := range subscriptions {
+		for received := false; !received; {
+			select {
+			case detail := <-changes:
+				received = detail.ID == notified.OrderDetail.ID
+			case <-timeout:
+				t.Fatalf("replica %d was not notified", i)
+			}
+		}
+	}
+}
@@ -36,6 +36,7 @@ type OrderRepository interface {
 	GetOrderByID(ctx context.Context, tx *gorm.DB, id uuid.UUID) (*models.Order, error)
 
 	GetOrderDetailByID(ctx context.Context, tx *gorm.DB, id uuid.UUID) (*models.OrderDetail, error)
+	GetCurrencyByID(ctx context.Context, tx *gorm.DB, id uuid.UUID) (*models.Currency, error)
 
 	GetOrdersByUserId(
 		ctx context.Context,
@@ -261,6 +262,14 @@ func (r *orderRepository) GetOrderDetailByID(ctx context.Context, tx *gorm.DB, i
 	return &orderDetail, nil
 }
 
+func (r *orderRepository) GetCurrencyByID(ctx context.Context, tx *gorm.DB, id uuid.UUID) (*models.Currency, error) {
+	var currency models.Currency
+	if err := tx.WithContext(ctx).Table(currencyTable).Where("id = ?", id).First(&currency).Error; err != nil {
+		return nil, fmt.Errorf("currency not found: %w", err)
+	}
+	return &currency, nil
+}
+
 func (r *orderRepository) UpdateOrderStatus(ctx context.Context, tx *gorm.DB, orderDetailId uuid.UUID, newStatus model.OrderDetailStatus) (*models.OrderDetail, error) {
 	var detail models.OrderDetail
 	if err := tx.WithContext(ctx).Model(&models.OrderDetail{}).
@@ -276,7 +285,7 @@ func (r *orderRepository) UpdateOrderStatus(ctx context.Context, tx *gorm.DB, or
 
 func (r *orderRepository) CancelOrder(ctx context.Context, tx *gorm.DB, id uuid.UUID) (*models.Order, error) {
 	if err := tx.WithContext(ctx).Model(&models.OrderDetail{}).
-		Where("id = ?", id).
+		Where("orders_id = ?", id).
 		Update("status", model.OrderDetailStatusCancelled.String()).Error; err != nil {
 		return nil, fmt.Errorf("failed to cancel order: %w", err)
 	}
@@ -0,0 +1,216 @@
+// Package saga orchestrates the flow of an order across the inventory and notification
+// services. The saga of each order is persisted, and advanced in the transaction handling
+// the event that moves it, so it changes with the order and its inbox record. A step that
+// fails or times out is compensated by cancelling the order and releasing its inventory.
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"orderservice/internal/metrics"
+	"orderservice/internal/models"
+	"orderservice/pkg/enums"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotFound is returned for an order without a saga, placed before sagas were recorded
+	ErrNotFound = errors.New("order saga not found")
+	// ErrInvalidTransition is returned for a trigger that does not apply to the state of a saga
+	ErrInvalidTransition = errors.New("invalid order saga transition")
+)
+
+// State is the state of a saga
+type State string
+
+const (
+	// StateAwaitingNotification is the state of an order placed on reserved inventory, whose
+	// customer is yet to be notified
+	StateAwaitingNotification State = "awaiting_notification"
+	StateCompleted            State = "completed"
+	// StateCancelled is the state of an order cancelled by its owner or support
+	StateCancelled State = "cancelled"
+	// StateFailed is the state of an order cancelled because a step failed or timed out
+	StateFailed State = "failed"
+)
+
+// Trigger advances a saga: the detail type of an event, a cancellation or a timeout
+type Trigger string
+
+var (
+	InventoryReserved          = Trigger(enums.EVENT_TYPE.InventoryReserved.String())
+	InventoryReservationFailed = Trigger(enums.EVENT_TYPE.InventoryReservationFailed.String())
+	NotificationSent           = Trigger(enums.EVENT_TYPE.NotificationSentSuccess.String())
+	NotificationFailed         = Trigger(enums.EVENT_TYPE.NotificationSentFailed.String())
+	// Cancelled is the cancellation of the order by its owner or support
+	Cancelled = Trigger(enums.EVENT_TYPE.OrderCancelled.String())
+)
+
+// Timeout is the expiry of the deadline of the step a saga waits on
+const Timeout Trigger = "timeout"
+
+// Action is what a transition does to the order of the saga
+type Action int
+
+const (
+	// ActionNone leaves the order as it is
+	ActionNone Action = iota
+	// ActionComplete completes the items of the order
+	ActionComplete
+	// ActionCancel cancels the items of the order, whose inventory was not reserved
+	ActionCancel
+	// ActionRelease cancels the items of the order and releases their inventory, the
+	// compensation of a reserved order
+	ActionRelease
+)
+
+type transition struct {
+	to     State
+	action Action
+}
+
+// transitions are the triggers each state goes on with, terminal states have none but
+// the cancellation of a completed order
+var transitions = map[State]map[Trigger]transition{
+	StateAwaitingNotification: {
+		NotificationSent:           {StateCompleted, ActionComplete},
+		NotificationFailed:         {StateFailed, ActionRelease},
+		InventoryReservationFailed: {StateFailed, ActionCancel},
+		Cancelled:                  {StateCancelled, ActionRelease},
+		Timeout:                    {StateFailed, ActionRelease},
+	},
+	StateCompleted: {
+		Cancelled: {StateCancelled, ActionRelease},
+	},
+}
+
+// Next returns the state a saga in state goes to on trigger, and the action to take on its
+// order. It returns ErrInvalidTransition when trigger does not apply to state.
+func Next(state State, trigger Trigger) (State, Action, error) {
+	next, ok := transitions[state][trigger]
+	if !ok {
+		return "", ActionNone, fmt.Errorf("%w: %s in state %s", ErrInvalidTransition, trigger, state)
+	}
+	return next.to, next.action, nil
+}
+
+// Step is a trigger applied to a saga
+type Step struct {
+	Trigger Trigger
+	// EventID is the id of the event of the trigger, if any
+	EventID string
+	// Reason explains a failure or cancellation
+	Reason string
+}
+
+// Result is a transition applied to a saga
+type Result struct {
+	Saga   *models.OrderSaga
+	From   State
+	Action Action
+}
+
+// Start starts the saga of an order placed on reserved inventory in tx. The notification
+// of the customer is awaited for timeout.
+func Start(ctx context.Context, tx *gorm.DB, orderID uuid.UUID, eventID string, timeout time.Duration) error {
+	err := tx.WithContext(ctx).Model(&models.OrderSaga{}).Create(map[string]any{
+		"order_id":   orderID,
+		"state":      string(StateAwaitingNotification),
+		"last_event": string(InventoryReserved),
+		"deadline":   gorm.Expr("now() + make_interval(secs => ?)", timeout.Seconds()),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to start saga of order %s: %w", orderID, err)
+	}
+	saga := &models.OrderSaga{OrderID: orderID, State: string(StateAwaitingNotification)}
+	if err := recordStep(ctx, tx, saga, nil, Step{Trigger: InventoryReserved, EventID: eventID}); err != nil {
+		return err
+	}
+
+	metrics.SagaTransitions.WithLabelValues(string(InventoryReserved), saga.State).Inc()
+	return nil
+}
+
+// Advance applies step to the saga of an order in tx, and returns the action to take on
+// the order. The saga stays locked until tx ends, so concurrent steps apply one after
+// the other.
+func Advance(ctx context.Context, tx *gorm.DB, orderID uuid.UUID, step Step) (*Result, error) {
+	var saga models.OrderSaga
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ?", orderID).
+		First(&saga).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: order %s", ErrNotFound, orderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load saga of order %s: %w", orderID, err)
+	}
+
+	from := State(saga.State)
+	to, action, err := Next(from, step.Trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	saga.State = string(to)
+	saga.LastEvent = string(step.Trigger)
+	// Every state but awaiting_notification is over, so nothing is left to time out
+	saga.Deadline = nil
+	if step.Reason != "" {
+		saga.Reason = &step.Reason
+	}
+	if err := tx.WithContext(ctx).Model(&saga).Select("state", "last_event", "deadline", "reason").Updates(&saga).Error; err != nil {
+		return nil, fmt.Errorf("failed to update saga of order %s: %w", orderID, err)
+	}
+	fromState := string(from)
+	if err := recordStep(ctx, tx, &saga, &fromState, step); err != nil {
+		return nil, err
+	}
+
+	metrics.SagaTransitions.WithLabelValues(string(step.Trigger), saga.State).Inc()
+	return &Result{Saga: &saga, From: from, Action: action}, nil
+}
+
+func recordStep(ctx context.Context, tx *gorm.DB, saga *models.OrderSaga, from *string, step Step) error {
+	record := &models.OrderSagaStep{
+		OrderID:   saga.OrderID,
+		FromState: from,
+		ToState:   saga.State,
+		Trigger:   string(step.Trigger),
+	}
+	if step.EventID != "" {
+		record.EventID = &step.EventID
+	}
+	if step.Reason != "" {
+		record.Reason = &step.Reason
+	}
+	if err := tx.WithContext(ctx).Create(record).Error; err != nil {
+		return fmt.Errorf("failed to record saga step of order %s: %w", saga.OrderID, err)
+	}
+	return nil
+}
+
+// Get returns the saga of an order with its steps, oldest first
+func Get(ctx context.Context, db *gorm.DB, orderID uuid.UUID) (*models.OrderSaga, []*models.OrderSagaStep, error) {
+	var saga models.OrderSaga
+	err := db.WithContext(ctx).Where("order_id = ?", orderID).First(&saga).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, fmt.Errorf("%w: order %s", ErrNotFound, orderID)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load saga of order %s: %w", orderID, err)
+	}
+
+	var steps []*models.OrderSagaStep
+	if err := db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at ASC").Find(&steps).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to load saga steps of order %s: %w", orderID, err)
+	}
+	return &saga, steps, nil
+}
@@ -0,0 +1,56 @@
+package saga
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		state   State
+		trigger Trigger
+		to      State
+		action  Action
+	}{
+		{StateAwaitingNotification, NotificationSent, StateCompleted, ActionComplete},
+		{StateAwaitingNotification, NotificationFailed, StateFailed, ActionRelease},
+		{StateAwaitingNotification, InventoryReservationFailed, StateFailed, ActionCancel},
+		{StateAwaitingNotification, Cancelled, StateCancelled, ActionRelease},
+		{StateAwaitingNotification, Timeout, StateFailed, ActionRelease},
+		{StateCompleted, Cancelled, StateCancelled, ActionRelease},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state)+"/"+string(tt.trigger), func(t *testing.T) {
+			to, action, err := Next(tt.state, tt.trigger)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.to, to)
+			assert.Equal(t, tt.action, action)
+		})
+	}
+}
+
+func TestNextRejectsTriggersNotApplying(t *testing.T) {
+	tests := []struct {
+		state   State
+		trigger Trigger
+	}{
+		// A saga is started by the reservation, never advanced by it
+		{StateAwaitingNotification, InventoryReserved},
+		// A redelivered or late notification leaves a completed order as it is
+		{StateCompleted, NotificationSent},
+		{StateCompleted, NotificationFailed},
+		{StateCompleted, Timeout},
+		{StateCancelled, Cancelled},
+		{StateCancelled, NotificationSent},
+		{StateFailed, Cancelled},
+		{StateFailed, Timeout},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state)+"/"+string(tt.trigger), func(t *testing.T) {
+			_, action, err := Next(tt.state, tt.trigger)
+			assert.ErrorIs(t, err, ErrInvalidTransition)
+			assert.Equal(t, ActionNone, action)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"orderservice/internal/models"
+	"orderservice/internal/utils"
+
+	"github.com/demo-micro/backend/pkg/logging"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Config configures how long sagas wait on their steps
+type Config struct {
+	// NotificationTimeout is how long the notification of the customer is awaited before
+	// the order is cancelled and its inventory released
+	NotificationTimeout time.Duration
+	// TimeoutInterval is the time between looking for sagas past their deadline
+	TimeoutInterval time.Duration
+	// TimeoutBatchSize is the number of sagas timed out per transaction
+	TimeoutBatchSize int
+}
+
+// ConfigFromEnv reads the configuration from SAGA_NOTIFICATION_TIMEOUT,
+// SAGA_TIMEOUT_INTERVAL and SAGA_TIMEOUT_BATCH_SIZE
+func ConfigFromEnv() Config {
+	return Config{
+		NotificationTimeout: utils.GetEnv("SAGA_NOTIFICATION_TIMEOUT", 15*time.Minute),
+		TimeoutInterval:     utils.GetEnv("SAGA_TIMEOUT_INTERVAL", 30*time.Second),
+		TimeoutBatchSize:    utils.GetEnv("SAGA_TIMEOUT_BATCH_SIZE", 100),
+	}
+}
+
+// Expired returns the orders of up to limit sagas past their deadline, locked until tx
+// ends. Replicas share the work: sagas locked by another transaction are skipped.
+func Expired(ctx context.Context, tx *gorm.DB, limit int) ([]uuid.UUID, error) {
+	var orderIDs []uuid.UUID
+	err := tx.WithContext(ctx).
+		Model(&models.OrderSaga{}).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("deadline IS NOT NULL AND deadline < now()").
+		Order("deadline ASC").
+		Limit(limit).
+		Pluck("order_id", &orderIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired sagas: %w", err)
+	}
+	return orderIDs, nil
+}
+
+// RunTimeouts calls expire every interval until ctx is done. expire times out a batch of
+// sagas and returns how many, batches are expired until one is not full.
+func RunTimeouts(ctx context.Context, config Config, expire func(ctx context.Context, limit int) (int, error)) {
+	ticker := time.NewTicker(config.TimeoutInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			expired, err := expire(ctx, config.TimeoutBatchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.Ctx(ctx).Warn("Failed to time out order sagas", zap.Error(err))
+				}
+				break
+			}
+			if expired > 0 {
+				logging.Ctx(ctx).Info("Timed out order sagas", zap.Int("expired", expired))
+			}
+			if expired < config.TimeoutBatchSize {
+				break
+			}
+		}
+	}
+}
@@ -3,28 +3,39 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"orderservice/graph/model"
 	eventemitter "orderservice/internal/event_emitter"
+	"orderservice/internal/inbox"
 	"orderservice/internal/models"
+	"orderservice/internal/outbox"
+	"orderservice/internal/pubsub"
 	"orderservice/internal/repository"
+	"orderservice/internal/saga"
 	"orderservice/internal/utils"
 	"orderservice/pkg/enums"
 	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/demo-micro/backend/pkg/logging"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// ErrOrderNotCancellable is returned by CancelOrder for an order whose saga failed or was
+// cancelled already
+var ErrOrderNotCancellable = errors.New("order can no longer be cancelled")
+
 type OrderService interface {
 	runTransaction(ctx context.Context, fn func(tx *gorm.DB) (any, error)) (any, error)
 
 	CreateOrder(ctx context.Context, userId uuid.UUID, items []OrderItemInput) (*model.Order, error)
 	GetAllOrders(ctx context.Context, first *int32, after *time.Time) (*model.OrderConnection, error)
 	GetOrderByID(ctx context.Context, id uuid.UUID) (*model.Order, error)
+	GetOrderByOrderDetailID(ctx context.Context, orderDetailID uuid.UUID) (*model.Order, error)
 	GetOrdersByUserId(ctx context.Context, userID uuid.UUID, first *int32, after *time.Time) (*model.OrderConnection, error)
 	CancelOrder(ctx context.Context, orderId uuid.UUID) (*model.Order, error)
 
@@ -32,12 +43,27 @@ type OrderService interface {
 	GetOrdersDetailByOrderId(ctx context.Context, orderId uuid.UUID, first *int32, after *time.Time) (*model.OrderDetailConnection, error)
 	UpdateOrderDetail(ctx context.Context, orderDetailID uuid.UUID, quantity *int32, status *model.OrderDetailStatus) (*model.OrderDetail, error)
 
+	// The event handlers record their event in the inbox in the transaction of their
+	// changes, and return inbox.ErrAlreadyProcessed for an event handled before
 	HandleInventoryReservedEvent(
 		ctx context.Context,
+		entry inbox.Entry,
 		userID uuid.UUID,
 		items []OrderItemInput,
 	) (*model.Order, error)
-	HandleOrderProcessingNotificationSentEvent(ctx context.Context, id uuid.UUID) (*model.Order, error)
+	// The events of the saga of an order return it once advanced, or nil when they do not
+	// apply to it, see saga.Next
+	HandleInventoryReservationFailedEvent(ctx context.Context, entry inbox.Entry, orderID uuid.UUID, reason string) (*model.OrderSaga, error)
+	HandleOrderProcessingNotificationSentEvent(ctx context.Context, entry inbox.Entry, orderID uuid.UUID) (*model.OrderSaga, error)
+	HandleNotificationSentFailedEvent(ctx context.Context, entry inbox.Entry, orderID uuid.UUID, reason string) (*model.OrderSaga, error)
+
+	// GetOrderSaga returns the saga of an order, nil for orders placed before sagas were recorded
+	GetOrderSaga(ctx context.Context, orderID uuid.UUID) (*model.OrderSaga, error)
+	// ExpireSagas times out up to limit sagas past their deadline, and returns how many
+	ExpireSagas(ctx context.Context, limit int) (int, error)
+
+	SubscribeOrderStatus(ctx context.Context, orderID uuid.UUID) <-chan *model.OrderDetail
+	SubscribeUserOrderStatus(ctx context.Context, userID uuid.UUID) <-chan *model.OrderDetail
 }
 
 type OrderItemInput struct {
@@ -69,23 +95,38 @@ func ToModelOrderItemInputs(items []OrderItemInput) []*repository.OrderItemInput
 	return result
 }
 
+// orderService writes the events of its changes to the outbox in the transaction of the
+// change, they are published by the outbox relay once the transaction committed
 type orderService struct {
-	orderRepo    repository.OrderRepository
-	eventEmitter eventemitter.EventEmitter
-	db           *gorm.DB
+	orderRepo     repository.OrderRepository
+	db            *gorm.DB
+	sagaConfig    saga.Config
+	statusChanges *pubsub.Broker
 }
 
-func NewOrderService(orderRepo repository.OrderRepository, eventEmitter eventemitter.EventEmitter, db *gorm.DB) OrderService {
+// NewOrderService returns the service, its subscriptions served by statusChanges, which
+// must be listening on db
+func NewOrderService(orderRepo repository.OrderRepository, db *gorm.DB, sagaConfig saga.Config, statusChanges *pubsub.Broker) OrderService {
 	return &orderService{
-		orderRepo:    orderRepo,
-		eventEmitter: eventEmitter,
-		db:           db,
+		orderRepo:     orderRepo,
+		db:            db,
+		sagaConfig:    sagaConfig,
+		statusChanges: statusChanges,
 	}
 }
 
+// statusChangeResult is returned by the transactions changing the status of order details,
+// the changes are published once they commit
+type statusChangeResult struct {
+	order   *model.Order
+	details []*model.OrderDetail
+	// saga is set by the transactions advancing the saga of the order
+	saga *model.OrderSaga
+}
+
 func (s *orderService) runTransaction(ctx context.Context, fn func(tx *gorm.DB) (any, error)) (any, error) {
 	var result any
-	err := s.db.Transaction(func(tx *gorm.DB) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		var err error
 		result, err = fn(tx)
 		return err
@@ -173,6 +214,34 @@ func (s *orderService) GetOrderByID(ctx context.Context, id uuid.UUID) (*model.O
 	return order, nil
 }
 
+// GetOrderByOrderDetailID returns the order an order detail belongs to
+func (s *orderService) GetOrderByOrderDetailID(ctx context.Context, orderDetailID uuid.UUID) (*model.Order, error) {
+	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
+		detail, err := s.orderRepo.GetOrderDetailByID(ctx, tx, orderDetailID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying db, %v", err)
+		}
+
+		res, err := s.orderRepo.GetOrderByID(ctx, tx, detail.OrderID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying db, %v", err)
+		}
+
+		return res.ToModelOrder(), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	order, ok := result.(*model.Order)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type from transaction")
+	}
+
+	return order, nil
+}
+
 func (s *orderService) GetOrdersByUserId(
 	ctx context.Context,
 	userId uuid.UUID,
@@ -232,20 +301,7 @@ func (s *orderService) CreateOrder(
 	items []OrderItemInput,
 ) (*model.Order, error) {
 	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
-		transformedItems := ToModelOrderItemInputs(items)
-		createdOrder, err := s.orderRepo.CreateOrder(ctx, tx, userId, transformedItems)
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to insert order: %v", err)
-		}
-
-		order := createdOrder.ToModelOrder()
-
-		if err := s.emitEvent(ctx, order, enums.OrderPlaced); err != nil {
-			return nil, fmt.Errorf("failed to emit order event: %v", err)
-		}
-
-		return order, nil
+		return s.createOrder(ctx, tx, userId, items)
 	})
 	if err != nil {
 		//err := s.emitEvent(ctx, "failed to create order", enums.OrderPlacedFail)
@@ -260,6 +316,21 @@ func (s *orderService) CreateOrder(
 	return order, nil
 }
 
+// createOrder inserts an order in tx, with its order_placed event
+func (s *orderService) createOrder(ctx context.Context, tx *gorm.DB, userId uuid.UUID, items []OrderItemInput) (*model.Order, error) {
+	createdOrder, err := s.orderRepo.CreateOrder(ctx, tx, userId, ToModelOrderItemInputs(items))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert order: %v", err)
+	}
+
+	order := createdOrder.ToModelOrder()
+	if err := s.emitEvent(ctx, tx, order, enums.OrderPlaced); err != nil {
+		return nil, fmt.Errorf("failed to emit order event: %v", err)
+	}
+
+	return order, nil
+}
+
 func (s *orderService) GetAllOrdersDetail(
 	ctx context.Context,
 	first *int32,
@@ -465,112 +536,352 @@ func (s *orderService) UpdateOrderDetail(ctx context.Context, orderDetailID uuid
 	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
 		var newOrderDetail models.OrderDetail
 		newOrderDetail.ID = orderDetailID
-		newOrderDetail.Quantity = int(*quantity)
-		newOrderDetail.Status = status.String()
+		if quantity != nil {
+			newOrderDetail.Quantity = int(*quantity)
+		}
+		if status != nil {
+			newOrderDetail.Status = status.String()
+		}
 
 		orderDetail, err := s.orderRepo.UpdateOrderDetail(ctx, tx, newOrderDetail)
 		if err != nil {
 			return nil, fmt.Errorf("error updating order detail: %v", err)
 		}
-		return orderDetail, nil
+		if err := s.emitEvent(ctx, tx, orderDetail, enums.OrderUpdated); err != nil {
+			return nil, fmt.Errorf("failed to emit an event: %v", err)
+		}
+
+		order, err := s.orderRepo.GetOrderByID(ctx, tx, orderDetail.OrderID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying db, %v", err)
+		}
+		details, err := s.toModelOrderDetails(ctx, tx, []*models.OrderDetail{orderDetail})
+		if err != nil {
+			return nil, err
+		}
+		return &statusChangeResult{order: order.ToModelOrder(), details: details}, nil
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	orderDetail, ok := result.(*model.OrderDetail)
+	change, ok := result.(*statusChangeResult)
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type from transaction")
 	}
 
-	return orderDetail, nil
+	if status != nil {
+		s.publishStatusChanges(ctx, change.order.UserID, change.details)
+	}
+	return change.details[0], nil
 }
 
+// CancelOrder cancels an order through its saga, releasing its inventory. Orders placed
+// before sagas were recorded are cancelled all the same, orders whose saga failed or was
+// cancelled return ErrOrderNotCancellable.
 func (s *orderService) CancelOrder(ctx context.Context, orderId uuid.UUID) (*model.Order, error) {
 	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
-		order, err := s.orderRepo.CancelOrder(ctx, tx, orderId)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch order details: %v", err)
+		action := saga.ActionRelease
+		transition, err := saga.Advance(ctx, tx, orderId, saga.Step{Trigger: saga.Cancelled})
+		switch {
+		case errors.Is(err, saga.ErrNotFound):
+			// Orders placed before sagas were recorded have none to advance
+		case errors.Is(err, saga.ErrInvalidTransition):
+			return nil, ErrOrderNotCancellable
+		case err != nil:
+			return nil, err
+		default:
+			action = transition.Action
 		}
-		return order, err
+
+		return s.applySagaAction(ctx, tx, orderId, action, string(saga.Cancelled))
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	order, ok := result.(*model.Order)
+	change, ok := result.(*statusChangeResult)
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type from transaction")
 	}
 
-	return order, nil
+	s.publishStatusChanges(ctx, change.order.UserID, change.details)
+	return change.order, nil
+}
+
+// SubscribeOrderStatus returns the status changes of the items of an order, until ctx is done
+func (s *orderService) SubscribeOrderStatus(ctx context.Context, orderID uuid.UUID) <-chan *model.OrderDetail {
+	return s.statusChanges.Subscribe(ctx, pubsub.ForOrder(orderID))
+}
+
+// SubscribeUserOrderStatus returns the status changes of the items of every order of a user,
+// until ctx is done
+func (s *orderService) SubscribeUserOrderStatus(ctx context.Context, userID uuid.UUID) <-chan *model.OrderDetail {
+	return s.statusChanges.Subscribe(ctx, pubsub.ForUser(userID))
 }
 
-func (s *orderService) emitEvent(ctx context.Context, detail any, detailType enums.OrderEventType) error {
+// publishStatusChanges notifies the subscriptions served by every instance. The changes are
+// committed already, a subscriber missing them is not worth failing the request for.
+func (s *orderService) publishStatusChanges(ctx context.Context, userID uuid.UUID, details []*model.OrderDetail) {
+	for _, detail := range details {
+		if err := pubsub.Notify(ctx, s.db, pubsub.StatusChange{UserID: userID, OrderDetail: detail}); err != nil {
+			logging.Ctx(ctx).Warn("Failed to publish status change", zap.String("order_detail_id", detail.ID.String()), zap.Error(err))
+		}
+	}
+}
+
+// toModelOrderDetails converts order details to their GraphQL model, with the names of
+// their currencies
+func (s *orderService) toModelOrderDetails(ctx context.Context, tx *gorm.DB, details []*models.OrderDetail) ([]*model.OrderDetail, error) {
+	currencies := make(map[uuid.UUID]string)
+	result := make([]*model.OrderDetail, 0, len(details))
+	for _, detail := range details {
+		name, ok := currencies[detail.CurrencyID]
+		if !ok {
+			currency, err := s.orderRepo.GetCurrencyByID(ctx, tx, detail.CurrencyID)
+			if err != nil {
+				return nil, fmt.Errorf("error querying db, %v", err)
+			}
+			name = currency.Name
+			currencies[detail.CurrencyID] = name
+		}
+		result = append(result, detail.ToModelOrderDetail(name))
+	}
+	return result, nil
+}
+
+// emitEvent writes an event to the outbox in tx, so it is published if and only if tx
+// commits. The event keeps the trace context of ctx.
+func (s *orderService) emitEvent(ctx context.Context, tx *gorm.DB, detail any, detailType enums.OrderEventType) error {
 	detailJSON, err := json.Marshal(detail)
 	if err != nil {
-		log.Fatalf("failed to marshal order detail: %v", err)
-		return err
+		return fmt.Errorf("failed to marshal event detail: %w", err)
 	}
-	correlationID := uuid.New().String()
-
 	event := &eventemitter.Event{
 		Source:       aws.String(os.Getenv("EVENT_BRIDGE_EVENT_SOURCE")),
 		DetailType:   aws.String(detailType.String()),
 		Detail:       aws.String(string(detailJSON)),
 		EventBusName: aws.String(os.Getenv("EVENT_BRIDGE_BUS_NAME")),
-		TraceHeader:  aws.String(correlationID),
 	}
 
-	return s.eventEmitter.Emit(ctx, event)
+	return outbox.Enqueue(ctx, tx, event)
 }
 
 func (s *orderService) HandleInventoryReservedEvent(
 	ctx context.Context,
+	entry inbox.Entry,
 	userID uuid.UUID,
 	items []OrderItemInput,
 ) (*model.Order, error) {
 	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
-		order, err := s.CreateOrder(ctx, userID, items)
+		if err := inbox.Record(ctx, tx, entry); err != nil {
+			return nil, err
+		}
 
+		order, err := s.createOrder(ctx, tx, userID, items)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create order %s", err)
 		}
-		err = s.emitEvent(ctx, order, enums.OrderPlaced)
-		if err != nil {
-			return nil, fmt.Errorf("failed to emit an event %s", err)
+		if err := saga.Start(ctx, tx, order.ID, entry.EventID, s.sagaConfig.NotificationTimeout); err != nil {
+			return nil, err
 		}
 
 		return order, nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	order, ok := result.(*model.Order)
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type from transaction")
 	}
-	return order, err
+	return order, nil
+}
+
+func (s *orderService) HandleInventoryReservationFailedEvent(ctx context.Context, entry inbox.Entry, orderID uuid.UUID, reason string) (*model.OrderSaga, error) {
+	return s.advanceSaga(ctx, entry, orderID, saga.Step{Trigger: saga.InventoryReservationFailed, EventID: entry.EventID, Reason: reason})
+}
+
+func (s *orderService) HandleOrderProcessingNotificationSentEvent(ctx context.Context, entry inbox.Entry, orderID uuid.UUID) (*model.OrderSaga, error) {
+	return s.advanceSaga(ctx, entry, orderID, saga.Step{Trigger: saga.NotificationSent, EventID: entry.EventID})
+}
+
+func (s *orderService) HandleNotificationSentFailedEvent(ctx context.Context, entry inbox.Entry, orderID uuid.UUID, reason string) (*model.OrderSaga, error) {
+	return s.advanceSaga(ctx, entry, orderID, saga.Step{Trigger: saga.NotificationFailed, EventID: entry.EventID, Reason: reason})
 }
 
-func (s *orderService) HandleOrderProcessingNotificationSentEvent(ctx context.Context, orderDetailId uuid.UUID) (*model.Order, error) {
+// advanceSaga applies step to the saga of an order, in the transaction recording the event
+// of the step in the inbox. An event that does not apply to the saga is recorded all the
+// same, so it is not handled again, and nil is returned.
+func (s *orderService) advanceSaga(ctx context.Context, entry inbox.Entry, orderID uuid.UUID, step saga.Step) (*model.OrderSaga, error) {
 	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
-		order, err := s.orderRepo.UpdateOrderStatus(ctx, tx, orderDetailId, model.OrderDetailStatusCompleted)
+		if err := inbox.Record(ctx, tx, entry); err != nil {
+			return nil, err
+		}
 
+		transition, err := saga.Advance(ctx, tx, orderID, step)
+		if errors.Is(err, saga.ErrNotFound) || errors.Is(err, saga.ErrInvalidTransition) {
+			logging.Ctx(ctx).Info("Ignoring event for the order saga", zap.String("order_id", orderID.String()), zap.Error(err))
+			return &statusChangeResult{}, nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to update order, %s", err)
+			return nil, err
 		}
-		err = s.emitEvent(ctx, order, enums.OrderUpdated)
+
+		change, err := s.applySagaAction(ctx, tx, orderID, transition.Action, string(step.Trigger))
 		if err != nil {
-			return nil, fmt.Errorf("failed to emit an event, %s", err)
+			return nil, err
 		}
+		change.saga = transition.Saga.ToModelOrderSaga(nil)
+		return change, nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		return order, nil
+	change, ok := result.(*statusChangeResult)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type from transaction")
+	}
+
+	if change.order != nil {
+		s.publishStatusChanges(ctx, change.order.UserID, change.details)
+	}
+	return change.saga, nil
+}
+
+// applySagaAction takes the action of a saga transition on its order in tx, reason is the
+// trigger of the transition
+func (s *orderService) applySagaAction(ctx context.Context, tx *gorm.DB, orderID uuid.UUID, action saga.Action, reason string) (*statusChangeResult, error) {
+	orderDetails, err := s.orderRepo.GetOrderDetailByOrderID(ctx, tx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch order details: %v", err)
+	}
+
+	var changed []*models.OrderDetail
+	switch action {
+	case saga.ActionComplete:
+		for _, detail := range orderDetails {
+			if detail.Status == model.OrderDetailStatusCancelled.String() {
+				continue
+			}
+			orderDetail, err := s.orderRepo.UpdateOrderStatus(ctx, tx, detail.ID, model.OrderDetailStatusCompleted)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update order, %s", err)
+			}
+			if err := s.emitEvent(ctx, tx, orderDetail, enums.OrderUpdated); err != nil {
+				return nil, fmt.Errorf("failed to emit an event, %s", err)
+			}
+			changed = append(changed, orderDetail)
+		}
+	case saga.ActionCancel, saga.ActionRelease:
+		order, err := s.orderRepo.CancelOrder(ctx, tx, orderID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to cancel order: %v", err)
+		}
+		cancelled := &models.OrderCancelledEventDetail{
+			ID:             order.ID.String(),
+			OrderID:        order.ID.String(),
+			UserID:         order.UserID.String(),
+			OrderDetailIDs: make([]string, 0, len(orderDetails)),
+			Items:          []*models.ReleasedItem{},
+			Reason:         reason,
+			CancelledAt:    time.Now().UTC().Format(time.RFC3339),
+		}
+		for _, detail := range orderDetails {
+			cancelled.OrderDetailIDs = append(cancelled.OrderDetailIDs, detail.ID.String())
+		}
+		// The inventory of an order whose reservation failed has nothing to release
+		if action == saga.ActionRelease {
+			for _, detail := range orderDetails {
+				cancelled.Items = append(cancelled.Items, &models.ReleasedItem{
+					ProductID: detail.ProductID.String(),
+					Quantity:  int32(detail.Quantity),
+				})
+			}
+		}
+		if err := s.emitEvent(ctx, tx, cancelled, enums.OrderCancelled); err != nil {
+			return nil, fmt.Errorf("failed to emit an event: %v", err)
+		}
+		if changed, err = s.orderRepo.GetOrderDetailByOrderID(ctx, tx, orderID); err != nil {
+			return nil, fmt.Errorf("failed to fetch order details: %v", err)
+		}
+	}
+
+	order, err := s.orderRepo.GetOrderByID(ctx, tx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying db, %v", err)
+	}
+	details, err := s.toModelOrderDetails(ctx, tx, changed)
+	if err != nil {
+		return nil, err
+	}
+	return &statusChangeResult{order: order.ToModelOrder(), details: details}, nil
+}
+
+// GetOrderSaga returns the saga of an order with its steps
+func (s *orderService) GetOrderSaga(ctx context.Context, orderID uuid.UUID) (*model.OrderSaga, error) {
+	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
+		orderSaga, steps, err := saga.Get(ctx, tx, orderID)
+		if errors.Is(err, saga.ErrNotFound) {
+			return (*model.OrderSaga)(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return orderSaga.ToModelOrderSaga(steps), nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	order, ok := result.(*model.Order)
+	orderSaga, ok := result.(*model.OrderSaga)
 	if !ok {
 		return nil, fmt.Errorf("unexpected result type from transaction")
 	}
-	return order, err
+	return orderSaga, nil
+}
+
+// ExpireSagas compensates the sagas whose notification was not received before their
+// deadline, cancelling their order and releasing its inventory
+func (s *orderService) ExpireSagas(ctx context.Context, limit int) (int, error) {
+	result, err := s.runTransaction(ctx, func(tx *gorm.DB) (any, error) {
+		orderIDs, err := saga.Expired(ctx, tx, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		changes := make([]*statusChangeResult, 0, len(orderIDs))
+		for _, orderID := range orderIDs {
+			transition, err := saga.Advance(ctx, tx, orderID, saga.Step{
+				Trigger: saga.Timeout,
+				Reason:  "notification not sent before the deadline",
+			})
+			if err != nil {
+				return nil, err
+			}
+			change, err := s.applySagaAction(ctx, tx, orderID, transition.Action, string(saga.Timeout))
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+		return changes, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	changes, ok := result.([]*statusChangeResult)
+	if !ok {
+		return 0, fmt.Errorf("unexpected result type from transaction")
+	}
+
+	for _, change := range changes {
+		s.publishStatusChanges(ctx, change.order.UserID, change.details)
+	}
+	return len(changes), nil
 }
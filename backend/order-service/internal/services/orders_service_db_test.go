@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"orderservice/graph/model"
+	"orderservice/internal/db/dbtest"
+	"orderservice/internal/inbox"
+	"orderservice/internal/models"
+	"orderservice/internal/pubsub"
+	"orderservice/internal/repository"
+	"orderservice/internal/saga"
+	"orderservice/pkg/enums"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestOrderService(t *testing.T) (OrderService, *gorm.DB) {
+	db := dbtest.Open(t)
+	return NewOrderService(repository.NewOrderRepository(db), db, saga.Config{NotificationTimeout: time.Minute}, pubsub.NewBroker()), db
+}
+
+var testItems = []OrderItemInput{
+	{ProductID: "6f1c2a4e-8d0b-4c1e-9a3f-2b7d5e6f8a9c", Quantity: 2, Price: 19.99, Currency: "USD"},
+	{ProductID: "0b4e7d2a-1c3f-4a5b-8e6d-9f8a7b6c5d4e", Quantity: 1, Price: 5, Currency: "EUR"},
+}
+
+// count returns the rows of model matching the condition
+func count(t *testing.T, db *gorm.DB, model any, query string, args ...any) int64 {
+	var n int64
+	require.NoError(t, db.Model(model).Where(query, args...).Count(&n).Error)
+	return n
+}
+
+func TestHandleInventoryReservedEventOnce(t *testing.T) {
+	service, db := newTestOrderService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	entry := inbox.Entry{EventID: "event-1", IdempotencyKey: "reservation-1", DetailType: "inventory_reserved"}
+	order, err := service.HandleInventoryReservedEvent(ctx, entry, userID, testItems)
+	require.NoError(t, err)
+	require.NotNil(t, order)
+
+	tests := []struct {
+		name  string
+		entry inbox.Entry
+	}{
+		{name: "redelivered event", entry: entry},
+		{name: "reservation published again", entry: inbox.Entry{EventID: "event-2", IdempotencyKey: "reservation-1", DetailType: "inventory_reserved"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duplicate, err := service.HandleInventoryReservedEvent(ctx, tt.entry, userID, testItems)
+			assert.ErrorIs(t, err, inbox.ErrAlreadyProcessed)
+			assert.Nil(t, duplicate)
+		})
+	}
+
+	assert.Equal(t, int64(1), count(t, db, &models.Order{}, "user_id = ?", userID))
+	assert.Equal(t, int64(2), count(t, db, &models.OrderDetail{}, "orders_id = ?", order.ID))
+	assert.Equal(t, int64(1), count(t, db, &models.OutboxMessage{}, "detail_type = ?", enums.OrderPlaced.String()))
+	assert.Equal(t, int64(1), count(t, db, &models.OrderSaga{}, "order_id = ?", order.ID))
+
+	// Another reservation places another order
+	_, err = service.HandleInventoryReservedEvent(ctx, inbox.Entry{EventID: "event-3", IdempotencyKey: "reservation-2", DetailType: "inventory_reserved"}, userID, testItems)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count(t, db, &models.Order{}, "user_id = ?", userID))
+}
+
+// placeTestOrder places an order on the reservation of an event
+func placeTestOrder(t *testing.T, service OrderService, eventID string) uuid.UUID {
+	order, err := service.HandleInventoryReservedEvent(context.Background(), inbox.Entry{EventID: eventID, DetailType: "inventory_reserved"}, uuid.New(), testItems)
+	require.NoError(t, err)
+	return order.ID
+}
+
+// cancelledEvents returns the order_cancelled events enqueued for an order
+func cancelledEvents(t *testing.T, db *gorm.DB, orderID uuid.UUID) []models.OrderCancelledEventDetail {
+	var messages []models.OutboxMessage
+	require.NoError(t, db.Where("detail_type = ? AND detail->>'orderId' = ?", enums.OrderCancelled.String(), orderID.String()).Find(&messages).Error)
+
+	events := make([]models.OrderCancelledEventDetail, len(messages))
+	for i, message := range messages {
+		require.NoError(t, json.Unmarshal([]byte(message.Detail), &events[i]))
+	}
+	return events
+}
+
+// detailStatuses returns the statuses of the items of an order
+func detailStatuses(t *testing.T, db *gorm.DB, orderID uuid.UUID) []string {
+	var statuses []string
+	require.NoError(t, db.Model(&models.OrderDetail{}).Where("orders_id = ?", orderID).Pluck("status", &statuses).Error)
+	return statuses
+}
+
+func TestAdvanceSaga(t *testing.T) {
+	tests := []struct {
+		name     string
+		advance  func(service OrderService, entry inbox.Entry, orderID uuid.UUID) (*model.OrderSaga, error)
+		state    model.OrderSagaState
+		statuses []string
+		// released are the quantities released per product by order_cancelled, nil when
+		// the order is not cancelled
+		released map[string]int32
+	}{
+		{
+			name: "notification sent",
+			advance: func(service OrderService, entry inbox.Entry, orderID uuid.UUID) (*model.OrderSaga, error) {
+				return service.HandleOrderProcessingNotificationSentEvent(context.Background(), entry, orderID)
+			},
+			state:    model.OrderSagaState(saga.StateCompleted),
+			statuses: []string{"completed", "completed"},
+		},
+		{
+			name: "notification failed",
+			advance: func(service OrderService, entry inbox.Entry, orderID uuid.UUID) (*model.OrderSaga, error) {
+				return service.HandleNotificationSentFailedEvent(context.Background(), entry, orderID, "mailbox full")
+			},
+			state:    model.OrderSagaState(saga.StateFailed),
+			statuses: []string{"cancelled", "cancelled"},
+			released: map[string]int32{testItems[0].ProductID: 2, testItems[1].ProductID: 1},
+		},
+		{
+			name: "inventory reservation failed",
+			advance: func(service OrderService, entry inbox.Entry, orderID uuid.UUID) (*model.OrderSaga, error) {
+				return service.HandleInventoryReservationFailedEvent(context.Background(), entry, orderID, "out of stock")
+			},
+			state:    model.OrderSagaState(saga.StateFailed),
+			statuses: []string{"cancelled", "cancelled"},
+			// Nothing was reserved, so nothing is released
+			released: map[string]int32{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, db := newTestOrderService(t)
+			orderID := placeTestOrder(t, service, "reserved")
+
+			entry := inbox.Entry{EventID: "step", DetailType: "step"}
+			orderSaga, err := tt.advance(service, entry, orderID)
+			require.NoError(t, err)
+			require.NotNil(t, orderSaga)
+			assert.Equal(t, tt.state, orderSaga.State)
+			assert.Nil(t, orderSaga.Deadline)
+			assert.ElementsMatch(t, tt.statuses, detailStatuses(t, db, orderID))
+
+			events := cancelledEvents(t, db, orderID)
+			if tt.released == nil {
+				assert.Empty(t, events)
+			} else {
+				require.Len(t, events, 1)
+				assert.Len(t, events[0].OrderDetailIDs, 2)
+				released := map[string]int32{}
+				for _, item := range events[0].Items {
+					released[item.ProductID] += item.Quantity
+				}
+				assert.Equal(t, tt.released, released)
+			}
+
+			// The redelivered event is skipped
+			_, err = tt.advance(service, entry, orderID)
+			assert.ErrorIs(t, err, inbox.ErrAlreadyProcessed)
+
+			// An event that no longer applies is recorded and ignored
+			late, err := service.HandleOrderProcessingNotificationSentEvent(context.Background(), inbox.Entry{EventID: "late", DetailType: "notification_sent_success"}, orderID)
+			require.NoError(t, err)
+			assert.Nil(t, late)
+			assert.Equal(t, int64(1), count(t, db, &models.ProcessedEvent{}, "event_id = ?", "late"))
+		})
+	}
+}
+
+func TestExpireSagas(t *testing.T) {
+	service, db := newTestOrderService(t)
+	ctx := context.Background()
+
+	expired := placeTestOrder(t, service, "reserved-1")
+	pending := placeTestOrder(t, service, "reserved-2")
+	require.NoError(t, db.Model(&models.OrderSaga{}).Where("order_id = ?", expired).
+		Update("deadline", gorm.Expr("now() - interval '1 second'")).Error)
+
+	n, err := service.ExpireSagas(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	orderSaga, err := service.GetOrderSaga(ctx, expired)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderSagaState(saga.StateFailed), orderSaga.State)
+	assert.Equal(t, string(saga.Timeout), orderSaga.LastEvent)
+	assert.Nil(t, orderSaga.Deadline)
+	assert.Equal(t, []string{"cancelled", "cancelled"}, detailStatuses(t, db, expired))
+
+	events := cancelledEvents(t, db, expired)
+	require.Len(t, events, 1)
+	assert.Equal(t, string(saga.Timeout), events[0].Reason)
+	assert.Len(t, events[0].Items, 2)
+
+	// The saga still waiting is left alone, and expired sagas are not expired again
+	n, err = service.ExpireSagas(ctx, 10)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	orderSaga, err = service.GetOrderSaga(ctx, pending)
+	require.NoError(t, err)
+	assert.Equal(t, model.OrderSagaState(saga.StateAwaitingNotification), orderSaga.State)
+	assert.Empty(t, cancelledEvents(t, db, pending))
+}
+
+func TestCancelOrder(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("awaiting notification", func(t *testing.T) {
+		service, db := newTestOrderService(t)
+		orderID := placeTestOrder(t, service, "reserved")
+
+		order, err := service.CancelOrder(ctx, orderID)
+		require.NoError(t, err)
+		assert.Equal(t, orderID, order.ID)
+		assert.Equal(t, []string{"cancelled", "cancelled"}, detailStatuses(t, db, orderID))
+
+		events := cancelledEvents(t, db, orderID)
+		require.Len(t, events, 1)
+		assert.Len(t, events[0].Items, 2)
+
+		orderSaga, err := service.GetOrderSaga(ctx, orderID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderSagaState(saga.StateCancelled), orderSaga.State)
+
+		// Cancelling again changes nothing
+		_, err = service.CancelOrder(ctx, orderID)
+		assert.ErrorIs(t, err, ErrOrderNotCancellable)
+		assert.Len(t, cancelledEvents(t, db, orderID), 1)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		service, db := newTestOrderService(t)
+		orderID := placeTestOrder(t, service, "reserved")
+		_, err := service.HandleInventoryReservationFailedEvent(ctx, inbox.Entry{EventID: "failed", DetailType: "inventory_reservation_failed"}, orderID, "out of stock")
+		require.NoError(t, err)
+		require.Len(t, cancelledEvents(t, db, orderID), 1)
+
+		_, err = service.CancelOrder(ctx, orderID)
+		assert.ErrorIs(t, err, ErrOrderNotCancellable)
+		assert.NotErrorIs(t, err, saga.ErrInvalidTransition)
+		assert.Len(t, cancelledEvents(t, db, orderID), 1)
+
+		orderSaga, err := service.GetOrderSaga(ctx, orderID)
+		require.NoError(t, err)
+		assert.Equal(t, model.OrderSagaState(saga.StateFailed), orderSaga.State)
+	})
+}
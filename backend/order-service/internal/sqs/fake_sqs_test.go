@@ -0,0 +1,201 @@
+package sqs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// fakeSQS is a local stand-in for SQS, speaking its JSON protocol. Messages become
+// visible again as soon as their visibility is changed: the timeouts asked for are
+// recorded rather than waited for.
+type fakeSQS struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	queues map[string][]*fakeMessage
+	nextID int
+	// receiveErrors is the number of ReceiveMessage calls left to fail
+	receiveErrors int
+	receives      int
+	deleteBatches [][]string
+	visibility    []int32
+	sent          map[string][]fakeSent
+}
+
+type fakeMessage struct {
+	id            string
+	body          string
+	receiveCount  int
+	receiptHandle string
+	inFlight      bool
+	// traceHeader is the AWSTraceHeader EventBridge set on the message
+	traceHeader string
+}
+
+type fakeSent struct {
+	Body       string
+	Attributes map[string]string
+}
+
+func newFakeSQS(t *testing.T) *fakeSQS {
+	f := &fakeSQS{queues: map[string][]*fakeMessage{}, sent: map[string][]fakeSent{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// client returns an SQS client of the stand-in, without retries so failures show directly
+func (f *fakeSQS) client() *sqs.Client {
+	return sqs.New(sqs.Options{
+		Region:                           "ap-southeast-1",
+		BaseEndpoint:                     aws.String(f.server.URL),
+		Credentials:                      aws.AnonymousCredentials{},
+		Retryer:                          aws.NopRetryer{},
+		DisableMessageChecksumValidation: true,
+	})
+}
+
+func (f *fakeSQS) queueURL(name string) string {
+	return f.server.URL + "/000000000000/" + name
+}
+
+func (f *fakeSQS) send(queueURL, body string) {
+	f.sendTraced(queueURL, body, "")
+}
+
+// sendTraced sends a message the way EventBridge delivers an event with a TraceHeader
+func (f *fakeSQS) sendTraced(queueURL, body, traceHeader string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	f.queues[queueURL] = append(f.queues[queueURL], &fakeMessage{id: fmt.Sprintf("msg-%d", f.nextID), body: body, traceHeader: traceHeader})
+}
+
+// depth is the number of messages in a queue, in flight or not
+func (f *fakeSQS) depth(queueURL string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.queues[queueURL])
+}
+
+func (f *fakeSQS) serve(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+	var input map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var output any
+	switch operation {
+	case "ReceiveMessage":
+		f.receives++
+		if f.receiveErrors > 0 {
+			f.receiveErrors--
+			w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.sqs#InternalError", "message": "unavailable"})
+			return
+		}
+		output = f.receive(input["QueueUrl"].(string), int(input["MaxNumberOfMessages"].(float64)))
+	case "DeleteMessageBatch":
+		output = f.deleteBatch(input["QueueUrl"].(string), input["Entries"].([]any))
+	case "ChangeMessageVisibility":
+		f.visibility = append(f.visibility, int32(input["VisibilityTimeout"].(float64)))
+		for _, message := range f.queues[input["QueueUrl"].(string)] {
+			if message.receiptHandle == input["ReceiptHandle"].(string) {
+				message.inFlight = false
+			}
+		}
+		output = map[string]any{}
+	case "SendMessage":
+		sent := fakeSent{Body: input["MessageBody"].(string), Attributes: map[string]string{}}
+		if attributes, ok := input["MessageAttributes"].(map[string]any); ok {
+			for name, value := range attributes {
+				sent.Attributes[name] = value.(map[string]any)["StringValue"].(string)
+			}
+		}
+		queueURL := input["QueueUrl"].(string)
+		f.sent[queueURL] = append(f.sent[queueURL], sent)
+		f.nextID++
+		output = map[string]any{"MessageId": fmt.Sprintf("msg-%d", f.nextID), "MD5OfMessageBody": md5Hex(sent.Body)}
+	default:
+		http.Error(w, "unsupported operation "+operation, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(output)
+}
+
+func (f *fakeSQS) receive(queueURL string, max int) map[string]any {
+	var messages []map[string]any
+	for _, message := range f.queues[queueURL] {
+		if len(messages) == max {
+			break
+		}
+		if message.inFlight {
+			continue
+		}
+		message.inFlight = true
+		message.receiveCount++
+		message.receiptHandle = fmt.Sprintf("%s-%d", message.id, message.receiveCount)
+		attributes := map[string]string{"ApproximateReceiveCount": strconv.Itoa(message.receiveCount)}
+		if message.traceHeader != "" {
+			attributes["AWSTraceHeader"] = message.traceHeader
+		}
+		messages = append(messages, map[string]any{
+			"MessageId":     message.id,
+			"ReceiptHandle": message.receiptHandle,
+			"Body":          message.body,
+			"MD5OfBody":     md5Hex(message.body),
+			"Attributes":    attributes,
+		})
+	}
+	if len(messages) == 0 {
+		// Stands in for long polling, without holding the lock
+		f.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		f.mu.Lock()
+	}
+	return map[string]any{"Messages": messages}
+}
+
+func (f *fakeSQS) deleteBatch(queueURL string, entries []any) map[string]any {
+	var handles []string
+	var successful []map[string]string
+	for _, entry := range entries {
+		entry := entry.(map[string]any)
+		handle := entry["ReceiptHandle"].(string)
+		handles = append(handles, handle)
+		for i, message := range f.queues[queueURL] {
+			if message.receiptHandle == handle {
+				f.queues[queueURL] = append(f.queues[queueURL][:i], f.queues[queueURL][i+1:]...)
+				break
+			}
+		}
+		successful = append(successful, map[string]string{"Id": entry["Id"].(string)})
+	}
+	f.deleteBatches = append(f.deleteBatches, handles)
+	return map[string]any{"Successful": successful, "Failed": []any{}}
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,39 +3,44 @@ package sqs
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
-	eventhandler "orderservice/internal/event_handler"
+	"orderservice/internal/metrics"
 	"orderservice/internal/models"
+	"orderservice/internal/tracing"
 	"orderservice/internal/utils"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/demo-micro/backend/pkg/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
-var (
-	client                *sqs.Client
-	MAX_NUMBER_OF_MESSAGE = utils.GetEnv("MAX_NUMBER_OF_MESSAGE", int32(10))
-	WAIT_TIME_SECONDS     = utils.GetEnv("WAIT_TIME_SECONDS", int32(10))
-	TIMEOUT               = time.Duration(utils.GetEnv("TIMEOUT", int32(30))) * time.Second
-)
-
-func init() {
-	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
-	defer cancel()
+// API is the part of the SQS client used by the consumer
+type API interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
 
-	var err error
-	client, err = createSQSClient(ctx)
-	if err != nil {
-		log.Fatalf("Failed to create SQS client: %v", err)
-	}
+// MessageHandler handles the event of a message. A message whose handler returns an
+// error is received again later.
+type MessageHandler interface {
+	HandleMessage(ctx context.Context, msg any) error
 }
 
-func createSQSClient(ctx context.Context) (*sqs.Client, error) {
+// NewClient creates an SQS client for LocalStack
+func NewClient(ctx context.Context) (*sqs.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithEndpointResolver(aws.EndpointResolverFunc(
 		func(service, region string) (aws.Endpoint, error) {
 			if service == sqs.ServiceID && region == "ap-southeast-1" {
@@ -52,103 +57,389 @@ func createSQSClient(ctx context.Context) (*sqs.Client, error) {
 	return sqs.NewFromConfig(cfg), nil
 }
 
-type SQSConsumer struct {
-	client       *sqs.Client
-	eventHandler eventhandler.EventHandler
-	queueURL     string
-	maxConsumers int
+// maxVisibilityTimeout is the longest visibility timeout SQS accepts, 12 hours
+const maxVisibilityTimeout = 12 * time.Hour
+
+// deleteBatchSize is the most entries SQS accepts in a DeleteMessageBatch request
+const deleteBatchSize = 10
+
+// Config configures how the consumer receives, retries and dead-letters messages
+type Config struct {
+	QueueURL string
+	// DeadLetterQueueURL receives the messages that failed MaxReceiveCount times, and those
+	// that cannot be parsed. Without it they are left to the redrive policy of the queue.
+	DeadLetterQueueURL string
+	// MaxReceiveCount is the number of times a message is handled before giving up on it
+	MaxReceiveCount int
+	MaxConsumers    int
+	// MaxNumberOfMessages and WaitTimeSeconds are those of ReceiveMessage
+	MaxNumberOfMessages int32
+	WaitTimeSeconds     int32
+	// Timeout bounds the handling of a message
+	Timeout time.Duration
+	// RetryBackoff is the visibility timeout after the first failure, doubling with every
+	// later receive of the message
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// FetchBackoff is the time to wait after a failed ReceiveMessage, doubling with every
+	// consecutive failure
+	FetchBackoff    time.Duration
+	MaxFetchBackoff time.Duration
+	// AckInterval bounds how long a handled message waits for its batch to be deleted
+	AckInterval time.Duration
 }
 
-func NewSQSConsumer(ctx context.Context, eventHandler eventhandler.EventHandler, queueURL string, maxConsumers int) *SQSConsumer {
-	consumer := &SQSConsumer{
-		client:       client,
-		eventHandler: eventHandler,
-		queueURL:     queueURL,
-		maxConsumers: maxConsumers,
+// ConfigFromEnv reads the configuration from ORDERS_QUEUE_URL, ORDERS_DLQ_URL,
+// SQS_MAX_RECEIVE_COUNT, MAX_CONSUMER, MAX_NUMBER_OF_MESSAGE, WAIT_TIME_SECONDS, TIMEOUT,
+// SQS_RETRY_BACKOFF, SQS_MAX_RETRY_BACKOFF, SQS_FETCH_BACKOFF, SQS_MAX_FETCH_BACKOFF and
+// SQS_ACK_INTERVAL
+func ConfigFromEnv() Config {
+	return Config{
+		QueueURL:            utils.GetEnv("ORDERS_QUEUE_URL", ""),
+		DeadLetterQueueURL:  utils.GetEnv("ORDERS_DLQ_URL", ""),
+		MaxReceiveCount:     utils.GetEnv("SQS_MAX_RECEIVE_COUNT", 5),
+		MaxConsumers:        utils.GetEnv("MAX_CONSUMER", 5),
+		MaxNumberOfMessages: utils.GetEnv("MAX_NUMBER_OF_MESSAGE", int32(10)),
+		WaitTimeSeconds:     utils.GetEnv("WAIT_TIME_SECONDS", int32(10)),
+		Timeout:             time.Duration(utils.GetEnv("TIMEOUT", 30)) * time.Second,
+		RetryBackoff:        utils.GetEnv("SQS_RETRY_BACKOFF", 5*time.Second),
+		MaxRetryBackoff:     utils.GetEnv("SQS_MAX_RETRY_BACKOFF", 15*time.Minute),
+		FetchBackoff:        utils.GetEnv("SQS_FETCH_BACKOFF", time.Second),
+		MaxFetchBackoff:     utils.GetEnv("SQS_MAX_FETCH_BACKOFF", time.Minute),
+		AckInterval:         utils.GetEnv("SQS_ACK_INTERVAL", time.Second),
 	}
-	go consumer.start(ctx)
-	return consumer
 }
 
-func (c *SQSConsumer) start(ctx context.Context) {
+// ErrNoQueue is returned by Validate without a queue to consume
+var ErrNoQueue = errors.New("no queue URL configured")
 
-	jobs := make(chan types.Message, 50)
-	var wg sync.WaitGroup
-
-	for i := 0; i < c.maxConsumers; i++ {
-		wg.Add(1)
-		go c.worker(ctx, jobs, &wg)
+// Validate checks that the configuration can be consumed with
+func (c Config) Validate() error {
+	if c.QueueURL == "" {
+		return ErrNoQueue
+	}
+	if c.MaxConsumers < 1 {
+		return fmt.Errorf("MAX_CONSUMER must be at least 1, got %d", c.MaxConsumers)
 	}
+	return nil
+}
+
+// SQSConsumer handles the messages of a queue with a pool of workers.
+//
+// A handled message is acknowledged by deleting it, in batches. A message whose handler
+// fails is hidden for a backoff growing with its ApproximateReceiveCount, then received
+// again, until MaxReceiveCount, after which it is moved to the dead-letter queue. Messages
+// may still be handled more than once, so handlers must be idempotent.
+type SQSConsumer struct {
+	client  API
+	handler MessageHandler
+	config  Config
+}
+
+func NewSQSConsumer(client API, handler MessageHandler, config Config) *SQSConsumer {
+	return &SQSConsumer{client: client, handler: handler, config: config}
+}
 
-	log.Println("SQS Consumer running...")
+// Run handles messages until ctx is done, then waits for the messages being handled and
+// acknowledges them
+func (c *SQSConsumer) Run(ctx context.Context) {
+	jobs := make(chan types.Message)
+	acks := make(chan string)
 
-	go c.fetchMessages(ctx, jobs)
+	var workers sync.WaitGroup
+	for i := 0; i < c.config.MaxConsumers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range jobs {
+				c.process(ctx, msg, acks)
+			}
+		}()
+	}
 
-	<-ctx.Done()
-	log.Println("Shutdown signal received. Cleaning up...")
+	ackerDone := make(chan struct{})
+	go func() {
+		defer close(ackerDone)
+		c.ackLoop(acks)
+	}()
+
+	logging.Ctx(ctx).Info("SQS consumer running", zap.String("queue_url", c.config.QueueURL))
+	c.fetchMessages(ctx, jobs)
 
 	close(jobs)
-	wg.Wait()
-	log.Println("All workers exited. Shutdown complete.")
-}
-
-func (c *SQSConsumer) worker(ctx context.Context, jobs <-chan types.Message, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for msg := range jobs {
-		ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
-		var event models.Event
-		if err := json.Unmarshal([]byte(*msg.Body), &event); err != nil {
-			log.Printf("Failed to unmarshal event body: %v", err)
-			//c.deleteMessage(*msg.ReceiptHandle)
-			cancel()
+	workers.Wait()
+	close(acks)
+	<-ackerDone
+	logging.Ctx(ctx).Info("SQS consumer stopped")
+}
+
+// fetchMessages receives messages for the workers until ctx is done, backing off while
+// ReceiveMessage fails
+func (c *SQSConsumer) fetchMessages(ctx context.Context, jobs chan<- types.Message) {
+	failures := 0
+	for ctx.Err() == nil {
+		output, err := c.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.config.QueueURL),
+			MaxNumberOfMessages: c.config.MaxNumberOfMessages,
+			WaitTimeSeconds:     c.config.WaitTimeSeconds,
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameAWSTraceHeader,
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+			},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			wait := backoff(c.config.FetchBackoff, c.config.MaxFetchBackoff, failures)
+			metrics.SQSErrors.WithLabelValues("receive").Inc()
+			logging.Ctx(ctx).Error("Failed to receive messages", zap.Int("failures", failures), zap.Duration("backoff", wait), zap.Error(err))
+			if !sleep(ctx, wait) {
+				return
+			}
 			continue
 		}
+		failures = 0
 
-		if err := c.eventHandler.HandleMessage(ctx, &event); err != nil {
-			c.deleteMessage(*msg.ReceiptHandle)
+		for _, msg := range output.Messages {
+			select {
+			case jobs <- msg:
+			case <-ctx.Done():
+				// Messages not handed out become visible again after their timeout
+				return
+			}
 		}
 	}
 }
 
-func (c *SQSConsumer) fetchMessages(ctx context.Context, jobs chan<- types.Message) {
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping message fetcher...")
+// outcome is what becomes of a message after it was handled
+type outcome int
+
+const (
+	outcomeAck outcome = iota
+	outcomeRetry
+	outcomeDeadLetter
+)
+
+// process handles a message in a consumer span that continues the trace of the emitter of
+// the event, which EventBridge forwards as the AWSTraceHeader of the message
+func (c *SQSConsumer) process(ctx context.Context, msg types.Message, acks chan<- string) {
+	// Stopping the consumer lets the messages being handled finish
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.config.Timeout)
+	defer cancel()
+
+	ctx = tracing.WithTraceHeader(ctx, msg.Attributes[string(types.MessageSystemAttributeNameAWSTraceHeader)])
+	ctx, span := tracing.Tracer().Start(ctx, "sqs.process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.destination.name", c.config.QueueURL),
+			attribute.String("messaging.message.id", aws.ToString(msg.MessageId)),
+		),
+	)
+	defer span.End()
+
+	received := receiveCount(msg)
+	log := logging.Ctx(ctx).With(zap.String("message_id", aws.ToString(msg.MessageId)), zap.Int("receive_count", received))
+
+	var event models.Event
+	if err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &event); err != nil {
+		// The body will not parse on a later receive either
+		log.Error("Failed to unmarshal event body", zap.Error(err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid event body")
+		c.settle(ctx, log, msg, "", outcomeDeadLetter, err, acks)
+		return
+	}
+
+	// Lines logged while handling the event carry its ID as their request ID
+	ctx = logging.WithRequestID(ctx, event.ID)
+	log = log.With(zap.String("request_id", event.ID), zap.String("detail_type", event.DetailType))
+	span.SetName("sqs.process " + event.DetailType)
+	span.SetAttributes(
+		attribute.String("messaging.eventbridge.event_id", event.ID),
+		attribute.String("messaging.eventbridge.detail_type", event.DetailType),
+		attribute.Int("messaging.sqs.receive_count", received),
+	)
+
+	err := c.handler.HandleMessage(ctx, &event)
+	if err == nil {
+		c.settle(ctx, log, msg, event.DetailType, outcomeAck, nil, acks)
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	if c.config.MaxReceiveCount > 0 && received >= c.config.MaxReceiveCount {
+		log.Error("Failed to handle event, giving up", zap.Error(err))
+		c.settle(ctx, log, msg, event.DetailType, outcomeDeadLetter, err, acks)
+		return
+	}
+	log.Warn("Failed to handle event, retrying", zap.Error(err))
+	c.settle(ctx, log, msg, event.DetailType, outcomeRetry, err, acks)
+}
+
+// settle acknowledges, retries or dead-letters a message
+func (c *SQSConsumer) settle(ctx context.Context, log *zap.Logger, msg types.Message, detailType string, result outcome, cause error, acks chan<- string) {
+	if result == outcomeDeadLetter {
+		if c.config.DeadLetterQueueURL == "" {
+			// Hidden as long as possible, for the redrive policy of the queue to move it
+			log.Warn("No dead-letter queue configured, leaving the message to the queue")
+			c.changeVisibility(ctx, log, msg, maxVisibilityTimeout)
+			metrics.SQSMessages.WithLabelValues(detailType, "failed").Inc()
+			return
+		}
+		if err := c.deadLetter(ctx, msg, cause); err != nil {
+			metrics.SQSErrors.WithLabelValues("dead_letter").Inc()
+			log.Error("Failed to move message to the dead-letter queue", zap.Error(err))
+			result = outcomeRetry
+		} else {
+			metrics.SQSMessages.WithLabelValues(detailType, "dead_lettered").Inc()
+			acks <- aws.ToString(msg.ReceiptHandle)
 			return
-		default:
-			output := c.receiveMessages(ctx)
-			for _, msg := range output.Messages {
-				select {
-				case jobs <- msg:
-				case <-ctx.Done():
-					return
-				}
-			}
 		}
 	}
+
+	switch result {
+	case outcomeAck:
+		metrics.SQSMessages.WithLabelValues(detailType, "processed").Inc()
+		acks <- aws.ToString(msg.ReceiptHandle)
+	case outcomeRetry:
+		metrics.SQSMessages.WithLabelValues(detailType, "retried").Inc()
+		c.changeVisibility(ctx, log, msg, backoff(c.config.RetryBackoff, c.config.MaxRetryBackoff, receiveCount(msg)))
+	}
 }
 
-func (c *SQSConsumer) receiveMessages(ctx context.Context) *sqs.ReceiveMessageOutput {
-	output, err := c.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(c.queueURL),
-		MaxNumberOfMessages: MAX_NUMBER_OF_MESSAGE,
-		WaitTimeSeconds:     WAIT_TIME_SECONDS,
+// changeVisibility hides a message for timeout, after which it is received again. Without
+// it, the message is received again when the visibility timeout of the queue expires.
+func (c *SQSConsumer) changeVisibility(ctx context.Context, log *zap.Logger, msg types.Message, timeout time.Duration) {
+	_, err := c.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(c.config.QueueURL),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: int32(timeout / time.Second),
 	})
-
 	if err != nil {
-		log.Printf("Error receiving message: %v", err)
-		return &sqs.ReceiveMessageOutput{}
+		metrics.SQSErrors.WithLabelValues("change_visibility").Inc()
+		log.Error("Failed to change message visibility", zap.Error(err))
 	}
-	return output
 }
 
-func (c *SQSConsumer) deleteMessage(receiptHandle string) {
-	_, err := c.client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(c.queueURL),
-		ReceiptHandle: aws.String(receiptHandle),
+// deadLetter copies a message to the dead-letter queue, with the reason it failed
+func (c *SQSConsumer) deadLetter(ctx context.Context, msg types.Message, cause error) error {
+	attributes := map[string]types.MessageAttributeValue{
+		"SourceQueueUrl":           stringAttribute(c.config.QueueURL),
+		"SourceMessageId":          stringAttribute(aws.ToString(msg.MessageId)),
+		"ApproximateReceiveCount":  {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(receiveCount(msg)))},
+		"DeadLetterReason":         stringAttribute(cause.Error()),
+		"DeadLetteredAtUnixMillis": {DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	}
+	_, err := c.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(c.config.DeadLetterQueueURL),
+		MessageBody:       msg.Body,
+		MessageAttributes: attributes,
+	})
+	return err
+}
+
+// ackLoop deletes the handled messages in batches, once a batch is full or has waited
+// AckInterval, until acks is closed
+func (c *SQSConsumer) ackLoop(acks <-chan string) {
+	ticker := time.NewTicker(c.config.AckInterval)
+	defer ticker.Stop()
+
+	batch := make([]string, 0, deleteBatchSize)
+	for {
+		select {
+		case receiptHandle, ok := <-acks:
+			if !ok {
+				c.deleteBatch(batch)
+				return
+			}
+			batch = append(batch, receiptHandle)
+			if len(batch) == deleteBatchSize {
+				c.deleteBatch(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			c.deleteBatch(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// deleteBatch deletes handled messages. Those that fail to be deleted are received and
+// handled again.
+func (c *SQSConsumer) deleteBatch(receiptHandles []string) {
+	if len(receiptHandles) == 0 {
+		return
+	}
+	// Runs after the consumer was stopped too, to acknowledge the last messages
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+
+	entries := make([]types.DeleteMessageBatchRequestEntry, len(receiptHandles))
+	for i, receiptHandle := range receiptHandles {
+		entries[i] = types.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(receiptHandle),
+		}
+	}
+
+	output, err := c.client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(c.config.QueueURL),
+		Entries:  entries,
 	})
 	if err != nil {
-		log.Printf("Error deleting message: %v", err)
+		metrics.SQSErrors.WithLabelValues("delete").Add(float64(len(entries)))
+		logging.Ctx(ctx).Error("Failed to delete messages", zap.Int("messages", len(entries)), zap.Error(err))
+		return
+	}
+	for _, failed := range output.Failed {
+		metrics.SQSErrors.WithLabelValues("delete").Inc()
+		logging.Ctx(ctx).Error("Failed to delete message",
+			zap.String("code", aws.ToString(failed.Code)),
+			zap.String("message", aws.ToString(failed.Message)),
+		)
+	}
+}
+
+// receiveCount is the number of times a message was received, this time included
+func receiveCount(msg types.Message) int {
+	count, err := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if err != nil || count < 1 {
+		return 1
 	}
+	return count
+}
+
+// backoff is base doubled for every attempt after the first, up to max
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	if max <= 0 || max > maxVisibilityTimeout {
+		max = maxVisibilityTimeout
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// sleep waits for d, and reports false when ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
 }
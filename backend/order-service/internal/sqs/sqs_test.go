@@ -0,0 +1,230 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	eventemitter "orderservice/internal/event_emitter"
+	"orderservice/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handlerFunc handles the events of the tests
+type handlerFunc func(ctx context.Context, event *models.Event) error
+
+func (f handlerFunc) HandleMessage(ctx context.Context, msg any) error {
+	return f(ctx, msg.(*models.Event))
+}
+
+func testConfig(f *fakeSQS) Config {
+	return Config{
+		QueueURL:            f.queueURL("orders-queue"),
+		DeadLetterQueueURL:  f.queueURL("orders-dlq"),
+		MaxReceiveCount:     3,
+		MaxConsumers:        2,
+		MaxNumberOfMessages: 10,
+		Timeout:             5 * time.Second,
+		RetryBackoff:        time.Second,
+		MaxRetryBackoff:     time.Minute,
+		FetchBackoff:        10 * time.Millisecond,
+		MaxFetchBackoff:     40 * time.Millisecond,
+		AckInterval:         20 * time.Millisecond,
+	}
+}
+
+// run runs the consumer until done reports true, and then stops it
+func run(t *testing.T, consumer *SQSConsumer, done func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		consumer.Run(ctx)
+	}()
+
+	assert.Eventually(t, done, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop")
+	}
+}
+
+func TestConsumerDeletesHandledMessagesInBatches(t *testing.T) {
+	f := newFakeSQS(t)
+	config := testConfig(f)
+	config.AckInterval = 200 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		f.send(config.QueueURL, `{"id":"event-`+string(rune('a'+i))+`","detail-type":"inventory_reserved"}`)
+	}
+
+	var mu sync.Mutex
+	var handled []string
+	consumer := NewSQSConsumer(f.client(), handlerFunc(func(ctx context.Context, event *models.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, event.ID)
+		return nil
+	}), config)
+
+	run(t, consumer, func() bool { return f.depth(config.QueueURL) == 0 })
+
+	assert.ElementsMatch(t, []string{"event-a", "event-b", "event-c"}, handled)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deleted := 0
+	for _, batch := range f.deleteBatches {
+		deleted += len(batch)
+	}
+	assert.Equal(t, 3, deleted)
+	assert.Less(t, len(f.deleteBatches), 3, "messages are deleted in batches")
+	assert.Empty(t, f.visibility)
+}
+
+func TestConsumerBacksOffFailedMessagesThenDeadLetters(t *testing.T) {
+	f := newFakeSQS(t)
+	config := testConfig(f)
+	f.send(config.QueueURL, `{"id":"event-a","detail-type":"inventory_reserved"}`)
+
+	consumer := NewSQSConsumer(f.client(), handlerFunc(func(ctx context.Context, event *models.Event) error {
+		return errors.New("database unavailable")
+	}), config)
+
+	run(t, consumer, func() bool { return f.depth(config.QueueURL) == 0 })
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Hidden for 1s after the first receive and 2s after the second, dead-lettered on the third
+	assert.Equal(t, []int32{1, 2}, f.visibility)
+	require.Len(t, f.sent[config.DeadLetterQueueURL], 1)
+	deadLetter := f.sent[config.DeadLetterQueueURL][0]
+	assert.Equal(t, `{"id":"event-a","detail-type":"inventory_reserved"}`, deadLetter.Body)
+	assert.Equal(t, "3", deadLetter.Attributes["ApproximateReceiveCount"])
+	assert.Equal(t, "database unavailable", deadLetter.Attributes["DeadLetterReason"])
+}
+
+func TestConsumerDeadLettersUnparseableMessages(t *testing.T) {
+	f := newFakeSQS(t)
+	config := testConfig(f)
+	f.send(config.QueueURL, `not json`)
+
+	consumer := NewSQSConsumer(f.client(), handlerFunc(func(ctx context.Context, event *models.Event) error {
+		t.Error("handler called for an unparseable message")
+		return nil
+	}), config)
+
+	run(t, consumer, func() bool { return f.depth(config.QueueURL) == 0 })
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.Len(t, f.sent[config.DeadLetterQueueURL], 1)
+	assert.Equal(t, "not json", f.sent[config.DeadLetterQueueURL][0].Body)
+	assert.Empty(t, f.visibility)
+}
+
+func TestConsumerLeavesPoisonMessagesWithoutDeadLetterQueue(t *testing.T) {
+	f := newFakeSQS(t)
+	config := testConfig(f)
+	config.DeadLetterQueueURL = ""
+	config.MaxReceiveCount = 1
+	f.send(config.QueueURL, `{"id":"event-a","detail-type":"inventory_reserved"}`)
+
+	consumer := NewSQSConsumer(f.client(), handlerFunc(func(ctx context.Context, event *models.Event) error {
+		return errors.New("invalid order")
+	}), config)
+
+	run(t, consumer, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.visibility) > 0
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal(t, int32(maxVisibilityTimeout/time.Second), f.visibility[0])
+	assert.Len(t, f.queues[config.QueueURL], 1)
+	assert.Empty(t, f.deleteBatches)
+}
+
+func TestConsumerBacksOffWhenReceiveFails(t *testing.T) {
+	f := newFakeSQS(t)
+	config := testConfig(f)
+	f.receiveErrors = 4
+	f.send(config.QueueURL, `{"id":"event-a","detail-type":"inventory_reserved"}`)
+
+	consumer := NewSQSConsumer(f.client(), handlerFunc(func(ctx context.Context, event *models.Event) error {
+		return nil
+	}), config)
+
+	start := time.Now()
+	run(t, consumer, func() bool { return f.depth(config.QueueURL) == 0 })
+
+	// 10ms, 20ms, 40ms and 40ms before the fifth receive succeeds
+	assert.GreaterOrEqual(t, time.Since(start), 110*time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.GreaterOrEqual(t, f.receives, 5)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, backoff(5*time.Second, time.Minute, 1))
+	assert.Equal(t, 20*time.Second, backoff(5*time.Second, time.Minute, 3))
+	assert.Equal(t, time.Minute, backoff(5*time.Second, time.Minute, 10))
+	assert.Equal(t, maxVisibilityTimeout, backoff(time.Hour, 0, 100))
+}
+
+// putEventsFunc stands in for EventBridge
+type putEventsFunc func(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+
+func (f putEventsFunc) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	return f(ctx, params, optFns...)
+}
+
+func TestConsumerContinuesTraceOfEmitter(t *testing.T) {
+	f := newFakeSQS(t)
+	config := testConfig(f)
+
+	// EventBridge delivers the TraceHeader of an event as the AWSTraceHeader of its message
+	emitter := eventemitter.NewEventBridgeEmitterWithClient(putEventsFunc(func(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+		for _, entry := range params.Entries {
+			f.sendTraced(config.QueueURL, `{"id":"event-a","detail-type":"inventory_reserved"}`, aws.ToString(entry.TraceHeader))
+		}
+		return &eventbridge.PutEventsOutput{Entries: []types.PutEventsResultEntry{{EventId: aws.String("event-a")}}}, nil
+	}))
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, emit := provider.Tracer("test").Start(context.Background(), "emit")
+	require.NoError(t, emitter.Emit(ctx, &eventemitter.Event{
+		Source:       aws.String("inventory-service"),
+		DetailType:   aws.String("inventory_reserved"),
+		Detail:       aws.String(`{}`),
+		EventBusName: aws.String("orders"),
+	}))
+	emit.End()
+
+	var mu sync.Mutex
+	var handled trace.SpanContext
+	consumer := NewSQSConsumer(f.client(), handlerFunc(func(ctx context.Context, event *models.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = trace.SpanContextFromContext(ctx)
+		return nil
+	}), config)
+
+	run(t, consumer, func() bool { return f.depth(config.QueueURL) == 0 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, emit.SpanContext().TraceID(), handled.TraceID())
+	assert.True(t, handled.IsSampled())
+}
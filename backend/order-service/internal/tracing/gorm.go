@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GORM is a plugin that traces every query with the statement it ran, under the span in
+// the context of the query, so queries have to be made with db.WithContext(ctx)
+type GORM struct{}
+
+func (GORM) Name() string {
+	return "tracing"
+}
+
+func (GORM) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"row", callback.Row().Before("gorm:row").Register, callback.Row().After("gorm:row").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+
+	for _, p := range processors {
+		if err := p.before("tracing:before_"+p.operation, startQuery(p.operation)); err != nil {
+			return err
+		}
+		if err := p.after("tracing:after_"+p.operation, endQuery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil || !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+			// Queries outside of a request or event would each be a trace of their own
+			return
+		}
+
+		name := "db." + operation
+		if db.Statement.Table != "" {
+			name += " " + db.Statement.Table
+		}
+		_, span := Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", db.Dialector.Name()),
+				attribute.String("db.operation", operation),
+				attribute.String("db.sql.table", db.Statement.Table),
+			),
+		)
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endQuery(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	// The statement without its variables, which may be personal data
+	span.SetAttributes(
+		attribute.String("db.statement", strings.TrimSpace(db.Statement.SQL.String())),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
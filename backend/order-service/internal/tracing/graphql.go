@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GraphQL is a gqlgen extension that traces each operation, and each field that is
+// resolved by a resolver rather than read from its parent
+type GraphQL struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = GraphQL{}
+
+func (GraphQL) ExtensionName() string {
+	return "OpenTelemetry"
+}
+
+func (GraphQL) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptResponse traces each response, which is every event of a subscription
+func (GraphQL) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+	oc := graphql.GetOperationContext(ctx)
+
+	operationType := "query"
+	if oc.Operation != nil {
+		operationType = string(oc.Operation.Operation)
+	}
+	name := "graphql." + operationType
+	if oc.OperationName != "" {
+		name += " " + oc.OperationName
+	}
+
+	ctx, span := Tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String("graphql.operation.type", operationType),
+		attribute.String("graphql.operation.name", oc.OperationName),
+	))
+	defer span.End()
+
+	response := next(ctx)
+	if response != nil && len(response.Errors) > 0 {
+		span.SetStatus(codes.Error, response.Errors.Error())
+	}
+	return response
+}
+
+func (GraphQL) InterceptField(ctx context.Context, next graphql.Resolver) (any, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	ctx, span := Tracer().Start(ctx, fc.Object+"."+fc.Field.Name, trace.WithAttributes(
+		attribute.String("graphql.field.path", fc.Path().String()),
+	))
+	defer span.End()
+
+	res, err := next(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, err
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// The TraceHeader of an EventBridge event and the AWSTraceHeader attribute of the SQS
+// messages it ends up in hold an X-Ray trace header, as X-Amzn-Trace-Id does over HTTP
+const traceHeaderKey = "X-Amzn-Trace-Id"
+
+var traceHeaderPropagator = xray.Propagator{}
+
+// TraceHeader returns the X-Ray trace header of the span in ctx, to send as the TraceHeader
+// of an event. It is empty when ctx has no span.
+func TraceHeader(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceHeaderPropagator.Inject(ctx, carrier)
+	return carrier.Get(traceHeaderKey)
+}
+
+// WithTraceHeader returns ctx with the remote span of an X-Ray trace header, so spans
+// started from it continue the trace of the event. Invalid headers are ignored.
+func WithTraceHeader(ctx context.Context, header string) context.Context {
+	if header == "" {
+		return ctx
+	}
+	return traceHeaderPropagator.Extract(ctx, propagation.MapCarrier{traceHeaderKey: header})
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceHeaderContinuesTrace(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	ctx, emit := provider.Tracer("test").Start(context.Background(), "emit")
+	defer emit.End()
+
+	header := TraceHeader(ctx)
+	require.NotEmpty(t, header)
+	traceID := emit.SpanContext().TraceID().String()
+	assert.Equal(t, "Root=1-"+traceID[:8]+"-"+traceID[8:]+";Parent="+emit.SpanContext().SpanID().String()+";Sampled=1", header)
+
+	remote := trace.SpanContextFromContext(WithTraceHeader(context.Background(), header))
+	assert.True(t, remote.IsRemote())
+	assert.Equal(t, emit.SpanContext().TraceID(), remote.TraceID())
+	assert.Equal(t, emit.SpanContext().SpanID(), remote.SpanID())
+	assert.True(t, remote.IsSampled())
+
+	_, consume := provider.Tracer("test").Start(WithTraceHeader(context.Background(), header), "consume")
+	defer consume.End()
+
+	assert.Equal(t, emit.SpanContext().TraceID(), consume.SpanContext().TraceID())
+}
+
+func TestTraceHeaderIgnoresOtherFormats(t *testing.T) {
+	assert.Empty(t, TraceHeader(context.Background()))
+
+	for _, header := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"Root=1-5759e988-bd862e3fe1be46a994272793",
+		"not a trace header",
+	} {
+		ctx := WithTraceHeader(context.Background(), header)
+		assert.False(t, trace.SpanContextFromContext(ctx).IsValid(), header)
+	}
+}
@@ -0,0 +1,13 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "orderservice"
+
+// Tracer returns the tracer the service instruments its own code with
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
@@ -6,8 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
+	"github.com/demo-micro/backend/pkg/logging"
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 )
 
 func LoadEnvFile(ctx context.Context) {
@@ -34,11 +37,11 @@ func LoadEnvFile(ctx context.Context) {
 	if err != nil {
 		log.Println("Failed to load .env file from any of the specified paths") //dont exit
 	}
-
-	ValidateEnvVars()
 }
 
-func ValidateEnvVars() {
+// ValidateEnvVars exits when a required environment variable is missing, and logs the
+// values of the others with their secrets redacted
+func ValidateEnvVars(logger *zap.Logger) {
 	requiredVars := []string{
 		"AWS_ACCESS_KEY_ID",
 		"AWS_SECRET_ACCESS_KEY",
@@ -53,13 +56,14 @@ func ValidateEnvVars() {
 		"DATABASE_USERNAME",
 		"DATABASE_PASSWORD",
 		"DATABASE_NAME",
+		"SUBGRAPH_SIGNING_SECRET",
 	}
 
 	for _, varName := range requiredVars {
 		if value := os.Getenv(varName); value == "" {
-			log.Fatalf("Missing required environment variable: %s", varName)
+			logger.Fatal("Missing required environment variable", zap.String("name", varName))
 		} else {
-			log.Printf("Environment variable %s is set to: %s", varName, value)
+			logger.Info("Environment variable set", zap.String("name", varName), zap.String("value", logging.RedactEnv(varName, value)))
 		}
 	}
 }
@@ -79,6 +83,10 @@ func GetEnv[T any](key string, defaultVal T) T {
 		if val, err := strconv.Atoi(valStr); err == nil {
 			return any(int32(val)).(T)
 		}
+	case time.Duration:
+		if val, err := time.ParseDuration(valStr); err == nil {
+			return any(val).(T)
+		}
 	case string:
 		return any(valStr).(T)
 	}
@@ -40,6 +40,65 @@ CREATE TABLE order_details (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Outbox Table: events written with the changes they announce, published by the relay
+CREATE TABLE outbox (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    source TEXT NOT NULL,
+    detail_type TEXT NOT NULL,
+    detail JSONB NOT NULL,
+    event_bus_name TEXT NOT NULL,
+    trace_header TEXT,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    failed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Messages waiting to be published, in the order the relay reads them
+CREATE INDEX outbox_pending_idx ON outbox (created_at) WHERE sent_at IS NULL AND failed_at IS NULL;
+CREATE INDEX outbox_sent_at_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
+
+-- Processed Events Table: the inbox of the events handled, so redeliveries are skipped
+CREATE TABLE processed_events (
+    event_id TEXT PRIMARY KEY,
+    idempotency_key TEXT,
+    detail_type TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (detail_type, idempotency_key)
+);
+
+CREATE INDEX processed_events_processed_at_idx ON processed_events (processed_at);
+
+-- Order Sagas Table: where each order is in its flow across the inventory and notification services
+CREATE TABLE order_sagas (
+    order_id UUID PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+    state TEXT NOT NULL,
+    last_event TEXT NOT NULL,
+    reason TEXT,
+    deadline TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Sagas waiting on a step, in the order their deadlines expire
+CREATE INDEX order_sagas_deadline_idx ON order_sagas (deadline) WHERE deadline IS NOT NULL;
+
+-- Order Saga Steps Table: the history of the transitions of each saga
+CREATE TABLE order_saga_steps (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES order_sagas(order_id) ON DELETE CASCADE,
+    from_state TEXT,
+    to_state TEXT NOT NULL,
+    trigger TEXT NOT NULL,
+    event_id TEXT,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX order_saga_steps_order_id_idx ON order_saga_steps (order_id, created_at);
+
 -- Trigger function to update updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
@@ -58,6 +117,10 @@ CREATE TRIGGER update_order_details_updated_at
 BEFORE UPDATE ON order_details
 FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER update_order_sagas_updated_at
+BEFORE UPDATE ON order_sagas
+FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
 -- Seed currencies
 INSERT INTO currencies (name) VALUES
     ('USD'),
@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the ID of a request between services
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey struct{}
+
+// request holds the IDs of the request a context belongs to. The user is only known once
+// the request is authenticated, after the request was put in the context.
+type request struct {
+	id     string
+	userID string
+}
+
+// WithRequestID returns ctx belonging to the request with the given ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &request{id: id})
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to
+func RequestIDFromContext(ctx context.Context) string {
+	if req, ok := ctx.Value(contextKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetUserID records the user a request was authenticated as, for every line logged for
+// the request from then on, including the access log line
+func SetUserID(ctx context.Context, userID string) {
+	if req, ok := ctx.Value(contextKey{}).(*request); ok {
+		req.userID = userID
+	}
+}
+
+// Ctx returns the global logger with the request ID, trace ID and user ID of ctx
+func Ctx(ctx context.Context) *zap.Logger {
+	return With(ctx, zap.L())
+}
+
+// With returns logger with the request ID, trace ID and user ID of ctx
+func With(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	fields := make([]zap.Field, 0, 3)
+	if req, ok := ctx.Value(contextKey{}).(*request); ok {
+		fields = append(fields, zap.String("request_id", req.id))
+		if req.userID != "" {
+			fields = append(fields, zap.String("user_id", req.userID))
+		}
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+	}
+	if len(fields) == 0 {
+		return logger
+	}
+	return logger.With(fields...)
+}
+
+// validRequestID matches the request IDs accepted from clients, so they cannot forge log lines
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Middleware gives each request an ID, taken from the X-Request-ID header when it has a
+// valid one, returns it in the response, and logs an access line when the request is done
+func Middleware(next http.Handler, logger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", sw.status),
+			zap.Int64("bytes", sw.bytes),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("user_agent", r.UserAgent()),
+		}
+		if logger.Core().Enabled(zap.DebugLevel) {
+			fields = append(fields, Headers("headers", r.Header))
+		}
+		With(ctx, logger).Info("Request served", fields...)
+	})
+}
+
+// statusWriter remembers the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher for streamed responses
+func (w *statusWriter) Flush() {
+	w.wroteHeader = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for websocket upgrades, which are logged as switching
+// protocols once the connection is closed
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && !w.wroteHeader {
+		w.status = http.StatusSwitchingProtocols
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the response behind the writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
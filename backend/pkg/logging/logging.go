@@ -0,0 +1,129 @@
+// Package logging writes JSON logs to stdout and/or a rotating file. The level can be
+// changed at runtime, and lines logged with Ctx carry the request, trace and user of
+// their context. Secrets are redacted from every line.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config configures where logs are written and from which level on
+type Config struct {
+	// Level is the minimum level logged: debug, info, warn or error
+	Level string
+	// Stdout writes the logs to stdout
+	Stdout bool
+	// File writes the logs to a file that is rotated, unless empty
+	File string
+	// MaxSizeMB rotates the file once it would grow beyond this many megabytes
+	MaxSizeMB int
+	// RotationInterval rotates the file once it is this old
+	RotationInterval time.Duration
+	// MaxBackups is how many rotated files are kept
+	MaxBackups int
+}
+
+// ConfigFromEnv reads the configuration from LOG_LEVEL, LOG_STDOUT, LOG_FILE,
+// LOG_MAX_SIZE_MB, LOG_ROTATION_INTERVAL and LOG_MAX_BACKUPS
+func ConfigFromEnv() Config {
+	config := Config{
+		Level:            os.Getenv("LOG_LEVEL"),
+		Stdout:           true,
+		File:             os.Getenv("LOG_FILE"),
+		MaxSizeMB:        100,
+		RotationInterval: 24 * time.Hour,
+		MaxBackups:       7,
+	}
+	if v, err := strconv.ParseBool(os.Getenv("LOG_STDOUT")); err == nil {
+		config.Stdout = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LOG_MAX_SIZE_MB")); err == nil {
+		config.MaxSizeMB = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOG_ROTATION_INTERVAL")); err == nil {
+		config.RotationInterval = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("LOG_MAX_BACKUPS")); err == nil {
+		config.MaxBackups = v
+	}
+	return config
+}
+
+// Logger is a zap logger with a level that can be changed while it is in use
+type Logger struct {
+	*zap.Logger
+	level zap.AtomicLevel
+	file  io.Closer
+}
+
+// New creates a logger. It logs nothing when neither stdout nor a file is configured.
+func New(config Config) (*Logger, error) {
+	level := zap.NewAtomicLevel()
+	if config.Level != "" {
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", config.Level, err)
+		}
+	}
+
+	var writers []zapcore.WriteSyncer
+	if config.Stdout {
+		writers = append(writers, zapcore.Lock(os.Stdout))
+	}
+
+	var file *RotatingFile
+	if config.File != "" {
+		var err error
+		file, err = NewRotatingFile(config.File, int64(config.MaxSizeMB)*1024*1024, config.RotationInterval, config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, file)
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.NewMultiWriteSyncer(writers...), level)
+	logger := &Logger{
+		Logger: zap.New(redactingCore{core}, zap.AddCaller()),
+		level:  level,
+	}
+	if file != nil {
+		logger.file = file
+	}
+	return logger, nil
+}
+
+// Level is the level of the logger, which serves GET and PUT {"level":"debug"} requests
+// to read and change it
+func (l *Logger) Level() zap.AtomicLevel {
+	return l.level
+}
+
+// Close closes the file of the logger. Lines are written unbuffered, so there is
+// nothing to flush.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// SetGlobal makes l the logger of Ctx and zap.L, and redirects the standard library log
+// to it at info level. The returned function restores the previous loggers.
+func SetGlobal(l *Logger) func() {
+	restoreGlobals := zap.ReplaceGlobals(l.Logger)
+	restoreStdLog := zap.RedirectStdLog(l.Logger)
+	return func() {
+		restoreStdLog()
+		restoreGlobals()
+	}
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces the values of secrets
+const Redacted = "[REDACTED]"
+
+// secretKeyParts are the parts of field, header and environment variable names whose
+// values are secrets
+var secretKeyParts = []string{"authorization", "cookie", "password", "secret", "token", "api_key", "api-key", "access_key", "private_key"}
+
+// IsSecret reports whether a field, header or environment variable name holds a secret
+func IsSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range secretKeyParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// dsnPassword matches the password of a key=value connection string
+var dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// RedactEnv returns the value of an environment variable as it may be logged. Besides
+// the variables named like secrets, the credentials of connection strings are redacted:
+// the userinfo of URLs and the password of key=value strings.
+func RedactEnv(name, value string) string {
+	if IsSecret(name) {
+		return Redacted
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		u.User = nil
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(value, "${1}"+Redacted)
+}
+
+// Headers logs HTTP headers under key, with the values of secret headers redacted
+func Headers(key string, header http.Header) zap.Field {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		if IsSecret(name) {
+			redacted[name] = Redacted
+			continue
+		}
+		redacted[name] = strings.Join(values, ", ")
+	}
+	return zap.Any(key, redacted)
+}
+
+// redactingCore replaces the values of fields named like secrets before they are
+// encoded, so a secret logged by mistake does not end up in the logs
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	var redacted []zapcore.Field
+	for i, field := range fields {
+		if !IsSecret(field.Key) {
+			continue
+		}
+		if redacted == nil {
+			redacted = make([]zapcore.Field, len(fields))
+			copy(redacted, fields)
+		}
+		redacted[i] = zap.String(field.Key, Redacted)
+	}
+	if redacted == nil {
+		return fields
+	}
+	return redacted
+}
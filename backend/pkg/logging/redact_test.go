@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactEnv(t *testing.T) {
+	assert.Equal(t, Redacted, RedactEnv("AWS_SECRET_ACCESS_KEY", "abc"))
+	assert.Equal(t, Redacted, RedactEnv("DATABASE_PASSWORD", "abc"))
+	assert.Equal(t, Redacted, RedactEnv("SUBGRAPH_SIGNING_SECRET", "abc"))
+	assert.Equal(t, "orders", RedactEnv("DATABASE_NAME", "orders"))
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "URL with credentials",
+			value: "postgres://orders:s3cret@db:5432/orders?sslmode=disable",
+			want:  "postgres://db:5432/orders?sslmode=disable",
+		},
+		{
+			name:  "URL with a user only",
+			value: "redis://default@redis:6379/0",
+			want:  "redis://redis:6379/0",
+		},
+		{
+			name:  "URL without credentials",
+			value: "http://localstack:4566",
+			want:  "http://localstack:4566",
+		},
+		{
+			name:  "key=value string",
+			value: "host=db user=orders password=s3cret dbname=orders",
+			want:  "host=db user=orders password=" + Redacted + " dbname=orders",
+		},
+		{
+			name:  "key=value string with a quoted password",
+			value: `host=db password = 'my s3cret\'s' dbname=orders`,
+			want:  "host=db password = " + Redacted + " dbname=orders",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, RedactEnv("DATABASE_URL", tt.value))
+		})
+	}
+}
+
+func TestRedactingCoreRedactsSecretFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(redactingCore{core}).With(zap.String("api_key", "k"))
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	header.Set("Content-Type", "application/json")
+	logger.Info("request", zap.String("password", "p"), zap.String("path", "/query"), Headers("headers", header))
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, Redacted, fields["api_key"])
+	assert.Equal(t, Redacted, fields["password"])
+	assert.Equal(t, "/query", fields["path"])
+	assert.Equal(t, map[string]string{"Authorization": Redacted, "Content-Type": "application/json"}, fields["headers"])
+}
+
+func TestMiddlewareLogsRequestAndUser(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), "user-1")
+		w.WriteHeader(http.StatusCreated)
+	}), zap.New(core))
+
+	r := httptest.NewRequest(http.MethodPost, "/query", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "req-1", w.Header().Get(RequestIDHeader))
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "req-1", fields["request_id"])
+	assert.Equal(t, "user-1", fields["user_id"])
+	assert.EqualValues(t, http.StatusCreated, fields["status"])
+}
+
+func TestMiddlewareReplacesInvalidRequestID(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEqual(t, "bad\nid", RequestIDFromContext(r.Context()))
+		assert.NotEmpty(t, RequestIDFromContext(r.Context()))
+	}), zap.NewNop())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(RequestIDHeader, "bad\nid")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Empty(t, RequestIDFromContext(context.Background()))
+}
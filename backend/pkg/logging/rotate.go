@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files, sorting them by the time they were rotated
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is a log file that is renamed and replaced by a new one when it grows too
+// big or too old. Rotated files are named after the file and the time of their rotation,
+// app.log becomes app-2006-01-02T15-04-05.000.log, and only the newest are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// NewRotatingFile opens the file at path, appending to it. A maxSize or interval of zero
+// disables rotation on that condition, a maxBackups of zero keeps every rotated file.
+func NewRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	// A file left by a previous run is as old as its first line, which is unknown. Its
+	// modification time is the best guess.
+	f.openedAt = f.now()
+	if info.Size() > 0 {
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first when p would not fit or the file
+// is due
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.interval > 0 && f.now().Sub(f.openedAt) >= f.interval
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync implements zapcore.WriteSyncer
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file. Writes after closing fail.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	f.file = nil
+
+	ext := filepath.Ext(f.path)
+	backup := strings.TrimSuffix(f.path, ext) + "-" + f.now().Format(backupTimeFormat) + ext
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.openedAt = f.now()
+
+	f.removeOldBackups()
+	return nil
+}
+
+// removeOldBackups removes the oldest rotated files beyond maxBackups. Failing to do so
+// must not stop logging, so errors are ignored.
+func (f *RotatingFile) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(f.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(f.path, ext) + "-*" + ext)
+	if err != nil || len(backups) <= f.maxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		os.Remove(backup)
+	}
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFileRotatesOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := NewRotatingFile(path, 10, 0, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	_, err = f.Write([]byte("12345678\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("abc\n"))
+	require.NoError(t, err)
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "abc\n", string(current))
+
+	backup, err := os.ReadFile(filepath.Join(filepath.Dir(path), "app-2024-05-01T12-00-00.000.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "12345678\n", string(backup))
+}
+
+func TestRotatingFileRotatesOnAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := NewRotatingFile(path, 0, time.Hour, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour)
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(current))
+}
+
+func TestRotatingFileKeepsNewestBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f, err := NewRotatingFile(path, 1, 0, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	for _, line := range []string{"a", "b", "c", "d"} {
+		_, err = f.Write([]byte(line))
+		require.NoError(t, err)
+		now = now.Add(time.Second)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "app-2024-05-01T12-00-02.000.log"),
+		filepath.Join(dir, "app-2024-05-01T12-00-03.000.log"),
+	}, backups)
+}
+
+func TestRotatingFileFailsAfterClose(t *testing.T) {
+	f, err := NewRotatingFile(filepath.Join(t.TempDir(), "app.log"), 0, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = f.Write([]byte("late\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,100 @@
+// Package telemetry sets up OpenTelemetry tracing for the services: the tracer provider,
+// its exporter and the propagators of the trace context.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Init installs the global tracer provider and the W3C trace context propagator. Spans are
+// reported as coming from serviceName, unless OTEL_SERVICE_NAME says otherwise. The
+// exporter is chosen with OTEL_TRACES_EXPORTER:
+//
+//	otlp     OTLP over HTTP, configured with the OTEL_EXPORTER_OTLP_* variables (default)
+//	console  pretty printed to stdout
+//	file     JSON lines appended to OTEL_TRACES_FILE
+//	none     spans are propagated but not exported
+//
+// The returned function flushes the remaining spans and has to be called on shutdown.
+func Init(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closer, err := newExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(serviceName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	// The sampler is configured with OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context) (sdktrace.SpanExporter, io.Closer, error) {
+	switch kind := os.Getenv("OTEL_TRACES_EXPORTER"); kind {
+	case "", "otlp":
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case "console", "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create console exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case "file":
+		path := os.Getenv("OTEL_TRACES_FILE")
+		if path == "" {
+			path = "traces.jsonl"
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		return exporter, file, nil
+	case "none":
+		return nil, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", kind)
+	}
+}
@@ -51,9 +51,16 @@ const RegisterPage = () => {
         }),
       });
 
-      if (res.status === 400) {
+      if (res.status === 409) {
         toast.error('This is already registered');
-        setError('Slready in use');
+        setError('Already in use');
+      }
+      if (res.status === 400) {
+        const body = await res.json().catch(() => null);
+        const message =
+          body?.errors?.password?.[0] ?? body?.errors?.username?.[0] ?? 'Invalid registration';
+        toast.error(message);
+        setError(message);
       }
       if (res.status === 200) {
         setError('');
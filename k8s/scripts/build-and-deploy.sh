@@ -65,13 +65,13 @@ cd ../..
 
 # Build API Gateway
 print_info "Building API Gateway..."
-docker build -t api-gateway:latest backend/api-gateway
+docker build -t api-gateway:latest -f backend/api-gateway/Dockerfile backend
 print_success "API Gateway built"
 
 # Build Order Service
 echo ""
 print_info "Building Order Service..."
-docker build -t order-service:latest backend/order-service
+docker build -t order-service:latest -f backend/order-service/Dockerfile backend
 print_success "Order Service built"
 
 # Build Inventory Service
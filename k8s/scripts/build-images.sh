@@ -40,12 +40,12 @@ fi
 
 # Build API Gateway
 print_info "Building API Gateway image..."
-docker build -t api-gateway:latest ./backend/api-gateway
+docker build -t api-gateway:latest -f ./backend/api-gateway/Dockerfile ./backend
 print_success "API Gateway image built successfully"
 
 # Build Order Service
 print_info "Building Order Service image..."
-docker build -t order-service:latest ./backend/order-service
+docker build -t order-service:latest -f ./backend/order-service/Dockerfile ./backend
 print_success "Order Service image built successfully"
 
 # Build Inventory Service
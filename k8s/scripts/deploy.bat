@@ -50,7 +50,10 @@ kubectl apply -f k8s\infrastructure\redis\
 echo [SUCCESS] Redis deployed
 
 echo [INFO]   Deploying LocalStack...
+kubectl create configmap localstack-scripts -n demo-micro --from-file=scripts\ --dry-run=client -o yaml | kubectl apply -f -
 kubectl apply -f k8s\infrastructure\localstack\
+kubectl wait --for=condition=ready pod -l app=localstack -n demo-micro --timeout=120s 2>nul
+kubectl exec -n demo-micro deploy/localstack -- bash /scripts/concurent.sh
 echo [SUCCESS] LocalStack deployed
 
 REM Ask about Kafka
@@ -116,10 +116,13 @@ kubectl apply -f "$K8S_DIR/infrastructure/redis/"
 kubectl wait --for=condition=ready pod -l app=redis -n demo-micro --timeout=120s 2>/dev/null || true
 print_success "  Redis deployed"
 
-# Deploy LocalStack
+# Deploy LocalStack, with the scripts creating its queues, rules and API destinations
 print_info "  Deploying LocalStack..."
+kubectl create configmap localstack-scripts -n demo-micro --from-file="$ROOT_DIR/scripts/" \
+    --dry-run=client -o yaml | kubectl apply -f -
 kubectl apply -f "$K8S_DIR/infrastructure/localstack/"
 kubectl wait --for=condition=ready pod -l app=localstack -n demo-micro --timeout=120s 2>/dev/null || true
+kubectl exec -n demo-micro deploy/localstack -- bash /scripts/concurent.sh
 print_success "  LocalStack deployed"
 
 # Deploy Kafka if requested
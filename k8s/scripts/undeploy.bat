@@ -50,6 +50,7 @@ echo [INFO] Deleting infrastructure...
 kubectl delete -f ..\infrastructure\elk\ --ignore-not-found=true 2>nul
 kubectl delete -f ..\infrastructure\kafka\ --ignore-not-found=true 2>nul
 kubectl delete -f ..\infrastructure\localstack\ --ignore-not-found=true 2>nul
+kubectl delete configmap localstack-scripts -n demo-micro --ignore-not-found=true 2>nul
 kubectl delete -f ..\infrastructure\redis\ --ignore-not-found=true 2>nul
 
 echo [INFO] Deleting PVCs...
@@ -64,6 +64,7 @@ print_info "Deleting infrastructure..."
 kubectl delete -f "$K8S_DIR/infrastructure/elk/" --ignore-not-found=true 2>/dev/null
 kubectl delete -f "$K8S_DIR/infrastructure/kafka/" --ignore-not-found=true 2>/dev/null
 kubectl delete -f "$K8S_DIR/infrastructure/localstack/" --ignore-not-found=true 2>/dev/null
+kubectl delete configmap localstack-scripts -n demo-micro --ignore-not-found=true 2>/dev/null
 kubectl delete -f "$K8S_DIR/infrastructure/redis/" --ignore-not-found=true 2>/dev/null
 
 print_info "Deleting PVCs..."
@@ -0,0 +1,47 @@
+#!/bin/bash
+# Delivers the order events that change cached responses to the cache invalidation endpoint
+# of the API gateway, through an EventBridge API destination. Run it again to update the
+# endpoint or the token.
+set -euo pipefail
+
+AWS_ENDPOINT_URL="${AWS_ENDPOINT_URL:-http://localhost:4566}"
+REGION="${AWS_REGION:-ap-southeast-1}"
+EVENT_BUS_NAME="${EVENT_BUS_NAME:-evbus}"
+CACHE_INVALIDATION_URL="${CACHE_INVALIDATION_URL:-http://api-gateway:8080/cache/invalidate}"
+CACHE_INVALIDATION_TOKEN="${CACHE_INVALIDATION_TOKEN:?must be the CACHE_INVALIDATION_TOKEN of the API gateway}"
+NAME="gateway-cache-invalidation"
+
+events() {
+  aws --endpoint-url="$AWS_ENDPOINT_URL" --region "$REGION" events "$@"
+}
+
+# The gateway expects the token as a bearer token
+AUTH_PARAMETERS="{\"ApiKeyAuthParameters\":{\"ApiKeyName\":\"Authorization\",\"ApiKeyValue\":\"Bearer ${CACHE_INVALIDATION_TOKEN}\"}}"
+if CONNECTION_ARN=$(events describe-connection --name "$NAME" --query ConnectionArn --output text 2>/dev/null); then
+  events update-connection --name "$NAME" --authorization-type API_KEY --auth-parameters "$AUTH_PARAMETERS" >/dev/null
+else
+  CONNECTION_ARN=$(events create-connection --name "$NAME" --authorization-type API_KEY \
+    --auth-parameters "$AUTH_PARAMETERS" --query ConnectionArn --output text)
+fi
+
+if DESTINATION_ARN=$(events describe-api-destination --name "$NAME" --query ApiDestinationArn --output text 2>/dev/null); then
+  events update-api-destination --name "$NAME" --connection-arn "$CONNECTION_ARN" \
+    --invocation-endpoint "$CACHE_INVALIDATION_URL" --http-method POST >/dev/null
+else
+  DESTINATION_ARN=$(events create-api-destination --name "$NAME" --connection-arn "$CONNECTION_ARN" \
+    --invocation-endpoint "$CACHE_INVALIDATION_URL" --http-method POST --query ApiDestinationArn --output text)
+fi
+
+events put-rule \
+  --name "$NAME" \
+  --event-bus-name "$EVENT_BUS_NAME" \
+  --event-pattern '{"source": ["com.order.service"], "detail-type": ["order_updated", "order_cancelled"]}' \
+  --description "Invalidate the cached responses holding changed orders" >/dev/null
+
+# Events the gateway failed to handle are retried for a day
+events put-targets \
+  --rule "$NAME" \
+  --event-bus-name "$EVENT_BUS_NAME" \
+  --targets "[{\"Id\":\"1\",\"Arn\":\"$DESTINATION_ARN\",\"RoleArn\":\"arn:aws:iam::000000000000:role/$NAME\",\"RetryPolicy\":{\"MaximumRetryAttempts\":185,\"MaximumEventAgeInSeconds\":86400}}]" >/dev/null
+
+echo "Order events are delivered to $CACHE_INVALIDATION_URL"
@@ -142,4 +142,9 @@ put_targets "notification_sent_success" "$TARGET_ORDERS_ARN"
 put_targets "notification_sent_failed" "$TARGET_ORDERS_ARN"
 
 wait
+
+# Order events evict the cached responses of the API gateway
+CACHE_INVALIDATION_TOKEN="${CACHE_INVALIDATION_TOKEN:-change-me}" \
+  bash "$(dirname "$0")/cache-invalidation.sh"
+
 echo "✅ LocalStack EventBridge and SQS setup completed."
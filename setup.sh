@@ -9,6 +9,7 @@ done
 
 # Create SQS Queues concurrently
 aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name orders-queue &
+aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name orders-dlq &
 aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name notification-queue &
 aws --endpoint-url=http://localhost:4566 sqs create-queue --queue-name inventory-queue &
 
@@ -107,7 +108,7 @@ aws --endpoint-url=http://localhost:4566 events put-targets \
 aws --endpoint-url=http://localhost:4566 events put-targets \
     --rule "order_cancelled" \
     --event-bus-name "${EVENT_BUS_NAME}" \
-    --targets "Id"="1","Arn"="${TARGET_ORDERS_ARN}","Id"="2","Arn"="${TARGET_NOTIFICATION_ARN}" &
+    --targets "Id"="1","Arn"="${TARGET_ORDERS_ARN}","Id"="2","Arn"="${TARGET_NOTIFICATION_ARN}","Id"="3","Arn"="${TARGET_INVENTORY_ARN}" &
 
 aws --endpoint-url=http://localhost:4566 events put-targets \
     --rule "inventory_reserved" \
@@ -122,12 +123,17 @@ aws --endpoint-url=http://localhost:4566 events put-targets \
 aws --endpoint-url=http://localhost:4566 events put-targets \
     --rule "notification_sent_success" \
     --event-bus-name "${EVENT_BUS_NAME}" \
-    --targets "Id"="1","Arn"="${TARGET_NOTIFICATION_ARN}" &
+    --targets "Id"="1","Arn"="${TARGET_NOTIFICATION_ARN}","Id"="2","Arn"="${TARGET_ORDERS_ARN}" &
 
 aws --endpoint-url=http://localhost:4566 events put-targets \
     --rule "notification_sent_failed" \
     --event-bus-name "${EVENT_BUS_NAME}" \
-    --targets "Id"="1","Arn"="${TARGET_NOTIFICATION_ARN}" &
+    --targets "Id"="1","Arn"="${TARGET_NOTIFICATION_ARN}","Id"="2","Arn"="${TARGET_ORDERS_ARN}" &
 
 # Wait for all target additions to finish
-wait
\ No newline at end of file
+wait
+
+# Order events evict the cached responses of the API gateway
+AWS_ENDPOINT_URL="http://localhost:4566" AWS_REGION="${REGION}" EVENT_BUS_NAME="${EVENT_BUS_NAME}" \
+    CACHE_INVALIDATION_TOKEN="${CACHE_INVALIDATION_TOKEN:-change-me}" \
+    bash "$(dirname "$0")/scripts/cache-invalidation.sh"
\ No newline at end of file